@@ -0,0 +1,307 @@
+// Package acme obtains and renews TLS certificates from an ACME certificate
+// authority such as Let's Encrypt (RFC 8555), answering its HTTP-01 or
+// TLS-ALPN-01 (RFC 8737) challenges itself, so the proxy needs neither
+// certbot nor a reload to serve fresh certificates.
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LetsEncryptURL is the directory of the Let's Encrypt production CA. Try
+// LetsEncryptStagingURL first: production rate limits are strict.
+const (
+	LetsEncryptURL        = "https://acme-v02.api.letsencrypt.org/directory"
+	LetsEncryptStagingURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
+)
+
+// directory lists the endpoints of a CA.
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+// problem is an error document of the CA (RFC 7807).
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (p *problem) Error() string {
+	return fmt.Sprintf("acme: %s (%s)", p.Detail, p.Type)
+}
+
+const badNonce = "urn:ietf:params:acme:error:badNonce"
+
+type order struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+	Error          *problem `json:"error"`
+}
+
+type authorization struct {
+	Status     string `json:"status"`
+	Identifier struct {
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type challenge struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url"`
+	Token  string   `json:"token"`
+	Status string   `json:"status"`
+	Error  *problem `json:"error"`
+}
+
+// client speaks the ACME protocol for one account, identified by its key.
+type client struct {
+	http         *http.Client
+	directoryURL string
+	key          *ecdsa.PrivateKey
+
+	mux    sync.Mutex
+	dir    *directory
+	kid    string // account URL, once registered
+	nonces []string
+}
+
+var b64 = base64.RawURLEncoding
+
+// register creates the account of the key, or finds it if it exists.
+func (c *client) register(ctx context.Context, email string) error {
+	dir, err := c.directory(ctx)
+	if err != nil {
+		return err
+	}
+	account := map[string]any{"termsOfServiceAgreed": true}
+	if email != "" {
+		account["contact"] = []string{"mailto:" + email}
+	}
+	header, _, err := c.post(ctx, dir.NewAccount, account)
+	if err != nil {
+		return fmt.Errorf("account registration: %w", err)
+	}
+	kid := header.Get("Location")
+	if kid == "" {
+		return errors.New("acme: account registration returned no account URL")
+	}
+	c.mux.Lock()
+	c.kid = kid
+	c.mux.Unlock()
+	return nil
+}
+
+func (c *client) directory(ctx context.Context) (*directory, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.dir != nil {
+		return c.dir, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.directoryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("acme: directory %s: %s", c.directoryURL, resp.Status)
+	}
+	var dir directory
+	if err := json.NewDecoder(resp.Body).Decode(&dir); err != nil {
+		return nil, fmt.Errorf("acme: invalid directory: %w", err)
+	}
+	c.dir = &dir
+	return c.dir, nil
+}
+
+// nonce returns a nonce saved from a previous response, or a fresh one.
+func (c *client) nonce(ctx context.Context) (string, error) {
+	c.mux.Lock()
+	if n := len(c.nonces); n > 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		c.mux.Unlock()
+		return nonce, nil
+	}
+	c.mux.Unlock()
+
+	dir, err := c.directory(ctx)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, dir.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("acme: no nonce in newNonce response")
+	}
+	return nonce, nil
+}
+
+// post sends payload (nil for a POST-as-GET) signed by the account key and
+// returns the response headers and body. A rejected nonce is retried once,
+// as RFC 8555 expects.
+func (c *client) post(ctx context.Context, url string, payload any) (http.Header, []byte, error) {
+	var data []byte
+	if payload != nil {
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			return nil, nil, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		header, body, err := c.postOnce(ctx, url, data)
+		var p *problem
+		if errors.As(err, &p) && p.Type == badNonce && attempt == 0 {
+			continue
+		}
+		return header, body, err
+	}
+}
+
+func (c *client) postOnce(ctx context.Context, url string, payload []byte) (http.Header, []byte, error) {
+	nonce, err := c.nonce(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := c.sign(url, nonce, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/jose+json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if nonce := resp.Header.Get("Replay-Nonce"); nonce != "" {
+		c.mux.Lock()
+		c.nonces = append(c.nonces, nonce)
+		c.mux.Unlock()
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode >= 400 {
+		p := &problem{Status: resp.StatusCode}
+		if json.Unmarshal(data, p) != nil || p.Detail == "" {
+			p.Detail = resp.Status
+		}
+		return nil, nil, p
+	}
+	return resp.Header, data, nil
+}
+
+// postJSON is post decoding the response into out.
+func (c *client) postJSON(ctx context.Context, url string, payload, out any) (http.Header, error) {
+	header, body, err := c.post(ctx, url, payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return nil, fmt.Errorf("acme: invalid response from %s: %w", url, err)
+	}
+	return header, nil
+}
+
+// poll fetches url into out until done reports true, every interval.
+func (c *client) poll(ctx context.Context, url string, interval time.Duration, out any, done func() bool) error {
+	for {
+		if _, err := c.postJSON(ctx, url, nil, out); err != nil {
+			return err
+		}
+		if done() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// sign wraps payload in a flattened JWS (ES256), authenticated by the
+// account URL once registered and by the public key until then.
+func (c *client) sign(url, nonce string, payload []byte) ([]byte, error) {
+	protected := map[string]any{"alg": "ES256", "nonce": nonce, "url": url}
+	c.mux.Lock()
+	if c.kid != "" {
+		protected["kid"] = c.kid
+	} else {
+		protected["jwk"] = jwk(&c.key.PublicKey)
+	}
+	c.mux.Unlock()
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return json.Marshal(map[string]string{
+		"protected": b64.EncodeToString(header),
+		"payload":   b64.EncodeToString(payload),
+		"signature": b64.EncodeToString(sig),
+	})
+}
+
+// jwk is the JSON Web Key of a P-256 public key, its members in the
+// lexicographic order RFC 7638 thumbprints require.
+func jwk(pub *ecdsa.PublicKey) map[string]string {
+	key, _ := pub.ECDH() // P-256, generated by us
+	point := key.Bytes() // 0x04 || x || y
+	return map[string]string{
+		"crv": "P-256",
+		"kty": "EC",
+		"x":   b64.EncodeToString(point[1:33]),
+		"y":   b64.EncodeToString(point[33:]),
+	}
+}
+
+// keyAuthorization is the answer to the challenge of token.
+func (c *client) keyAuthorization(token string) string {
+	data, _ := json.Marshal(jwk(&c.key.PublicKey)) // maps marshal sorted
+	thumbprint := sha256.Sum256(data)
+	return token + "." + b64.EncodeToString(thumbprint[:])
+}
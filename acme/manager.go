@@ -0,0 +1,447 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Challenge types the Manager can answer.
+const (
+	ChallengeTLSALPN01 = "tls-alpn-01" // on the TLS listener itself (port 443)
+	ChallengeHTTP01    = "http-01"     // on a plain HTTP listener (port 80), see HTTPHandler
+)
+
+// ALPNProto is the protocol a TLS-ALPN-01 validation negotiates.
+const ALPNProto = "acme-tls/1"
+
+// idPeACMEIdentifier is the certificate extension of a TLS-ALPN-01 answer.
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// Manager obtains a certificate for each of its Domains, keeps them in
+// CacheDir across restarts, renews them before they expire and serves them
+// through GetCertificate.
+type Manager struct {
+	Domains      []string
+	Email        string        // account contact for expiry notices; optional
+	CacheDir     string        // account key and certificates; required
+	DirectoryURL string        // defaults to LetsEncryptURL
+	Challenge    string        // ChallengeTLSALPN01 (default) or ChallengeHTTP01
+	RenewBefore  time.Duration // renew when a certificate expires within; defaults to 30 days
+	HTTPClient   *http.Client  // to reach the CA; defaults to http.DefaultClient
+
+	pollInterval time.Duration // between checks of a pending order
+
+	obtainMux sync.Mutex // one order at a time
+	client    *client
+
+	mux    sync.RWMutex
+	certs  map[string]*tls.Certificate // by domain
+	tokens map[string]string           // HTTP-01: token → key authorization
+	alpn   map[string]*tls.Certificate // TLS-ALPN-01: domain → challenge certificate
+}
+
+// Start serves the certificates found in CacheDir right away, then obtains
+// the missing ones and renews them in the background until ctx is
+// cancelled. Handshakes for a domain still waiting for its certificate fail.
+func (m *Manager) Start(ctx context.Context) error {
+	if err := m.init(); err != nil {
+		return err
+	}
+	for _, domain := range m.Domains {
+		if cert, err := m.load(domain); err == nil {
+			m.setCert(domain, cert)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("ACME: ignoring cached certificate of %s: %v", domain, err)
+		}
+	}
+	go func() {
+		for {
+			wait := 12 * time.Hour
+			if !m.renew(ctx) {
+				wait = 10 * time.Minute // retry sooner after a failure
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+	return nil
+}
+
+func (m *Manager) init() error {
+	if m.CacheDir == "" {
+		return errors.New("acme: a cache directory is required")
+	}
+	if err := os.MkdirAll(m.CacheDir, 0o700); err != nil {
+		return err
+	}
+	if m.DirectoryURL == "" {
+		m.DirectoryURL = LetsEncryptURL
+	}
+	if m.Challenge == "" {
+		m.Challenge = ChallengeTLSALPN01
+	}
+	if m.RenewBefore <= 0 {
+		m.RenewBefore = 30 * 24 * time.Hour
+	}
+	if m.HTTPClient == nil {
+		m.HTTPClient = http.DefaultClient
+	}
+	if m.pollInterval <= 0 {
+		m.pollInterval = time.Second
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.certs == nil {
+		m.certs = make(map[string]*tls.Certificate)
+		m.tokens = make(map[string]string)
+		m.alpn = make(map[string]*tls.Certificate)
+	}
+	return nil
+}
+
+// renew obtains the certificates that are missing or expire within
+// RenewBefore. It reports whether all of them are fine.
+func (m *Manager) renew(ctx context.Context) bool {
+	ok := true
+	for _, domain := range m.Domains {
+		m.mux.RLock()
+		cert := m.certs[domain]
+		m.mux.RUnlock()
+		if cert != nil && time.Until(cert.Leaf.NotAfter) > m.RenewBefore {
+			continue
+		}
+		obtainCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+		err := m.obtain(obtainCtx, domain)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("ACME: failed to obtain a certificate for %s: %v", domain, err)
+			}
+			ok = false
+			continue
+		}
+		log.Printf("ACME: obtained a certificate for %s", domain)
+	}
+	return ok
+}
+
+// obtain orders a certificate for domain, answers its challenge and stores
+// the result.
+func (m *Manager) obtain(ctx context.Context, domain string) error {
+	m.obtainMux.Lock()
+	defer m.obtainMux.Unlock()
+	c, err := m.account(ctx)
+	if err != nil {
+		return err
+	}
+	dir, err := c.directory(ctx)
+	if err != nil {
+		return err
+	}
+
+	var o order
+	header, err := c.postJSON(ctx, dir.NewOrder, map[string]any{
+		"identifiers": []map[string]string{{"type": "dns", "value": domain}},
+	}, &o)
+	if err != nil {
+		return fmt.Errorf("new order: %w", err)
+	}
+	orderURL := header.Get("Location")
+	for _, authzURL := range o.Authorizations {
+		if err := m.authorize(ctx, c, authzURL); err != nil {
+			return err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: []string{domain},
+	}, key)
+	if err != nil {
+		return err
+	}
+	if _, err := c.postJSON(ctx, o.Finalize, map[string]string{"csr": b64.EncodeToString(csr)}, &o); err != nil {
+		return fmt.Errorf("finalize: %w", err)
+	}
+	err = c.poll(ctx, orderURL, m.pollInterval, &o, func() bool { return o.Status != "processing" && o.Status != "ready" })
+	if err != nil {
+		return fmt.Errorf("order: %w", err)
+	}
+	if o.Status != "valid" {
+		if o.Error != nil {
+			return fmt.Errorf("order %s: %w", o.Status, o.Error)
+		}
+		return fmt.Errorf("order %s", o.Status)
+	}
+
+	_, chain, err := c.post(ctx, o.Certificate, nil)
+	if err != nil {
+		return fmt.Errorf("certificate download: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	data := append(chain, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})...)
+	cert, err := parseCert(data)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(m.certPath(domain), data); err != nil {
+		return err
+	}
+	m.setCert(domain, cert)
+	return nil
+}
+
+// authorize proves control of the domain of an authorization, unless it is
+// already valid.
+func (m *Manager) authorize(ctx context.Context, c *client, authzURL string) error {
+	var authz authorization
+	if _, err := c.postJSON(ctx, authzURL, nil, &authz); err != nil {
+		return fmt.Errorf("authorization: %w", err)
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+	i := slices.IndexFunc(authz.Challenges, func(ch challenge) bool { return ch.Type == m.Challenge })
+	if i < 0 {
+		return fmt.Errorf("the CA offers no %s challenge for %s", m.Challenge, authz.Identifier.Value)
+	}
+	chal := authz.Challenges[i]
+	domain := authz.Identifier.Value
+	keyAuth := c.keyAuthorization(chal.Token)
+
+	m.mux.Lock()
+	if m.Challenge == ChallengeHTTP01 {
+		m.tokens[chal.Token] = keyAuth
+	} else {
+		cert, err := alpnCert(domain, keyAuth)
+		if err != nil {
+			m.mux.Unlock()
+			return err
+		}
+		m.alpn[domain] = cert
+	}
+	m.mux.Unlock()
+	defer func() {
+		m.mux.Lock()
+		delete(m.tokens, chal.Token)
+		delete(m.alpn, domain)
+		m.mux.Unlock()
+	}()
+
+	if _, _, err := c.post(ctx, chal.URL, struct{}{}); err != nil {
+		return fmt.Errorf("%s challenge: %w", m.Challenge, err)
+	}
+	err := c.poll(ctx, authzURL, m.pollInterval, &authz, func() bool { return authz.Status != "pending" })
+	if err != nil {
+		return fmt.Errorf("authorization: %w", err)
+	}
+	if authz.Status != "valid" {
+		for _, ch := range authz.Challenges {
+			if ch.Type == m.Challenge && ch.Error != nil {
+				return fmt.Errorf("%s challenge for %s: %w", m.Challenge, domain, ch.Error)
+			}
+		}
+		return fmt.Errorf("authorization of %s is %s", domain, authz.Status)
+	}
+	return nil
+}
+
+// account returns the client of the ACME account, registering it on first
+// use with the key kept in CacheDir.
+func (m *Manager) account(ctx context.Context) (*client, error) {
+	if m.client != nil {
+		return m.client, nil
+	}
+	path := filepath.Join(m.CacheDir, "acme_account.key")
+	var key *ecdsa.PrivateKey
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM key found", path)
+		}
+		if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+
+	c := &client{http: m.HTTPClient, directoryURL: m.DirectoryURL, key: key}
+	if err := c.register(ctx, m.Email); err != nil {
+		return nil, err
+	}
+	m.client = c
+	return c, nil
+}
+
+// GetCertificate serves the certificate of the requested domain, or the
+// answer to a pending TLS-ALPN-01 challenge. Use it as
+// tls.Config.GetCertificate, see TLSConfig.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" && len(m.Domains) > 0 {
+		name = m.Domains[0] // clients without SNI
+	}
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	if slices.Contains(hello.SupportedProtos, ALPNProto) {
+		if cert := m.alpn[name]; cert != nil {
+			return cert, nil
+		}
+		return nil, fmt.Errorf("acme: no pending tls-alpn-01 challenge for %q", name)
+	}
+	if cert := m.certs[name]; cert != nil {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("acme: no certificate for %q", name)
+}
+
+// TLSConfig is a TLS config serving the managed certificates and answering
+// TLS-ALPN-01 challenges.
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1", ALPNProto},
+	}
+}
+
+// HTTPHandler answers HTTP-01 challenges and hands every other request to
+// fallback; a nil fallback redirects them to https.
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := strings.CutPrefix(r.URL.Path, "/.well-known/acme-challenge/"); ok {
+			m.mux.RLock()
+			keyAuth, found := m.tokens[token]
+			m.mux.RUnlock()
+			if !found {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(keyAuth))
+			return
+		}
+		if fallback != nil {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Use HTTPS", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "https://"+r.Host+r.URL.RequestURI(), http.StatusFound)
+	})
+}
+
+func (m *Manager) setCert(domain string, cert *tls.Certificate) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.certs[domain] = cert
+}
+
+func (m *Manager) certPath(domain string) string {
+	return filepath.Join(m.CacheDir, domain+".pem")
+}
+
+// load reads the cached certificate of domain.
+func (m *Manager) load(domain string) (*tls.Certificate, error) {
+	data, err := os.ReadFile(m.certPath(domain))
+	if err != nil {
+		return nil, err
+	}
+	return parseCert(data)
+}
+
+// parseCert parses a PEM chain followed by its private key.
+func parseCert(data []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil // Leaf is filled in by X509KeyPair
+}
+
+// alpnCert is the self-signed certificate answering the TLS-ALPN-01
+// challenge of domain.
+func alpnCert(domain, keyAuth string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(keyAuth))
+	ext, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: domain},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(24 * time.Hour),
+		DNSNames:        []string{domain},
+		ExtraExtensions: []pkix.Extension{{Id: idPeACMEIdentifier, Critical: true, Value: ext}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// writeFile replaces path atomically: a crash never leaves a truncated key
+// or certificate behind.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".acme-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,308 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCA is a minimal ACME server: it checks the JWS of every request and
+// validates challenges by calling the manager directly.
+type fakeCA struct {
+	t       *testing.T
+	srv     *httptest.Server
+	m       *Manager // whose challenges are validated
+	caKey   *ecdsa.PrivateKey
+	caCert  *x509.Certificate
+	mux     sync.Mutex
+	account *ecdsa.PublicKey
+	authz   string // status of the single authorization
+	order   string // status of the single order
+	cert    []byte // PEM chain once finalized
+	orders  int
+}
+
+func newFakeCA(t *testing.T) *fakeCA {
+	ca := &fakeCA{t: t, authz: "pending", order: "pending"}
+	var err error
+	if ca.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Fake ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &ca.caKey.PublicKey, ca.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.caCert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	ca.srv = httptest.NewServer(http.HandlerFunc(ca.serve))
+	t.Cleanup(ca.srv.Close)
+	return ca
+}
+
+func (ca *fakeCA) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", "nonce")
+	if r.URL.Path == "/dir" {
+		json.NewEncoder(w).Encode(directory{
+			NewNonce:   ca.srv.URL + "/nonce",
+			NewAccount: ca.srv.URL + "/account",
+			NewOrder:   ca.srv.URL + "/order",
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		return
+	}
+
+	payload := ca.verify(r)
+	ca.mux.Lock()
+	defer ca.mux.Unlock()
+	switch r.URL.Path {
+	case "/account":
+		w.Header().Set("Location", ca.srv.URL+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	case "/order":
+		ca.orders++
+		w.Header().Set("Location", ca.srv.URL+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		ca.writeOrder(w)
+	case "/order/1":
+		ca.writeOrder(w)
+	case "/authz/1":
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":     ca.authz,
+			"identifier": map[string]string{"type": "dns", "value": "example.test"},
+			"challenges": []map[string]string{
+				{"type": ChallengeHTTP01, "url": ca.srv.URL + "/challenge/http", "token": "token-http"},
+				{"type": ChallengeTLSALPN01, "url": ca.srv.URL + "/challenge/alpn", "token": "token-alpn"},
+			},
+		})
+	case "/challenge/http":
+		ca.validateHTTP("token-http")
+		w.Write([]byte("{}"))
+	case "/challenge/alpn":
+		ca.validateALPN("token-alpn")
+		w.Write([]byte("{}"))
+	case "/finalize":
+		ca.finalize(payload)
+		ca.writeOrder(w)
+	case "/cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(ca.cert)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (ca *fakeCA) writeOrder(w io.Writer) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":         ca.order,
+		"authorizations": []string{ca.srv.URL + "/authz/1"},
+		"finalize":       ca.srv.URL + "/finalize",
+		"certificate":    ca.srv.URL + "/cert",
+	})
+}
+
+// verify checks the JWS of r and returns its payload.
+func (ca *fakeCA) verify(r *http.Request) []byte {
+	ca.t.Helper()
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		ca.t.Errorf("%s: invalid JWS: %v", r.URL.Path, err)
+		return nil
+	}
+	header, _ := b64.DecodeString(jws.Protected)
+	var protected struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  map[string]string
+	}
+	json.Unmarshal(header, &protected)
+	if protected.URL != ca.srv.URL+r.URL.Path || protected.Nonce == "" || protected.Alg != "ES256" {
+		ca.t.Errorf("%s: unexpected protected header %s", r.URL.Path, header)
+	}
+
+	ca.mux.Lock()
+	key := ca.account
+	ca.mux.Unlock()
+	if r.URL.Path == "/account" {
+		x, _ := b64.DecodeString(protected.JWK["x"])
+		y, _ := b64.DecodeString(protected.JWK["y"])
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		ca.mux.Lock()
+		ca.account = key
+		ca.mux.Unlock()
+	} else if protected.Kid != ca.srv.URL+"/account/1" {
+		ca.t.Errorf("%s: expected the account URL as kid, got %q", r.URL.Path, protected.Kid)
+	}
+
+	sig, _ := b64.DecodeString(jws.Signature)
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(sig) != 64 || !ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		ca.t.Errorf("%s: invalid signature", r.URL.Path)
+	}
+	payload, _ := b64.DecodeString(jws.Payload)
+	return payload
+}
+
+func (ca *fakeCA) keyAuthorization(token string) string {
+	data, _ := json.Marshal(jwk(ca.account))
+	thumbprint := sha256.Sum256(data)
+	return token + "." + b64.EncodeToString(thumbprint[:])
+}
+
+func (ca *fakeCA) validateHTTP(token string) {
+	rec := httptest.NewRecorder()
+	ca.m.HTTPHandler(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.test/.well-known/acme-challenge/"+token, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != ca.keyAuthorization(token) {
+		ca.t.Errorf("http-01: got %d %q", rec.Code, rec.Body.String())
+		ca.authz = "invalid"
+		return
+	}
+	ca.authz = "valid"
+}
+
+func (ca *fakeCA) validateALPN(token string) {
+	cert, err := ca.m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.test", SupportedProtos: []string{ALPNProto}})
+	if err != nil {
+		ca.t.Errorf("tls-alpn-01: %v", err)
+		ca.authz = "invalid"
+		return
+	}
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	want := sha256.Sum256([]byte(ca.keyAuthorization(token)))
+	for _, ext := range leaf.Extensions {
+		var got []byte
+		if ext.Id.Equal(idPeACMEIdentifier) && ext.Critical {
+			if _, err := asn1.Unmarshal(ext.Value, &got); err == nil && string(got) == string(want[:]) {
+				ca.authz = "valid"
+				return
+			}
+		}
+	}
+	ca.t.Error("tls-alpn-01: certificate without the expected acmeIdentifier extension")
+	ca.authz = "invalid"
+}
+
+func (ca *fakeCA) finalize(payload []byte) {
+	if ca.authz != "valid" {
+		ca.t.Error("finalize before the authorization is valid")
+		return
+	}
+	var req struct{ CSR string }
+	json.Unmarshal(payload, &req)
+	der, _ := b64.DecodeString(req.CSR)
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		ca.t.Errorf("invalid CSR: %v", err)
+		return
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, template, ca.caCert, csr.PublicKey, ca.caKey)
+	if err != nil {
+		ca.t.Error(err)
+		return
+	}
+	ca.cert = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.caCert.Raw})...)
+	ca.order = "valid"
+}
+
+func TestManager_ObtainsCertificate(t *testing.T) {
+	for _, challenge := range []string{ChallengeHTTP01, ChallengeTLSALPN01} {
+		t.Run(challenge, func(t *testing.T) {
+			ca := newFakeCA(t)
+			m := &Manager{
+				Domains:      []string{"example.test"},
+				Email:        "ops@example.test",
+				CacheDir:     t.TempDir(),
+				DirectoryURL: ca.srv.URL + "/dir",
+				Challenge:    challenge,
+				pollInterval: 10 * time.Millisecond,
+			}
+			ca.m = m
+			if err := m.init(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.test"}); err == nil {
+				t.Fatal("expected no certificate before the order")
+			}
+			if !m.renew(context.Background()) {
+				t.Fatal("expected the certificate to be obtained")
+			}
+
+			cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "Example.test."})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Contains(cert.Leaf.DNSNames, "example.test") || len(cert.Certificate) != 2 {
+				t.Errorf("unexpected certificate: %v, chain of %d", cert.Leaf.DNSNames, len(cert.Certificate))
+			}
+			if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.test", SupportedProtos: []string{ALPNProto}}); err == nil {
+				t.Error("the challenge certificate must be gone once validated")
+			}
+
+			// A restart serves the cached certificate without a new order.
+			restarted := &Manager{Domains: m.Domains, CacheDir: m.CacheDir, DirectoryURL: m.DirectoryURL}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if err := restarted.Start(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := restarted.GetCertificate(&tls.ClientHelloInfo{}); err != nil {
+				t.Errorf("expected the cached certificate to be served: %v", err)
+			}
+			restarted.renew(ctx)
+			ca.mux.Lock()
+			defer ca.mux.Unlock()
+			if ca.orders != 1 {
+				t.Errorf("expected 1 order, got %d", ca.orders)
+			}
+		})
+	}
+}
+
+func TestHTTPHandler_RedirectsToHTTPS(t *testing.T) {
+	m := &Manager{}
+	rec := httptest.NewRecorder()
+	m.HTTPHandler(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.test/a?b=c", nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://example.test/a?b=c" {
+		t.Errorf("got %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+	rec = httptest.NewRecorder()
+	m.HTTPHandler(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.test/.well-known/acme-challenge/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown token, got %d", rec.Code)
+	}
+}
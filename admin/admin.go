@@ -1,27 +1,377 @@
 package admin
 
 import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"reverse-proxy/cache"
+	"reverse-proxy/limit"
 	"reverse-proxy/pool"
+	"reverse-proxy/proxy"
+	"reverse-proxy/route"
+	"reverse-proxy/state"
+	"reverse-proxy/tcpproxy"
+	"slices"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 )
 
 type BackendStatus struct {
+	ID           string `json:"id"` // stable, see pool.Backend.ID
 	URL          string `json:"url"`
 	Alive        bool   `json:"alive"`
+	AdminDown    bool   `json:"admin_down"`
+	Ejected      bool   `json:"ejected"` // taken out by outlier detection
 	CurrentConns int64  `json:"current_connections"`
+	Weight       int    `json:"weight"` // used by the weighted strategies
+
+	Tags   map[string]string `json:"tags,omitempty"` // metadata routes filter backends by
+	Stats  pool.BackendStats `json:"stats"`
+	Uptime pool.Uptime       `json:"uptime"` // availability from the health transitions
 }
 
 type StatusResponse struct {
 	TotalBackends  int             `json:"total_backends"`
 	ActiveBackends int             `json:"active_backends"`
 	Backends       []BackendStatus `json:"backends"`
+
+	// Requests served by the proxy right now, with Options.Tracker.
+	InFlight    int64 `json:"in_flight"`
+	MaxInFlight int64 `json:"max_in_flight,omitempty"` // 0 = unlimited
+	Shed        int64 `json:"shed_requests"`           // rejected beyond max_in_flight since start
+}
+
+// GroupStatus is one weighted backend group of a route.
+type GroupStatus struct {
+	Name     string   `json:"name"`
+	Weight   int      `json:"weight"`
+	Priority int      `json:"priority"`
+	Active   bool     `json:"active"` // in the priority tier getting the traffic
+	Backends []string `json:"backends"`
+}
+
+// RouteStatus describes a route and, when it splits traffic, its groups.
+type RouteStatus struct {
+	Name       string        `json:"name"`
+	Host       string        `json:"host,omitempty"`
+	PathPrefix string        `json:"path_prefix"`
+	Rule       string        `json:"rule,omitempty"`
+	Backends   int           `json:"backends"`
+	Groups     []GroupStatus `json:"groups,omitempty"`
+}
+
+// ReadyResponse is the body of /readyz.
+type ReadyResponse struct {
+	Ready     bool `json:"ready"`
+	Available int  `json:"available_backends"`
+	Required  int  `json:"required_backends"`
+	Draining  bool `json:"draining,omitempty"` // shutting down: never ready again
+}
+
+// PoolStatus is the load-balancing strategy of a route's pool, with those it
+// can switch to.
+type PoolStatus struct {
+	Route      string   `json:"route"`
+	Strategy   string   `json:"strategy"`
+	Strategies []string `json:"strategies"`
+}
+
+// RouteDecision is the answer of the routing dry run: the matched route, the
+// IP filter verdict and the backend the strategy picked.
+type RouteDecision struct {
+	Route      RouteStatus     `json:"route"`
+	Allowed    bool            `json:"allowed"`
+	Reason     string          `json:"reason,omitempty"`  // why the request would be rejected or fail, or why no backend is given
+	Backend    string          `json:"backend,omitempty"` // empty when nothing would be forwarded or the strategy is random
+	Group      string          `json:"group,omitempty"`   // canary group of the backend, if any
+	Candidates []BackendStatus `json:"candidates"`
+}
+
+// TCPConnStatus is one connection proxied by a TCP listener.
+type TCPConnStatus struct {
+	ID       uint64    `json:"id"`
+	Client   string    `json:"client"`
+	Backend  string    `json:"backend"`
+	Started  time.Time `json:"started"`
+	BytesIn  int64     `json:"bytes_in"`  // client → backend
+	BytesOut int64     `json:"bytes_out"` // backend → client
+}
+
+// TCPStatus describes a TCP proxy listener, its backends and open connections.
+type TCPStatus struct {
+	Name        string          `json:"name"`
+	Backends    []BackendStatus `json:"backends"`
+	Connections []TCPConnStatus `json:"connections"`
 }
-func Start(serverPool pool.LoadBalancer, port int) {
+
+// Options carries the optional parts of the proxy the admin API can manage.
+type Options struct {
+	Routes *route.Table       // enables /routes; nil hides it
+	TCP    []*tcpproxy.Server // enables /tcp when TCP proxy listeners run
+	Cache  *cache.Cache       // enables /cache; nil when caching is off
+
+	// Faults enables /faults; nil when fault injection is off.
+	Faults *proxy.FaultInjector
+
+	// Tracker fills the in-flight counters of /status.
+	Tracker *proxy.Tracker
+
+	// IPFilters enables /ipfilter, keyed by scope: "global" or a route name.
+	IPFilters map[string]*limit.IPFilter
+
+	// OnChange runs after every successful change to the backends or to the
+	// route weights, e.g. to persist them.
+	OnChange func()
+
+	// ReadyMinBackends is how many available backends /readyz requires,
+	// counted over every route (default 1).
+	ReadyMinBackends int
+
+	// Draining, when set and true, fails /readyz whatever the backends,
+	// e.g. during the shutdown delay.
+	Draining func() bool
+
+	// Reload enables POST /reload. It returns the backend list of the
+	// default pool as currently written in the config file.
+	Reload func() ([]string, error)
+
+	// ReloadCerts enables POST /certs/reload. It re-reads the certificate of
+	// the TLS listener and describes the one now served.
+	ReloadCerts func() (CertificateStatus, error)
+
+	// Token, when set, is required as "Authorization: Bearer <token>" on
+	// every endpoint but the /healthz and /readyz probes.
+	Token string
+
+	// Tokens are more credentials accepted like Token, each with a role;
+	// Token itself acts as an operator token named "token".
+	Tokens []Token
+
+	// Debug enables the net/http/pprof profiles under /debug/pprof/ and the
+	// runtime stats of /debug/runtime.
+	Debug bool
+
+	// Audit, when set, records every mutating request and enables GET
+	// /audit to read the log back.
+	Audit *AuditLog
+}
+
+func (o Options) changed() {
+	if o.OnChange != nil {
+		o.OnChange()
+	}
+}
+
+// ReplaceResponse reports what PUT /backends, POST /reload or POST
+// /config/import changed; backends in both the old and the new list are kept
+// untouched.
+type ReplaceResponse struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// backendReplacer is implemented by pools that can swap their whole backend
+// list at once, such as pool.ServerPool.
+type backendReplacer interface {
+	ReplaceBackends([]*pool.Backend) (added, removed []*pool.Backend)
+}
+
+// weightSetter is implemented by pools whose backends carry a weight, such
+// as pool.ServerPool.
+type weightSetter interface {
+	SetBackendWeight(*url.URL, int) bool
+}
+
+// strategySwitcher is implemented by pools whose strategy can change at
+// runtime, such as pool.ServerPool and pool.GroupedPool.
+type strategySwitcher interface {
+	SetStrategy(string) error
+	StrategyName() string
+}
+
+// peerPreviewer is implemented by pools that can tell which backend they
+// would pick without consuming the pick, such as pool.ServerPool and
+// pool.GroupedPool.
+type peerPreviewer interface {
+	PeekNextValidPeer() (*pool.Backend, bool)
+}
+
+// CertificateStatus describes the certificate served by the TLS listener.
+type CertificateStatus struct {
+	File     string    `json:"file"`
+	Subject  string    `json:"subject"`
+	DNSNames []string  `json:"dns_names"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// IPFilterStatus lists the ranges of one filter scope.
+type IPFilterStatus struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// Start serves the admin API on the given port in a background goroutine.
+// The returned server lets the caller shut it down.
+func Start(serverPool pool.LoadBalancer, port int, opts Options) *http.Server {
+	srv := NewServer(serverPool, opts)
+	srv.Addr = fmt.Sprintf(":%d", port)
+
+	// ---------- START ADMIN SERVER ----------
+	log.Printf("Admin API running on :%d\n", port)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Admin server error: %v", err)
+		}
+	}()
+	return srv
+}
+
+// NewServer returns the admin API server without starting it, for callers
+// that bring their own listener.
+func NewServer(serverPool pool.LoadBalancer, opts Options) *http.Server {
+	// /events streams never go idle: cancelling their context on Shutdown
+	// ends them instead of holding the shutdown until its deadline.
+	base, cancel := context.WithCancel(context.Background())
+	srv := &http.Server{
+		Handler:     Handler(serverPool, opts),
+		BaseContext: func(net.Listener) context.Context { return base },
+	}
+	srv.RegisterOnShutdown(cancel)
+	return srv
+}
+
+// openAPISpec describes the v1 API. Keep it in step with the handlers below.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// Handler returns the admin API routes without starting a listener, so the
+// API can be mounted on any server (tests, self-test mode, ...).
+//
+// The routes live under /v1/; the unversioned paths are kept as aliases of
+// v1 for existing clients. A future breaking change gets its own /v2/ tree.
+func Handler(serverPool pool.LoadBalancer, opts Options) *http.ServeMux {
+	root := http.NewServeMux()
+	v1 := v1Handler(serverPool, opts)
+	root.Handle("/v1/", http.StripPrefix("/v1", v1))
+	root.Handle("/", v1)
+
+	// ---------- OPENAPI ----------
+	spec := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPISpec)
+	}
+	root.HandleFunc("/openapi.json", spec)
+	root.HandleFunc("/v1/openapi.json", spec)
+
+	// ---------- DASHBOARD ----------
+	// A single page over /status, /routes and /events, for operators
+	// without their own viewer.
+	root.HandleFunc("/dashboard", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Write(dashboardPage)
+	})
+
+	// ---------- DEBUG ----------
+	if opts.Debug {
+		registerDebug(root)
+	}
+
+	var h http.Handler = root
+	if opts.Audit != nil {
+		h = opts.Audit.middleware(serverPool, opts.Routes, h)
+	}
+	tokens := opts.Tokens
+	if opts.Token != "" {
+		tokens = append(slices.Clone(tokens), Token{Name: "token", Secret: opts.Token, Role: RoleOperator})
+	}
+	if len(tokens) > 0 {
+		h = requireToken(tokens, h)
+	}
+	if h == http.Handler(root) {
+		return root
+	}
+	wrapped := http.NewServeMux()
+	wrapped.Handle("/", h)
+	return wrapped
+}
+
+// public paths answer without a token: orchestrators don't carry one to the
+// probes, and the dashboard page holds no data (its API calls carry it).
+var public = map[string]bool{"/healthz": true, "/readyz": true, "/v1/healthz": true, "/v1/readyz": true, "/dashboard": true}
+
+// Role is what an admin token is allowed to do.
+type Role string
+
+const (
+	// RoleReadOnly reads the API (GET and HEAD) but cannot change anything
+	// nor reach /debug/.
+	RoleReadOnly Role = "read-only"
+	// RoleOperator can do everything.
+	RoleOperator Role = "operator"
+)
+
+// Token is one admin credential. Name identifies its holder in the audit
+// log.
+type Token struct {
+	Name   string `json:"name"`
+	Secret string `json:"token"`
+	Role   Role   `json:"role"`
+}
+
+// requireToken rejects requests without one of the bearer tokens with 401,
+// and those the token's role does not allow with 403.
+func requireToken(tokens []Token, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if public[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		got := []byte(r.Header.Get("Authorization"))
+		var match *Token
+		// Every token is compared, so the timing tells nothing of which one
+		// came close.
+		for i := range tokens {
+			if subtle.ConstantTimeCompare(got, []byte("Bearer "+tokens[i].Secret)) == 1 {
+				match = &tokens[i]
+			}
+		}
+		if match == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if match.Role != RoleOperator && (r.Method != http.MethodGet && r.Method != http.MethodHead ||
+			strings.HasPrefix(r.URL.Path, "/debug/")) {
+			http.Error(w, "Forbidden: read-only token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, withActor(r, match.Name))
+	})
+}
+
+func v1Handler(serverPool pool.LoadBalancer, opts Options) *http.ServeMux {
 	adminMux := http.NewServeMux()
 
 	// ---------- STATUS ----------
@@ -37,24 +387,61 @@ func Start(serverPool pool.LoadBalancer, port int) {
 		}
 
 		for _, b := range backends {
-			if b.IsAlive() {
+			if b.IsAvailable() {
 				resp.ActiveBackends++
 			}
-			resp.Backends = append(resp.Backends, BackendStatus{
-				URL:          b.URL.String(),
-				Alive:        b.IsAlive(),
-				CurrentConns: atomic.LoadInt64(&b.CurrentConns),
-			})
+			resp.Backends = append(resp.Backends, backendStatus(b))
+		}
+		if t := opts.Tracker; t != nil {
+			resp.InFlight, resp.MaxInFlight, resp.Shed = t.InFlight(), t.Max, t.Shed()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	// ---------- LIVENESS & READINESS ----------
+	// /healthz only tells the process answers; /readyz tells whether it can
+	// serve, so an orchestrator stops sending traffic to a proxy whose
+	// backends are all down.
+	adminMux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	})
+	adminMux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		resp := ReadyResponse{Required: max(opts.ReadyMinBackends, 1)}
+		seen := map[*pool.Backend]bool{}
+		pools := []pool.LoadBalancer{serverPool}
+		if opts.Routes != nil {
+			for _, rt := range opts.Routes.Routes() {
+				pools = append(pools, rt.Pool)
+			}
 		}
+		for _, p := range pools {
+			for _, b := range p.GetBackends() {
+				if !seen[b] && b.IsAvailable() {
+					resp.Available++
+				}
+				seen[b] = true
+			}
+		}
+		resp.Draining = opts.Draining != nil && opts.Draining()
+		resp.Ready = resp.Available >= resp.Required && !resp.Draining
 
 		w.Header().Set("Content-Type", "application/json")
+		if !resp.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
 		json.NewEncoder(w).Encode(resp)
 	})
 
 	// ---------- BACKENDS MANAGEMENT ----------
 	adminMux.HandleFunc("/backends", func(w http.ResponseWriter, r *http.Request) {
 		var body struct {
-			URL string `json:"url"`
+			URL    string `json:"url"`
+			ID     string `json:"id"`     // DELETE/PATCH: instead of url
+			Action string `json:"action"` // PATCH only: "enable" | "disable"
+			Force  bool   `json:"force"`  // DELETE only: cancel the requests in flight
 		}
 
 		switch r.Method {
@@ -65,28 +452,31 @@ func Start(serverPool pool.LoadBalancer, port int) {
 				return
 			}
 
-			parsedURL, err := url.Parse(body.URL)
-			if err != nil || parsedURL.Host == "" {
+			parsedURL, err := pool.ParseBackendURL(body.URL)
+			if err != nil {
 				http.Error(w, "Invalid URL", http.StatusBadRequest)
 				return
 			}
 
+			// Add as alive: false — the health checker will verify and enable it
+			// on the next tick. This prevents routing traffic to an unverified backend.
+			added := &pool.Backend{URL: parsedURL}
+
 			// Check for duplicates
 			for _, b := range serverPool.GetBackends() {
-				if b.URL.String() == parsedURL.String() {
+				if b.ID() == added.ID() {
 					http.Error(w, "Backend already exists", http.StatusConflict)
 					return
 				}
 			}
 
-			// Add as alive: false — the health checker will verify and enable it
-			// on the next tick. This prevents routing traffic to an unverified backend.
-			serverPool.AddBackend(&pool.Backend{
-				URL: parsedURL,
-			})
+			serverPool.AddBackend(added)
 
 			log.Printf("Backend added (pending health check): %s", parsedURL.String())
+			opts.changed()
+			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(backendStatus(added))
 
 		case http.MethodDelete:
 			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
@@ -94,30 +484,884 @@ func Start(serverPool pool.LoadBalancer, port int) {
 				return
 			}
 
-			parsedURL, err := url.Parse(body.URL)
-			if err != nil || parsedURL.Host == "" {
-				http.Error(w, "Invalid URL", http.StatusBadRequest)
+			parsedURL, status, msg := targetURL(serverPool, body.ID, body.URL)
+			if parsedURL == nil {
+				http.Error(w, msg, status)
 				return
 			}
 
-			if !serverPool.RemoveBackend(parsedURL) {
+			if !removeBackend(serverPool, parsedURL, body.Force) {
 				http.Error(w, "Backend not found", http.StatusNotFound)
 				return
 			}
 
-			log.Printf("Backend removed: %s", parsedURL.String())
+			opts.changed()
 			w.WriteHeader(http.StatusNoContent)
 
+		case http.MethodPatch:
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+
+			parsedURL, status, msg := targetURL(serverPool, body.ID, body.URL)
+			if parsedURL == nil {
+				http.Error(w, msg, status)
+				return
+			}
+
+			var disable bool
+			switch body.Action {
+			case "disable":
+				disable = true
+			case "enable":
+				disable = false
+			default:
+				http.Error(w, "Invalid action (must be 'enable' or 'disable')", http.StatusBadRequest)
+				return
+			}
+
+			// Maintenance mode is independent of health: a disabled backend
+			// stays out of rotation even if its health checks pass.
+			if !serverPool.SetBackendAdminDown(parsedURL, disable) {
+				http.Error(w, "Backend not found", http.StatusNotFound)
+				return
+			}
+
+			log.Printf("Backend %sd by admin: %s", body.Action, parsedURL.String())
+			opts.changed()
+			w.WriteHeader(http.StatusNoContent)
+
+		case http.MethodPut:
+			replacer, ok := serverPool.(backendReplacer)
+			if !ok {
+				http.Error(w, "Pool does not support replacing its backends", http.StatusNotImplemented)
+				return
+			}
+			var desired struct {
+				Backends []string `json:"backends"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&desired); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			if len(desired.Backends) == 0 {
+				http.Error(w, "Backend list must not be empty", http.StatusBadRequest)
+				return
+			}
+
+			replaceBackends(w, replacer, desired.Backends, "admin", opts)
+
 		default:
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
 
-	// ---------- START ADMIN SERVER ----------
-	log.Printf("Admin API running on :%d\n", port)
-	go func() {
-		if err := http.ListenAndServe(fmt.Sprintf(":%d", port), adminMux); err != nil {
-			log.Printf("Admin server error: %v", err)
+	// ---------- SINGLE BACKEND ----------
+	// /backends/{id}: the backend's ID, its URL, path-escaped, or its
+	// host:port when no other backend of the pool shares it.
+	adminMux.HandleFunc("/backends/", func(w http.ResponseWriter, r *http.Request) {
+		b, status, msg := findBackend(serverPool, strings.TrimPrefix(r.URL.Path, "/backends/"))
+		if b == nil {
+			http.Error(w, msg, status)
+			return
 		}
-	}()
-}
\ No newline at end of file
+
+		switch r.Method {
+		case http.MethodGet:
+			// The backend's status, as after a PATCH.
+
+		case http.MethodPatch:
+			var body struct {
+				Weight *int              `json:"weight"`
+				Action string            `json:"action"` // "enable" | "disable"
+				Tags   map[string]string `json:"tags"`   // replaces the tags; {} removes them
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			if body.Weight == nil && body.Action == "" && body.Tags == nil {
+				http.Error(w, `Nothing to change: expected "weight", "action" and/or "tags"`, http.StatusBadRequest)
+				return
+			}
+			if _, ok := body.Tags[""]; ok {
+				http.Error(w, "Tag names must not be empty", http.StatusBadRequest)
+				return
+			}
+			if body.Action != "" && body.Action != "enable" && body.Action != "disable" {
+				http.Error(w, `Action must be "enable" or "disable"`, http.StatusBadRequest)
+				return
+			}
+			if body.Weight != nil && *body.Weight < 0 {
+				http.Error(w, "Weight must not be negative", http.StatusBadRequest)
+				return
+			}
+			setter, ok := serverPool.(weightSetter)
+			if body.Weight != nil && !ok {
+				http.Error(w, "Pool does not support backend weights", http.StatusNotImplemented)
+				return
+			}
+
+			// Lookups and changes are not atomic: a backend removed in between
+			// is reported as not found.
+			if body.Weight != nil {
+				if !setter.SetBackendWeight(b.URL, *body.Weight) {
+					http.Error(w, "Backend not found", http.StatusNotFound)
+					return
+				}
+				log.Printf("Backend weight set to %d by admin: %s", *body.Weight, b.URL.String())
+			}
+			if body.Action != "" {
+				if !serverPool.SetBackendAdminDown(b.URL, body.Action == "disable") {
+					http.Error(w, "Backend not found", http.StatusNotFound)
+					return
+				}
+				log.Printf("Backend %sd by admin: %s", body.Action, b.URL.String())
+			}
+			if body.Tags != nil {
+				b.SetTags(body.Tags)
+				log.Printf("Backend tags set to %v by admin: %s", body.Tags, b.URL.String())
+			}
+			opts.changed()
+
+		case http.MethodDelete:
+			force := false
+			if raw := r.URL.Query().Get("force"); raw != "" {
+				var err error
+				if force, err = strconv.ParseBool(raw); err != nil {
+					http.Error(w, "Invalid force (must be true or false)", http.StatusBadRequest)
+					return
+				}
+			}
+			if !removeBackend(serverPool, b.URL, force) {
+				http.Error(w, "Backend not found", http.StatusNotFound)
+				return
+			}
+			opts.changed()
+			w.WriteHeader(http.StatusNoContent)
+			return
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backendStatus(b))
+	})
+
+	// ---------- RELOAD ----------
+	// Re-reads the backend list of the config file and reconciles the pool
+	// with it, as PUT /backends would.
+	if opts.Reload != nil {
+		adminMux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			replacer, ok := serverPool.(backendReplacer)
+			if !ok {
+				http.Error(w, "Pool does not support replacing its backends", http.StatusNotImplemented)
+				return
+			}
+			urls, err := opts.Reload()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Reload failed: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if len(urls) == 0 {
+				http.Error(w, "Reload failed: config has no backends", http.StatusInternalServerError)
+				return
+			}
+			replaceBackends(w, replacer, urls, "reload", opts)
+		})
+	}
+
+	// ---------- TLS CERTIFICATE RELOAD ----------
+	// Swaps in the certificate files of the listener without a restart: new
+	// handshakes get the new certificate, open connections are untouched.
+	if opts.ReloadCerts != nil {
+		adminMux.HandleFunc("/certs/reload", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			cert, err := opts.ReloadCerts()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Certificate reload failed, keeping the current one: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(cert)
+		})
+	}
+
+	// ---------- CONFIG EXPORT / IMPORT ----------
+	// A snapshot of the runtime configuration (backends, maintenance flags,
+	// weights), in the format of the state file: back it up, or promote the
+	// one of staging to production.
+	adminMux.HandleFunc("/config/export", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state.Capture(serverPool, opts.Routes))
+	})
+
+	// The snapshot is validated as a whole first: a bad one leaves
+	// everything untouched.
+	adminMux.HandleFunc("/config/import", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		replacer, ok := serverPool.(backendReplacer)
+		if !ok {
+			http.Error(w, "Pool does not support replacing its backends", http.StatusNotImplemented)
+			return
+		}
+		var st state.State
+		if err := json.NewDecoder(r.Body).Decode(&st); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := st.Validate(serverPool, opts.Routes); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid snapshot: %v", err), http.StatusBadRequest)
+			return
+		}
+		backends := make([]*pool.Backend, 0, len(st.Backends))
+		for _, b := range st.Backends {
+			parsedURL, _ := url.Parse(b.URL) // checked by Validate
+			backends = append(backends, &pool.Backend{URL: parsedURL})
+		}
+
+		resp := reconcile(replacer, backends, "import")
+		st.Apply(serverPool, opts.Routes)
+		opts.changed()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	// ---------- ROUTES & CANARY WEIGHTS ----------
+	if opts.Routes != nil {
+		adminMux.HandleFunc("/routes", func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+
+			case http.MethodGet:
+				resp := []RouteStatus{}
+				for _, rt := range opts.Routes.Routes() {
+					resp = append(resp, routeStatus(rt))
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(resp)
+
+			case http.MethodPatch:
+				var body struct {
+					Route   string         `json:"route"`
+					Weights map[string]int `json:"weights"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					http.Error(w, "Invalid JSON", http.StatusBadRequest)
+					return
+				}
+
+				rt := opts.Routes.Get(body.Route)
+				if rt == nil {
+					http.Error(w, "Route not found", http.StatusNotFound)
+					return
+				}
+				groups := rt.Groups()
+				if groups == nil {
+					http.Error(w, "Route has no backend groups", http.StatusBadRequest)
+					return
+				}
+
+				// Validate everything before applying, so a bad request never
+				// leaves the split half-updated.
+				total := 0
+				for _, g := range groups {
+					weight := g.Weight()
+					if v, ok := body.Weights[g.Name]; ok {
+						weight = v
+					}
+					if weight < 0 {
+						http.Error(w, "Weights must not be negative", http.StatusBadRequest)
+						return
+					}
+					total += weight
+				}
+				for name := range body.Weights {
+					if !hasGroup(groups, name) {
+						http.Error(w, fmt.Sprintf("Group not found: %s", name), http.StatusNotFound)
+						return
+					}
+				}
+				if total == 0 {
+					http.Error(w, "At least one group needs a positive weight", http.StatusBadRequest)
+					return
+				}
+
+				for _, g := range groups {
+					if weight, ok := body.Weights[g.Name]; ok {
+						g.SetWeight(weight)
+					}
+				}
+				log.Printf("Route %s weights updated by admin: %v", rt.Name, body.Weights)
+				opts.changed()
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(routeStatus(rt))
+
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		})
+	}
+
+	// ---------- POOL STRATEGY ----------
+	// The strategy of the default pool, or of the route given by ?route=
+	// (GET) or "route" (PATCH), switched without a restart.
+	adminMux.HandleFunc("/pool", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Route    string `json:"route"`
+			Strategy string `json:"strategy"`
+		}
+		switch r.Method {
+		case http.MethodGet:
+			body.Route = r.URL.Query().Get("route")
+		case http.MethodPatch:
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			if body.Strategy == "" {
+				http.Error(w, `Nothing to change: expected "strategy"`, http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name, lb := "default", serverPool
+		if body.Route != "" && body.Route != name {
+			var rt *route.Route
+			if opts.Routes != nil {
+				rt = opts.Routes.Get(body.Route)
+			}
+			if rt == nil {
+				http.Error(w, "Route not found", http.StatusNotFound)
+				return
+			}
+			name, lb = rt.Name, rt.Pool
+		}
+		switcher, ok := lb.(strategySwitcher)
+		if !ok {
+			http.Error(w, "Pool does not support switching strategies", http.StatusNotImplemented)
+			return
+		}
+
+		if r.Method == http.MethodPatch && body.Strategy != switcher.StrategyName() {
+			if err := switcher.SetStrategy(body.Strategy); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("Route %s switched to strategy %s by admin", name, body.Strategy)
+			opts.changed()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PoolStatus{Route: name, Strategy: switcher.StrategyName(), Strategies: pool.Strategies()})
+	})
+
+	// ---------- ROUTING DRY RUN ----------
+	// Reports how a request would be routed, without forwarding anything.
+	if opts.Routes != nil {
+		adminMux.HandleFunc("/route", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			q := r.URL.Query()
+			probe := &http.Request{Method: http.MethodGet, Host: q.Get("host"), URL: &url.URL{Path: q.Get("path"), RawQuery: q.Get("query")}, Header: http.Header{}}
+			if probe.URL.Path == "" {
+				probe.URL.Path = "/"
+			}
+			if m := q.Get("method"); m != "" {
+				probe.Method = strings.ToUpper(m)
+			}
+			// For route rules: header=X-Beta:true, repeatable.
+			for _, h := range q["header"] {
+				name, value, ok := strings.Cut(h, ":")
+				if !ok {
+					http.Error(w, "Invalid header, expected Name:value", http.StatusBadRequest)
+					return
+				}
+				probe.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+			}
+			var ip net.IP
+			if raw := q.Get("ip"); raw != "" {
+				if ip = net.ParseIP(raw); ip == nil {
+					http.Error(w, "Invalid ip", http.StatusBadRequest)
+					return
+				}
+			}
+
+			rt := opts.Routes.Match(probe)
+			if rt == nil {
+				http.Error(w, "No route matches", http.StatusNotFound)
+				return
+			}
+			resp := RouteDecision{Route: routeStatus(rt), Allowed: true, Candidates: []BackendStatus{}}
+			if ip != nil {
+				for _, scope := range []string{"global", rt.Name} {
+					if f := opts.IPFilters[scope]; f != nil {
+						if ok, reason := f.Check(ip); !ok {
+							resp.Allowed, resp.Reason = false, fmt.Sprintf("%s ip_filter: %s", scope, reason)
+							break
+						}
+					}
+				}
+			}
+			for _, b := range rt.Pool.GetBackends() {
+				resp.Candidates = append(resp.Candidates, backendStatus(b))
+			}
+			if resp.Allowed {
+				// A dry run must not take the next request's turn: only
+				// preview the pick.
+				var b *pool.Backend
+				previewer, ok := rt.Pool.(peerPreviewer)
+				if ok {
+					b, ok = previewer.PeekNextValidPeer()
+				}
+				switch {
+				case !ok:
+					resp.Reason = "the strategy picks at random among the candidates"
+				case b == nil:
+					resp.Reason = "no backend available"
+				default:
+					resp.Backend = b.URL.String()
+					for _, g := range rt.Groups() {
+						if slices.Contains(g.Pool.GetBackends(), b) {
+							resp.Group = g.Name
+						}
+					}
+				}
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+		})
+	}
+
+	// ---------- AUDIT LOG ----------
+	if opts.Audit != nil {
+		adminMux.HandleFunc("/audit", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			since, limit, err := sinceLimitQuery(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			entries, err := opts.Audit.Entries(since, limit)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to read the audit log: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(entries)
+		})
+	}
+
+	// ---------- STATE HISTORY ----------
+	// The last pool.HistorySize state transitions of every pool, for
+	// post-incident analysis; /events only shows what happens while watching.
+	adminMux.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		since, limit, err := sinceLimitQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		transitions := pool.History(since, 0)
+		if backend := r.URL.Query().Get("backend"); backend != "" {
+			transitions = slices.DeleteFunc(transitions, func(t pool.Transition) bool {
+				return t.ID != backend && t.URL != backend
+			})
+		}
+		if limit > 0 && len(transitions) > limit {
+			transitions = transitions[len(transitions)-limit:]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(transitions)
+	})
+
+	// ---------- EVENT STREAM ----------
+	// Server-Sent Events: one "event: <type>" message per pool change, with
+	// the pool.Event as JSON data, so dashboards need not poll /status.
+	adminMux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		events, cancel := pool.SubscribeAll(64)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		rc := http.NewResponseController(w)
+		rc.Flush()
+
+		// Comments keep idle connections open through intermediaries.
+		keepAlive := time.NewTicker(15 * time.Second)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case e := <-events:
+				data, _ := json.Marshal(e)
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case <-r.Context().Done():
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	// ---------- IP ALLOW/DENY LISTS ----------
+	if len(opts.IPFilters) > 0 {
+		adminMux.HandleFunc("/ipfilter", func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+
+			case http.MethodGet:
+				resp := map[string]IPFilterStatus{}
+				for scope, f := range opts.IPFilters {
+					allow, deny := f.Lists()
+					resp[scope] = IPFilterStatus{Allow: allow, Deny: deny}
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(resp)
+
+			case http.MethodPost, http.MethodDelete:
+				var body struct {
+					Scope string `json:"scope"` // defaults to "global"
+					List  string `json:"list"`  // "allow" | "deny"
+					CIDR  string `json:"cidr"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					http.Error(w, "Invalid JSON", http.StatusBadRequest)
+					return
+				}
+				if body.Scope == "" {
+					body.Scope = "global"
+				}
+				f, ok := opts.IPFilters[body.Scope]
+				if !ok {
+					http.Error(w, fmt.Sprintf("Scope not found: %s", body.Scope), http.StatusNotFound)
+					return
+				}
+
+				if r.Method == http.MethodPost {
+					added, err := f.Add(body.List, body.CIDR)
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					if !added {
+						http.Error(w, "Entry already exists", http.StatusConflict)
+						return
+					}
+					log.Printf("IP filter %s: %s added to %s list by admin", body.Scope, body.CIDR, body.List)
+					w.WriteHeader(http.StatusCreated)
+					return
+				}
+
+				removed, err := f.Remove(body.List, body.CIDR)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				if !removed {
+					http.Error(w, "Entry not found", http.StatusNotFound)
+					return
+				}
+				log.Printf("IP filter %s: %s removed from %s list by admin", body.Scope, body.CIDR, body.List)
+				w.WriteHeader(http.StatusNoContent)
+
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		})
+	}
+
+	// ---------- FAULT INJECTION ----------
+	if opts.Faults != nil {
+		adminMux.HandleFunc("/faults", func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+
+			case http.MethodGet:
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(opts.Faults.Rules())
+
+			case http.MethodPut:
+				var rules []proxy.FaultRule
+				if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+					http.Error(w, "Invalid JSON", http.StatusBadRequest)
+					return
+				}
+				for _, rule := range rules {
+					if rule.Route != "" && opts.Routes != nil && opts.Routes.Get(rule.Route) == nil {
+						http.Error(w, fmt.Sprintf("Route not found: %s", rule.Route), http.StatusNotFound)
+						return
+					}
+				}
+				if err := opts.Faults.SetRules(rules); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				log.Printf("Fault injection: %d rules set by admin", len(rules))
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(opts.Faults.Rules())
+
+			case http.MethodDelete:
+				opts.Faults.SetRules(nil)
+				log.Println("Fault injection: rules cleared by admin")
+				w.WriteHeader(http.StatusNoContent)
+
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		})
+	}
+
+	// ---------- RESPONSE CACHE ----------
+	if opts.Cache != nil {
+		adminMux.HandleFunc("/cache", func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+
+			case http.MethodGet:
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(opts.Cache.Stats())
+
+			case http.MethodDelete:
+				// The body is optional: no body purges everything.
+				var body struct {
+					Prefix string `json:"prefix"`
+				}
+				if r.ContentLength != 0 {
+					if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+						http.Error(w, "Invalid JSON", http.StatusBadRequest)
+						return
+					}
+				}
+				purged := opts.Cache.Purge(body.Prefix)
+				log.Printf("Cache purged by admin (prefix %q): %d entries", body.Prefix, purged)
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		})
+	}
+
+	// ---------- TCP PROXY ----------
+	if len(opts.TCP) > 0 {
+		adminMux.HandleFunc("/tcp", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			resp := []TCPStatus{}
+			for _, srv := range opts.TCP {
+				status := TCPStatus{Name: srv.Name, Backends: []BackendStatus{}, Connections: []TCPConnStatus{}}
+				for _, b := range srv.Pool.GetBackends() {
+					status.Backends = append(status.Backends, backendStatus(b))
+				}
+				for _, c := range srv.Connections() {
+					status.Connections = append(status.Connections, TCPConnStatus{
+						ID:       c.ID,
+						Client:   c.Client,
+						Backend:  c.Backend,
+						Started:  c.Started,
+						BytesIn:  c.BytesIn(),
+						BytesOut: c.BytesOut(),
+					})
+				}
+				resp = append(resp, status)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+		})
+	}
+
+	return adminMux
+}
+
+// replaceBackends reconciles the pool with urls and writes the
+// ReplaceResponse. The whole list is validated first: a bad URL leaves the
+// pool untouched.
+func replaceBackends(w http.ResponseWriter, replacer backendReplacer, urls []string, by string, opts Options) {
+	backends := make([]*pool.Backend, 0, len(urls))
+	for _, raw := range urls {
+		parsedURL, err := pool.ParseBackendURL(raw)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid URL: %s", raw), http.StatusBadRequest)
+			return
+		}
+		// New backends wait for the health checker, as with POST.
+		backends = append(backends, &pool.Backend{URL: parsedURL})
+	}
+
+	resp := reconcile(replacer, backends, by)
+	if len(resp.Added)+len(resp.Removed) > 0 {
+		opts.changed()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// reconcile swaps the backend list of the pool for backends and reports the
+// difference.
+func reconcile(replacer backendReplacer, backends []*pool.Backend, by string) ReplaceResponse {
+	added, removed := replacer.ReplaceBackends(backends)
+	resp := ReplaceResponse{Added: []string{}, Removed: []string{}}
+	for _, b := range added {
+		resp.Added = append(resp.Added, b.URL.String())
+	}
+	for _, b := range removed {
+		resp.Removed = append(resp.Removed, b.URL.String())
+	}
+	log.Printf("Backends replaced by %s: %d added, %d removed", by, len(added), len(removed))
+	return resp
+}
+
+// targetURL resolves the backend named in the body of DELETE/PATCH
+// /backends: by ID when given, else by URL. On failure it returns the HTTP
+// status and message to answer with.
+func targetURL(serverPool pool.LoadBalancer, id, rawURL string) (*url.URL, int, string) {
+	if id != "" {
+		for _, b := range serverPool.GetBackends() {
+			if b.ID() == id {
+				return b.URL, 0, ""
+			}
+		}
+		return nil, http.StatusNotFound, "Backend not found"
+	}
+	parsedURL, err := pool.ParseBackendURL(rawURL)
+	if err != nil {
+		return nil, http.StatusBadRequest, "Invalid URL"
+	}
+	return parsedURL, 0, ""
+}
+
+// removeBackend removes the backend with URL u from serverPool. With force,
+// its requests in flight are cancelled: the idempotent ones are sent to
+// another backend, the others fail with 502. It is meant for a backend
+// corrupting its responses, which must not finish them.
+func removeBackend(serverPool pool.LoadBalancer, u *url.URL, force bool) bool {
+	var removed *pool.Backend
+	for _, b := range serverPool.GetBackends() {
+		if b.URL.String() == u.String() {
+			removed = b
+		}
+	}
+	if !serverPool.RemoveBackend(u) {
+		return false
+	}
+	if force && removed != nil {
+		removed.Abort()
+		log.Printf("Backend removed by force, requests in flight cancelled: %s", u.String())
+	} else {
+		log.Printf("Backend removed: %s", u.String())
+	}
+	return true
+}
+
+// findBackend resolves the id of /backends/{id}: a backend ID, a full URL,
+// or a host:port shared by no other backend. On failure it returns the HTTP
+// status and message to answer with.
+func findBackend(serverPool pool.LoadBalancer, id string) (*pool.Backend, int, string) {
+	if id == "" {
+		return nil, http.StatusNotFound, "Backend not found"
+	}
+	var match *pool.Backend
+	for _, b := range serverPool.GetBackends() {
+		if b.ID() == id || b.URL.String() == id {
+			return b, 0, ""
+		}
+		if b.URL.Host == id {
+			if match != nil {
+				return nil, http.StatusConflict, fmt.Sprintf("Several backends listen on %s: use the full URL", id)
+			}
+			match = b
+		}
+	}
+	if match == nil {
+		return nil, http.StatusNotFound, "Backend not found"
+	}
+	return match, 0, ""
+}
+
+func backendStatus(b *pool.Backend) BackendStatus {
+	return BackendStatus{
+		ID:           b.ID(),
+		URL:          b.URL.String(),
+		Alive:        b.IsAlive(),
+		AdminDown:    b.IsAdminDown(),
+		Ejected:      b.IsEjected(),
+		CurrentConns: atomic.LoadInt64(&b.CurrentConns),
+		Weight:       b.Weight(),
+		Tags:         b.Tags(),
+		Stats:        b.Stats(),
+		Uptime:       b.Uptime(),
+	}
+}
+
+func routeStatus(rt *route.Route) RouteStatus {
+	status := RouteStatus{
+		Name:       rt.Name,
+		Host:       rt.Host,
+		PathPrefix: rt.PathPrefix,
+		Backends:   len(rt.Pool.GetBackends()),
+	}
+	if rt.Rule != nil {
+		status.Rule = rt.Rule.String()
+	}
+	var tier int
+	var serving bool
+	if gp, ok := rt.Pool.(*pool.GroupedPool); ok {
+		tier, serving = gp.ActiveTier()
+	}
+	for _, g := range rt.Groups() {
+		gs := GroupStatus{Name: g.Name, Weight: g.Weight(), Priority: g.Priority, Active: serving && g.Priority == tier, Backends: []string{}}
+		for _, b := range g.Pool.GetBackends() {
+			gs.Backends = append(gs.Backends, b.URL.String())
+		}
+		status.Groups = append(status.Groups, gs)
+	}
+	return status
+}
+
+func hasGroup(groups []*pool.Group, name string) bool {
+	for _, g := range groups {
+		if g.Name == name {
+			return true
+		}
+	}
+	return false
+}
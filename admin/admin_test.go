@@ -0,0 +1,1013 @@
+package admin_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-proxy/admin"
+	"reverse-proxy/cache"
+	"reverse-proxy/limit"
+	"reverse-proxy/pool"
+	"reverse-proxy/proxy"
+	"reverse-proxy/route"
+	"reverse-proxy/tcpproxy"
+)
+
+func newPool(t *testing.T, rawURLs ...string) *pool.ServerPool {
+	t.Helper()
+	var backends []*pool.Backend
+	for _, raw := range rawURLs {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatalf("invalid URL %s: %v", raw, err)
+		}
+		b := &pool.Backend{URL: u}
+		b.SetAlive(true)
+		backends = append(backends, b)
+	}
+	sp, err := pool.NewServerPool(pool.WithBackends(backends...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sp
+}
+
+// do sends a JSON request to the admin handler and returns the recorder.
+func do(t *testing.T, h http.Handler, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, &buf))
+	return rec
+}
+
+func getStatus(t *testing.T, h http.Handler) admin.StatusResponse {
+	t.Helper()
+	rec := do(t, h, http.MethodGet, "/status", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /status returned %d", rec.Code)
+	}
+	var status admin.StatusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("invalid /status JSON: %v", err)
+	}
+	return status
+}
+
+// ── Maintenance mode ─────────────────────────────────────────────────────────
+
+func TestPatchBackends_DisableAndEnable(t *testing.T) {
+	sp := newPool(t, "http://a:8080", "http://b:8080")
+	h := admin.Handler(sp, admin.Options{})
+
+	rec := do(t, h, http.MethodPatch, "/backends", map[string]string{"url": "http://a:8080", "action": "disable"})
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	status := getStatus(t, h)
+	if status.ActiveBackends != 1 {
+		t.Errorf("expected 1 active backend while a is disabled, got %d", status.ActiveBackends)
+	}
+	if !status.Backends[0].AdminDown || !status.Backends[0].Alive {
+		t.Errorf("expected a to be alive but admin_down, got %+v", status.Backends[0])
+	}
+	for i := 0; i < 4; i++ {
+		if b := sp.GetNextValidPeer(); b.URL.Host == "a:8080" {
+			t.Fatal("disabled backend must not receive traffic")
+		}
+	}
+
+	rec = do(t, h, http.MethodPatch, "/backends", map[string]string{"url": "http://a:8080", "action": "enable"})
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	if status := getStatus(t, h); status.ActiveBackends != 2 {
+		t.Errorf("expected 2 active backends after enable, got %d", status.ActiveBackends)
+	}
+}
+
+func TestPatchBackends_Errors(t *testing.T) {
+	h := admin.Handler(newPool(t, "http://a:8080"), admin.Options{})
+
+	cases := []struct {
+		name string
+		body map[string]string
+		want int
+	}{
+		{"unknown action", map[string]string{"url": "http://a:8080", "action": "reboot"}, http.StatusBadRequest},
+		{"invalid url", map[string]string{"url": "not a url", "action": "disable"}, http.StatusBadRequest},
+		{"unknown backend", map[string]string{"url": "http://ghost:8080", "action": "disable"}, http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		if rec := do(t, h, http.MethodPatch, "/backends", tc.body); rec.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, rec.Code)
+		}
+	}
+}
+
+func TestPatchBackendByID_WeightAndAction(t *testing.T) {
+	sp := newPool(t, "http://a:8080", "http://b:8080")
+	sp.Strategy = "weighted-round-robin"
+	changes := 0
+	h := admin.Handler(sp, admin.Options{OnChange: func() { changes++ }})
+
+	// By full URL, path-escaped.
+	rec := do(t, h, http.MethodPatch, "/v1/backends/"+url.PathEscape("http://a:8080"), map[string]int{"weight": 0})
+	var status admin.BackendStatus
+	json.Unmarshal(rec.Body.Bytes(), &status)
+	if rec.Code != http.StatusOK || status.URL != "http://a:8080" || status.Weight != 0 || changes != 1 {
+		t.Fatalf("expected a at weight 0, got %d %s", rec.Code, rec.Body)
+	}
+	for i := 0; i < 4; i++ {
+		if b := sp.GetNextValidPeer(); b.URL.Host != "b:8080" {
+			t.Fatal("a backend of weight 0 must not receive traffic")
+		}
+	}
+
+	// By host:port, weight and action together.
+	rec = do(t, h, http.MethodPatch, "/backends/b:8080", map[string]any{"weight": 3, "action": "disable"})
+	json.Unmarshal(rec.Body.Bytes(), &status)
+	if rec.Code != http.StatusOK || status.Weight != 3 || !status.AdminDown {
+		t.Fatalf("expected b at weight 3 and disabled, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, http.MethodGet, "/backends/b:8080", nil); !strings.Contains(rec.Body.String(), `"weight":3`) {
+		t.Errorf("GET: unexpected body %s", rec.Body)
+	}
+	if getStatus(t, h).Backends[1].Weight != 3 {
+		t.Error("/status should report the weight")
+	}
+
+	sp.AddBackend(&pool.Backend{URL: &url.URL{Scheme: "https", Host: "b:8080"}})
+	cases := []struct {
+		name, id string
+		body any
+		want int
+	}{
+		{"unknown backend", "ghost:8080", map[string]int{"weight": 1}, http.StatusNotFound},
+		{"ambiguous host", "b:8080", map[string]int{"weight": 1}, http.StatusConflict},
+		{"negative weight", "a:8080", map[string]int{"weight": -1}, http.StatusBadRequest},
+		{"unknown action", "a:8080", map[string]string{"action": "reboot"}, http.StatusBadRequest},
+		{"nothing to change", "a:8080", map[string]string{}, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		if rec := do(t, h, http.MethodPatch, "/backends/"+tc.id, tc.body); rec.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, rec.Code)
+		}
+	}
+	if changes != 2 {
+		t.Errorf("rejected PATCHes must not count as changes, got %d", changes)
+	}
+}
+
+func TestPatchBackendByID_Tags(t *testing.T) {
+	sp := newPool(t, "http://a:8080", "http://b:8080")
+	sp.Tags = pool.TagPolicy{Require: map[string]string{"version": "v2"}}
+	h := admin.Handler(sp, admin.Options{})
+
+	rec := do(t, h, http.MethodPatch, "/backends/b:8080", map[string]any{"tags": map[string]string{"version": "v2", "region": "eu"}})
+	var status admin.BackendStatus
+	json.Unmarshal(rec.Body.Bytes(), &status)
+	if rec.Code != http.StatusOK || status.Tags["version"] != "v2" || status.Tags["region"] != "eu" {
+		t.Fatalf("expected b tagged, got %d %s", rec.Code, rec.Body)
+	}
+	for i := 0; i < 4; i++ {
+		if b := sp.GetNextValidPeer(); b == nil || b.URL.Host != "b:8080" {
+			t.Fatalf("only the backend tagged version=v2 may be picked, got %v", b)
+		}
+	}
+
+	rec = do(t, h, http.MethodPatch, "/backends/b:8080", map[string]any{"tags": map[string]string{}})
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), `"tags"`) {
+		t.Fatalf("expected the tags removed, got %d %s", rec.Code, rec.Body)
+	}
+	if b := sp.GetNextValidPeer(); b != nil {
+		t.Fatalf("no backend carries version=v2 any more, got %v", b)
+	}
+	if rec := do(t, h, http.MethodPatch, "/backends/a:8080", map[string]any{"tags": map[string]string{"": "x"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("an empty tag name: expected 400, got %d", rec.Code)
+	}
+}
+
+func TestHealthzAndReadyz(t *testing.T) {
+	sp := newPool(t, "http://a:8080", "http://b:8080")
+	h := admin.Handler(sp, admin.Options{ReadyMinBackends: 2})
+
+	if rec := do(t, h, http.MethodGet, "/healthz", nil); rec.Code != http.StatusOK {
+		t.Errorf("/healthz: expected 200, got %d", rec.Code)
+	}
+	if rec := do(t, h, http.MethodGet, "/readyz", nil); rec.Code != http.StatusOK {
+		t.Errorf("/readyz with 2 available backends: expected 200, got %d", rec.Code)
+	}
+
+	sp.SetBackendStatus(sp.GetBackends()[0].URL, false)
+	rec := do(t, h, http.MethodGet, "/readyz", nil)
+	var ready admin.ReadyResponse
+	json.Unmarshal(rec.Body.Bytes(), &ready)
+	if rec.Code != http.StatusServiceUnavailable || ready.Ready || ready.Available != 1 || ready.Required != 2 {
+		t.Errorf("expected 503 below the threshold, got %d %+v", rec.Code, ready)
+	}
+	if rec := do(t, h, http.MethodGet, "/healthz", nil); rec.Code != http.StatusOK {
+		t.Error("liveness must not depend on the backends")
+	}
+}
+
+func TestToken_AndDebugEndpoints(t *testing.T) {
+	sp := newPool(t, "http://a:8080")
+	h := admin.Handler(sp, admin.Options{Token: "s3cret", Debug: true})
+	get := func(path, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{"/status", "/v1/status", "/debug/runtime", "/debug/pprof/"} {
+		if rec := get(path, ""); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s without a token: expected 401, got %d", path, rec.Code)
+		}
+		if rec := get(path, "Bearer wrong"); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s with a wrong token: expected 401, got %d", path, rec.Code)
+		}
+		if rec := get(path, "Bearer s3cret"); rec.Code != http.StatusOK {
+			t.Errorf("%s with the token: expected 200, got %d", path, rec.Code)
+		}
+	}
+	if rec := get("/v1/healthz", ""); rec.Code != http.StatusOK {
+		t.Errorf("probes must not require the token, got %d", rec.Code)
+	}
+	if rec := get("/dashboard", ""); rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(rec.Body.String(), "/v1/status") {
+		t.Errorf("the dashboard page must be served without the token, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	var stats admin.RuntimeStats
+	rec := get("/debug/runtime", "Bearer s3cret")
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil || stats.Goroutines == 0 || stats.HeapAllocBytes == 0 || stats.GoVersion == "" {
+		t.Errorf("unexpected runtime stats %s (%v)", rec.Body, err)
+	}
+
+	h = admin.Handler(sp, admin.Options{})
+	if rec := do(t, h, http.MethodGet, "/debug/pprof/", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("debug endpoints must be off by default, got %d", rec.Code)
+	}
+}
+
+func TestTokens_Roles(t *testing.T) {
+	sp := newPool(t, "http://a:8080")
+	dir := t.TempDir()
+	audit, err := admin.OpenAuditLog(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	h := admin.Handler(sp, admin.Options{Debug: true, Audit: audit, Tokens: []admin.Token{
+		{Name: "dashboard", Secret: "look", Role: admin.RoleReadOnly},
+		{Name: "alice", Secret: "act", Role: admin.RoleOperator},
+	}})
+	call := func(method, path, auth, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, path := range []string{"/v1/status", "/v1/config/export"} {
+		if code := call(http.MethodGet, path, "Bearer look", ""); code != http.StatusOK {
+			t.Errorf("read-only GET %s: expected 200, got %d", path, code)
+		}
+	}
+	if code := call(http.MethodPost, "/v1/backends", "Bearer look", `{"url":"http://b:8080"}`); code != http.StatusForbidden {
+		t.Errorf("read-only POST: expected 403, got %d", code)
+	}
+	if code := call(http.MethodGet, "/debug/runtime", "Bearer look", ""); code != http.StatusForbidden {
+		t.Errorf("read-only debug: expected 403, got %d", code)
+	}
+	if len(sp.GetBackends()) != 1 {
+		t.Fatal("a read-only token must not add backends")
+	}
+	if code := call(http.MethodPost, "/v1/backends", "Bearer act", `{"url":"http://b:8080"}`); code != http.StatusCreated {
+		t.Errorf("operator POST: expected 201, got %d", code)
+	}
+	if code := call(http.MethodGet, "/v1/status", "Bearer nope", ""); code != http.StatusUnauthorized {
+		t.Errorf("unknown token: expected 401, got %d", code)
+	}
+
+	entries, err := audit.Entries(time.Time{}, 0)
+	if err != nil || len(entries) != 1 || entries[0].Actor != "alice" {
+		t.Errorf("expected one entry by alice, got %+v (%v)", entries, err)
+	}
+}
+
+func TestStatus_InFlight(t *testing.T) {
+	tracker := &proxy.Tracker{Max: 10}
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	served := tracker.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		close(entered)
+		<-release
+	}))
+	go served.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	<-entered
+	defer close(release)
+
+	status := getStatus(t, admin.Handler(newPool(t, "http://a:8080"), admin.Options{Tracker: tracker}))
+	if status.InFlight != 1 || status.MaxInFlight != 10 || status.Shed != 0 {
+		t.Errorf("unexpected counters: %d in flight, max %d, %d shed", status.InFlight, status.MaxInFlight, status.Shed)
+	}
+}
+
+func TestVersionedRoutesAndOpenAPI(t *testing.T) {
+	sp := newPool(t, "http://a:8080")
+	h := admin.Handler(sp, admin.Options{})
+
+	for _, path := range []string{"/v1/status", "/status"} {
+		rec := do(t, h, http.MethodGet, path, nil)
+		var status admin.StatusResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); rec.Code != http.StatusOK || err != nil || status.TotalBackends != 1 {
+			t.Errorf("GET %s: got %d %q", path, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := do(t, h, http.MethodPost, "/v1/backends", map[string]string{"url": "http://b:8080"}); rec.Code != http.StatusCreated {
+		t.Errorf("POST /v1/backends: expected 201, got %d", rec.Code)
+	}
+
+	rec := do(t, h, http.MethodGet, "/openapi.json", nil)
+	var spec struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("GET /openapi.json: got %d, %v", rec.Code, err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") || len(spec.Servers) != 1 || spec.Servers[0].URL != "/v1" {
+		t.Errorf("unexpected spec header: %+v", spec)
+	}
+	for _, path := range []string{"/status", "/backends"} {
+		if _, ok := spec.Paths[path]; !ok {
+			t.Errorf("spec is missing %s", path)
+		}
+	}
+	for _, schema := range []string{"StatusResponse", "BackendStatus", "BackendStats", "ReplaceResponse"} {
+		if _, ok := spec.Components.Schemas[schema]; !ok {
+			t.Errorf("spec is missing the %s schema", schema)
+		}
+	}
+}
+
+// ── Existing endpoints ───────────────────────────────────────────────────────
+
+func TestPostAndDeleteBackends(t *testing.T) {
+	sp := newPool(t, "http://a:8080")
+	h := admin.Handler(sp, admin.Options{})
+
+	if rec := do(t, h, http.MethodPost, "/backends", map[string]string{"url": "http://b:8080"}); rec.Code != http.StatusCreated {
+		t.Fatalf("POST: expected 201, got %d", rec.Code)
+	}
+	if rec := do(t, h, http.MethodPost, "/backends", map[string]string{"url": "http://b:8080"}); rec.Code != http.StatusConflict {
+		t.Fatalf("duplicate POST: expected 409, got %d", rec.Code)
+	}
+	if status := getStatus(t, h); status.TotalBackends != 2 || status.ActiveBackends != 1 {
+		t.Errorf("new backend must be pending health check, got %+v", status)
+	}
+
+	if rec := do(t, h, http.MethodDelete, "/backends", map[string]string{"url": "http://b:8080"}); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: expected 204, got %d", rec.Code)
+	}
+	if rec := do(t, h, http.MethodDelete, "/backends", map[string]string{"url": "http://b:8080"}); rec.Code != http.StatusNotFound {
+		t.Fatalf("second DELETE: expected 404, got %d", rec.Code)
+	}
+}
+
+// Entries that only differ by a trailing slash get distinct IDs, which name
+// each of them without ambiguity.
+func TestBackendIDs(t *testing.T) {
+	sp := newPool(t, "http://a:8080")
+	h := admin.Handler(sp, admin.Options{})
+
+	rec := do(t, h, http.MethodPost, "/backends", map[string]string{"url": "http://a:8080/"})
+	var added admin.BackendStatus
+	if rec.Code != http.StatusCreated || json.Unmarshal(rec.Body.Bytes(), &added) != nil {
+		t.Fatalf("POST: expected 201 with the backend, got %d %q", rec.Code, rec.Body.String())
+	}
+	status := getStatus(t, h)
+	if added.ID == "" || status.Backends[0].ID == added.ID || status.Backends[1].ID != added.ID {
+		t.Fatalf("expected distinct IDs, the new one %q last, got %+v", added.ID, status.Backends)
+	}
+	if sp.GetBackendByID(added.ID) != sp.GetBackends()[1] {
+		t.Error("GetBackendByID should find the new backend")
+	}
+
+	// Both listen on a:8080: the host:port is ambiguous, not the ID.
+	if rec := do(t, h, http.MethodGet, "/backends/a:8080", nil); rec.Code != http.StatusConflict {
+		t.Errorf("GET by host:port: expected 409, got %d", rec.Code)
+	}
+	if rec := do(t, h, http.MethodPatch, "/backends", map[string]string{"id": added.ID, "action": "disable"}); rec.Code != http.StatusNoContent {
+		t.Fatalf("PATCH by ID: expected 204, got %d", rec.Code)
+	}
+	if sp.GetBackends()[0].IsAdminDown() || !sp.GetBackends()[1].IsAdminDown() {
+		t.Error("PATCH by ID should only disable the named backend")
+	}
+
+	if rec := do(t, h, http.MethodDelete, "/backends/"+added.ID, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE by ID: expected 204, got %d", rec.Code)
+	}
+	if backends := sp.GetBackends(); len(backends) != 1 || backends[0].URL.String() != "http://a:8080" {
+		t.Errorf("DELETE by ID removed the wrong backend: %v", backends)
+	}
+	if rec := do(t, h, http.MethodDelete, "/backends", map[string]string{"id": added.ID}); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE of a removed ID: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestPutBackends_Reconciles(t *testing.T) {
+	sp := newPool(t, "http://a:8080", "http://b:8080")
+	kept := sp.GetBackends()[1]
+	kept.RecordRequest(pool.RequestResult{Latency: time.Millisecond})
+	h := admin.Handler(sp, admin.Options{})
+
+	rec := do(t, h, http.MethodPut, "/backends", map[string][]string{"backends": {"http://b:8080", "http://c:8080"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp admin.ReplaceResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid PUT response: %v", err)
+	}
+	if len(resp.Added) != 1 || resp.Added[0] != "http://c:8080" || len(resp.Removed) != 1 || resp.Removed[0] != "http://a:8080" {
+		t.Errorf("unexpected changes: %+v", resp)
+	}
+
+	backends := sp.GetBackends()
+	if len(backends) != 2 || backends[0] != kept || backends[1].URL.Host != "c:8080" {
+		t.Fatalf("unexpected pool after PUT: %v", backends)
+	}
+	if kept.Stats().Requests != 1 || !kept.IsAlive() {
+		t.Error("a backend kept by PUT must keep its state and statistics")
+	}
+	if backends[1].IsAlive() {
+		t.Error("a new backend must be pending health check")
+	}
+
+	// Any invalid entry rejects the whole list.
+	for _, body := range []map[string][]string{
+		{"backends": {"http://d:8080", "not a url"}},
+		{"backends": {}},
+	} {
+		if rec := do(t, h, http.MethodPut, "/backends", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected 400, got %d", body, rec.Code)
+		}
+	}
+	if len(sp.GetBackends()) != 2 {
+		t.Error("a rejected PUT must leave the pool untouched")
+	}
+}
+
+func TestReload_ReconcilesWithConfig(t *testing.T) {
+	sp := newPool(t, "http://a:8080", "http://b:8080")
+	if rec := do(t, admin.Handler(sp, admin.Options{}), http.MethodPost, "/reload", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("/reload without a Reload func: expected 404, got %d", rec.Code)
+	}
+
+	config, fail := []string{"http://b:8080", "http://c:8080"}, error(nil)
+	changes := 0
+	h := admin.Handler(sp, admin.Options{
+		Reload:   func() ([]string, error) { return config, fail },
+		OnChange: func() { changes++ },
+	})
+
+	rec := do(t, h, http.MethodPost, "/v1/reload", nil)
+	var resp admin.ReplaceResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || len(resp.Added) != 1 || len(resp.Removed) != 1 || changes != 1 {
+		t.Fatalf("expected b kept, c added, a removed: got %d %+v (%d changes)", rec.Code, resp, changes)
+	}
+
+	fail = errors.New("config.json: unexpected end of JSON input")
+	if rec := do(t, h, http.MethodPost, "/reload", nil); rec.Code != http.StatusInternalServerError {
+		t.Errorf("unreadable config: expected 500, got %d", rec.Code)
+	}
+	if rec := do(t, h, http.MethodGet, "/reload", nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /reload: expected 405, got %d", rec.Code)
+	}
+	if len(sp.GetBackends()) != 2 || changes != 1 {
+		t.Error("a failed reload must leave the pool untouched")
+	}
+}
+
+func TestConfigExportImport(t *testing.T) {
+	staging := newPool(t, "http://a:8080", "http://b:8080")
+	staging.SetBackendAdminDown(staging.GetBackends()[1].URL, true)
+	staging.SetBackendWeight(staging.GetBackends()[0].URL, 3)
+	stagingRoutes := newCanaryRoutes(t)
+	stagingRoutes.Get("web").Groups()[1].SetWeight(50)
+
+	rec := do(t, admin.Handler(staging, admin.Options{Routes: stagingRoutes}), http.MethodGet, "/config/export", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /config/export returned %d", rec.Code)
+	}
+	snapshot := rec.Body.Bytes()
+
+	prod := newPool(t, "http://b:8080", "http://c:8080")
+	prodRoutes := newCanaryRoutes(t)
+	changes := 0
+	h := admin.Handler(prod, admin.Options{Routes: prodRoutes, OnChange: func() { changes++ }})
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/config/import", bytes.NewReader(snapshot)))
+	var resp admin.ReplaceResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || len(resp.Added) != 1 || resp.Added[0] != "http://a:8080" || len(resp.Removed) != 1 || changes != 1 {
+		t.Fatalf("expected a added, c removed: got %d %s (%d changes)", rec.Code, rec.Body, changes)
+	}
+	backends := prod.GetBackends()
+	if len(backends) != 2 || backends[0].Weight() != 3 || backends[0].IsAdminDown() || !backends[1].IsAdminDown() {
+		t.Errorf("backends not restored from the snapshot: %+v", getStatus(t, h).Backends)
+	}
+	if w := prodRoutes.Get("web").Groups()[1].Weight(); w != 50 {
+		t.Errorf("canary weight not restored: %d", w)
+	}
+
+	// Any invalid part rejects the whole snapshot.
+	for _, body := range []map[string]any{
+		{"backends": []map[string]any{{"url": "http://d:8080"}}, "weights": map[string]any{"web": map[string]int{"blue": 1}}},
+		{"strategy": "least-conn", "backends": []map[string]any{{"url": "http://d:8080"}}},
+		{"backends": []map[string]any{}},
+	} {
+		if rec := do(t, h, http.MethodPost, "/config/import", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected 400, got %d", body, rec.Code)
+		}
+	}
+	if len(prod.GetBackends()) != 2 || prod.GetBackends()[0].URL.Host != "a:8080" || changes != 1 {
+		t.Error("a rejected import must leave everything untouched")
+	}
+}
+
+// ── Routes & canary weights ──────────────────────────────────────────────────
+
+func newCanaryRoutes(t *testing.T) *route.Table {
+	t.Helper()
+	gp := pool.NewGroupedPool(
+		pool.NewGroup("stable", 95, newPool(t, "http://stable:8080")),
+		pool.NewGroup("canary", 5, newPool(t, "http://canary:8080")),
+	)
+	return route.NewTable(&route.Route{Name: "web", PathPrefix: "/", Pool: gp})
+}
+
+func TestPatchRoutes_UpdatesWeights(t *testing.T) {
+	routes := newCanaryRoutes(t)
+	h := admin.Handler(newPool(t), admin.Options{Routes: routes})
+
+	rec := do(t, h, http.MethodPatch, "/routes", map[string]any{
+		"route":   "web",
+		"weights": map[string]int{"stable": 50, "canary": 50},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH /routes returned %d: %s", rec.Code, rec.Body)
+	}
+	for _, g := range routes.Get("web").Groups() {
+		if g.Weight() != 50 {
+			t.Errorf("group %s has weight %d, want 50", g.Name, g.Weight())
+		}
+	}
+
+	rec = do(t, h, http.MethodGet, "/routes", nil)
+	var status []admin.RouteStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("invalid /routes JSON: %v", err)
+	}
+	if len(status) != 1 || len(status[0].Groups) != 2 || status[0].Groups[1].Weight != 50 {
+		t.Errorf("unexpected /routes response: %s", rec.Body)
+	}
+}
+
+func TestPatchPool_SwitchesStrategy(t *testing.T) {
+	sp := newPool(t, "http://a:8080")
+	routes := newCanaryRoutes(t)
+	changes := 0
+	h := admin.Handler(sp, admin.Options{Routes: routes, OnChange: func() { changes++ }})
+
+	rec := do(t, h, http.MethodPatch, "/pool", map[string]string{"strategy": "least-connections"})
+	var status admin.PoolStatus
+	json.Unmarshal(rec.Body.Bytes(), &status)
+	if rec.Code != http.StatusOK || status.Route != "default" || status.Strategy != "least-connections" || len(status.Strategies) == 0 {
+		t.Fatalf("PATCH /pool: got %d %s", rec.Code, rec.Body)
+	}
+	if sp.StrategyName() != "least-connections" || changes != 1 {
+		t.Errorf("expected the default pool switched, got %s (changes=%d)", sp.StrategyName(), changes)
+	}
+
+	rec = do(t, h, http.MethodPatch, "/pool", map[string]string{"route": "web", "strategy": "p2c"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH /pool on a route: got %d %s", rec.Code, rec.Body)
+	}
+	for _, g := range routes.Get("web").Groups() {
+		if g.Pool.StrategyName() != "p2c" {
+			t.Errorf("group %s still on %s", g.Name, g.Pool.StrategyName())
+		}
+	}
+	if rec := do(t, h, http.MethodGet, "/pool?route=web", nil); !strings.Contains(rec.Body.String(), `"strategy":"p2c"`) {
+		t.Errorf("GET /pool?route=web: unexpected body %s", rec.Body)
+	}
+
+	cases := []struct {
+		name string
+		body map[string]string
+		want int
+	}{
+		{"unknown strategy", map[string]string{"strategy": "fastest"}, http.StatusBadRequest},
+		{"nothing to change", map[string]string{}, http.StatusBadRequest},
+		{"unknown route", map[string]string{"route": "api", "strategy": "p2c"}, http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		if rec := do(t, h, http.MethodPatch, "/pool", tc.body); rec.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, rec.Code)
+		}
+	}
+	if changes != 2 {
+		t.Errorf("rejected PATCHes must not count as changes, got %d", changes)
+	}
+}
+
+func TestRouteDryRun(t *testing.T) {
+	api := &route.Route{Name: "api", PathPrefix: "/api", Pool: newPool(t, "http://api:8080")}
+	canary := newCanaryRoutes(t).Get("web")
+	canary.Host = "shop.example.com"
+	rule, _ := route.CompileRule(`header("X-Beta") == "true" && method == "POST"`)
+	beta := &route.Route{Name: "beta", PathPrefix: "/api", Rule: rule, Pool: newPool(t, "http://beta:8080")}
+	routes := route.NewTable(api, canary, beta)
+	deny, _ := limit.NewIPFilter(nil, []string{"203.0.113.0/24"})
+	h := admin.Handler(newPool(t), admin.Options{Routes: routes, IPFilters: map[string]*limit.IPFilter{"api": deny}})
+
+	decide := func(query string) (int, admin.RouteDecision) {
+		t.Helper()
+		rec := do(t, h, http.MethodGet, "/route?"+query, nil)
+		var d admin.RouteDecision
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &d); err != nil {
+				t.Fatalf("invalid /route JSON: %v", err)
+			}
+		}
+		return rec.Code, d
+	}
+
+	if code, d := decide("path=/api/users"); code != http.StatusOK || d.Route.Name != "api" || d.Backend != "http://api:8080" || len(d.Candidates) != 1 {
+		t.Errorf("unexpected decision for /api/users: %d %+v", code, d)
+	}
+	if _, d := decide("path=/cart&host=shop.example.com:443"); d.Route.Name != "web" || d.Group != "stable" {
+		t.Errorf("expected the stable group of the web route, got %+v", d)
+	}
+	if _, d := decide("path=/api&ip=203.0.113.7"); d.Allowed || d.Backend != "" || !strings.Contains(d.Reason, "api ip_filter") {
+		t.Errorf("expected the route's IP filter to reject, got %+v", d)
+	}
+	if _, d := decide("path=/api&method=post&header=X-Beta:%20true"); d.Route.Name != "beta" || d.Route.Rule == "" {
+		t.Errorf("expected the beta rule to match, got %+v", d)
+	}
+	if code, _ := decide("path=/api&header=X-Beta"); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a header without a value, got %d", code)
+	}
+	if code, _ := decide("path=/cart"); code != http.StatusNotFound {
+		t.Errorf("expected 404 when no route matches, got %d", code)
+	}
+	if code, _ := decide("path=/api&ip=nope"); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid ip, got %d", code)
+	}
+
+	// A dry run previews the pick: the next real request still gets it.
+	rr := newPool(t, "http://rr1:8080", "http://rr2:8080")
+	routes.Replace(&route.Route{Name: "rr", PathPrefix: "/rr", Pool: rr})
+	_, first := decide("path=/rr")
+	_, second := decide("path=/rr")
+	if first.Backend == "" || second.Backend != first.Backend {
+		t.Fatalf("expected repeated dry runs to agree, got %q then %q", first.Backend, second.Backend)
+	}
+	if b := rr.GetNextValidPeer(); b.URL.String() != first.Backend {
+		t.Errorf("the dry run took a turn: next pick is %s, previewed %s", b.URL, first.Backend)
+	}
+	rr.SetStrategy("p2c")
+	if _, d := decide("path=/rr"); d.Backend != "" || d.Reason == "" || len(d.Candidates) != 2 {
+		t.Errorf("expected no pick for a random strategy, got %+v", d)
+	}
+}
+
+func TestOnChange_CalledAfterEachMutation(t *testing.T) {
+	changes := 0
+	h := admin.Handler(newPool(t, "http://a:8080"), admin.Options{
+		Routes:   newCanaryRoutes(t),
+		OnChange: func() { changes++ },
+	})
+
+	do(t, h, http.MethodPost, "/backends", map[string]string{"url": "http://b:8080"})
+	do(t, h, http.MethodPatch, "/backends", map[string]string{"url": "http://b:8080", "action": "disable"})
+	do(t, h, http.MethodDelete, "/backends", map[string]string{"url": "http://b:8080"})
+	do(t, h, http.MethodPatch, "/routes", map[string]any{"route": "web", "weights": map[string]int{"canary": 10}})
+	if changes != 4 {
+		t.Errorf("expected 4 changes, got %d", changes)
+	}
+
+	// Failed requests change nothing.
+	do(t, h, http.MethodDelete, "/backends", map[string]string{"url": "http://missing:8080"})
+	do(t, h, http.MethodGet, "/status", nil)
+	if changes != 4 {
+		t.Errorf("rejected or read-only requests must not report a change, got %d", changes)
+	}
+}
+
+func TestPatchRoutes_Errors(t *testing.T) {
+	routes := newCanaryRoutes(t)
+	h := admin.Handler(newPool(t), admin.Options{Routes: routes})
+
+	cases := []struct {
+		name string
+		body map[string]any
+		want int
+	}{
+		{"unknown route", map[string]any{"route": "api", "weights": map[string]int{"stable": 1}}, http.StatusNotFound},
+		{"unknown group", map[string]any{"route": "web", "weights": map[string]int{"blue": 1}}, http.StatusNotFound},
+		{"negative weight", map[string]any{"route": "web", "weights": map[string]int{"canary": -1}}, http.StatusBadRequest},
+		{"all zero", map[string]any{"route": "web", "weights": map[string]int{"stable": 0, "canary": 0}}, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if rec := do(t, h, http.MethodPatch, "/routes", c.body); rec.Code != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, rec.Code, c.want)
+		}
+	}
+	if w := routes.Get("web").Groups()[0].Weight(); w != 95 {
+		t.Errorf("rejected updates must not change weights, stable is at %d", w)
+	}
+}
+
+// ── TCP proxy ────────────────────────────────────────────────────────────────
+
+func TestGetTCP(t *testing.T) {
+	srv := &tcpproxy.Server{Name: "postgres", Pool: newPool(t, "tcp://db1:5432", "tcp://db2:5432")}
+
+	if rec := do(t, admin.Handler(newPool(t), admin.Options{}), http.MethodGet, "/tcp", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("/tcp must not exist without TCP listeners, got %d", rec.Code)
+	}
+
+	rec := do(t, admin.Handler(newPool(t), admin.Options{TCP: []*tcpproxy.Server{srv}}), http.MethodGet, "/tcp", nil)
+	var status []admin.TCPStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("invalid /tcp JSON: %v", err)
+	}
+	if len(status) != 1 || status[0].Name != "postgres" || len(status[0].Backends) != 2 || len(status[0].Connections) != 0 {
+		t.Errorf("unexpected /tcp response: %s", rec.Body)
+	}
+}
+
+// ── Response cache ───────────────────────────────────────────────────────────
+
+func TestCache_StatsAndPurge(t *testing.T) {
+	c := cache.New(1<<20, 0, time.Minute)
+	proxied := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	for _, path := range []string{"/api/a", "/api/b", "/home"} {
+		proxied.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	h := admin.Handler(newPool(t), admin.Options{Cache: c})
+
+	var stats cache.Stats
+	json.Unmarshal(do(t, h, http.MethodGet, "/cache", nil).Body.Bytes(), &stats)
+	if stats.Entries != 3 {
+		t.Errorf("expected 3 cached entries, got %+v", stats)
+	}
+
+	rec := do(t, h, http.MethodDelete, "/cache", map[string]string{"prefix": "/api/"})
+	if rec.Code != http.StatusOK || c.Stats().Entries != 1 {
+		t.Errorf("prefix purge: got %d, %d entries left", rec.Code, c.Stats().Entries)
+	}
+	if rec := do(t, h, http.MethodDelete, "/cache", nil); rec.Code != http.StatusOK || c.Stats().Entries != 0 {
+		t.Errorf("full purge: got %d, %d entries left", rec.Code, c.Stats().Entries)
+	}
+}
+
+// ── IP filters ───────────────────────────────────────────────────────────────
+
+func TestIPFilterEndpoint(t *testing.T) {
+	global, _ := limit.NewIPFilter(nil, nil)
+	api, _ := limit.NewIPFilter([]string{"10.0.0.0/8"}, nil)
+	h := admin.Handler(newPool(t), admin.Options{IPFilters: map[string]*limit.IPFilter{"global": global, "api": api}})
+
+	if rec := do(t, h, http.MethodPost, "/ipfilter", map[string]string{"list": "deny", "cidr": "203.0.113.0/24"}); rec.Code != http.StatusCreated {
+		t.Fatalf("POST: expected 201, got %d", rec.Code)
+	}
+	if ok, _ := global.Check(net.ParseIP("203.0.113.1")); ok {
+		t.Error("the range added at runtime must be denied at once")
+	}
+
+	var status map[string]admin.IPFilterStatus
+	json.Unmarshal(do(t, h, http.MethodGet, "/ipfilter", nil).Body.Bytes(), &status)
+	if len(status["global"].Deny) != 1 || len(status["api"].Allow) != 1 {
+		t.Errorf("unexpected GET /ipfilter response: %+v", status)
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		body   map[string]string
+		want   int
+	}{
+		{"duplicate", http.MethodPost, map[string]string{"list": "deny", "cidr": "203.0.113.0/24"}, http.StatusConflict},
+		{"unknown scope", http.MethodPost, map[string]string{"scope": "web", "list": "deny", "cidr": "1.2.3.4"}, http.StatusNotFound},
+		{"bad CIDR", http.MethodPost, map[string]string{"list": "deny", "cidr": "1.2.3.4/99"}, http.StatusBadRequest},
+		{"missing entry", http.MethodDelete, map[string]string{"scope": "api", "list": "deny", "cidr": "1.2.3.4"}, http.StatusNotFound},
+		{"remove", http.MethodDelete, map[string]string{"list": "deny", "cidr": "203.0.113.0/24"}, http.StatusNoContent},
+	}
+	for _, c := range cases {
+		if rec := do(t, h, c.method, "/ipfilter", c.body); rec.Code != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, rec.Code, c.want)
+		}
+	}
+}
+
+// ── Event stream ─────────────────────────────────────────────────────────────
+
+func TestEvents_StreamsPoolChanges(t *testing.T) {
+	sp := newPool(t, "http://a:8080")
+	srv := httptest.NewServer(admin.Handler(sp, admin.Options{}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", ct)
+	}
+
+	// The headers arrive once the handler has subscribed, so nothing is missed.
+	u, _ := url.Parse("http://a:8080")
+	sp.SetBackendStatus(u, false)
+
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+	var got []string
+	timeout := time.After(2 * time.Second)
+	for len(got) < 2 {
+		select {
+		case line := <-lines:
+			if line != "" {
+				got = append(got, line)
+			}
+		case <-timeout:
+			t.Fatalf("no event received, got %q", got)
+		}
+	}
+	var e pool.Event
+	if got[0] != "event: down" || json.Unmarshal([]byte(strings.TrimPrefix(got[1], "data: ")), &e) != nil || e.URL != "http://a:8080" {
+		t.Errorf("unexpected event %q", got)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := admin.OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	h := admin.Handler(newPool(t, "http://a:8080"), admin.Options{Routes: newCanaryRoutes(t), Audit: audit, Token: "s3cret"})
+	send := func(method, path string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	send(http.MethodPost, "/v1/backends", map[string]string{"url": "http://b:8080"})
+	send(http.MethodPatch, "/backends/http:%2F%2Fa:8080", map[string]string{"action": "disable"})
+	send(http.MethodPatch, "/routes", map[string]any{"route": "web", "weights": map[string]int{"canary": 20}})
+	send(http.MethodDelete, "/backends", map[string]string{"url": "http://missing:8080"})
+	send(http.MethodGet, "/status", nil)
+
+	rec := send(http.MethodGet, "/v1/audit", nil)
+	var entries []admin.AuditEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("GET /audit: %d %s", rec.Code, rec.Body)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected the 4 mutations to be audited, got %d: %s", len(entries), rec.Body)
+	}
+	add := entries[0]
+	if add.Actor != "token" || add.SourceIP != "192.0.2.1" || add.Method != http.MethodPost || add.Status != http.StatusCreated ||
+		!strings.Contains(string(add.Request), "http://b:8080") || len(add.Changes) != 1 ||
+		add.Changes[0].Field != "backends[http://b:8080]" || add.Changes[0].Old != nil {
+		t.Errorf("unexpected entry for the addition: %+v", add)
+	}
+	if c := entries[1].Changes; len(c) != 1 || c[0].Old.(map[string]any)["admin_down"] != nil || c[0].New.(map[string]any)["admin_down"] != true {
+		t.Errorf("expected the old and new backend state, got %+v", c)
+	}
+	if c := entries[2].Changes; len(c) != 1 || c[0].Field != "weights.web.canary" || c[0].Old != 5.0 || c[0].New != 20.0 {
+		t.Errorf("expected the canary weight change, got %+v", c)
+	}
+	if e := entries[3]; e.Status != http.StatusNotFound || len(e.Changes) != 0 {
+		t.Errorf("a failed mutation is audited without changes, got %+v", e)
+	}
+
+	if rec := send(http.MethodGet, "/audit?limit=1", nil); !strings.Contains(rec.Body.String(), `"DELETE"`) || strings.Contains(rec.Body.String(), `"POST"`) {
+		t.Errorf("expected only the last entry, got %s", rec.Body)
+	}
+	if rec := send(http.MethodGet, "/audit?since=2999-01-01T00:00:00Z", nil); strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("expected no entry, got %s", rec.Body)
+	}
+	if rec := send(http.MethodGet, "/audit?since=yesterday", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid since, got %d", rec.Code)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	sp := newPool(t, "http://history:8080")
+	h := admin.Handler(sp, admin.Options{})
+	do(t, h, http.MethodPatch, "/backends", map[string]string{"url": "http://history:8080", "action": "disable"})
+	sp.SetBackendStatus(sp.GetBackends()[0].URL, false)
+
+	rec := do(t, h, http.MethodGet, "/v1/history?backend="+sp.GetBackends()[0].ID(), nil)
+	var transitions []pool.Transition
+	if err := json.Unmarshal(rec.Body.Bytes(), &transitions); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body)
+	}
+	if len(transitions) != 2 || transitions[0].To != pool.StateMaintenance || transitions[0].Cause != pool.CauseAdmin ||
+		transitions[1].To != pool.StateDown || transitions[1].Cause != pool.CauseHealthCheck {
+		t.Errorf("unexpected transitions %+v", transitions)
+	}
+	if rec := do(t, h, http.MethodGet, "/v1/history?limit=x", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a bad limit, got %d", rec.Code)
+	}
+}
+
+func TestFaultsEndpoint(t *testing.T) {
+	if rec := do(t, admin.Handler(newPool(t), admin.Options{}), http.MethodGet, "/faults", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("/faults must not exist when fault injection is off, got %d", rec.Code)
+	}
+
+	faults := &proxy.FaultInjector{}
+	h := admin.Handler(newPool(t), admin.Options{Routes: newCanaryRoutes(t), Faults: faults})
+	rules := []proxy.FaultRule{{Route: "web", Percent: 10, DelayMs: 500}, {Percent: 1, Status: 503}}
+	if rec := do(t, h, http.MethodPut, "/faults", rules); rec.Code != http.StatusOK {
+		t.Fatalf("PUT: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if got := faults.Rules(); len(got) != 2 || got[0].DelayMs != 500 {
+		t.Errorf("rules not applied: %+v", got)
+	}
+
+	cases := []struct {
+		name string
+		body any
+		want int
+	}{
+		{"unknown route", []proxy.FaultRule{{Route: "api", Percent: 10, Abort: true}}, http.StatusNotFound},
+		{"invalid rule", []proxy.FaultRule{{Percent: 10}}, http.StatusBadRequest},
+		{"not a list", map[string]string{"route": "web"}, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if rec := do(t, h, http.MethodPut, "/faults", c.body); rec.Code != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, rec.Code, c.want)
+		}
+	}
+	if len(faults.Rules()) != 2 {
+		t.Error("a rejected PUT must keep the rules")
+	}
+
+	if rec := do(t, h, http.MethodDelete, "/faults", nil); rec.Code != http.StatusNoContent || len(faults.Rules()) != 0 {
+		t.Errorf("DELETE: got %d, rules %+v", rec.Code, faults.Rules())
+	}
+}
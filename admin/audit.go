@@ -0,0 +1,259 @@
+package admin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"reverse-proxy/pool"
+	"reverse-proxy/route"
+	"reverse-proxy/state"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry records one admin API request that may change the proxy.
+type AuditEntry struct {
+	Time     time.Time       `json:"time"`
+	Actor    string          `json:"actor"` // who authenticated, "anonymous" without a token
+	SourceIP string          `json:"source_ip"`
+	Method   string          `json:"method"`
+	Path     string          `json:"path"`
+	Request  json.RawMessage `json:"request,omitempty"` // the request body, when JSON and small enough
+	Status   int             `json:"status"`
+	Changes  []AuditChange   `json:"changes"` // empty when nothing of the runtime state changed
+}
+
+// AuditChange is one value of the runtime state (see state.State) changed
+// by a request. Old is null for an addition, New for a removal.
+type AuditChange struct {
+	Field string `json:"field"` // "backends[<url>]", "strategy", "strategies.<route>" or "weights.<route>.<group>"
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// maxAuditBody caps the request body kept in an entry.
+const maxAuditBody = 4 << 10
+
+// AuditLog appends every admin mutation to a file, one JSON entry per line.
+// The file is only ever appended to; rotate it with an external tool.
+type AuditLog struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+
+	// serial runs the audited requests one at a time, so the changes seen
+	// between the two captures are the request's own.
+	serial sync.Mutex
+}
+
+// OpenAuditLog opens (or creates) the audit log at path for appending.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{path: path, file: f}, nil
+}
+
+// Close closes the file.
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// Record appends e to the log.
+func (a *AuditLog) Record(e AuditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.file.Write(append(line, '\n'))
+	return err
+}
+
+// Entries reads back the entries recorded at or after since, oldest first,
+// keeping the last limit ones (0 = all).
+func (a *AuditLog) Entries(since time.Time, limit int) ([]AuditEntry, error) {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []AuditEntry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // a line cut by a crash
+		}
+		if e.Time.Before(since) {
+			continue
+		}
+		entries = append(entries, e)
+		if limit > 0 && len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+	return entries, scanner.Err()
+}
+
+type actorKey struct{}
+
+// withActor records who authenticated the request, for the audit log.
+func withActor(r *http.Request, actor string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), actorKey{}, actor))
+}
+
+func actorFrom(r *http.Request) string {
+	if actor, ok := r.Context().Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return "anonymous"
+}
+
+// statusRecorder keeps the status of the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// middleware records the requests that may change something, successful
+// or not, with the state changes they made.
+func (a *AuditLog) middleware(serverPool pool.LoadBalancer, routes *route.Table, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions ||
+			strings.HasPrefix(r.URL.Path, "/debug/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		entry := AuditEntry{Time: time.Now().UTC(), Actor: actorFrom(r), Method: r.Method, Path: r.URL.Path}
+		entry.SourceIP, _, _ = net.SplitHostPort(r.RemoteAddr)
+		if r.Body != nil {
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxAuditBody+1))
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			if err == nil && len(body) <= maxAuditBody && json.Valid(body) {
+				entry.Request = bytes.TrimSpace(body)
+			}
+		}
+
+		a.serial.Lock()
+		defer a.serial.Unlock()
+		before := state.Capture(serverPool, routes)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		entry.Status = rec.status
+		entry.Changes = diffState(before, state.Capture(serverPool, routes))
+
+		if err := a.Record(entry); err != nil {
+			log.Printf("Failed to write the audit log: %v", err)
+		}
+	})
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// diffState lists what changed between two captures.
+func diffState(before, after *state.State) []AuditChange {
+	changes := []AuditChange{}
+	old := map[string]state.Backend{}
+	for _, b := range before.Backends {
+		old[b.URL] = b
+	}
+	for _, b := range after.Backends {
+		prev, existed := old[b.URL]
+		delete(old, b.URL)
+		switch {
+		case !existed:
+			changes = append(changes, AuditChange{Field: "backends[" + b.URL + "]", New: b})
+		case prev.AdminDown != b.AdminDown || weightOf(prev) != weightOf(b) || !maps.Equal(prev.Tags, b.Tags):
+			changes = append(changes, AuditChange{Field: "backends[" + b.URL + "]", Old: prev, New: b})
+		}
+	}
+	for _, b := range before.Backends {
+		if prev, removed := old[b.URL]; removed {
+			changes = append(changes, AuditChange{Field: "backends[" + b.URL + "]", Old: prev})
+		}
+	}
+
+	if before.Strategy != after.Strategy {
+		changes = append(changes, AuditChange{Field: "strategy", Old: before.Strategy, New: after.Strategy})
+	}
+	for _, rt := range slices.Sorted(maps.Keys(after.Strategies)) {
+		if prev := before.Strategies[rt]; prev != after.Strategies[rt] {
+			changes = append(changes, AuditChange{Field: "strategies." + rt, Old: prev, New: after.Strategies[rt]})
+		}
+	}
+
+	var fields []string
+	for rt, groups := range after.Weights {
+		for g, w := range groups {
+			if prev, ok := before.Weights[rt][g]; !ok || prev != w {
+				fields = append(fields, rt+"\x00"+g)
+			}
+		}
+	}
+	slices.Sort(fields)
+	for _, f := range fields {
+		rt, g, _ := strings.Cut(f, "\x00")
+		changes = append(changes, AuditChange{Field: "weights." + rt + "." + g, Old: before.Weights[rt][g], New: after.Weights[rt][g]})
+	}
+	return changes
+}
+
+func weightOf(b state.Backend) int {
+	if b.Weight == nil {
+		return pool.DefaultWeight
+	}
+	return *b.Weight
+}
+
+// sinceLimitQuery parses the since and limit parameters of GET /audit and
+// GET /history.
+func sinceLimitQuery(r *http.Request) (since time.Time, limit int, err error) {
+	limit = 100
+	q := r.URL.Query()
+	if raw := q.Get("since"); raw != "" {
+		if since, err = time.Parse(time.RFC3339, raw); err != nil {
+			return since, 0, fmt.Errorf("invalid since, expected RFC 3339: %w", err)
+		}
+	}
+	if raw := q.Get("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 0 {
+			return since, 0, fmt.Errorf("invalid limit %q", raw)
+		}
+	}
+	return since, limit, nil
+}
@@ -0,0 +1,91 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// startTime is when the process started serving, for the uptime of
+// /debug/runtime.
+var startTime = time.Now()
+
+// RuntimeStats is the response of GET /debug/runtime: a cheap snapshot to
+// look at before reaching for a profile.
+type RuntimeStats struct {
+	GoVersion     string  `json:"go_version"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	NumCPU        int     `json:"num_cpu"`
+	GOMAXPROCS    int     `json:"gomaxprocs"`
+	Goroutines    int     `json:"goroutines"`
+
+	HeapAllocBytes  uint64  `json:"heap_alloc_bytes"`  // live objects
+	HeapInuseBytes  uint64  `json:"heap_inuse_bytes"`  // spans holding them
+	HeapObjects     uint64  `json:"heap_objects"`      // live objects count
+	SysBytes        uint64  `json:"sys_bytes"`         // obtained from the OS
+	TotalAllocBytes uint64  `json:"total_alloc_bytes"` // cumulative
+	NextGCBytes     uint64  `json:"next_gc_bytes"`     // heap size triggering the next GC
+	StackInuseBytes uint64  `json:"stack_inuse_bytes"` // goroutine stacks
+	LiveAllocations uint64  `json:"live_allocations"`  // mallocs - frees
+	GCCPUFraction   float64 `json:"gc_cpu_fraction"`   // share of CPU spent in GC since start
+
+	NumGC        uint32    `json:"num_gc"`
+	LastGC       time.Time `json:"last_gc,omitzero"`
+	PauseTotalMs float64   `json:"gc_pause_total_ms"`
+	RecentPauses []float64 `json:"gc_recent_pauses_ms"` // most recent first, up to 16
+}
+
+func runtimeStats() RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m) // stops the world briefly: fine for an on-demand endpoint
+
+	stats := RuntimeStats{
+		GoVersion:       runtime.Version(),
+		UptimeSeconds:   time.Since(startTime).Seconds(),
+		NumCPU:          runtime.NumCPU(),
+		GOMAXPROCS:      runtime.GOMAXPROCS(0),
+		Goroutines:      runtime.NumGoroutine(),
+		HeapAllocBytes:  m.HeapAlloc,
+		HeapInuseBytes:  m.HeapInuse,
+		HeapObjects:     m.HeapObjects,
+		SysBytes:        m.Sys,
+		TotalAllocBytes: m.TotalAlloc,
+		NextGCBytes:     m.NextGC,
+		StackInuseBytes: m.StackInuse,
+		LiveAllocations: m.Mallocs - m.Frees,
+		GCCPUFraction:   m.GCCPUFraction,
+		NumGC:           m.NumGC,
+		PauseTotalMs:    float64(m.PauseTotalNs) / 1e6,
+		RecentPauses:    []float64{},
+	}
+	if m.LastGC != 0 {
+		stats.LastGC = time.Unix(0, int64(m.LastGC))
+	}
+	// PauseNs is a ring buffer whose latest entry is at (NumGC-1)%256.
+	for i := uint32(0); i < min(m.NumGC, 16); i++ {
+		pause := m.PauseNs[(m.NumGC-1-i)%uint32(len(m.PauseNs))]
+		stats.RecentPauses = append(stats.RecentPauses, float64(pause)/1e6)
+	}
+	return stats
+}
+
+// registerDebug adds the net/http/pprof profiles and /debug/runtime, to
+// profile the proxy under production load without a special build.
+func registerDebug(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index) // heap, goroutine, allocs, block, mutex, ...
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile) // CPU, ?seconds=30
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("/debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(runtimeStats())
+	})
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksTTL is how long fetched keys are trusted before a refresh.
+	jwksTTL = 10 * time.Minute
+	// jwksMinRefresh throttles refreshes triggered by unknown key IDs, so
+	// tokens with random kids cannot hammer the identity provider.
+	jwksMinRefresh = 30 * time.Second
+)
+
+// jwksCache holds the RSA keys of a JWKS endpoint by key ID.
+type jwksCache struct {
+	url    string
+	client *http.Client
+
+	mux      sync.Mutex
+	keys     map[string]*rsa.PublicKey
+	fetched  time.Time     // last successful fetch
+	tried    time.Time     // last attempt
+	fetching chan struct{} // closed when the refresh in progress ends; nil if none
+}
+
+func newJWKSCache(url string) *jwksCache {
+	return &jwksCache{url: url, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// key returns the key for kid, refreshing the set when it is stale or does
+// not know kid (the provider may have rotated its keys). An empty kid is
+// accepted when the set holds a single key. The refresh runs without the
+// lock: known keys keep being served meanwhile, only the callers missing
+// their key wait for the new set.
+func (c *jwksCache) key(kid string) *rsa.PublicKey {
+	c.mux.Lock()
+	now := time.Now()
+	key := c.lookup(kid)
+	stale := now.Sub(c.fetched) > jwksTTL
+	if (key == nil || stale) && c.fetching == nil && now.Sub(c.tried) > jwksMinRefresh {
+		c.tried = now
+		c.fetching = make(chan struct{})
+		go c.refresh(c.fetching)
+	}
+	fetching := c.fetching
+	c.mux.Unlock()
+	if key != nil || fetching == nil {
+		return key
+	}
+
+	<-fetching
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.lookup(kid)
+}
+
+// refresh replaces the key set with a fresh fetch, keeping the current one
+// if it fails, then wakes the callers waiting on done.
+func (c *jwksCache) refresh(done chan struct{}) {
+	keys, err := c.fetch()
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if err != nil {
+		log.Printf("JWKS refresh from %s failed: %v", c.url, err)
+	} else {
+		c.keys, c.fetched = keys, time.Now()
+	}
+	c.fetching = nil
+	close(done)
+}
+
+func (c *jwksCache) lookup(kid string) *rsa.PublicKey {
+	if kid == "" && len(c.keys) == 1 {
+		for _, k := range c.keys {
+			return k
+		}
+	}
+	return c.keys[kid]
+}
+
+func (c *jwksCache) fetch() (map[string]*rsa.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
@@ -0,0 +1,270 @@
+// Package auth verifies JSON Web Tokens at the proxy, so backends receive
+// only authenticated requests along with the caller's claims.
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Errors reported by Validate. Every one of them means 401 for the client.
+var (
+	ErrMissingToken = errors.New("missing bearer token")
+	ErrMalformed    = errors.New("malformed token")
+	ErrSignature    = errors.New("invalid token signature")
+	ErrExpired      = errors.New("token expired")
+	ErrNotYetValid  = errors.New("token not valid yet")
+	ErrClaims       = errors.New("token claims rejected")
+)
+
+// Config selects the keys and the claim checks. Exactly one key source is
+// needed: Secret (HS256), PublicKeyFile (RS256) or JWKSURL (RS256 keys
+// picked by "kid").
+type Config struct {
+	Secret        string
+	PublicKeyFile string
+	JWKSURL       string
+
+	Issuer   string        // required "iss" when set
+	Audience string        // must appear in "aud" when set
+	Leeway   time.Duration // clock skew tolerated on exp/nbf
+
+	// ClaimHeaders forwards claims to the backend, e.g. {"sub": "X-User-Id"}.
+	// Those headers are always stripped from the incoming request first, so
+	// a client cannot forge them.
+	ClaimHeaders map[string]string
+}
+
+// Validator checks tokens against one Config. Create it with NewValidator.
+type Validator struct {
+	cfg    Config
+	secret []byte
+	rsaKey *rsa.PublicKey
+	jwks   *jwksCache
+	now    func() time.Time
+}
+
+// NewValidator loads the configured key. A JWKS is fetched lazily, so an
+// identity provider that is briefly down does not prevent startup.
+func NewValidator(cfg Config) (*Validator, error) {
+	v := &Validator{cfg: cfg, now: time.Now}
+	sources := 0
+	if cfg.Secret != "" {
+		v.secret = []byte(cfg.Secret)
+		sources++
+	}
+	if cfg.PublicKeyFile != "" {
+		key, err := loadPublicKey(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		v.rsaKey = key
+		sources++
+	}
+	if cfg.JWKSURL != "" {
+		v.jwks = newJWKSCache(cfg.JWKSURL)
+		sources++
+	}
+	if sources != 1 {
+		return nil, errors.New("jwt: configure exactly one of secret, public_key_file or jwks_url")
+	}
+	return v, nil
+}
+
+func loadPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("jwt: %s holds no PEM block", path)
+	}
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		if key, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+			return key, nil
+		}
+		return nil, fmt.Errorf("jwt: %s: %w", path, err)
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("jwt: %s is not an RSA public key", path)
+	}
+	return key, nil
+}
+
+// Authenticate validates the request's bearer token and returns a copy of
+// the request carrying the configured claim headers.
+func (v *Validator) Authenticate(r *http.Request) (*http.Request, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, ErrMissingToken
+	}
+	claims, err := v.Validate(strings.TrimSpace(token))
+	if err != nil {
+		return nil, err
+	}
+
+	r = r.Clone(r.Context())
+	names := make([]string, 0, len(v.cfg.ClaimHeaders))
+	for claim := range v.cfg.ClaimHeaders {
+		names = append(names, claim)
+	}
+	sort.Strings(names)
+	for _, claim := range names {
+		header := v.cfg.ClaimHeaders[claim]
+		r.Header.Del(header)
+		if value, ok := claims[claim]; ok {
+			r.Header.Set(header, claimString(value))
+		}
+	}
+	return r, nil
+}
+
+// Validate verifies the signature and the registered claims of a compact
+// JWS token and returns its claims.
+func (v *Validator) Validate(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if err := v.verify(header.Alg, header.Kid, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrMalformed
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verify checks the signature with the key matching the token's algorithm.
+// The algorithm must match the configured key type, which rules out "none"
+// and HS256 tokens signed with a public key.
+func (v *Validator) verify(alg, kid, signed string, sig []byte) error {
+	switch alg {
+	case "HS256":
+		if v.secret == nil {
+			return ErrSignature
+		}
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return ErrSignature
+		}
+		return nil
+	case "RS256":
+		key := v.rsaKey
+		if v.jwks != nil {
+			key = v.jwks.key(kid)
+		}
+		if key == nil {
+			return ErrSignature
+		}
+		digest := sha256.Sum256([]byte(signed))
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) != nil {
+			return ErrSignature
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unsupported algorithm %q", ErrSignature, alg)
+}
+
+func (v *Validator) checkClaims(claims map[string]any) error {
+	now := v.now()
+	if exp, ok := numericDate(claims["exp"]); ok && !now.Before(exp.Add(v.cfg.Leeway)) {
+		return ErrExpired
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(v.cfg.Leeway).Before(nbf) {
+		return ErrNotYetValid
+	}
+	if v.cfg.Issuer != "" && claims["iss"] != v.cfg.Issuer {
+		return fmt.Errorf("%w: unexpected issuer", ErrClaims)
+	}
+	if v.cfg.Audience != "" && !hasAudience(claims["aud"], v.cfg.Audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrClaims)
+	}
+	return nil
+}
+
+func decodeSegment(seg string, out any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func numericDate(v any) (time.Time, bool) {
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// hasAudience accepts "aud" as a single string or an array of strings.
+func hasAudience(aud any, want string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == want
+	case []any:
+		for _, v := range a {
+			if v == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimString renders a claim as a header value: strings as is, arrays
+// comma-separated, anything else as JSON.
+func claimString(v any) string {
+	switch c := v.(type) {
+	case string:
+		return c
+	case []any:
+		parts := make([]string, len(c))
+		for i, item := range c {
+			parts[i] = claimString(item)
+		}
+		return strings.Join(parts, ",")
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func segment(v any) string {
+	data, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHS256(secret string, claims map[string]any) string {
+	signed := segment(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + segment(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	signed := segment(map[string]string{"alg": "RS256", "kid": kid}) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestValidate_HS256(t *testing.T) {
+	v, err := NewValidator(Config{Secret: "s3cret", Issuer: "idp", Audience: "shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := float64(time.Now().Add(time.Hour).Unix())
+
+	cases := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"valid", signHS256("s3cret", map[string]any{"iss": "idp", "aud": []string{"shop", "blog"}, "exp": exp}), nil},
+		{"wrong secret", signHS256("guess", map[string]any{"iss": "idp", "aud": "shop", "exp": exp}), ErrSignature},
+		{"expired", signHS256("s3cret", map[string]any{"iss": "idp", "aud": "shop", "exp": float64(time.Now().Add(-time.Minute).Unix())}), ErrExpired},
+		{"not yet valid", signHS256("s3cret", map[string]any{"iss": "idp", "aud": "shop", "nbf": float64(time.Now().Add(time.Hour).Unix())}), ErrNotYetValid},
+		{"wrong issuer", signHS256("s3cret", map[string]any{"iss": "evil", "aud": "shop", "exp": exp}), ErrClaims},
+		{"wrong audience", signHS256("s3cret", map[string]any{"iss": "idp", "aud": "blog", "exp": exp}), ErrClaims},
+		{"alg none", segment(map[string]string{"alg": "none"}) + "." + segment(map[string]any{"iss": "idp"}) + ".", ErrSignature},
+		{"garbage", "not-a-token", ErrMalformed},
+	}
+	for _, c := range cases {
+		_, err := v.Validate(c.token)
+		if (c.want == nil) != (err == nil) || (c.want != nil && !errors.Is(err, c.want)) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+}
+
+func TestValidate_JWKS(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var fetches int64
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&fetches, 1)
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer idp.Close()
+
+	v, err := NewValidator(Config{JWKSURL: idp.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]any{"sub": "42"}
+	if _, err := v.Validate(signRS256(key, "k1", claims)); err != nil {
+		t.Fatalf("valid RS256 token rejected: %v", err)
+	}
+
+	// An unknown kid triggers at most one refresh within the throttle window.
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for i := 0; i < 3; i++ {
+		if _, err := v.Validate(signRS256(other, "k2", claims)); !errors.Is(err, ErrSignature) {
+			t.Errorf("token signed by an unknown key: got %v", err)
+		}
+	}
+	if n := atomic.LoadInt64(&fetches); n != 1 {
+		t.Errorf("expected a single JWKS fetch, got %d", n)
+	}
+
+	// An HS256 token must not be accepted by an RSA-only validator.
+	if _, err := v.Validate(signHS256("anything", claims)); !errors.Is(err, ErrSignature) {
+		t.Errorf("HS256 token on an RS256 validator: got %v", err)
+	}
+}
+
+// A stale key set is refreshed in the background: the cached keys keep
+// being served while the provider is slow to answer.
+func TestJWKSCache_ServesCachedKeysWhileRefreshing(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var fetches int64
+	release := make(chan struct{})
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&fetches, 1) > 1 {
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer idp.Close()
+	defer close(release)
+
+	c := newJWKSCache(idp.URL)
+	if c.key("k1") == nil {
+		t.Fatal("expected the key after the first fetch")
+	}
+
+	// Make the set stale: the next lookups start a single refresh, which
+	// blocks, and still answer at once from the cached set.
+	c.mux.Lock()
+	c.fetched, c.tried = time.Now().Add(-2*jwksTTL), time.Time{}
+	c.mux.Unlock()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if c.key("k1") == nil {
+			t.Fatal("expected the cached key during the refresh")
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("lookups waited for the refresh (%v)", elapsed)
+	}
+	for atomic.LoadInt64(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&fetches); n != 2 {
+		t.Errorf("expected a single refresh, got %d fetches", n-1)
+	}
+}
+
+func TestAuthenticate_ForwardsClaimHeaders(t *testing.T) {
+	v, _ := NewValidator(Config{
+		Secret:       "s3cret",
+		ClaimHeaders: map[string]string{"sub": "X-User-Id", "roles": "X-User-Roles", "email": "X-User-Email"},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, err := v.Authenticate(req); !errors.Is(err, ErrMissingToken) {
+		t.Errorf("expected ErrMissingToken, got %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+signHS256("s3cret", map[string]any{"sub": "42", "roles": []string{"admin", "ops"}}))
+	req.Header.Set("X-User-Email", "forged@example.com")
+	authed, err := v.Authenticate(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authed.Header.Get("X-User-Id") != "42" || authed.Header.Get("X-User-Roles") != "admin,ops" {
+		t.Errorf("unexpected claim headers: %v", authed.Header)
+	}
+	if authed.Header.Get("X-User-Email") != "" {
+		t.Error("a claim header sent by the client must be stripped")
+	}
+	if req.Header.Get("X-User-Id") != "" {
+		t.Error("the original request must not be modified")
+	}
+}
@@ -0,0 +1,463 @@
+// Package cache is an in-memory HTTP response cache placed in front of the
+// backends. It follows the shared-cache rules of Cache-Control/Expires, keys
+// entries by method, host and URL plus the request headers listed in Vary,
+// and evicts the least recently used entries beyond its size budget.
+// Optionally, concurrent misses on the same resource are coalesced into a
+// single backend fetch.
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"reverse-proxy/proxy"
+)
+
+// Cache holds responses within a byte budget. Create it with New.
+type Cache struct {
+	// Coalesce reports whether the requests missing r's resource while it is
+	// already being fetched wait for that fetch, then are answered from the
+	// cache, so a spike of misses reaches the backend once. It lets the
+	// caller enable it per route; nil never coalesces. Set it before use.
+	Coalesce func(r *http.Request) bool
+
+	maxBytes      int64
+	maxEntryBytes int64
+	defaultTTL    time.Duration
+
+	mux     sync.Mutex
+	size    int64
+	lru     *list.List               // of *entry, most recent first
+	entries map[string]*list.Element // by full key (base + Vary values)
+	bases   map[string]*variants     // by base key
+	flights map[string]*flight       // fetches in progress, by base key, with Coalesce
+	now     func() time.Time
+}
+
+// flight is a backend fetch other requests for the same resource wait for.
+type flight struct {
+	done    chan struct{} // closed once the response is stored, or not
+	waiters int
+}
+
+// Stats is a snapshot of the cache occupancy.
+type Stats struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	MaxSize int64 `json:"max_bytes"`
+}
+
+// variants are the stored versions of one resource, told apart by the
+// request headers listed in the response's Vary.
+type variants struct {
+	names []string
+	count int
+}
+
+type entry struct {
+	key     string
+	base    string
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+func (e *entry) size() int64 {
+	n := int64(len(e.key) + len(e.body))
+	for k, vals := range e.header {
+		for _, v := range vals {
+			n += int64(len(k) + len(v))
+		}
+	}
+	return n
+}
+
+// New returns a cache holding up to maxBytes of responses, none larger than
+// maxEntryBytes. defaultTTL applies to cacheable responses without explicit
+// freshness information; 0 only caches responses that declare a lifetime.
+func New(maxBytes, maxEntryBytes int64, defaultTTL time.Duration) *Cache {
+	if maxEntryBytes <= 0 || maxEntryBytes > maxBytes {
+		maxEntryBytes = maxBytes
+	}
+	return &Cache{
+		maxBytes:      maxBytes,
+		maxEntryBytes: maxEntryBytes,
+		defaultTTL:    defaultTTL,
+		lru:           list.New(),
+		entries:       make(map[string]*list.Element),
+		bases:         make(map[string]*variants),
+		flights:       make(map[string]*flight),
+		now:           time.Now,
+	}
+}
+
+// Middleware serves fresh cached responses and stores cacheable ones coming
+// back from next. X-Cache tells the client whether the response was a HIT or
+// a MISS.
+func (c *Cache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		reqCC := parseCacheControl(r.Header.Get("Cache-Control"))
+		if _, ok := reqCC["no-store"]; ok || r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		base := baseKey(r)
+		_, noCache := reqCC["no-cache"] // revalidate: skip the lookup, still store
+		if !noCache {
+			if e := c.lookup(base, r); e != nil {
+				proxy.DecisionFromContext(r.Context()).Check("cache", true, "hit")
+				c.serve(w, r, e)
+				return
+			}
+		}
+
+		if c.Coalesce != nil && !noCache && c.Coalesce(r) {
+			f, leader := c.join(base)
+			if leader {
+				defer c.land(base, f)
+			} else {
+				select {
+				case <-f.done:
+				case <-r.Context().Done():
+					return // the client left
+				}
+				// The response may not be cacheable, or be another variant:
+				// then fetch it as if there had been no flight.
+				if e := c.lookup(base, r); e != nil {
+					proxy.DecisionFromContext(r.Context()).Check("cache", true, "coalesced")
+					c.serve(w, r, e)
+					return
+				}
+			}
+		}
+
+		rec := &recorder{ResponseWriter: w, limit: c.maxEntryBytes}
+		rec.Header().Set("X-Cache", "MISS")
+		next.ServeHTTP(rec, r)
+		if rec.storable() {
+			c.store(base, r, rec)
+		}
+	})
+}
+
+// Purge drops the entries whose URL starts with prefix, or every entry when
+// prefix is empty. A prefix starting with "/" matches the path and query on
+// every host; otherwise it includes the host ("example.com/api"). It returns
+// how many entries were removed.
+func (c *Cache) Purge(prefix string) int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	removed := 0
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		e := el.Value.(*entry)
+		if strings.HasPrefix(urlOf(e.base, strings.HasPrefix(prefix, "/")), prefix) {
+			c.remove(el)
+			removed++
+		}
+		el = next
+	}
+	return removed
+}
+
+// Stats reports how many entries and bytes the cache holds.
+func (c *Cache) Stats() Stats {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return Stats{Entries: len(c.entries), Bytes: c.size, MaxSize: c.maxBytes}
+}
+
+// join returns the flight fetching base, and whether the caller is the one
+// to fetch it and then land the flight.
+func (c *Cache) join(base string) (*flight, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if f, ok := c.flights[base]; ok {
+		f.waiters++
+		return f, false
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[base] = f
+	return f, true
+}
+
+// land releases the requests waiting for f.
+func (c *Cache) land(base string, f *flight) {
+	c.mux.Lock()
+	delete(c.flights, base)
+	c.mux.Unlock()
+	close(f.done)
+}
+
+func (c *Cache) lookup(base string, r *http.Request) *entry {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	v, ok := c.bases[base]
+	if !ok {
+		return nil
+	}
+	el, ok := c.entries[fullKey(base, v.names, r.Header)]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*entry)
+	if !c.now().Before(e.expires) {
+		c.remove(el)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return e
+}
+
+func (c *Cache) serve(w http.ResponseWriter, r *http.Request, e *entry) {
+	h := w.Header()
+	for k, vals := range e.header {
+		h[k] = append([]string(nil), vals...)
+	}
+	h.Set("Age", strconv.Itoa(int(c.now().Sub(e.stored).Seconds())))
+	h.Set("X-Cache", "HIT")
+	w.WriteHeader(e.status)
+	if r.Method != http.MethodHead {
+		w.Write(e.body)
+	}
+}
+
+func (c *Cache) store(base string, r *http.Request, rec *recorder) {
+	header := rec.Header().Clone()
+	header.Del("X-Cache")
+	ttl, ok := c.ttl(header)
+	if !ok {
+		return
+	}
+	names := varyNames(header)
+	if names == nil {
+		return // Vary: * can never match
+	}
+
+	now := c.now()
+	e := &entry{
+		base:    base,
+		key:     fullKey(base, names, r.Header),
+		status:  rec.status,
+		header:  header,
+		body:    rec.body.Bytes(),
+		stored:  now,
+		expires: now.Add(ttl),
+	}
+	if e.size() > c.maxEntryBytes {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if v, ok := c.bases[base]; ok && !equalNames(v.names, names) {
+		// The backend changed its Vary list: variants stored under the old
+		// one can no longer be looked up.
+		c.dropBase(base)
+	}
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	v, ok := c.bases[base]
+	if !ok {
+		v = &variants{names: names}
+		c.bases[base] = v
+	}
+	v.count++
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += e.size()
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// ttl derives the freshness lifetime of a response as a shared cache would:
+// s-maxage, then max-age, then Expires, then the default TTL.
+func (c *Cache) ttl(h http.Header) (time.Duration, bool) {
+	cc := parseCacheControl(h.Get("Cache-Control"))
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[d]; ok {
+			return 0, false
+		}
+	}
+	if h.Get("Set-Cookie") != "" {
+		return 0, false // per-user by nature
+	}
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[d]; ok {
+			secs, err := strconv.Atoi(v)
+			return time.Duration(secs) * time.Second, err == nil && secs > 0
+		}
+	}
+	if v := h.Get("Expires"); v != "" {
+		exp, err := http.ParseTime(v)
+		if err != nil {
+			return 0, false // invalid Expires means already expired
+		}
+		base := c.now()
+		if date, err := http.ParseTime(h.Get("Date")); err == nil {
+			base = date
+		}
+		ttl := exp.Sub(base)
+		return ttl, ttl > 0
+	}
+	return c.defaultTTL, c.defaultTTL > 0
+}
+
+// remove unlinks an entry; the caller holds the lock.
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.entries, e.key)
+	c.size -= e.size()
+	if v := c.bases[e.base]; v != nil {
+		if v.count--; v.count == 0 {
+			delete(c.bases, e.base)
+		}
+	}
+}
+
+func (c *Cache) dropBase(base string) {
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*entry).base == base {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// baseKey identifies a resource: method, host and request URI.
+func baseKey(r *http.Request) string {
+	return r.Method + " " + r.Host + r.URL.RequestURI()
+}
+
+// urlOf strips the method, and the host when pathOnly is set, from a base key.
+func urlOf(base string, pathOnly bool) string {
+	_, u, _ := strings.Cut(base, " ")
+	if i := strings.Index(u, "/"); pathOnly && i >= 0 {
+		return u[i:]
+	}
+	return u
+}
+
+func fullKey(base string, names []string, h http.Header) string {
+	if len(names) == 0 {
+		return base
+	}
+	var b strings.Builder
+	b.WriteString(base)
+	for _, name := range names {
+		b.WriteString("\x00")
+		b.WriteString(strings.Join(h.Values(name), ","))
+	}
+	return b.String()
+}
+
+// varyNames returns the canonical, sorted header names of Vary, or nil for
+// "Vary: *".
+func varyNames(h http.Header) []string {
+	names := []string{}
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil
+			}
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCacheControl maps each directive to its (unquoted) value.
+func parseCacheControl(v string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(v, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return directives
+}
+
+// recorder passes the response through to the client while keeping a copy
+// of it, up to limit bytes, so it can be stored afterwards.
+type recorder struct {
+	http.ResponseWriter
+	limit    int64
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.status == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) { // 1xx: the final one follows
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if !r.overflow {
+		if int64(r.body.Len()+len(p)) > r.limit {
+			r.overflow = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(p)
+		}
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer's Flush.
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// storable reports whether the recorded response may go into the cache:
+// complete, of a cacheable status, and not a stream.
+func (r *recorder) storable() bool {
+	if r.overflow {
+		return false
+	}
+	switch r.status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusMovedPermanently,
+		http.StatusNotFound, http.StatusGone:
+	default:
+		return false
+	}
+	ct := r.Header().Get("Content-Type")
+	return !strings.HasPrefix(ct, "text/event-stream") && !strings.HasPrefix(ct, "application/grpc")
+}
@@ -0,0 +1,254 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// counting returns a handler replying with the request count, using the
+// headers set by setup, and the pointer to that count.
+func counting(setup func(w http.ResponseWriter, r *http.Request)) (http.Handler, *int64) {
+	var calls int64
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&calls, 1)
+		setup(w, r)
+		fmt.Fprintf(w, "response %d", n)
+	}), &calls
+}
+
+func get(t *testing.T, h http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCache_HonorsMaxAgeAndExpires(t *testing.T) {
+	c := New(1<<20, 0, 0)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	backend, calls := counting(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/max-age":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		case "/expires":
+			w.Header().Set("Expires", now.Add(30*time.Second).UTC().Format(http.TimeFormat))
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		}
+	})
+	h := c.Middleware(backend)
+
+	for _, path := range []string{"/max-age", "/expires"} {
+		first := get(t, h, path, nil)
+		second := get(t, h, path, nil)
+		if first.Header().Get("X-Cache") != "MISS" || second.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("%s: expected MISS then HIT, got %s then %s", path,
+				first.Header().Get("X-Cache"), second.Header().Get("X-Cache"))
+		}
+		if second.Body.String() != first.Body.String() {
+			t.Errorf("%s: cached body %q differs from %q", path, second.Body, first.Body)
+		}
+	}
+
+	// Without freshness information (and no default TTL), or when private,
+	// every request goes to the backend.
+	before := atomic.LoadInt64(calls)
+	for _, path := range []string{"/none", "/none", "/private", "/private"} {
+		get(t, h, path, nil)
+	}
+	if n := atomic.LoadInt64(calls) - before; n != 4 {
+		t.Errorf("expected 4 uncached backend calls, got %d", n)
+	}
+
+	// Entries expire with their TTL.
+	now = now.Add(45 * time.Second)
+	if rec := get(t, h, "/expires", nil); rec.Header().Get("X-Cache") != "MISS" {
+		t.Error("expected the Expires entry to be stale after 45s")
+	}
+	if rec := get(t, h, "/max-age", nil); rec.Header().Get("X-Cache") != "HIT" || rec.Header().Get("Age") != "45" {
+		t.Errorf("expected a max-age HIT with Age 45, got %s (Age %q)", rec.Header().Get("X-Cache"), rec.Header().Get("Age"))
+	}
+}
+
+func TestCache_DefaultTTLAndRequestDirectives(t *testing.T) {
+	c := New(1<<20, 0, time.Minute)
+	backend, calls := counting(func(w http.ResponseWriter, r *http.Request) {})
+	h := c.Middleware(backend)
+
+	get(t, h, "/page", nil)
+	get(t, h, "/page", nil)
+	if n := atomic.LoadInt64(calls); n != 1 {
+		t.Fatalf("default TTL should cache responses without directives, got %d backend calls", n)
+	}
+
+	// no-cache bypasses the lookup but refreshes the entry; Authorization
+	// and no-store bypass the cache entirely.
+	get(t, h, "/page", http.Header{"Cache-Control": {"no-cache"}})
+	get(t, h, "/page", http.Header{"Authorization": {"Bearer x"}})
+	get(t, h, "/page", http.Header{"Cache-Control": {"no-store"}})
+	if n := atomic.LoadInt64(calls); n != 4 {
+		t.Errorf("expected 4 backend calls, got %d", n)
+	}
+	if rec := get(t, h, "/page", nil); rec.Body.String() != "response 2" {
+		t.Errorf("expected the entry refreshed by no-cache, got %q", rec.Body)
+	}
+
+	// Only GET and HEAD are cached.
+	req := httptest.NewRequest(http.MethodPost, "/page", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if n := atomic.LoadInt64(calls); n != 5 {
+		t.Errorf("POST must reach the backend, got %d calls", n)
+	}
+}
+
+func TestCache_Vary(t *testing.T) {
+	c := New(1<<20, 0, 0)
+	backend, calls := counting(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+	})
+	h := c.Middleware(backend)
+
+	fr := http.Header{"Accept-Language": {"fr"}}
+	en := http.Header{"Accept-Language": {"en"}}
+	a := get(t, h, "/greeting", fr)
+	b := get(t, h, "/greeting", en)
+	if a.Body.String() == b.Body.String() {
+		t.Error("different Accept-Language values must not share an entry")
+	}
+	if rec := get(t, h, "/greeting", fr); rec.Header().Get("X-Cache") != "HIT" || rec.Body.String() != a.Body.String() {
+		t.Errorf("expected the fr variant from the cache, got %q", rec.Body)
+	}
+	if n := atomic.LoadInt64(calls); n != 2 {
+		t.Errorf("expected 2 backend calls, got %d", n)
+	}
+}
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	body := strings.Repeat("x", 400)
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, body)
+	})
+	c := New(1000, 0, 0) // room for two entries
+	h := c.Middleware(backend)
+
+	get(t, h, "/a", nil)
+	get(t, h, "/b", nil)
+	get(t, h, "/a", nil) // /a is now the most recently used
+	get(t, h, "/c", nil) // evicts /b
+
+	if rec := get(t, h, "/a", nil); rec.Header().Get("X-Cache") != "HIT" {
+		t.Error("expected /a to survive eviction")
+	}
+	if rec := get(t, h, "/b", nil); rec.Header().Get("X-Cache") != "MISS" {
+		t.Error("expected /b to be evicted")
+	}
+	if s := c.Stats(); s.Bytes > 1000 || s.Entries != 2 {
+		t.Errorf("cache over budget: %+v", s)
+	}
+}
+
+func TestCache_Purge(t *testing.T) {
+	c := New(1<<20, 0, time.Minute)
+	backend, _ := counting(func(w http.ResponseWriter, r *http.Request) {})
+	h := c.Middleware(backend)
+	for _, path := range []string{"/api/users", "/api/orders", "/static/app.js"} {
+		get(t, h, path, nil)
+	}
+
+	if n := c.Purge("/api/"); n != 2 {
+		t.Errorf("expected 2 entries purged by path prefix, got %d", n)
+	}
+	if n := c.Purge("example.com/static"); n != 1 {
+		t.Errorf("expected 1 entry purged by host and path prefix, got %d", n)
+	}
+	if s := c.Stats(); s.Entries != 0 || s.Bytes != 0 {
+		t.Errorf("expected an empty cache, got %+v", s)
+	}
+}
+
+func TestCache_CoalescesConcurrentMisses(t *testing.T) {
+	c := New(1<<20, 0, 0)
+	c.Coalesce = func(r *http.Request) bool { return r.URL.Path != "/uncoalesced" }
+	release := make(chan struct{})
+	backend, calls := counting(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		if r.URL.Path != "/private" {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+	})
+	h := c.Middleware(backend)
+
+	for _, tc := range []struct {
+		path  string
+		calls int64 // backend calls for the 5 requests
+	}{
+		{"/cacheable", 1},
+		{"/private", 5}, // nothing to share: each waiter fetches its own
+	} {
+		atomic.StoreInt64(calls, 0)
+		release = make(chan struct{})
+		bodies := make(chan string, 5)
+		for i := 0; i < 5; i++ {
+			go func() { bodies <- get(t, h, tc.path, nil).Body.String() }()
+		}
+		// Release the fetch once the 4 other requests wait for it.
+		waitFor(t, func() bool {
+			c.mux.Lock()
+			defer c.mux.Unlock()
+			f := c.flights[http.MethodGet+" example.com"+tc.path]
+			return f != nil && f.waiters == 4
+		})
+		close(release)
+
+		seen := map[string]bool{}
+		for i := 0; i < 5; i++ {
+			seen[<-bodies] = true
+		}
+		if n := atomic.LoadInt64(calls); n != tc.calls {
+			t.Errorf("%s: expected %d backend calls, got %d", tc.path, tc.calls, n)
+		}
+		if tc.calls == 1 && len(seen) != 1 {
+			t.Errorf("%s: every waiter should get the same response, got %v", tc.path, seen)
+		}
+	}
+
+	// Where Coalesce says no, every miss goes to the backend at once.
+	atomic.StoreInt64(calls, 0)
+	release = make(chan struct{})
+	done := make(chan struct{}, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			get(t, h, "/uncoalesced", nil)
+			done <- struct{}{}
+		}()
+	}
+	waitFor(t, func() bool { return atomic.LoadInt64(calls) == 5 })
+	close(release)
+	for i := 0; i < 5; i++ {
+		<-done
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,105 @@
+// Package capture records a sample of the proxied requests to a file, one
+// JSON line each, and replays such a file against a target, for load and
+// regression tests with realistic traffic.
+package capture
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultRedactHeaders are the headers whose values are not recorded when
+// Options.RedactHeaders is nil: a capture file must not leak credentials.
+var DefaultRedactHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// Request is one captured request.
+type Request struct {
+	Time   time.Time   `json:"time"`
+	Method string      `json:"method"`
+	Host   string      `json:"host"`
+	URI    string      `json:"uri"` // path and query
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"` // base64 in the file
+	// BodyOmitted is set when the body was larger than Options.BodyBytes:
+	// it is replayed without one.
+	BodyOmitted bool `json:"body_omitted,omitempty"`
+}
+
+// Options tunes the recorder.
+type Options struct {
+	SamplePercent float64  // share of the requests recorded, (0, 100]
+	BodyBytes     int64    // bodies up to this size are recorded; 0 records none
+	RedactHeaders []string // recorded as "REDACTED"; nil uses DefaultRedactHeaders
+}
+
+// Recorder is the capture middleware. Create it with New.
+type Recorder struct {
+	opts Options
+	mux  sync.Mutex
+	enc  *json.Encoder
+}
+
+// New returns a recorder writing to w.
+func New(w io.Writer, opts Options) *Recorder {
+	if opts.RedactHeaders == nil {
+		opts.RedactHeaders = DefaultRedactHeaders
+	}
+	return &Recorder{opts: opts, enc: json.NewEncoder(w)}
+}
+
+// Middleware records the sampled requests as they arrive, before next sees
+// them. A recorded body is read up to Options.BodyBytes and handed on
+// unchanged.
+func (c *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rand.Float64()*100 >= c.opts.SamplePercent {
+			next.ServeHTTP(w, r)
+			return
+		}
+		req := Request{
+			Time:   time.Now(),
+			Method: r.Method,
+			Host:   r.Host,
+			URI:    r.URL.RequestURI(),
+			Header: r.Header.Clone(),
+		}
+		for _, name := range c.opts.RedactHeaders {
+			if req.Header.Get(name) != "" {
+				req.Header.Set(name, "REDACTED")
+			}
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			if r.ContentLength > c.opts.BodyBytes {
+				req.BodyOmitted = true
+			} else {
+				head, err := io.ReadAll(io.LimitReader(r.Body, c.opts.BodyBytes+1))
+				if err != nil || int64(len(head)) > c.opts.BodyBytes {
+					req.BodyOmitted = true
+				} else {
+					req.Body = head
+				}
+				r = r.WithContext(r.Context()) // don't swap the body of the caller's request
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+			}
+		}
+		c.write(&req)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *Recorder) write(req *Request) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if err := c.enc.Encode(req); err != nil {
+		log.Printf("Capture write failed: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	var file bytes.Buffer
+	var forwarded []string
+	rec := New(&file, Options{SamplePercent: 100, BodyBytes: 8})
+	h := rec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwarded = append(forwarded, string(body))
+	}))
+	for _, body := range []string{"small", "much larger than the cap"} {
+		req := httptest.NewRequest(http.MethodPost, "http://shop.example/cart?id=7", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("X-Tenant", "acme")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	if forwarded[0] != "small" || forwarded[1] != "much larger than the cap" {
+		t.Fatalf("the recorder must hand the bodies on unchanged, got %q", forwarded)
+	}
+	if strings.Contains(file.String(), "secret") {
+		t.Error("the Authorization header must be redacted")
+	}
+
+	type received struct{ method, host, uri, tenant, auth, body string }
+	var mux sync.Mutex
+	var got []received
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mux.Lock()
+		got = append(got, received{r.Method, r.Host, r.URL.RequestURI(), r.Header.Get("X-Tenant"), r.Header.Get("Authorization"), string(body)})
+		mux.Unlock()
+		if r.URL.Path == "/base/health" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer target.Close()
+	u, _ := url.Parse(target.URL + "/base/")
+
+	report, err := Replay(context.Background(), &file, ReplayOptions{Targets: []*url.URL{u}, Rate: 1000, Concurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Sent != 3 || report.Failed != 0 || report.Statuses[200] != 2 || report.Statuses[503] != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	want := []received{
+		{"POST", "shop.example", "/base/cart?id=7", "acme", "", "small"},
+		{"POST", "shop.example", "/base/cart?id=7", "acme", "", ""}, // body over the cap
+		{"GET", "example.com", "/base/health", "", "", ""},
+	}
+	for i := range want {
+		if i >= len(got) || got[i] != want[i] {
+			t.Errorf("request %d: got %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestRecorder_Samples(t *testing.T) {
+	var file bytes.Buffer
+	h := New(&file, Options{SamplePercent: 10}).Middleware(http.NotFoundHandler())
+	for range 1000 {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if n := strings.Count(file.String(), "\n"); n < 50 || n > 200 {
+		t.Errorf("expected about 100 of 1000 requests recorded, got %d", n)
+	}
+}
@@ -0,0 +1,172 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplayOptions tunes Replay.
+type ReplayOptions struct {
+	Targets     []*url.URL // base URLs, sent to in turn
+	Rate        float64    // requests per second; 0 keeps the recorded pacing
+	Concurrency int        // requests in flight at most; defaults to 10
+	Client      *http.Client
+}
+
+// Report sums up a replay.
+type Report struct {
+	Sent      int
+	Failed    int // transport errors; error statuses are in Statuses
+	Statuses  map[int]int
+	Duration  time.Duration
+	Latencies []time.Duration // sorted
+}
+
+// Percentile returns the latency below which p (0-1) of the responses came.
+func (r Report) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	return r.Latencies[int(float64(len(r.Latencies)-1)*p)]
+}
+
+// hopHeaders are not replayed: the client sets its own.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length"}
+
+// Replay sends the requests captured in r to the targets, with the recorded
+// method, URI, headers (but the redacted ones), Host and body. It stops at
+// the end of r or when ctx is cancelled.
+func Replay(ctx context.Context, r io.Reader, opts ReplayOptions) (Report, error) {
+	if len(opts.Targets) == 0 {
+		return Report{}, errors.New("no replay target")
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 10
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	}
+
+	report := Report{Statuses: map[int]int{}}
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, opts.Concurrency)
+	start := time.Now()
+	var first time.Time
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var err error
+	for i := 0; ; i++ {
+		var captured Request
+		if err = dec.Decode(&captured); err != nil {
+			if err == io.EOF {
+				err = nil
+			} else {
+				err = fmt.Errorf("request %d: %w", i+1, err)
+			}
+			break
+		}
+		if i == 0 {
+			first = captured.Time
+		}
+		at := start.Add(captured.Time.Sub(first))
+		if opts.Rate > 0 {
+			at = start.Add(time.Duration(float64(i) / opts.Rate * float64(time.Second)))
+		}
+		if err = sleepUntil(ctx, at); err != nil {
+			break
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			break
+		}
+
+		target := opts.Targets[i%len(opts.Targets)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			sent := time.Now()
+			status, sendErr := send(ctx, client, target, &captured)
+			latency := time.Since(sent)
+			mux.Lock()
+			defer mux.Unlock()
+			report.Sent++
+			if sendErr != nil {
+				report.Failed++
+				return
+			}
+			report.Statuses[status]++
+			report.Latencies = append(report.Latencies, latency)
+		}()
+	}
+	wg.Wait()
+	report.Duration = time.Since(start)
+	slices.Sort(report.Latencies)
+	if errors.Is(err, context.Canceled) {
+		err = nil // stopped on purpose
+	}
+	return report, err
+}
+
+func sleepUntil(ctx context.Context, at time.Time) error {
+	d := time.Until(at)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func send(ctx context.Context, client *http.Client, target *url.URL, captured *Request) (int, error) {
+	u := *target
+	ref, err := url.Parse(captured.URI)
+	if err != nil {
+		return 0, err
+	}
+	u.Path = strings.TrimSuffix(target.Path, "/") + ref.Path
+	u.RawPath = strings.TrimSuffix(target.EscapedPath(), "/") + ref.EscapedPath()
+	u.RawQuery = ref.RawQuery
+	req, err := http.NewRequestWithContext(ctx, captured.Method, u.String(), bytes.NewReader(captured.Body))
+	if err != nil {
+		return 0, err
+	}
+	for name, values := range captured.Header {
+		if slices.Contains(hopHeaders, http.CanonicalHeaderKey(name)) || (len(values) == 1 && values[0] == "REDACTED") {
+			continue
+		}
+		req.Header[name] = values
+	}
+	if captured.Host != "" {
+		req.Host = captured.Host
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"reverse-proxy/admin"
+)
+
+const loadtestUsage = `usage: proxyctl [flags] loadtest [options] <url>
+
+Sends traffic to url (the proxy, not the admin API) and reports throughput,
+latency percentiles, status codes and, from the admin API's /status, how the
+requests spread over the backends of the default pool.
+
+Options:
+`
+
+// loadtestReport is what `loadtest` prints.
+type loadtestReport struct {
+	Requests      int            `json:"requests"`
+	Errors        int            `json:"errors"` // no response: refused, timed out...
+	Statuses      map[int]int    `json:"statuses"`
+	DurationMs    float64        `json:"duration_ms"`
+	RequestsPerS  float64        `json:"requests_per_second"`
+	LatencyP50Ms  float64        `json:"latency_p50_ms"`
+	LatencyP90Ms  float64        `json:"latency_p90_ms"`
+	LatencyP99Ms  float64        `json:"latency_p99_ms"`
+	LatencyMaxMs  float64        `json:"latency_max_ms"`
+	Backends      []backendShare `json:"backends,omitempty"`
+	BackendsError string         `json:"backends_error,omitempty"` // admin API unreachable
+}
+
+// backendShare is the part of the load a backend served, from the request
+// counters of /status before and after the test.
+type backendShare struct {
+	ID       string  `json:"id"`
+	URL      string  `json:"url"`
+	Requests int64   `json:"requests"`
+	Failures int64   `json:"failures"`
+	Percent  float64 `json:"percent"`
+}
+
+// headerFlags collects repeated -H "Name: value" options.
+type headerFlags http.Header
+
+func (h headerFlags) String() string { return "" }
+
+func (h headerFlags) Set(v string) error {
+	name, value, ok := strings.Cut(v, ":")
+	if !ok {
+		return fmt.Errorf("invalid header %q, expected \"Name: value\"", v)
+	}
+	http.Header(h).Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	return nil
+}
+
+func loadtest(c *client, p printer, args []string, stderr io.Writer) error {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	requests := fs.Int("n", 1000, "requests to send")
+	duration := fs.Duration("d", 0, "send for this long instead of -n requests")
+	concurrency := fs.Int("c", 10, "concurrent workers")
+	rate := fs.Float64("rate", 0, "requests per second at most; 0 = as fast as the workers go")
+	method := fs.String("method", http.MethodGet, "HTTP method")
+	body := fs.String("body", "", "request body")
+	timeout := fs.Duration("request-timeout", 30*time.Second, "timeout of each request")
+	headers := headerFlags{}
+	fs.Var(headers, "H", `request header "Name: value" (repeatable)`)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, loadtestUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 || *concurrency <= 0 || (*requests <= 0 && *duration <= 0) {
+		if err == nil {
+			fs.Usage()
+		}
+		return errUsage
+	}
+	target := fs.Arg(0)
+
+	var before admin.StatusResponse
+	statusErr := c.do(http.MethodGet, "/status", nil, &before)
+
+	ctx := context.Background()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+		*requests = 0 // unlimited
+	}
+	client := &http.Client{
+		Timeout:   *timeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, MaxIdleConnsPerHost: *concurrency},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	jobs := make(chan struct{})
+	go func() {
+		defer close(jobs)
+		var tick <-chan time.Time
+		if *rate > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for i := 0; *requests == 0 || i < *requests; i++ {
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case jobs <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		mux       sync.Mutex
+		latencies []time.Duration
+		report    = loadtestReport{Statuses: map[int]int{}}
+		wg        sync.WaitGroup
+	)
+	start := time.Now()
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				req, err := http.NewRequest(*method, target, strings.NewReader(*body))
+				if err != nil {
+					mux.Lock()
+					report.Errors++
+					mux.Unlock()
+					continue
+				}
+				req.Header = http.Header(headers).Clone()
+				if host := req.Header.Get("Host"); host != "" {
+					req.Host = host
+				}
+				sent := time.Now()
+				resp, err := client.Do(req)
+				if err == nil {
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+				elapsed := time.Since(sent)
+
+				mux.Lock()
+				report.Requests++
+				if err != nil {
+					report.Errors++
+				} else {
+					report.Statuses[resp.StatusCode]++
+					latencies = append(latencies, elapsed)
+				}
+				mux.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	total := time.Since(start)
+
+	report.DurationMs = ms(total)
+	report.RequestsPerS = float64(report.Requests) / total.Seconds()
+	slices.Sort(latencies)
+	if len(latencies) > 0 {
+		at := func(q float64) float64 { return ms(latencies[int(float64(len(latencies)-1)*q)]) }
+		report.LatencyP50Ms, report.LatencyP90Ms, report.LatencyP99Ms = at(0.50), at(0.90), at(0.99)
+		report.LatencyMaxMs = ms(latencies[len(latencies)-1])
+	}
+
+	var after admin.StatusResponse
+	if statusErr == nil {
+		statusErr = c.do(http.MethodGet, "/status", nil, &after)
+	}
+	if statusErr != nil {
+		report.BackendsError = statusErr.Error()
+	} else {
+		report.Backends = shares(before, after)
+	}
+
+	if p.json {
+		if err := p.encode(report); err != nil {
+			return err
+		}
+	} else {
+		printLoadtest(p, report)
+	}
+	if report.Requests == 0 {
+		return errors.New("no request sent")
+	}
+	return nil
+}
+
+// shares diffs the request counters of two /status responses.
+func shares(before, after admin.StatusResponse) []backendShare {
+	prev := map[string]admin.BackendStatus{}
+	for _, b := range before.Backends {
+		prev[b.ID] = b
+	}
+	var out []backendShare
+	var total int64
+	for _, b := range after.Backends {
+		s := backendShare{
+			ID:       b.ID,
+			URL:      b.URL,
+			Requests: b.Stats.Requests - prev[b.ID].Stats.Requests,
+			Failures: b.Stats.Failures - prev[b.ID].Stats.Failures,
+		}
+		total += s.Requests
+		out = append(out, s)
+	}
+	for i := range out {
+		if total > 0 {
+			out[i].Percent = 100 * float64(out[i].Requests) / float64(total)
+		}
+	}
+	return out
+}
+
+func printLoadtest(p printer, r loadtestReport) {
+	fmt.Fprintf(p.out, "Requests:   %d in %.0fms (%.1f req/s), %d errors\n", r.Requests, r.DurationMs, r.RequestsPerS, r.Errors)
+	fmt.Fprintf(p.out, "Latency:    p50=%.1fms p90=%.1fms p99=%.1fms max=%.1fms\n",
+		r.LatencyP50Ms, r.LatencyP90Ms, r.LatencyP99Ms, r.LatencyMaxMs)
+	codes := make([]int, 0, len(r.Statuses))
+	for code := range r.Statuses {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	statuses := make([]string, len(codes))
+	for i, code := range codes {
+		statuses[i] = fmt.Sprintf("%d: %d", code, r.Statuses[code])
+	}
+	fmt.Fprintf(p.out, "Statuses:   %s\n", strings.Join(statuses, ", "))
+
+	if r.BackendsError != "" {
+		fmt.Fprintf(p.out, "\nBackend distribution unavailable: %s\n", r.BackendsError)
+		return
+	}
+	fmt.Fprintln(p.out)
+	tw := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tBACKEND\tREQUESTS\tSHARE\tFAILURES")
+	for _, b := range r.Backends {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.1f%%\t%d\n", b.ID, b.URL, b.Requests, b.Percent, b.Failures)
+	}
+	tw.Flush()
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
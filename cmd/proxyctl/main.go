@@ -0,0 +1,419 @@
+// Command proxyctl drives a running reverse proxy through its admin API, so
+// operators don't have to craft curl commands by hand.
+//
+//	proxyctl [-admin URL] [-token T] [-cacert F -cert F -key F] [-o table|json] status
+//	proxyctl [-admin URL] [-o table|json] backend add <url>
+//	proxyctl [-admin URL] [-o table|json] backend remove|drain|enable <id|url>
+//	proxyctl [-admin URL] [-o table|json] backend weight <id|url> <n>
+//	proxyctl [-admin URL] [-o table|json] reload
+//	proxyctl [-admin URL] [-o table|json] certs reload
+//	proxyctl [-admin URL] config export
+//	proxyctl [-admin URL] [-o table|json] config import <file>
+//	proxyctl [-admin URL] [-o table|json] audit [limit]
+//	proxyctl [-admin URL] [-o table|json] loadtest [-n N|-d D] [-c C] [-rate R] <url>
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"reverse-proxy/admin"
+	"reverse-proxy/state"
+)
+
+const usage = `usage: proxyctl [flags] <command>
+
+Commands:
+  status                      list the backends of the default pool
+  backend add <url>           add a backend (it waits for a health check)
+  backend remove <id|url>     remove a backend
+  backend drain <id|url>      take a backend out of rotation (maintenance mode)
+  backend enable <id|url>     put a drained backend back in rotation
+  backend weight <id|url> <n> set the share of traffic under the weighted
+                              strategies (0 shifts all load off the backend)
+  reload                      reconcile the backends with the config file
+  certs reload                re-read the certificate files of the TLS
+                              listener without dropping connections
+  config export               print a snapshot of the runtime configuration
+                              (backends, maintenance flags, weights) as JSON
+  config import <file>        apply a snapshot from config export, as a whole
+  audit [limit]               list the last admin changes (default 20)
+  loadtest [options] <url>    send traffic through the proxy and report
+                              throughput, latency and the backends' shares
+                              (see loadtest -h)
+
+Backends are named by the ID listed by status, their URL, or their host:port
+when no other backend shares it.
+
+Flags:
+`
+
+// clientTLS builds the TLS config of an https admin URL; nil keeps the
+// defaults.
+func clientTLS(caFile, certFile, keyFile string) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	c := &tls.Config{}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificate found", caFile)
+		}
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("-cert and -key go together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// errUsage makes main print the usage and exit with status 2.
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	err := run(os.Args[1:], os.Stdout, os.Stderr)
+	switch {
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "proxyctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	defaultAdmin := os.Getenv("PROXYCTL_ADMIN")
+	if defaultAdmin == "" {
+		defaultAdmin = "http://localhost:8081"
+	}
+
+	fs := flag.NewFlagSet("proxyctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	adminURL := fs.String("admin", defaultAdmin, "admin API base URL (env PROXYCTL_ADMIN)")
+	output := fs.String("o", "table", "output format: table or json")
+	timeout := fs.Duration("timeout", 10*time.Second, "request timeout")
+	token := fs.String("token", os.Getenv("PROXYCTL_TOKEN"), "admin API bearer token (env PROXYCTL_TOKEN)")
+	caFile := fs.String("cacert", os.Getenv("PROXYCTL_CACERT"), "PEM bundle trusted for an https admin URL (env PROXYCTL_CACERT)")
+	certFile := fs.String("cert", os.Getenv("PROXYCTL_CERT"), "client certificate (PEM) when the admin API requires one (env PROXYCTL_CERT)")
+	keyFile := fs.String("key", os.Getenv("PROXYCTL_KEY"), "private key (PEM) of -cert (env PROXYCTL_KEY)")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(stderr, "invalid output format %q (must be 'table' or 'json')\n", *output)
+		return errUsage
+	}
+
+	tlsConfig, err := clientTLS(*caFile, *certFile, *keyFile)
+	if err != nil {
+		return err
+	}
+	c := &client{
+		base:  strings.TrimSuffix(*adminURL, "/") + "/v1",
+		http:  &http.Client{Timeout: *timeout, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}},
+		token: *token,
+	}
+	p := printer{out: stdout, json: *output == "json"}
+
+	switch cmd := fs.Args(); {
+	case len(cmd) == 1 && cmd[0] == "status":
+		return status(c, p)
+	case len(cmd) == 3 && cmd[0] == "backend":
+		return backend(c, p, cmd[1], cmd[2])
+	case len(cmd) == 4 && cmd[0] == "backend" && cmd[1] == "weight":
+		return weight(c, p, cmd[2], cmd[3])
+	case len(cmd) == 1 && cmd[0] == "reload":
+		return reload(c, p)
+	case len(cmd) == 2 && cmd[0] == "certs" && cmd[1] == "reload":
+		return reloadCerts(c, p)
+	case len(cmd) == 2 && cmd[0] == "config" && cmd[1] == "export":
+		return export(c, p)
+	case len(cmd) == 3 && cmd[0] == "config" && cmd[1] == "import":
+		return importConfig(c, p, cmd[2])
+	case len(cmd) >= 1 && len(cmd) <= 2 && cmd[0] == "audit":
+		limit := "20"
+		if len(cmd) == 2 {
+			limit = cmd[1]
+		}
+		return audit(c, p, limit)
+	case len(cmd) >= 1 && cmd[0] == "loadtest":
+		return loadtest(c, p, cmd[1:], stderr)
+	default:
+		fs.Usage()
+		return errUsage
+	}
+}
+
+func status(c *client, p printer) error {
+	var resp admin.StatusResponse
+	if err := c.do(http.MethodGet, "/status", nil, &resp); err != nil {
+		return err
+	}
+	if p.json {
+		return p.encode(resp)
+	}
+
+	tw := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tBACKEND\tSTATE\tWEIGHT\tCONNS\tREQUESTS\tFAILURES\tP50\tP99")
+	for _, b := range resp.Backends {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%.1fms\t%.1fms\n", b.ID, b.URL, backendState(b), b.Weight, b.CurrentConns,
+			b.Stats.Requests, b.Stats.Failures, b.Stats.P50, b.Stats.P99)
+	}
+	tw.Flush()
+	fmt.Fprintf(p.out, "\n%d/%d backends available\n", resp.ActiveBackends, resp.TotalBackends)
+	return nil
+}
+
+// backendState sums up the flags of a backend, the one that keeps it out of
+// rotation first.
+func backendState(b admin.BackendStatus) string {
+	switch {
+	case b.AdminDown:
+		return "drained"
+	case !b.Alive:
+		return "down"
+	case b.Ejected:
+		return "ejected"
+	default:
+		return "up"
+	}
+}
+
+// backendResult is what `backend` prints.
+type backendResult struct {
+	ID      string `json:"id"`
+	Backend string `json:"backend"`
+	Action  string `json:"action"`
+}
+
+func backend(c *client, p printer, action, ref string) error {
+	// remove answers without a body: the backend is the one it names.
+	resp := admin.BackendStatus{URL: ref}
+	path := "/backends/" + url.PathEscape(ref)
+	var err error
+	switch action {
+	case "add":
+		err = c.do(http.MethodPost, "/backends", map[string]string{"url": ref}, &resp)
+	case "remove":
+		err = c.do(http.MethodDelete, path, nil, nil)
+	case "drain":
+		err = c.do(http.MethodPatch, path, map[string]string{"action": "disable"}, &resp)
+	case "enable":
+		err = c.do(http.MethodPatch, path, map[string]string{"action": "enable"}, &resp)
+	default:
+		return fmt.Errorf("unknown backend action %q (add, remove, drain or enable)", action)
+	}
+	if err != nil {
+		return err
+	}
+
+	result := backendResult{ID: resp.ID, Backend: resp.URL, Action: action}
+	if p.json {
+		return p.encode(result)
+	}
+	msg := map[string]string{
+		"add": "added, pending health check", "remove": "removed", "drain": "drained", "enable": "enabled",
+	}[action]
+	if action == "add" {
+		msg += ", id " + result.ID
+	}
+	fmt.Fprintf(p.out, "%s: %s\n", result.Backend, msg)
+	return nil
+}
+
+func weight(c *client, p printer, ref, rawWeight string) error {
+	w, err := strconv.Atoi(rawWeight)
+	if err != nil || w < 0 {
+		return fmt.Errorf("invalid weight %q (must be a non-negative integer)", rawWeight)
+	}
+	var resp admin.BackendStatus
+	if err := c.do(http.MethodPatch, "/backends/"+url.PathEscape(ref), map[string]int{"weight": w}, &resp); err != nil {
+		return err
+	}
+	if p.json {
+		return p.encode(resp)
+	}
+	fmt.Fprintf(p.out, "%s: weight %d\n", resp.URL, resp.Weight)
+	return nil
+}
+
+func reload(c *client, p printer) error {
+	var resp admin.ReplaceResponse
+	if err := c.do(http.MethodPost, "/reload", nil, &resp); err != nil {
+		return err
+	}
+	if p.json {
+		return p.encode(resp)
+	}
+	if len(resp.Added)+len(resp.Removed) == 0 {
+		fmt.Fprintln(p.out, "Backends already match the config file")
+		return nil
+	}
+	printChanges(p, resp)
+	return nil
+}
+
+func reloadCerts(c *client, p printer) error {
+	var resp admin.CertificateStatus
+	if err := c.do(http.MethodPost, "/certs/reload", nil, &resp); err != nil {
+		return err
+	}
+	if p.json {
+		return p.encode(resp)
+	}
+	fmt.Fprintf(p.out, "Serving %s (%s), expires %s\n", resp.File, strings.Join(resp.DNSNames, ", "),
+		resp.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+func audit(c *client, p printer, limit string) error {
+	if n, err := strconv.Atoi(limit); err != nil || n < 0 {
+		return fmt.Errorf("invalid limit %q", limit)
+	}
+	var entries []admin.AuditEntry
+	if err := c.do(http.MethodGet, "/audit?limit="+limit, nil, &entries); err != nil {
+		return err
+	}
+	if p.json {
+		return p.encode(entries)
+	}
+
+	tw := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tACTOR\tSOURCE\tREQUEST\tSTATUS\tCHANGES")
+	for _, e := range entries {
+		changes := make([]string, len(e.Changes))
+		for i, ch := range e.Changes {
+			changes[i] = ch.Field
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s %s\t%d\t%s\n", e.Time.Local().Format(time.DateTime), e.Actor, e.SourceIP,
+			e.Method, e.Path, e.Status, strings.Join(changes, ", "))
+	}
+	return tw.Flush()
+}
+
+// export always prints JSON: the snapshot is meant to be saved and imported
+// back.
+func export(c *client, p printer) error {
+	var snapshot state.State
+	if err := c.do(http.MethodGet, "/config/export", nil, &snapshot); err != nil {
+		return err
+	}
+	return p.encode(snapshot)
+}
+
+func importConfig(c *client, p printer, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var snapshot state.State
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("%s: invalid snapshot: %w", path, err)
+	}
+	var resp admin.ReplaceResponse
+	if err := c.do(http.MethodPost, "/config/import", snapshot, &resp); err != nil {
+		return err
+	}
+	if p.json {
+		return p.encode(resp)
+	}
+	printChanges(p, resp)
+	fmt.Fprintln(p.out, "Snapshot applied")
+	return nil
+}
+
+func printChanges(p printer, resp admin.ReplaceResponse) {
+	for _, u := range resp.Added {
+		fmt.Fprintf(p.out, "+ %s\n", u)
+	}
+	for _, u := range resp.Removed {
+		fmt.Fprintf(p.out, "- %s\n", u)
+	}
+}
+
+type printer struct {
+	out  io.Writer
+	json bool
+}
+
+func (p printer) encode(v any) error {
+	enc := json.NewEncoder(p.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// client calls the v1 admin API.
+type client struct {
+	base  string
+	http  *http.Client
+	token string // sent as a bearer token when set
+}
+
+// do sends body as JSON and decodes the response into out when it is not
+// nil. Error responses come back as errors carrying the API's message.
+func (c *client) do(method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: invalid response: %w", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-proxy/admin"
+	"reverse-proxy/pool"
+)
+
+// newAdmin serves the real admin API over a pool with one live backend.
+func newAdmin(t *testing.T, opts admin.Options) (*pool.ServerPool, string) {
+	t.Helper()
+	u, _ := url.Parse("http://a:8080")
+	b := &pool.Backend{URL: u}
+	b.SetAlive(true)
+	sp, err := pool.NewServerPool(pool.WithBackends(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(admin.Handler(sp, opts))
+	t.Cleanup(srv.Close)
+	return sp, srv.URL
+}
+
+func proxyctl(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := run(args, &out, io.Discard)
+	return out.String(), err
+}
+
+func TestStatus_TableAndJSON(t *testing.T) {
+	sp, base := newAdmin(t, admin.Options{})
+	sp.GetBackends()[0].RecordRequest(pool.RequestResult{Failed: true})
+
+	out, err := proxyctl(t, "-admin", base, "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out, "\n")
+	id := sp.GetBackends()[0].ID()
+	if !strings.HasPrefix(lines[0], "ID") || !strings.HasPrefix(lines[1], id) || !strings.Contains(lines[1], "http://a:8080") ||
+		!strings.Contains(lines[1], " up ") || !strings.Contains(out, "1/1 backends available") {
+		t.Errorf("unexpected table:\n%s", out)
+	}
+
+	out, err = proxyctl(t, "-admin", base, "-o", "json", "status")
+	var resp admin.StatusResponse
+	if err != nil || json.Unmarshal([]byte(out), &resp) != nil {
+		t.Fatalf("expected a StatusResponse, got %v:\n%s", err, out)
+	}
+	if resp.TotalBackends != 1 || resp.Backends[0].Stats.Failures != 1 {
+		t.Errorf("unexpected status: %+v", resp)
+	}
+}
+
+func TestBackend_AddDrainEnableRemove(t *testing.T) {
+	sp, base := newAdmin(t, admin.Options{})
+
+	out, err := proxyctl(t, "-admin", base, "backend", "add", "http://b:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sp.GetBackends()) != 2 {
+		t.Fatal("backend add did not reach the pool")
+	}
+	added := sp.GetBackends()[1]
+	if !strings.HasSuffix(out, "id "+added.ID()+"\n") {
+		t.Errorf("backend add should print the new ID, got %q", out)
+	}
+
+	out, err = proxyctl(t, "-admin", base, "-o", "json", "backend", "drain", "http://a:8080")
+	if err != nil || !sp.GetBackends()[0].IsAdminDown() {
+		t.Fatalf("backend drain: %v", err)
+	}
+	if !strings.Contains(out, `"action": "drain"`) {
+		t.Errorf("unexpected JSON output: %s", out)
+	}
+	out, _ = proxyctl(t, "-admin", base, "status")
+	if !strings.Contains(out, "drained") {
+		t.Errorf("status should show the drained backend:\n%s", out)
+	}
+
+	if _, err := proxyctl(t, "-admin", base, "backend", "enable", "http://a:8080"); err != nil || sp.GetBackends()[0].IsAdminDown() {
+		t.Fatalf("backend enable: %v", err)
+	}
+	out, err = proxyctl(t, "-admin", base, "backend", "weight", "http://a:8080", "4")
+	if err != nil || out != "http://a:8080: weight 4\n" || sp.GetBackends()[0].Weight() != 4 {
+		t.Fatalf("backend weight: %q %v", out, err)
+	}
+	if _, err := proxyctl(t, "-admin", base, "backend", "weight", "http://a:8080", "-1"); err == nil {
+		t.Error("a negative weight should be rejected")
+	}
+
+	if _, err := proxyctl(t, "-admin", base, "backend", "remove", added.ID()); err != nil || len(sp.GetBackends()) != 1 {
+		t.Fatalf("backend remove: %v", err)
+	}
+
+	// API errors come back with their message.
+	_, err = proxyctl(t, "-admin", base, "backend", "remove", "http://b:8080")
+	if err == nil || !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "Backend not found") {
+		t.Errorf("expected the 404 message, got %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	_, base := newAdmin(t, admin.Options{
+		Reload: func() ([]string, error) { return []string{"http://b:8080"}, nil },
+	})
+
+	out, err := proxyctl(t, "-admin", base, "reload")
+	if err != nil || out != "+ http://b:8080\n- http://a:8080\n" {
+		t.Fatalf("unexpected reload output %q (%v)", out, err)
+	}
+	out, _ = proxyctl(t, "-admin", base, "reload")
+	if !strings.Contains(out, "already match") {
+		t.Errorf("second reload should change nothing, got %q", out)
+	}
+}
+
+func TestToken(t *testing.T) {
+	_, base := newAdmin(t, admin.Options{Token: "s3cret"})
+	if _, err := proxyctl(t, "-admin", base, "status"); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected 401 without the token, got %v", err)
+	}
+	if _, err := proxyctl(t, "-admin", base, "-token", "s3cret", "status"); err != nil {
+		t.Errorf("status with the token: %v", err)
+	}
+}
+
+func TestHTTPSAdmin(t *testing.T) {
+	sp, _ := newAdmin(t, admin.Options{})
+	srv := httptest.NewTLSServer(admin.Handler(sp, admin.Options{}))
+	t.Cleanup(srv.Close)
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600)
+
+	if _, err := proxyctl(t, "-admin", srv.URL, "status"); err == nil {
+		t.Error("expected the unknown CA to be rejected")
+	}
+	if _, err := proxyctl(t, "-admin", srv.URL, "-cacert", ca, "status"); err != nil {
+		t.Errorf("status with -cacert: %v", err)
+	}
+	if _, err := proxyctl(t, "-admin", srv.URL, "-cacert", ca, "-cert", ca, "status"); err == nil {
+		t.Error("expected -cert without -key to be rejected")
+	}
+}
+
+func TestCertsReload(t *testing.T) {
+	_, base := newAdmin(t, admin.Options{
+		ReloadCerts: func() (admin.CertificateStatus, error) {
+			return admin.CertificateStatus{File: "cert.pem", DNSNames: []string{"example.com"},
+				NotAfter: time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)}, nil
+		},
+	})
+	out, err := proxyctl(t, "-admin", base, "certs", "reload")
+	if err != nil || out != "Serving cert.pem (example.com), expires 2030-01-02T00:00:00Z\n" {
+		t.Fatalf("unexpected output %q (%v)", out, err)
+	}
+
+	_, base = newAdmin(t, admin.Options{})
+	if _, err := proxyctl(t, "-admin", base, "certs", "reload"); err == nil {
+		t.Error("expected an error without a TLS listener")
+	}
+}
+
+func TestConfig_ExportImport(t *testing.T) {
+	staging, stagingBase := newAdmin(t, admin.Options{})
+	staging.SetBackendWeight(staging.GetBackends()[0].URL, 7)
+	prod, prodBase := newAdmin(t, admin.Options{})
+
+	out, err := proxyctl(t, "-admin", stagingBase, "config", "export")
+	if err != nil || !strings.Contains(out, `"weight": 7`) {
+		t.Fatalf("unexpected export %q (%v)", out, err)
+	}
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err = proxyctl(t, "-admin", prodBase, "config", "import", path)
+	if err != nil || !strings.Contains(out, "Snapshot applied") {
+		t.Fatalf("unexpected import output %q (%v)", out, err)
+	}
+	if w := prod.GetBackends()[0].Weight(); w != 7 {
+		t.Errorf("weight not imported: %d", w)
+	}
+	if _, err := proxyctl(t, "-admin", prodBase, "config", "import", filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("a missing snapshot file must fail")
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"frobnicate"},
+		{"backend", "add"},
+		{"-o", "yaml", "status"},
+	} {
+		if _, err := proxyctl(t, args...); !errors.Is(err, errUsage) {
+			t.Errorf("%v: expected a usage error, got %v", args, err)
+		}
+	}
+	if _, err := proxyctl(t, "backend", "restart", "http://a:8080"); err == nil || errors.Is(err, errUsage) {
+		t.Errorf("unknown backend action: expected an error, got %v", err)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	audit, err := admin.OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	_, base := newAdmin(t, admin.Options{Audit: audit})
+	if _, err := proxyctl(t, "-admin", base, "backend", "add", "http://new:8080"); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := proxyctl(t, "-admin", base, "audit")
+	if err != nil || !strings.Contains(out, "anonymous") || !strings.Contains(out, "POST /v1/backends") || !strings.Contains(out, "backends[http://new:8080]") {
+		t.Fatalf("unexpected output %q (%v)", out, err)
+	}
+	if _, err := proxyctl(t, "-admin", base, "audit", "many"); err == nil {
+		t.Error("expected an invalid limit to be rejected")
+	}
+}
+
+func TestLoadtest(t *testing.T) {
+	sp, base := newAdmin(t, admin.Options{})
+	b := sp.GetBackends()[0]
+	b.RecordRequest(pool.RequestResult{}) // before the test: not counted
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.RecordRequest(pool.RequestResult{}) // as the proxy would
+		if r.Header.Get("X-Test") != "yes" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer proxy.Close()
+
+	out, err := proxyctl(t, "-admin", base, "-o", "json", "loadtest", "-n", "20", "-c", "4", "-H", "X-Test: yes", proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report loadtestReport
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out, err)
+	}
+	if report.Requests != 20 || report.Errors != 0 || report.Statuses[http.StatusOK] != 20 || report.LatencyMaxMs <= 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if len(report.Backends) != 1 || report.Backends[0].Requests != 20 || report.Backends[0].Percent != 100 {
+		t.Errorf("unexpected distribution: %+v", report.Backends)
+	}
+
+	out, err = proxyctl(t, "-admin", "http://127.0.0.1:1", "loadtest", "-n", "3", proxy.URL)
+	if err != nil || !strings.Contains(out, "400: 3") || !strings.Contains(out, "Backend distribution unavailable") {
+		t.Errorf("unexpected output without the admin API %q (%v)", out, err)
+	}
+	if _, err := proxyctl(t, "loadtest", "-n", "3"); !errors.Is(err, errUsage) {
+		t.Errorf("loadtest without a URL: expected a usage error, got %v", err)
+	}
+}
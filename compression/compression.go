@@ -0,0 +1,267 @@
+// Package compression gzip- or deflate-encodes responses for clients that
+// accept it, when the backend sent them uncompressed.
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultTypes are the content types compressed when Options.Types is empty.
+// An entry ending in "/*" matches a whole family.
+var DefaultTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// Options tunes the middleware.
+type Options struct {
+	MinSize int      // smaller bodies are sent as is; defaults to 1024 bytes
+	Types   []string // compressible content types; defaults to DefaultTypes
+	Level   int      // compression level; 0 uses the default level
+}
+
+// Compressor is the compression middleware. Create it with New.
+type Compressor struct {
+	minSize int
+	types   []string
+	level   int
+	gzips   sync.Pool
+	flates  sync.Pool
+}
+
+// New returns a Compressor configured by opts.
+func New(opts Options) *Compressor {
+	c := &Compressor{minSize: opts.MinSize, types: opts.Types, level: opts.Level}
+	if c.minSize <= 0 {
+		c.minSize = 1024
+	}
+	if len(c.types) == 0 {
+		c.types = DefaultTypes
+	}
+	if c.level == 0 {
+		c.level = gzip.DefaultCompression
+	}
+	return c
+}
+
+// Middleware compresses the responses of next. The decision waits for the
+// first MinSize bytes of the body, unless the headers already settle it
+// (Content-Encoding set, type not allowed, small Content-Length).
+func (c *Compressor) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, c: c, encoding: encoding}
+		next.ServeHTTP(cw, r)
+		cw.finish()
+	})
+}
+
+// negotiate picks gzip, then deflate, among the encodings the client accepts.
+func negotiate(accept string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	for _, enc := range []string{"gzip", "deflate"} {
+		if ok, listed := accepted[enc]; ok || (!listed && accepted["*"]) {
+			return enc
+		}
+	}
+	return ""
+}
+
+func (c *Compressor) allowed(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range c.types {
+		if family, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mediaType, family+"/") {
+				return true
+			}
+		} else if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Compressor) encoder(encoding string, w io.Writer) io.WriteCloser {
+	if encoding == "gzip" {
+		if gz, ok := c.gzips.Get().(*gzip.Writer); ok {
+			gz.Reset(w)
+			return gz
+		}
+		gz, _ := gzip.NewWriterLevel(w, c.level)
+		return gz
+	}
+	if fl, ok := c.flates.Get().(*flate.Writer); ok {
+		fl.Reset(w)
+		return fl
+	}
+	fl, _ := flate.NewWriter(w, c.level)
+	return fl
+}
+
+func (c *Compressor) release(enc io.WriteCloser) {
+	switch e := enc.(type) {
+	case *gzip.Writer:
+		c.gzips.Put(e)
+	case *flate.Writer:
+		c.flates.Put(e)
+	}
+}
+
+// compressWriter holds the status and the start of the body until it knows
+// whether the response gets compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	c        *Compressor
+	encoding string
+
+	status  int
+	buf     bytes.Buffer
+	decided bool
+	enc     io.WriteCloser // nil when passing through
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.status != 0 || w.decided {
+		return
+	}
+	if code >= 100 && code < 200 {
+		w.ResponseWriter.WriteHeader(code) // informational, the final one follows
+		return
+	}
+	w.status = code
+	if !w.eligible() {
+		w.start(false)
+		return
+	}
+	if n, err := strconv.Atoi(w.Header().Get("Content-Length")); err == nil && n < w.c.minSize {
+		w.start(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.buf.Write(p)
+		if w.buf.Len() >= w.c.minSize {
+			if err := w.start(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// eligible checks what the headers alone can tell.
+func (w *compressWriter) eligible() bool {
+	h := w.Header()
+	switch {
+	case w.status < 200, w.status == http.StatusNoContent, w.status == http.StatusNotModified,
+		w.status == http.StatusPartialContent:
+		return false
+	case h.Get("Content-Encoding") != "" && h.Get("Content-Encoding") != "identity":
+		return false // never compress twice
+	case h.Get("Content-Range") != "":
+		return false
+	case strings.Contains(h.Get("Cache-Control"), "no-transform"):
+		return false
+	}
+	return w.c.allowed(h.Get("Content-Type"))
+}
+
+// start sends the headers, compressed or not, then what was buffered.
+func (w *compressWriter) start(compress bool) error {
+	w.decided = true
+	h := w.Header()
+	if compress {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		h.Add("Vary", "Accept-Encoding")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag) // the bytes differ from the backend's
+		}
+		w.enc = w.c.encoder(w.encoding, w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+// FlushError pushes buffered data out; an undecided response is sent as is,
+// since the client wants what was written so far now.
+func (w *compressWriter) FlushError() error {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		if err := w.start(false); err != nil {
+			return err
+		}
+	}
+	if fl, ok := w.enc.(interface{ Flush() error }); ok {
+		if err := fl.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish runs after the handler: small bodies go out uncompressed, and the
+// encoder is closed to write the compressed stream's footer.
+func (w *compressWriter) finish() {
+	if !w.decided {
+		if w.status == 0 {
+			return // nothing written; net/http sends an empty 200
+		}
+		w.start(false)
+	}
+	if w.enc != nil {
+		w.enc.Close()
+		w.c.release(w.enc)
+	}
+}
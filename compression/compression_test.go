@@ -0,0 +1,121 @@
+package compression
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var big = strings.Repeat("hello compression ", 200)
+
+func serve(h http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func backend(contentType, encoding, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		if encoding != "" {
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, body)
+	})
+}
+
+func TestCompress_GzipAndDeflate(t *testing.T) {
+	h := New(Options{}).Middleware(backend("text/html; charset=utf-8", "", big))
+
+	rec := serve(h, "br;q=1, gzip;q=0.8")
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected a gzip response varying on Accept-Encoding, got headers %v", rec.Header())
+	}
+	if rec.Header().Get("ETag") != `W/"v1"` {
+		t.Errorf("expected the ETag to be weakened, got %q", rec.Header().Get("ETag"))
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(gz); string(body) != big {
+		t.Error("gzip body does not decode to the original")
+	}
+
+	rec = serve(h, "deflate, gzip;q=0")
+	if rec.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatalf("expected deflate when gzip is refused, got %q", rec.Header().Get("Content-Encoding"))
+	}
+	if body, _ := io.ReadAll(flate.NewReader(rec.Body)); string(body) != big {
+		t.Error("deflate body does not decode to the original")
+	}
+}
+
+func TestCompress_LeavesResponsesAlone(t *testing.T) {
+	cases := []struct {
+		name    string
+		handler http.Handler
+		accept  string
+	}{
+		{"client without Accept-Encoding", backend("text/plain", "", big), ""},
+		{"below the size threshold", backend("text/plain", "", "tiny"), "gzip"},
+		{"type not in the allowlist", backend("image/png", "", big), "gzip"},
+		{"already encoded", backend("text/plain", "br", big), "gzip"},
+	}
+	for _, c := range cases {
+		rec := serve(New(Options{}).Middleware(c.handler), c.accept)
+		if enc := rec.Header().Get("Content-Encoding"); enc == "gzip" {
+			t.Errorf("%s: response must not be gzipped", c.name)
+		}
+		if rec.Header().Get("ETag") != `"v1"` {
+			t.Errorf("%s: ETag must be left as is, got %q", c.name, rec.Header().Get("ETag"))
+		}
+		if rec.Body.Len() == 0 {
+			t.Errorf("%s: body lost", c.name)
+		}
+	}
+}
+
+func TestCompress_ContentLengthDecidesEarly(t *testing.T) {
+	// A small declared length is passed through before any body is written,
+	// and its Content-Length kept.
+	h := New(Options{MinSize: 100}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "2")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "{}")
+	}))
+	rec := serve(h, "gzip")
+	if rec.Code != http.StatusCreated || rec.Header().Get("Content-Length") != "2" || rec.Body.String() != "{}" {
+		t.Errorf("unexpected response %d %v %q", rec.Code, rec.Header(), rec.Body)
+	}
+}
+
+func TestCompress_FlushCompressesAsItGoes(t *testing.T) {
+	h := New(Options{MinSize: 10}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"chunk":1, "padding":"...."}`)
+		http.NewResponseController(w).Flush()
+		io.WriteString(w, `{"chunk":2}`)
+	}))
+	rec := serve(h, "gzip")
+	if !rec.Flushed || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a flushed gzip stream, got %v", rec.Header())
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(gz); string(body) != `{"chunk":1, "padding":"...."}{"chunk":2}` {
+		t.Errorf("unexpected body %q", body)
+	}
+}
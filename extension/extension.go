@@ -0,0 +1,203 @@
+// Package extension runs custom business logic (tenant header rewriting,
+// request signing, ...) on the requests and responses of a route without
+// forking the proxy. An extension is either compiled in with Register, or
+// loaded at startup from a Go plugin (go build -buildmode=plugin).
+//
+// An extension is any value with one or both of these methods:
+//
+//	OnRequest(r *http.Request) error
+//	OnResponse(r *http.Request, status int, header http.Header) error
+//
+// OnRequest may rewrite r (headers, URL) before it is balanced; an error
+// rejects the request, with the status of its StatusCode() int method when
+// it has one, 500 otherwise. OnResponse may rewrite the response headers
+// before they are sent; an error replaces the response with a 502. Hooks
+// run concurrently and must be safe for that.
+package extension
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"plugin"
+	"reverse-proxy/proxy"
+	"sync"
+)
+
+// RequestHook is implemented by extensions acting on requests.
+type RequestHook interface {
+	OnRequest(r *http.Request) error
+}
+
+// ResponseHook is implemented by extensions acting on response headers.
+type ResponseHook interface {
+	OnResponse(r *http.Request, status int, header http.Header) error
+}
+
+// Factory builds an extension from its JSON config block (nil when none
+// is given). A Go plugin exports one as its New symbol:
+//
+//	func New(config json.RawMessage) (any, error)
+type Factory func(config json.RawMessage) (any, error)
+
+var (
+	registryMux sync.Mutex
+	registry    = map[string]Factory{}
+)
+
+// Register makes a compiled-in extension available under name, usually
+// from an init function of the embedding program.
+func Register(name string, f Factory) {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	registry[name] = f
+}
+
+// Config selects an extension: a registered Name, or the Plugin file to
+// load, and the Config block passed to its factory.
+type Config struct {
+	Name   string          `json:"name"`
+	Plugin string          `json:"plugin"` // path to a .so built with -buildmode=plugin
+	Config json.RawMessage `json:"config"`
+}
+
+// rejection is an OnRequest error carrying its status.
+type rejection struct {
+	status  int
+	message string
+}
+
+func (r *rejection) Error() string   { return r.message }
+func (r *rejection) StatusCode() int { return r.status }
+
+// Reject is an OnRequest error answering the client with status.
+func Reject(status int, message string) error {
+	return &rejection{status: status, message: message}
+}
+
+// Load builds the extension described by cfg.
+func Load(cfg Config) (*Extension, error) {
+	var factory Factory
+	name := cfg.Name
+	switch {
+	case cfg.Name != "" && cfg.Plugin != "":
+		return nil, errors.New("extension: use either name or plugin, not both")
+	case cfg.Name != "":
+		registryMux.Lock()
+		factory = registry[cfg.Name]
+		registryMux.Unlock()
+		if factory == nil {
+			return nil, fmt.Errorf("extension %q is not registered", cfg.Name)
+		}
+	case cfg.Plugin != "":
+		p, err := plugin.Open(cfg.Plugin)
+		if err != nil {
+			return nil, fmt.Errorf("extension plugin %s: %w", cfg.Plugin, err)
+		}
+		sym, err := p.Lookup("New")
+		if err != nil {
+			return nil, fmt.Errorf("extension plugin %s: %w", cfg.Plugin, err)
+		}
+		newFunc, ok := sym.(func(json.RawMessage) (any, error))
+		if !ok {
+			return nil, fmt.Errorf("extension plugin %s: New must be a func(json.RawMessage) (any, error), got %T", cfg.Plugin, sym)
+		}
+		factory, name = newFunc, cfg.Plugin
+	default:
+		return nil, errors.New("extension: name or plugin is required")
+	}
+
+	hooks, err := factory(cfg.Config)
+	if err != nil {
+		return nil, fmt.Errorf("extension %s: %w", name, err)
+	}
+	e := &Extension{Name: name}
+	e.request, _ = hooks.(RequestHook)
+	e.response, _ = hooks.(ResponseHook)
+	if e.request == nil && e.response == nil {
+		return nil, fmt.Errorf("extension %s: %T has neither OnRequest nor OnResponse", name, hooks)
+	}
+	return e, nil
+}
+
+// Extension is a loaded extension, ready to run as a pipeline stage.
+type Extension struct {
+	Name     string
+	request  RequestHook
+	response ResponseHook
+}
+
+// Middleware runs the hooks around the rest of the pipeline.
+func (e *Extension) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if e.request != nil {
+			decision := proxy.DecisionFromContext(r.Context())
+			// Our own copy: the hook may rewrite headers freely.
+			r = r.Clone(r.Context())
+			if err := e.request.OnRequest(r); err != nil {
+				status := http.StatusInternalServerError
+				var sc interface{ StatusCode() int }
+				if errors.As(err, &sc) {
+					status = sc.StatusCode()
+				} else {
+					log.Printf("Extension %s failed on %s %s: %v", e.Name, r.Method, r.URL.Path, err)
+				}
+				decision.Check("extension", false, e.Name+": "+err.Error())
+				http.Error(w, err.Error(), status)
+				return
+			}
+			decision.Check("extension", true, "")
+		}
+		if e.response != nil {
+			w = &hookWriter{ResponseWriter: w, r: r, e: e}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hookWriter runs OnResponse just before the headers go out.
+type hookWriter struct {
+	http.ResponseWriter
+	r     *http.Request
+	e     *Extension
+	wrote bool
+}
+
+func (w *hookWriter) WriteHeader(code int) {
+	if w.wrote || code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wrote = true
+	if err := w.e.response.OnResponse(w.r, code, w.Header()); err != nil {
+		log.Printf("Extension %s failed on the response to %s %s: %v", w.e.Name, w.r.Method, w.r.URL.Path, err)
+		for key := range w.Header() {
+			w.Header().Del(key) // nothing of the rejected response leaks
+		}
+		http.Error(w.ResponseWriter, "Bad Gateway", http.StatusBadGateway)
+		w.ResponseWriter = discard{w.ResponseWriter.Header()}
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *hookWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer's Flush.
+func (w *hookWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// discard swallows the body of a response replaced by an error.
+type discard struct{ header http.Header }
+
+func (d discard) Header() http.Header         { return d.header }
+func (d discard) Write(b []byte) (int, error) { return len(b), nil }
+func (d discard) WriteHeader(int)             {}
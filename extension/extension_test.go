@@ -0,0 +1,95 @@
+package extension
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// tenant maps the Host to an X-Tenant header, refuses unknown hosts and
+// tags the response.
+type tenant struct {
+	Tenants map[string]string `json:"tenants"`
+}
+
+func (t *tenant) OnRequest(r *http.Request) error {
+	id, ok := t.Tenants[r.Host]
+	if !ok {
+		return Reject(http.StatusForbidden, "unknown tenant")
+	}
+	r.Header.Set("X-Tenant", id)
+	return nil
+}
+
+func (t *tenant) OnResponse(r *http.Request, status int, header http.Header) error {
+	if status == http.StatusTeapot {
+		return errors.New("no teapots")
+	}
+	header.Set("X-Served-For", r.Header.Get("X-Tenant"))
+	return nil
+}
+
+func init() {
+	Register("tenant", func(config json.RawMessage) (any, error) {
+		t := &tenant{}
+		if err := json.Unmarshal(config, t); err != nil {
+			return nil, err
+		}
+		return t, nil
+	})
+	Register("noop", func(json.RawMessage) (any, error) { return struct{}{}, nil })
+}
+
+func TestExtension_Hooks(t *testing.T) {
+	e, err := Load(Config{Name: "tenant", Config: json.RawMessage(`{"tenants": {"a.example.com": "acme"}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := e.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/teapot" {
+			w.WriteHeader(http.StatusTeapot)
+		}
+		io.WriteString(w, "tenant="+r.Header.Get("X-Tenant"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "http://a.example.com/", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "tenant=acme" || rec.Header().Get("X-Served-For") != "acme" {
+		t.Fatalf("expected the hooks to rewrite both ways, got %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+	if req.Header.Get("X-Tenant") != "" {
+		t.Error("expected the caller's request to be left alone")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://b.example.com/", nil))
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "unknown tenant") {
+		t.Errorf("expected Reject to answer 403, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://a.example.com/teapot", nil))
+	if rec.Code != http.StatusBadGateway || strings.Contains(rec.Body.String(), "tenant=") {
+		t.Errorf("expected a failing OnResponse to replace the response with a 502, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	for _, cfg := range []Config{
+		{},
+		{Name: "missing"},
+		{Name: "noop"},
+		{Name: "tenant", Config: json.RawMessage(`[]`)},
+		{Name: "tenant", Plugin: "tenant.so"},
+		{Plugin: "does-not-exist.so"},
+	} {
+		if _, err := Load(cfg); err == nil {
+			t.Errorf("expected an error loading %+v", cfg)
+		}
+	}
+}
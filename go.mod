@@ -1,3 +1,3 @@
 module reverse-proxy
 
-go 1.22.0
\ No newline at end of file
+go 1.24.0
\ No newline at end of file
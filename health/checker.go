@@ -2,58 +2,377 @@ package health
 
 import (
 	"context"
+	"crypto/tls"
 	"log"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/url"
 	"reverse-proxy/pool"
 	"strings"
 	"time"
 )
 
-// Start launches a background goroutine that pings every backend at the given interval.
-// State transitions (UP→DOWN, DOWN→UP) are logged and applied via the LoadBalancer interface.
-func Start(serverPool pool.LoadBalancer, interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	go func() {
-		for range ticker.C {
-			backends := serverPool.GetBackends()
-			for _, backend := range backends {
-				newStatus := CheckBackend(backend.URL.String())
-				previousStatus := backend.IsAlive()
-
-				if previousStatus != newStatus {
-					// Route state mutation through the interface (consistent & testable)
-					serverPool.SetBackendStatus(backend.URL, newStatus)
-
-					if newStatus {
-						log.Printf("✓ Backend %s is now UP", backend.URL.String())
-					} else {
-						log.Printf("✗ Backend %s is now DOWN", backend.URL.String())
-					}
-				}
+// Target is the part of a pool the health checker needs. Every
+// pool.LoadBalancer is a Target.
+type Target interface {
+	GetBackends() []*pool.Backend
+	SetBackendStatus(u *url.URL, alive bool)
+}
+
+// DefaultMaxConcurrent is the number of checks a checker runs at once,
+// unless Options.MaxConcurrent says otherwise.
+const DefaultMaxConcurrent = 8
+
+// DefaultTimeout bounds a check, unless Probe.Timeout says otherwise.
+const DefaultTimeout = 2 * time.Second
+
+// Check types, see Check.
+const (
+	CheckHTTP = "http" // GET /health must answer 200 OK
+	CheckTCP  = "tcp"  // the backend only has to accept a connection
+	CheckGRPC = "grpc" // grpc.health.v1.Health/Check must answer SERVING
+)
+
+// Options tunes a health checker started with StartWithOptions.
+type Options struct {
+	Type          string        // CheckHTTP (default), CheckTCP or CheckGRPC
+	Interval      time.Duration // time between two checks of a backend
+	Jitter        float64       // each wait varies by up to ±Jitter×Interval (at most 1); defaults to 0.1
+	MaxConcurrent int           // checks running at once; defaults to DefaultMaxConcurrent
+	Header        http.Header   // added to the HTTP and gRPC checks, e.g. credentials; see Probe
+
+	// MaxBackoff, when above Interval, spaces out the checks of a DOWN
+	// backend: the wait doubles after each failed check, from Interval up
+	// to MaxBackoff, and is back to Interval once the backend is UP. A
+	// short outage is still noticed within an interval or two, while a
+	// host dead for hours is not probed every few seconds.
+	MaxBackoff time.Duration
+
+	// The checks have their own HTTP client, apart from the transport of
+	// the proxied requests: see Probe for these settings.
+	Timeout    time.Duration
+	TLS        *tls.Config // replaces the backends' own TLS config for the checks
+	Proxy      func(*http.Request) (*url.URL, error)
+	KeepAlives bool // reuse the connection to a backend from one check to the next
+}
+
+// Start launches a background health checker pinging every backend at the
+// given interval, with the default Options.
+func Start(ctx context.Context, serverPool Target, interval time.Duration) {
+	StartWithOptions(ctx, serverPool, Options{Interval: interval})
+}
+
+// StartWithOptions launches a background health checker. Every backend has
+// its own timer, started at a random point of the first interval and
+// jittered afterwards, so checks neither hit the backends in synchronized
+// bursts nor wait behind a slow one; at most MaxConcurrent of them run at
+// once. Backends added to the pool are picked up within an interval.
+// State transitions (UP→DOWN, DOWN→UP) are logged and applied via the Target
+// interface. Everything stops when ctx is cancelled.
+func StartWithOptions(ctx context.Context, serverPool Target, opts Options) {
+	if opts.Jitter <= 0 {
+		opts.Jitter = 0.1
+	}
+	opts.Jitter = min(opts.Jitter, 1)
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = DefaultMaxConcurrent
+	}
+	s := &scheduler{
+		target:   serverPool,
+		opts:     opts,
+		slots:    make(chan struct{}, opts.MaxConcurrent),
+		watchers: make(map[*pool.Backend]context.CancelFunc),
+	}
+	go s.run(ctx)
+	log.Printf("Health checker started (interval: %v)", opts.Interval)
+}
+
+// scheduler runs one watcher goroutine per backend of its target.
+type scheduler struct {
+	target   Target
+	opts     Options
+	slots    chan struct{}                        // semaphore bounding the running checks
+	watchers map[*pool.Backend]context.CancelFunc // only touched by run
+}
+
+// run keeps a watcher per backend of the pool, until ctx is cancelled.
+func (s *scheduler) run(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	// The initial backends are spread over a whole interval; the ones added
+	// later are checked soon after they are noticed.
+	spread := s.opts.Interval
+	for {
+		s.sync(ctx, spread)
+		spread = time.Duration(s.opts.Jitter * float64(s.opts.Interval))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync starts watchers for new backends and stops those of removed ones.
+func (s *scheduler) sync(ctx context.Context, spread time.Duration) {
+	current := make(map[*pool.Backend]bool)
+	for _, b := range s.target.GetBackends() {
+		current[b] = true
+		if _, ok := s.watchers[b]; ok {
+			continue
+		}
+		watchCtx, cancel := context.WithCancel(ctx)
+		s.watchers[b] = cancel
+		go s.watch(watchCtx, b, time.Duration(rand.Int64N(int64(spread)+1)))
+	}
+	for b, cancel := range s.watchers {
+		if !current[b] {
+			cancel()
+			delete(s.watchers, b)
+		}
+	}
+}
+
+// watch checks b after first, then every interval, give or take the jitter;
+// see Options.MaxBackoff for a DOWN backend.
+func (s *scheduler) watch(ctx context.Context, b *pool.Backend, first time.Duration) {
+	probe := s.probe(b)
+	if probe.Transport != nil {
+		defer probe.Transport.CloseIdleConnections()
+	}
+	timer := time.NewTimer(first)
+	defer timer.Stop()
+	failures := 0 // consecutive checks finding b DOWN
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if alive, ok := s.check(ctx, b, probe); ok {
+			if alive {
+				failures = 0
+			} else {
+				failures++
 			}
 		}
-	}()
-	log.Printf("Health checker started (interval: %v)", interval)
+		timer.Reset(s.next(failures))
+	}
+}
+
+// next returns the wait before the next check of a backend after failures
+// consecutive failed checks.
+func (s *scheduler) next(failures int) time.Duration {
+	interval := s.opts.Interval
+	for ; failures > 1 && interval < s.opts.MaxBackoff; failures-- {
+		interval = min(2*interval, s.opts.MaxBackoff)
+	}
+	spread := s.opts.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(float64(interval) * (1 + spread))
+}
+
+// probe returns how b is checked: with Options.TLS, or else the backend's
+// own TLS config, e.g. its client certificate.
+func (s *scheduler) probe(b *pool.Backend) Probe {
+	p := Probe{Type: s.opts.Type, TLS: s.opts.TLS, Header: s.opts.Header, Timeout: s.opts.Timeout, Proxy: s.opts.Proxy}
+	if p.TLS == nil {
+		p.TLS = b.TLSConfig() // a copy: the backend's transport writes to its own
+	}
+	if s.opts.KeepAlives {
+		p.Transport = p.newTransport()
+		p.Transport.DisableKeepAlives = false
+	}
+	return p
+}
+
+// check checks b and applies the result; ok is false when the checker
+// stopped, or b was removed, before the result was known.
+func (s *scheduler) check(ctx context.Context, b *pool.Backend, probe Probe) (alive, ok bool) {
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return false, false
+	}
+	newStatus := probe.Check(b.URL.String())
+	<-s.slots
+	if ctx.Err() != nil {
+		return false, false // stopped, or the backend was removed, meanwhile
+	}
+
+	if b.IsAlive() != newStatus {
+		// Route state mutation through the interface (consistent & testable)
+		s.target.SetBackendStatus(b.URL, newStatus)
+
+		if newStatus {
+			log.Printf("✓ Backend %s is now UP", b.URL.String())
+		} else {
+			log.Printf("✗ Backend %s is now DOWN", b.URL.String())
+		}
+	}
+	return newStatus, true
 }
 
 // CheckBackend performs a GET request to <url>/health and returns true if the
-// response status is 200 OK within a 2-second timeout.
+// response status is 200 OK within DefaultTimeout. tcp:// backends (TCP
+// proxy mode) speak no HTTP: they are UP when they accept a connection.
 func CheckBackend(rawURL string) bool {
+	return Check(rawURL, CheckHTTP)
+}
+
+// Check runs a check of the given type against the backend. CheckTCP suits
+// backends without a /health route: they are UP when they accept a
+// connection on their host:port (80 or 443 by default, after the scheme).
+// tcp:// backends always get a CheckTCP. CheckGRPC asks about the server as
+// a whole, see CheckGRPCHealth.
+func Check(rawURL, typ string) bool {
+	return CheckTLS(rawURL, typ, nil)
+}
+
+// CheckTLS is Check with the client TLS config of the backend, for https://
+// backends with a private CA or requiring a client certificate; nil uses
+// Go's defaults.
+func CheckTLS(rawURL, typ string, tlsConfig *tls.Config) bool {
+	return Probe{Type: typ, TLS: tlsConfig}.Check(rawURL)
+}
+
+// Probe describes how Check reaches a backend.
+type Probe struct {
+	Type    string        // CheckHTTP (default), CheckTCP or CheckGRPC
+	TLS     *tls.Config   // client TLS config of https:// backends; nil uses Go's defaults
+	Timeout time.Duration // of the whole check; defaults to DefaultTimeout
+
+	// Header is added to the HTTP and gRPC checks, for backends answering
+	// 401 without credentials: e.g. Authorization, or an API key.
+	Header http.Header
+
+	// Proxy is the HTTP proxy of the HTTP checks, as http.Transport.Proxy;
+	// nil honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// Transport sends the HTTP checks, e.g. to keep a connection open from
+	// one check to the next; nil opens a new connection for each check.
+	Transport *http.Transport
+}
+
+func (p Probe) timeout() time.Duration {
+	if p.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return p.Timeout
+}
+
+// newTransport returns a transport for the HTTP checks, without
+// keep-alives. It gets its own copy of p.TLS: net/http fills in the config
+// a transport uses, which would race with the other users of p.TLS.
+func (p Probe) newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = p.TLS.Clone()
+	t.DisableKeepAlives = true
+	if p.Proxy != nil {
+		t.Proxy = p.Proxy
+	}
+	return t
+}
+
+// Check runs the probe against the backend, see Check.
+func (p Probe) Check(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err == nil && u.Scheme == pool.SchemeUnix {
+		return p.checkUnix(u)
+	}
+	if err == nil && u.Scheme != "tcp" && p.Type == CheckGRPC {
+		return checkGRPC(rawURL, "", p.TLS, p.Header, p.timeout())
+	}
+	if err == nil && (u.Scheme == "tcp" || p.Type == CheckTCP) {
+		port := u.Port()
+		switch {
+		case port != "":
+		case u.Scheme == "https":
+			port = "443"
+		default:
+			port = "80"
+		}
+		return checkTCP(net.JoinHostPort(u.Hostname(), port), p.timeout())
+	}
 	healthURL := strings.TrimSuffix(rawURL, "/") + "/health"
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout())
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
 	if err != nil {
 		return false
 	}
+	p.setHeader(req)
 
-	resp, err := http.DefaultClient.Do(req)
+	transport := p.Transport
+	if transport == nil {
+		transport = p.newTransport()
+		defer transport.CloseIdleConnections() // one-off transport
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
 	if err != nil {
 		return false
 	}
 	defer resp.Body.Close()
 
 	return resp.StatusCode == http.StatusOK
-}
\ No newline at end of file
+}
+
+// setHeader adds p.Header to req; a Host header sets req.Host.
+func (p Probe) setHeader(req *http.Request) {
+	for name, values := range p.Header {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	if host := p.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+}
+
+// checkUnix runs the check of a unix:// backend through its socket.
+func (p Probe) checkUnix(u *url.URL) bool {
+	socket, _ := pool.UnixSocket(u)
+	if p.Type == CheckTCP {
+		conn, err := net.DialTimeout("unix", socket, p.timeout())
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+	target := pool.HTTPTarget(u).String()
+	if p.Type == CheckGRPC {
+		transport := grpcTransport.Clone()
+		transport.DialContext = pool.UnixDialContext(socket)
+		defer transport.CloseIdleConnections() // one-off transport
+		return roundTripGRPC(transport, target, "", p.Header, p.timeout())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(target, "/")+"/health", nil)
+	if err != nil {
+		return false
+	}
+	p.setHeader(req)
+	transport := &http.Transport{DialContext: pool.UnixDialContext(socket), DisableKeepAlives: true}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// checkTCP reports whether addr accepts a TCP connection within timeout.
+func checkTCP(addr string, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
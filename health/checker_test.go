@@ -1,9 +1,14 @@
 package health_test
 
 import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -45,9 +50,133 @@ func TestCheckBackend_Unreachable(t *testing.T) {
 	}
 }
 
+// tcp:// backends are checked with a plain TCP connect, without HTTP.
+func TestCheckBackend_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	if !health.CheckBackend("tcp://" + addr) {
+		t.Error("expected a listening TCP backend to return true")
+	}
+	ln.Close()
+	if health.CheckBackend("tcp://" + addr) {
+		t.Error("expected a closed TCP backend to return false")
+	}
+}
+
+// The tcp check type only dials: an HTTP backend without a /health route is
+// UP as long as it accepts connections.
+func TestCheck_TCPType(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	rawURL := srv.URL
+
+	if health.Check(rawURL, health.CheckHTTP) {
+		t.Error("http check: a 404 on /health should be DOWN")
+	}
+	if !health.Check(rawURL, health.CheckTCP) {
+		t.Error("tcp check: a listening backend should be UP")
+	}
+	srv.Close()
+	if health.Check(rawURL, health.CheckTCP) {
+		t.Error("tcp check: a closed backend should be DOWN")
+	}
+}
+
+// newGRPCHealthServer fakes grpc.health.v1 over h2c: it answers status for
+// the service "" and NOT_FOUND for any other.
+func newGRPCHealthServer(t *testing.T, status byte) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.URL.Path != "/grpc.health.v1.Health/Check" || r.Header.Get("Content-Type") != "application/grpc" {
+			http.NotFound(w, r)
+			return
+		}
+		req, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		if len(req) != 5 { // a service name was asked about
+			w.Header().Set("Grpc-Status", "5") // NOT_FOUND
+			return
+		}
+		w.Write([]byte{0, 0, 0, 0, 2, 0x08, status}) // HealthCheckResponse{status}
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCheck_GRPCType(t *testing.T) {
+	serving := newGRPCHealthServer(t, 1)
+	if !health.Check(serving.URL, health.CheckGRPC) {
+		t.Error("expected a SERVING backend to be UP")
+	}
+	if health.CheckGRPCHealth(serving.URL, "shop.Cart") {
+		t.Error("expected an unknown service (NOT_FOUND) to be DOWN")
+	}
+	if health.Check(newGRPCHealthServer(t, 2).URL, health.CheckGRPC) {
+		t.Error("expected a NOT_SERVING backend to be DOWN")
+	}
+
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	if health.Check(plain.URL, health.CheckGRPC) {
+		t.Error("expected a backend speaking no HTTP/2 to be DOWN")
+	}
+}
+
+// Backends answering 401 without credentials are UP once the probe sends them.
+func TestProbe_SendsCredentials(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" || r.Header.Get("X-Api-Key") != "k" || r.Host != "internal.example" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	if health.CheckBackend(srv.URL) {
+		t.Error("expected a 401 without credentials to be DOWN")
+	}
+	probe := health.Probe{Header: http.Header{
+		"Authorization": {"Bearer s3cret"},
+		"X-Api-Key":     {"k"},
+		"Host":          {"internal.example"},
+	}}
+	if !probe.Check(srv.URL) {
+		t.Error("expected the backend to be UP with its credentials")
+	}
+}
+
 // ── health.Start integration
 // Start should flip a backend from DOWN to UP once a healthy /health endpoint
 // becomes reachable within the check interval.
+func TestProbe_TimeoutAndTLS(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer slow.Close()
+	if (health.Probe{Timeout: 100 * time.Millisecond}).Check(slow.URL) {
+		t.Error("expected a backend slower than the timeout to be DOWN")
+	}
+	if !(health.Probe{Timeout: time.Second}).Check(slow.URL) {
+		t.Error("expected a backend within the timeout to be UP")
+	}
+
+	selfSigned := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer selfSigned.Close()
+	if (health.Probe{}).Check(selfSigned.URL) {
+		t.Error("expected a self-signed certificate to be rejected by default")
+	}
+	if !(health.Probe{TLS: &tls.Config{InsecureSkipVerify: true}}).Check(selfSigned.URL) {
+		t.Error("expected a self-signed certificate to be accepted with InsecureSkipVerify")
+	}
+}
+
 func TestStart_MarksBackendAlive(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/health" {
@@ -62,7 +191,7 @@ func TestStart_MarksBackendAlive(t *testing.T) {
 	b.SetAlive(false)                                       // starts dead
 	sp.AddBackend(b)
 
-	health.Start(sp, 100*time.Millisecond) 
+	health.Start(t.Context(), sp, 100*time.Millisecond) 
 
 	// Wait up to 1 second for the health checker to flip the backend UP.
 	deadline := time.Now().Add(1 * time.Second)
@@ -88,7 +217,7 @@ func TestStart_MarksBackendDead(t *testing.T) {
 	b.SetAlive(true)                                 // starts alive
 	sp.AddBackend(b)
 
-	health.Start(sp, 100*time.Millisecond)
+	health.Start(t.Context(), sp, 100*time.Millisecond)
 
 	// Close the server — next health check should mark the backend DOWN.
 	srv.Close()
@@ -101,4 +230,107 @@ func TestStart_MarksBackendDead(t *testing.T) {
 		time.Sleep(20 * time.Millisecond)
 	}
 	t.Error("backend was not marked dead within 1 second after server closed")
-}
\ No newline at end of file
+}
+// A DOWN backend is checked less and less often, and UP again soon after it
+// recovers.
+func TestStartWithOptions_BacksOffDownBackends(t *testing.T) {
+	var checks atomic.Int32
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	sp := &pool.ServerPool{Strategy: "round-robin"}
+	u, _ := url.Parse(srv.URL)
+	b := &pool.Backend{URL: u}
+	sp.AddBackend(b)
+	health.StartWithOptions(t.Context(), sp, health.Options{Interval: 20 * time.Millisecond, MaxBackoff: 160 * time.Millisecond})
+
+	time.Sleep(700 * time.Millisecond)
+	if n := checks.Load(); n < 4 || n > 12 {
+		t.Errorf("expected about 7 checks of the DOWN backend in 700ms, saw %d", n)
+	}
+	if b.IsAlive() {
+		t.Fatal("expected the backend to be DOWN")
+	}
+
+	healthy.Store(true)
+	deadline := time.Now().Add(400 * time.Millisecond)
+	for !b.IsAlive() {
+		if time.Now().After(deadline) {
+			t.Fatal("the backend was not marked alive within the maximum backoff")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Once its context is cancelled, Start must stop checking.
+func TestStart_StopsWhenContextCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sp := &pool.ServerPool{Strategy: "round-robin"}
+	u, _ := url.Parse(srv.URL)
+	b := &pool.Backend{URL: u}
+	sp.AddBackend(b)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	health.Start(ctx, sp, 50*time.Millisecond)
+	cancel()
+
+	time.Sleep(200 * time.Millisecond)
+	if b.IsAlive() {
+		t.Error("a stopped health checker must not mark the backend UP")
+	}
+}
+
+// Checks run concurrently, each backend on its own timer, but no more than
+// MaxConcurrent at once: slow backends do not hold the others back for a
+// whole round, nor let checks pile up.
+func TestStartWithOptions_ConcurrentAndBounded(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fast.Close()
+
+	sp := &pool.ServerPool{Strategy: "round-robin"}
+	for _, raw := range []string{slow.URL, slow.URL + "/a", slow.URL + "/b"} {
+		u, _ := url.Parse(raw)
+		sp.AddBackend(&pool.Backend{URL: u})
+	}
+	health.StartWithOptions(t.Context(), sp, health.Options{Interval: 50 * time.Millisecond, MaxConcurrent: 2})
+
+	// Added later, the fast backend is picked up and gets the next free slot.
+	u, _ := url.Parse(fast.URL)
+	b := &pool.Backend{URL: u}
+	sp.AddBackend(b)
+	deadline := time.Now().Add(time.Second)
+	for !b.IsAlive() {
+		if time.Now().After(deadline) {
+			t.Fatal("the fast backend was not marked alive within 1 second")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if m := maxInFlight.Load(); m != 2 {
+		t.Errorf("expected 2 checks at once, at most, saw %d", m)
+	}
+}
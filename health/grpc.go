@@ -0,0 +1,138 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// grpcTransport speaks HTTP/2 only: cleartext (prior knowledge) to http://
+// backends, negotiated by ALPN to https:// ones, as gRPC requires.
+var grpcTransport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Protocols = new(http.Protocols)
+	t.Protocols.SetHTTP2(true)
+	t.Protocols.SetUnencryptedHTTP2(true)
+	return t
+}()
+
+// grpcServing is HealthCheckResponse.ServingStatus SERVING.
+const grpcServing = 1
+
+// CheckGRPCHealth calls the standard grpc.health.v1.Health/Check RPC of the
+// backend for the given service ("" asks about the server as a whole) and
+// returns true if it answers SERVING within DefaultTimeout.
+func CheckGRPCHealth(rawURL, service string) bool {
+	return checkGRPC(rawURL, service, nil, nil, DefaultTimeout)
+}
+
+func checkGRPC(rawURL, service string, tlsConfig *tls.Config, header http.Header, timeout time.Duration) bool {
+	transport := grpcTransport
+	if tlsConfig != nil {
+		transport = grpcTransport.Clone()
+		transport.TLSClientConfig = tlsConfig.Clone()
+		defer transport.CloseIdleConnections() // one-off transport
+	}
+	return roundTripGRPC(transport, rawURL, service, header, timeout)
+}
+
+// roundTripGRPC sends the Health/Check RPC through transport, with header
+// as metadata.
+func roundTripGRPC(transport *http.Transport, rawURL, service string, header http.Header, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// HealthCheckRequest{service = 1}, in a gRPC length-prefixed frame.
+	var msg []byte
+	if service != "" {
+		msg = append([]byte{0x0a}, binary.AppendUvarint(nil, uint64(len(service)))...)
+		msg = append(msg, service...)
+	}
+	frame := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg)))
+	frame = append(frame, msg...)
+
+	checkURL := strings.TrimSuffix(rawURL, "/") + "/grpc.health.v1.Health/Check"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, checkURL, bytes.NewReader(frame))
+	if err != nil {
+		return false
+	}
+	Probe{Header: header}.setHeader(req)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	if err != nil {
+		return false
+	}
+
+	// An error comes back as a grpc-status other than 0, in the trailers or,
+	// for an immediate error, in the headers.
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status != "0" || len(body) < 5 || body[0] != 0 {
+		return false
+	}
+	n := binary.BigEndian.Uint32(body[1:5])
+	if int(n) != len(body)-5 {
+		return false
+	}
+	return servingStatus(body[5:]) == grpcServing
+}
+
+// servingStatus decodes HealthCheckResponse{status = 1}, skipping unknown
+// fields; -1 if the message is malformed.
+func servingStatus(msg []byte) int {
+	status := 0 // UNKNOWN, the proto3 default
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return -1
+		}
+		msg = msg[n:]
+		switch key & 7 { // wire type
+		case 0: // varint
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return -1
+			}
+			msg = msg[n:]
+			if key>>3 == 1 {
+				status = int(v)
+			}
+		case 1: // fixed64
+			if len(msg) < 8 {
+				return -1
+			}
+			msg = msg[8:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return -1
+			}
+			msg = msg[n+int(l):]
+		case 5: // fixed32
+			if len(msg) < 4 {
+				return -1
+			}
+			msg = msg[4:]
+		default:
+			return -1
+		}
+	}
+	return status
+}
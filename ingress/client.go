@@ -0,0 +1,188 @@
+package ingress
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir is where Kubernetes mounts the pod's API credentials.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Client is a minimal read-only Kubernetes API client: the controller only
+// needs a handful of GETs, which does not justify pulling in client-go.
+type Client struct {
+	BaseURL string // e.g. https://10.0.0.1:443
+	Token   string // bearer token; empty sends no Authorization header
+	HTTP    *http.Client
+
+	// TokenFile, when set, holds the bearer token in place of Token. It is
+	// read for every request: projected service account tokens rotate.
+	TokenFile string
+}
+
+// InClusterClient builds a client from the service account mounted in the
+// pod and the KUBERNETES_SERVICE_HOST/PORT environment variables.
+func InClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster: KUBERNETES_SERVICE_HOST/PORT are not set")
+	}
+	if _, err := os.Stat(serviceAccountDir + "/token"); err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in %s/ca.crt", serviceAccountDir)
+	}
+
+	return &Client{
+		BaseURL:   "https://" + net.JoinHostPort(host, port),
+		TokenFile: serviceAccountDir + "/token",
+		HTTP: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		},
+	}, nil
+}
+
+// get fetches path and decodes the JSON answer into out.
+func (c *Client) get(ctx context.Context, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.BaseURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	token := c.Token
+	if c.TokenFile != "" {
+		data, err := os.ReadFile(c.TokenFile)
+		if err != nil {
+			return err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &apiError{path: path, status: resp.StatusCode, body: strings.TrimSpace(string(body))}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type apiError struct {
+	path   string
+	status int
+	body   string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("GET %s: %d %s", e.path, e.status, e.body)
+}
+
+// temporary reports whether err may go away by itself: the API server could
+// not be reached, or answered 5xx or 429. Other errors, like a Service that
+// is not found, describe the cluster as it is.
+func temporary(err error) bool {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.status >= 500 || apiErr.status == http.StatusTooManyRequests
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// ── API objects (only the fields the controller reads) ───────────────────────
+
+type objectMeta struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type ingressList struct {
+	Items []ingressObject `json:"items"`
+}
+
+type ingressObject struct {
+	Metadata objectMeta  `json:"metadata"`
+	Spec     ingressSpec `json:"spec"`
+}
+
+type ingressSpec struct {
+	IngressClassName *string         `json:"ingressClassName"`
+	DefaultBackend   *ingressBackend `json:"defaultBackend"`
+	Rules            []ingressRule   `json:"rules"`
+}
+
+type ingressRule struct {
+	Host string `json:"host"`
+	HTTP *struct {
+		Paths []ingressPath `json:"paths"`
+	} `json:"http"`
+}
+
+type ingressPath struct {
+	Path     string         `json:"path"`
+	PathType string         `json:"pathType"` // Exact, Prefix or ImplementationSpecific
+	Backend  ingressBackend `json:"backend"`
+}
+
+type ingressBackend struct {
+	Service *struct {
+		Name string      `json:"name"`
+		Port servicePort `json:"port"`
+	} `json:"service"`
+}
+
+// servicePort references a Service port by number or by name.
+type servicePort struct {
+	Number int    `json:"number"`
+	Name   string `json:"name"`
+}
+
+type service struct {
+	Spec struct {
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"spec"`
+}
+
+type endpoints struct {
+	Subsets []struct {
+		Addresses []struct {
+			IP string `json:"ip"`
+		} `json:"addresses"` // ready addresses only; notReadyAddresses are skipped
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
@@ -0,0 +1,284 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"reverse-proxy/pool"
+	"reverse-proxy/route"
+)
+
+// classAnnotation is the pre-IngressClass way of selecting a controller,
+// still used by many manifests.
+const classAnnotation = "kubernetes.io/ingress.class"
+
+// Controller turns the Ingress resources of one IngressClass into routes of
+// a route.Table, each backed by a regular pool whose backends are the ready
+// endpoints of the referenced Service. Balancing, retries and health checks
+// are the same as for statically configured routes.
+type Controller struct {
+	Client    *Client
+	Class     string
+	Namespace string         // empty watches every namespace
+	Table     *route.Table   // receives Static plus the ingress routes on every sync
+	Static    []*route.Route // routes from the config file, always served
+
+	// NewPool and NewHandler build the pool and the proxy handler of a new
+	// ingress route, so they share the proxy's strategy and options.
+	NewPool    func() *pool.ServerPool
+	NewHandler func(name string, lb pool.LoadBalancer) http.Handler
+
+	mux    sync.Mutex
+	routes map[string]*route.Route // by name; kept across syncs to preserve backend state
+}
+
+// desiredRoute is one Ingress path resolved to backend URLs.
+type desiredRoute struct {
+	name     string
+	host     string
+	path     string
+	exact    bool
+	backends []string
+	stale    bool // backends unknown for now (API server failing), keep the current ones
+}
+
+// Run syncs immediately and then every interval until ctx is done. A failed
+// sync is logged and the previous routes keep serving.
+func (c *Controller) Run(ctx context.Context, interval time.Duration) {
+	if err := c.Sync(ctx); err != nil {
+		log.Printf("Ingress sync failed: %v", err)
+	}
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.Sync(ctx); err != nil {
+					log.Printf("Ingress sync failed: %v", err)
+				}
+			}
+		}
+	}()
+	log.Printf("Ingress controller started (class: %s, interval: %v)", c.Class, interval)
+}
+
+// Sync lists the Ingresses of our class, resolves their Services to ready
+// endpoints and installs the resulting routes.
+func (c *Controller) Sync(ctx context.Context) error {
+	path := "/apis/networking.k8s.io/v1/ingresses"
+	if c.Namespace != "" {
+		path = "/apis/networking.k8s.io/v1/namespaces/" + c.Namespace + "/ingresses"
+	}
+	var list ingressList
+	if err := c.Client.get(ctx, path, &list); err != nil {
+		return err
+	}
+
+	resolved := map[string][]string{} // one lookup per service port per sync
+	stale := map[string]bool{}        // keys whose lookup failed temporarily
+	var desired []desiredRoute
+	for _, ing := range list.Items {
+		if !c.owns(ing) {
+			continue
+		}
+		ns := ing.Metadata.Namespace
+		add := func(host string, p ingressPath) {
+			svc := p.Backend.Service
+			if svc == nil {
+				return // resource backends are not supported
+			}
+			key := fmt.Sprintf("%s/%s:%d%s", ns, svc.Name, svc.Port.Number, svc.Port.Name)
+			backends, ok := resolved[key]
+			if !ok {
+				var err error
+				if backends, err = c.resolve(ctx, ns, svc.Name, svc.Port); err != nil {
+					// Keep the route: an existing one keeps its backends
+					// while the API server is unreachable or failing, else
+					// it answers 503 until the Service resolves.
+					log.Printf("Ingress %s/%s: %v", ns, ing.Metadata.Name, err)
+					stale[key] = temporary(err)
+				}
+				resolved[key] = backends
+			}
+			if p.Path == "" {
+				p.Path = "/"
+			}
+			exact := p.PathType == "Exact"
+			name := fmt.Sprintf("%s/%s/%s%s", ns, ing.Metadata.Name, host, p.Path)
+			if exact {
+				name += " (exact)"
+			}
+			desired = append(desired, desiredRoute{name: name, host: host, path: p.Path, exact: exact, backends: backends, stale: stale[key]})
+		}
+
+		if ing.Spec.DefaultBackend != nil {
+			add("", ingressPath{Path: "/", Backend: *ing.Spec.DefaultBackend})
+		}
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, p := range rule.HTTP.Paths {
+				add(rule.Host, p)
+			}
+		}
+	}
+
+	c.apply(desired)
+	return nil
+}
+
+func (c *Controller) owns(ing ingressObject) bool {
+	if ing.Spec.IngressClassName != nil {
+		return *ing.Spec.IngressClassName == c.Class
+	}
+	return ing.Metadata.Annotations[classAnnotation] == c.Class
+}
+
+// resolve maps a Service port to the URLs of the Service's ready endpoints.
+func (c *Controller) resolve(ctx context.Context, ns, name string, port servicePort) ([]string, error) {
+	var svc service
+	if err := c.Client.get(ctx, "/api/v1/namespaces/"+ns+"/services/"+name, &svc); err != nil {
+		return nil, err
+	}
+	// Endpoints name their ports after the Service ports, so find the name.
+	portName, found := port.Name, port.Name != ""
+	for _, sp := range svc.Spec.Ports {
+		if !found && sp.Port == port.Number {
+			portName, found = sp.Name, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("service %s/%s has no port %d", ns, name, port.Number)
+	}
+
+	var eps endpoints
+	if err := c.Client.get(ctx, "/api/v1/namespaces/"+ns+"/endpoints/"+name, &eps); err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, subset := range eps.Subsets {
+		for _, p := range subset.Ports {
+			if p.Name != portName {
+				continue
+			}
+			for _, addr := range subset.Addresses {
+				urls = append(urls, "http://"+net.JoinHostPort(addr.IP, strconv.Itoa(p.Port)))
+			}
+		}
+	}
+	sort.Strings(urls)
+	return urls, nil
+}
+
+// apply reconciles the ingress routes with desired. Existing routes keep their
+// pool, so health state and connection counts of unchanged backends survive.
+func (c *Controller) apply(desired []desiredRoute) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.routes == nil {
+		c.routes = make(map[string]*route.Route)
+	}
+	next := make(map[string]*route.Route, len(desired))
+	for _, d := range desired {
+		rt, ok := c.routes[d.name]
+		if !ok {
+			sp := c.NewPool()
+			rt = &route.Route{
+				Name:       d.name,
+				Host:       d.host,
+				PathPrefix: d.path,
+				Exact:      d.exact,
+				Pool:       sp,
+				Handler:    c.NewHandler(d.name, sp),
+			}
+			log.Printf("Ingress route added: %s", d.name)
+		}
+		if !ok || !d.stale {
+			syncBackends(rt.Pool, d.backends)
+		}
+		next[d.name] = rt
+	}
+	for name := range c.routes {
+		if _, ok := next[name]; !ok {
+			log.Printf("Ingress route removed: %s", name)
+		}
+	}
+	c.routes = next
+
+	all := make([]*route.Route, 0, len(next)+len(c.Static))
+	for _, rt := range next {
+		all = append(all, rt)
+	}
+	// Map order is random: sort so equally specific routes keep a stable order.
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	c.Table.Replace(append(all, c.Static...)...)
+}
+
+// syncBackends adds and removes backends so the pool serves exactly urls.
+// Endpoints listed by Kubernetes are ready, so new backends start alive.
+func syncBackends(lb pool.LoadBalancer, urls []string) {
+	want := make(map[string]bool, len(urls))
+	for _, raw := range urls {
+		want[raw] = true
+	}
+	for _, b := range lb.GetBackends() {
+		if want[b.URL.String()] {
+			delete(want, b.URL.String())
+			continue
+		}
+		lb.RemoveBackend(b.URL)
+	}
+	for _, raw := range urls {
+		if !want[raw] {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+		b := &pool.Backend{URL: u}
+		b.SetAlive(true)
+		lb.AddBackend(b)
+	}
+}
+
+// GetBackends returns the backends of every ingress route, so the health
+// checker can watch them alongside the static pools.
+func (c *Controller) GetBackends() []*pool.Backend {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	var all []*pool.Backend
+	for _, rt := range c.routes {
+		all = append(all, rt.Pool.GetBackends()...)
+	}
+	return all
+}
+
+// SetBackendStatus applies a health transition to every ingress route that
+// has the backend (a Service can back several routes).
+func (c *Controller) SetBackendStatus(u *url.URL, alive bool) {
+	c.SetBackendStatusCause(u, alive, pool.CauseHealthCheck, "")
+}
+
+// SetBackendStatusCause is SetBackendStatus recording why, see
+// pool.SetStatusCause.
+func (c *Controller) SetBackendStatusCause(u *url.URL, alive bool, cause pool.Cause, detail string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for _, rt := range c.routes {
+		pool.SetStatusCause(rt.Pool, u, alive, cause, detail)
+	}
+}
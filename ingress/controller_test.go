@@ -0,0 +1,226 @@
+package ingress
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"reverse-proxy/pool"
+	"reverse-proxy/route"
+)
+
+// fakeAPI serves a set of objects by API path; a nil object is a 404 and an
+// int an error with that status.
+type fakeAPI struct {
+	mux     sync.Mutex
+	objects map[string]any
+}
+
+func (f *fakeAPI) set(path string, obj any) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if obj == nil {
+		delete(f.objects, path)
+		return
+	}
+	f.objects[path] = obj
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	obj, ok := f.objects[r.URL.Path]
+	f.mux.Unlock()
+	if !ok {
+		http.Error(w, `{"reason":"NotFound"}`, http.StatusNotFound)
+		return
+	}
+	if status, ok := obj.(int); ok {
+		http.Error(w, `{"reason":"InternalError"}`, status)
+		return
+	}
+	json.NewEncoder(w).Encode(obj)
+}
+
+// raw is a JSON literal used to describe API objects in tests.
+type raw = map[string]any
+
+func newController(t *testing.T, api *fakeAPI) *Controller {
+	t.Helper()
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	return &Controller{
+		Client:  &Client{BaseURL: srv.URL},
+		Class:   "reverse-proxy",
+		Table:   route.NewTable(),
+		NewPool: func() *pool.ServerPool { return &pool.ServerPool{Strategy: "round-robin"} },
+		NewHandler: func(name string, lb pool.LoadBalancer) http.Handler {
+			return http.NotFoundHandler()
+		},
+	}
+}
+
+func endpointsFor(ips ...string) raw {
+	var addrs []raw
+	for _, ip := range ips {
+		addrs = append(addrs, raw{"ip": ip})
+	}
+	return raw{"subsets": []raw{{
+		"addresses": addrs,
+		"ports":     []raw{{"name": "http", "port": 8080}},
+	}}}
+}
+
+func TestController_TranslatesIngressToRoutes(t *testing.T) {
+	api := &fakeAPI{objects: map[string]any{
+		"/apis/networking.k8s.io/v1/ingresses": raw{"items": []raw{
+			{
+				"metadata": raw{"name": "web", "namespace": "shop"},
+				"spec": raw{
+					"ingressClassName": "reverse-proxy",
+					"rules": []raw{{
+						"host": "shop.example.com",
+						"http": raw{"paths": []raw{{
+							"path": "/api", "pathType": "Prefix",
+							"backend": raw{"service": raw{"name": "api", "port": raw{"number": 80}}},
+						}}},
+					}},
+				},
+			},
+			{
+				"metadata": raw{"name": "other", "namespace": "shop"},
+				"spec": raw{
+					"ingressClassName": "nginx",
+					"defaultBackend":   raw{"service": raw{"name": "api", "port": raw{"number": 80}}},
+				},
+			},
+		}},
+		"/api/v1/namespaces/shop/services/api":  raw{"spec": raw{"ports": []raw{{"name": "http", "port": 80}}}},
+		"/api/v1/namespaces/shop/endpoints/api": endpointsFor("10.0.0.1", "10.0.0.2"),
+	}}
+	c := newController(t, api)
+
+	if err := c.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	routes := c.Table.Routes()
+	if len(routes) != 1 {
+		t.Fatalf("expected 1 route (other IngressClass ignored), got %d", len(routes))
+	}
+	rt := routes[0]
+	if rt.Host != "shop.example.com" || rt.PathPrefix != "/api" {
+		t.Errorf("unexpected route %s: host %q path %q", rt.Name, rt.Host, rt.PathPrefix)
+	}
+	if n := len(rt.Pool.GetBackends()); n != 2 {
+		t.Fatalf("expected 2 backends, got %d", n)
+	}
+
+	// A scaled-down Service drops the gone endpoint but keeps the pool and
+	// the state of the endpoint that stayed.
+	kept := rt.Pool.GetBackends()[0]
+	kept.SetAlive(false)
+	api.set("/api/v1/namespaces/shop/endpoints/api", endpointsFor("10.0.0.1"))
+	if err := c.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	backends := c.Table.Routes()[0].Pool.GetBackends()
+	if len(backends) != 1 || backends[0] != kept || kept.IsAlive() {
+		t.Errorf("expected the remaining backend to be reused as is, got %v", backends)
+	}
+}
+
+func TestController_KeepsRoutesWhenAPIFails(t *testing.T) {
+	api := &fakeAPI{objects: map[string]any{
+		"/apis/networking.k8s.io/v1/ingresses": raw{"items": []raw{{
+			"metadata": raw{"name": "web", "namespace": "shop",
+				"annotations": raw{classAnnotation: "reverse-proxy"}},
+			"spec": raw{"defaultBackend": raw{"service": raw{"name": "api", "port": raw{"name": "http"}}}},
+		}}},
+		"/api/v1/namespaces/shop/services/api":  raw{"spec": raw{"ports": []raw{{"name": "http", "port": 80}}}},
+		"/api/v1/namespaces/shop/endpoints/api": endpointsFor("10.0.0.1"),
+	}}
+	c := newController(t, api)
+	if err := c.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	api.set("/apis/networking.k8s.io/v1/ingresses", nil)
+	if err := c.Sync(context.Background()); err == nil {
+		t.Fatal("expected an error when the API fails")
+	}
+	if len(c.Table.Routes()) != 1 || len(c.GetBackends()) != 1 {
+		t.Error("a failed sync must keep the previous routes")
+	}
+}
+
+func TestController_KeepsBackendsWhenServiceFails(t *testing.T) {
+	api := &fakeAPI{objects: map[string]any{
+		"/apis/networking.k8s.io/v1/ingresses": raw{"items": []raw{{
+			"metadata": raw{"name": "web", "namespace": "shop",
+				"annotations": raw{classAnnotation: "reverse-proxy"}},
+			"spec": raw{"defaultBackend": raw{"service": raw{"name": "api", "port": raw{"name": "http"}}}},
+		}}},
+		"/api/v1/namespaces/shop/services/api":  raw{"spec": raw{"ports": []raw{{"name": "http", "port": 80}}}},
+		"/api/v1/namespaces/shop/endpoints/api": endpointsFor("10.0.0.1", "10.0.0.2"),
+	}}
+	c := newController(t, api)
+	if err := c.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	// The Ingress list still works but the endpoints lookup fails: the
+	// route must keep serving its last known backends instead of 503.
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		api.set("/api/v1/namespaces/shop/endpoints/api", status)
+		if err := c.Sync(context.Background()); err != nil {
+			t.Fatalf("sync failed: %v", err)
+		}
+		routes := c.Table.Routes()
+		if len(routes) != 1 {
+			t.Fatalf("expected 1 route, got %d", len(routes))
+		}
+		if n := len(routes[0].Pool.GetBackends()); n != 2 {
+			t.Errorf("%d: expected the 2 previous backends to be kept, got %d", status, n)
+		}
+	}
+
+	// A deleted Service is no failure: its backends go.
+	api.set("/api/v1/namespaces/shop/services/api", nil)
+	if err := c.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if n := len(c.GetBackends()); n != 0 {
+		t.Errorf("expected the backends of a deleted Service to be dropped, got %d", n)
+	}
+}
+
+// The in-cluster token is read for every request, as projected service
+// account tokens rotate.
+func TestClient_RereadsTokenFile(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+		io.WriteString(w, `{"items":[]}`)
+	}))
+	t.Cleanup(srv.Close)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	c := &Client{BaseURL: srv.URL, TokenFile: tokenFile}
+
+	for _, token := range []string{"first", "rotated"} {
+		if err := os.WriteFile(tokenFile, []byte(token+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		var list ingressList
+		if err := c.get(context.Background(), "/apis/networking.k8s.io/v1/ingresses", &list); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != 2 || got[0] != "Bearer first" || got[1] != "Bearer rotated" {
+		t.Errorf("expected the rotated token to be sent, got %q", got)
+	}
+}
@@ -0,0 +1,54 @@
+package limit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Bandwidth caps the bytes per second shared by every writer throttled with
+// it, as a token bucket: up to one second of traffic may go out at once,
+// then writers wait for their share.
+type Bandwidth struct {
+	rate   float64 // bytes per second
+	burst  float64
+	mux    sync.Mutex
+	tokens float64 // may go negative: the debt of the writers waiting
+	last   time.Time
+}
+
+// NewBandwidth allows bytesPerSecond, which must be positive.
+func NewBandwidth(bytesPerSecond int64) *Bandwidth {
+	rate := float64(bytesPerSecond)
+	return &Bandwidth{rate: rate, burst: rate, tokens: rate, last: time.Now()}
+}
+
+// Rate returns the bytes per second allowed.
+func (b *Bandwidth) Rate() int64 {
+	return int64(b.rate)
+}
+
+// WaitN takes n bytes from the bucket, waiting until the bandwidth allows
+// them or ctx is done. Bytes taken stay taken, so the writers waiting at
+// once share the bandwidth in turn.
+func (b *Bandwidth) WaitN(ctx context.Context, n int) error {
+	b.mux.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mux.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,37 @@
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBandwidth_PacesWritersAfterTheBurst(t *testing.T) {
+	b := NewBandwidth(10000)
+	ctx := context.Background()
+
+	start := time.Now()
+	if err := b.WaitN(ctx, 10000); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("the first second of traffic must go out at once, waited %v", elapsed)
+	}
+	// The bucket is empty: 2000 more bytes take ~200ms, shared or not.
+	done := make(chan struct{})
+	go func() {
+		b.WaitN(ctx, 1000)
+		close(done)
+	}()
+	b.WaitN(ctx, 1000)
+	<-done
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Errorf("expected ~200ms for 2000 bytes at 10000 B/s, got %v", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := b.WaitN(cancelled, 100000); err == nil {
+		t.Error("a cancelled wait must fail")
+	}
+}
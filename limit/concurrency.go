@@ -0,0 +1,82 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned when every slot is busy and the wait queue is full.
+	ErrQueueFull = errors.New("concurrency limit reached and queue is full")
+	// ErrQueueTimeout is returned when a queued request waited too long for a slot.
+	ErrQueueTimeout = errors.New("timed out waiting for a concurrency slot")
+)
+
+// ConcurrencyLimiter caps how many requests may be forwarded at once, e.g. to
+// protect one fragile backend pool. Requests beyond the ceiling wait in a
+// bounded queue (up to queueTimeout) or are rejected when the queue is full.
+type ConcurrencyLimiter struct {
+	slots        chan struct{}
+	maxQueue     int64
+	queueTimeout time.Duration
+	queued       int64 // atomic
+}
+
+// NewConcurrencyLimiter allows max concurrent holders. maxQueue is how many
+// callers may wait for a slot (0 = reject immediately when full).
+func NewConcurrencyLimiter(max, maxQueue int, queueTimeout time.Duration) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		slots:        make(chan struct{}, max),
+		maxQueue:     int64(maxQueue),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// Acquire takes a slot, waiting in the queue if necessary. Every successful
+// Acquire must be paired with a Release.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if atomic.AddInt64(&l.queued, 1) > l.maxQueue {
+		atomic.AddInt64(&l.queued, -1)
+		return ErrQueueFull
+	}
+	defer atomic.AddInt64(&l.queued, -1)
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		return ErrQueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release returns a slot taken by Acquire.
+func (l *ConcurrencyLimiter) Release() {
+	<-l.slots
+}
+
+// InFlight returns the number of slots currently held.
+func (l *ConcurrencyLimiter) InFlight() int {
+	return len(l.slots)
+}
+
+// Queued returns the number of callers waiting for a slot.
+func (l *ConcurrencyLimiter) Queued() int {
+	return int(atomic.LoadInt64(&l.queued))
+}
@@ -0,0 +1,68 @@
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter_RejectsWhenQueueFull(t *testing.T) {
+	l := NewConcurrencyLimiter(1, 0, time.Second)
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+	if err := l.Acquire(context.Background()); err != ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	l.Release()
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatalf("acquire after release failed: %v", err)
+	}
+}
+
+func TestConcurrencyLimiter_QueuedCallerGetsSlot(t *testing.T) {
+	l := NewConcurrencyLimiter(1, 1, time.Second)
+	l.Acquire(context.Background())
+
+	got := make(chan error, 1)
+	go func() { got <- l.Acquire(context.Background()) }()
+
+	deadline := time.Now().Add(time.Second)
+	for l.Queued() != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if l.Queued() != 1 {
+		t.Fatal("expected one queued caller")
+	}
+
+	l.Release()
+	if err := <-got; err != nil {
+		t.Fatalf("queued caller should have obtained the slot, got %v", err)
+	}
+	if l.InFlight() != 1 || l.Queued() != 0 {
+		t.Errorf("unexpected state: inflight=%d queued=%d", l.InFlight(), l.Queued())
+	}
+}
+
+func TestConcurrencyLimiter_QueueTimeout(t *testing.T) {
+	l := NewConcurrencyLimiter(1, 5, 50*time.Millisecond)
+	l.Acquire(context.Background())
+
+	if err := l.Acquire(context.Background()); err != ErrQueueTimeout {
+		t.Fatalf("expected ErrQueueTimeout, got %v", err)
+	}
+	if l.Queued() != 0 {
+		t.Errorf("queue counter leaked: %d", l.Queued())
+	}
+}
+
+func TestConcurrencyLimiter_ContextCancel(t *testing.T) {
+	l := NewConcurrencyLimiter(1, 5, 0)
+	l.Acquire(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Acquire(ctx); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
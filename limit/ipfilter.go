@@ -0,0 +1,157 @@
+package limit
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// IPFilter admits or rejects clients by address. A client in the denylist is
+// always rejected; otherwise, a non-empty allowlist admits only its members.
+// Both lists can be changed at runtime.
+type IPFilter struct {
+	mux   sync.RWMutex
+	allow []entry
+	deny  []entry
+}
+
+// entry keeps the text an operator wrote next to the parsed range, so the
+// lists read back exactly as they were configured.
+type entry struct {
+	text string
+	net  *net.IPNet
+}
+
+// NewIPFilter builds a filter; entries may be single IPs or CIDRs.
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	f := &IPFilter{}
+	if err := f.Set(allow, deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Set replaces both lists. Nothing changes if an entry is invalid.
+func (f *IPFilter) Set(allow, deny []string) error {
+	a, err := parseEntries(allow)
+	if err != nil {
+		return err
+	}
+	d, err := parseEntries(deny)
+	if err != nil {
+		return err
+	}
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.allow, f.deny = a, d
+	return nil
+}
+
+// Add appends an IP or CIDR to the "allow" or "deny" list. It reports false
+// when the entry was already there.
+func (f *IPFilter) Add(list, cidr string) (bool, error) {
+	parsed, err := parseEntries([]string{cidr})
+	if err != nil {
+		return false, err
+	}
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	l, err := f.list(list)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range *l {
+		if e.net.String() == parsed[0].net.String() {
+			return false, nil
+		}
+	}
+	*l = append(*l, parsed[0])
+	return true, nil
+}
+
+// Remove drops an IP or CIDR from the "allow" or "deny" list. It reports
+// false when the entry was not there.
+func (f *IPFilter) Remove(list, cidr string) (bool, error) {
+	parsed, err := parseEntries([]string{cidr})
+	if err != nil {
+		return false, err
+	}
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	l, err := f.list(list)
+	if err != nil {
+		return false, err
+	}
+	for i, e := range *l {
+		if e.net.String() == parsed[0].net.String() {
+			*l = append((*l)[:i:i], (*l)[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Lists returns copies of the allowlist and the denylist.
+func (f *IPFilter) Lists() (allow, deny []string) {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	return texts(f.allow), texts(f.deny)
+}
+
+// Check reports whether ip may pass, and why not when it may not.
+func (f *IPFilter) Check(ip net.IP) (bool, string) {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	if ip == nil {
+		if len(f.allow) > 0 {
+			return false, "unknown client address"
+		}
+		return true, ""
+	}
+	for _, e := range f.deny {
+		if e.net.Contains(ip) {
+			return false, fmt.Sprintf("%s is denied by %s", ip, e.text)
+		}
+	}
+	if len(f.allow) == 0 {
+		return true, ""
+	}
+	for _, e := range f.allow {
+		if e.net.Contains(ip) {
+			return true, ""
+		}
+	}
+	return false, fmt.Sprintf("%s is not in the allowlist", ip)
+}
+
+// list returns the named list; the caller holds the lock.
+func (f *IPFilter) list(name string) (*[]entry, error) {
+	switch name {
+	case "allow":
+		return &f.allow, nil
+	case "deny":
+		return &f.deny, nil
+	}
+	return nil, fmt.Errorf("unknown list %q (must be 'allow' or 'deny')", name)
+}
+
+func parseEntries(texts []string) ([]entry, error) {
+	nets, err := ParseCIDRs(texts)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]entry, len(nets))
+	for i, n := range nets {
+		entries[i] = entry{text: strings.TrimSpace(texts[i]), net: n}
+	}
+	return entries, nil
+}
+
+func texts(entries []entry) []string {
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		out[i] = e.text
+	}
+	return out
+}
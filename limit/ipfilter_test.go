@@ -0,0 +1,64 @@
+package limit
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIPFilter_DenyWinsOverAllow(t *testing.T) {
+	f, err := NewIPFilter([]string{"10.0.0.0/8"}, []string{"10.6.6.0/24", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"10.1.2.3":    true,  // allowlisted
+		"10.6.6.7":    false, // allowlisted but denied
+		"192.168.1.1": false, // not allowlisted
+		"2001:db8::1": false,
+	}
+	for ip, want := range cases {
+		if got, reason := f.Check(net.ParseIP(ip)); got != want {
+			t.Errorf("%s: got %v (%s), want %v", ip, got, reason, want)
+		}
+	}
+
+	// An empty allowlist admits everyone not denied.
+	open, _ := NewIPFilter(nil, []string{"203.0.113.0/24"})
+	if ok, _ := open.Check(net.ParseIP("198.51.100.1")); !ok {
+		t.Error("expected an address outside the denylist to pass")
+	}
+}
+
+func TestIPFilter_RuntimeChanges(t *testing.T) {
+	f, _ := NewIPFilter(nil, nil)
+	ip := net.ParseIP("203.0.113.9")
+
+	if added, err := f.Add("deny", "203.0.113.0/24"); !added || err != nil {
+		t.Fatalf("Add: %v %v", added, err)
+	}
+	if added, _ := f.Add("deny", "203.0.113.0/24"); added {
+		t.Error("a duplicate entry must not be added twice")
+	}
+	if ok, _ := f.Check(ip); ok {
+		t.Error("expected the added range to be denied")
+	}
+	if _, err := f.Add("block", "1.2.3.4"); err == nil {
+		t.Error("expected an error for an unknown list")
+	}
+	if _, err := f.Add("deny", "not-an-ip"); err == nil {
+		t.Error("expected an error for an invalid entry")
+	}
+
+	if removed, _ := f.Remove("deny", "203.0.113.0/24"); !removed {
+		t.Error("expected the range to be removed")
+	}
+	if ok, _ := f.Check(ip); !ok {
+		t.Error("expected the address to pass once the range is removed")
+	}
+	if err := f.Set([]string{"10.0.0.0/8"}, []string{"bogus"}); err == nil {
+		t.Error("expected Set to reject an invalid entry")
+	}
+	if allow, deny := f.Lists(); len(allow) != 0 || len(deny) != 0 {
+		t.Errorf("a rejected Set must not change the lists, got %v %v", allow, deny)
+	}
+}
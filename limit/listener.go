@@ -0,0 +1,138 @@
+package limit
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// PerIPListener caps the number of concurrent connections a single client IP
+// may hold open. Connections beyond the cap are closed right after Accept, so
+// one misbehaving client cannot exhaust the proxy's file descriptors.
+// Addresses matching the allowlist are never limited.
+type PerIPListener struct {
+	net.Listener
+	max       int
+	allowlist []*net.IPNet
+
+	mux   sync.Mutex
+	conns map[string]int
+}
+
+// NewPerIPListener wraps ln. allowlist entries may be single IPs or CIDRs.
+func NewPerIPListener(ln net.Listener, max int, allowlist []string) (*PerIPListener, error) {
+	nets, err := ParseCIDRs(allowlist)
+	if err != nil {
+		return nil, err
+	}
+	return &PerIPListener{
+		Listener:  ln,
+		max:       max,
+		allowlist: nets,
+		conns:     make(map[string]int),
+	}, nil
+}
+
+// ParseCIDRs parses a list of IPs or CIDR ranges; bare IPs become /32 or /128.
+func ParseCIDRs(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Accept returns the next connection whose client is under the limit.
+func (l *PerIPListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := remoteIP(c.RemoteAddr())
+		if ip == nil || l.allowed(ip) {
+			return c, nil
+		}
+
+		key := ip.String()
+		l.mux.Lock()
+		if l.conns[key] >= l.max {
+			l.mux.Unlock()
+			log.Printf("Connection limit reached for %s (%d open), rejecting", key, l.max)
+			c.Close()
+			continue
+		}
+		l.conns[key]++
+		l.mux.Unlock()
+
+		return &trackedConn{Conn: c, release: func() { l.release(key) }}, nil
+	}
+}
+
+// OpenConns returns how many limited connections ip currently holds.
+func (l *PerIPListener) OpenConns(ip string) int {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.conns[ip]
+}
+
+func (l *PerIPListener) allowed(ip net.IP) bool {
+	for _, n := range l.allowlist {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *PerIPListener) release(key string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.conns[key]--
+	if l.conns[key] <= 0 {
+		delete(l.conns, key) // keep the map bounded by live clients
+	}
+}
+
+func remoteIP(addr net.Addr) net.IP {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// trackedConn gives its slot back exactly once, however many times Close is called.
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
@@ -0,0 +1,127 @@
+package limit
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T, max int, allowlist []string) *PerIPListener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	l, err := NewPerIPListener(ln, max, allowlist)
+	if err != nil {
+		t.Fatalf("NewPerIPListener: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// acceptAll accepts connections in the background and sends them on a channel.
+func acceptAll(l *PerIPListener) <-chan net.Conn {
+	ch := make(chan net.Conn, 16)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				close(ch)
+				return
+			}
+			ch <- c
+		}
+	}()
+	return ch
+}
+
+func dial(t *testing.T, l net.Listener) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// isClosedByPeer reports whether the server side closed the connection.
+func isClosedByPeer(c net.Conn) bool {
+	c.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, err := c.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false
+	}
+	return err != nil
+}
+
+func TestPerIPListener_RejectsBeyondLimit(t *testing.T) {
+	l := listen(t, 2, nil)
+	accepted := acceptAll(l)
+
+	dial(t, l)
+	dial(t, l)
+	<-accepted
+	<-accepted
+
+	third := dial(t, l)
+	if !isClosedByPeer(third) {
+		t.Error("expected third connection from the same IP to be closed")
+	}
+	if n := l.OpenConns("127.0.0.1"); n != 2 {
+		t.Errorf("expected 2 tracked connections, got %d", n)
+	}
+}
+
+func TestPerIPListener_ReleasesSlotOnClose(t *testing.T) {
+	l := listen(t, 1, nil)
+	accepted := acceptAll(l)
+
+	dial(t, l)
+	serverSide := <-accepted
+	serverSide.Close()
+	serverSide.Close() // double close must not release twice
+
+	if n := l.OpenConns("127.0.0.1"); n != 0 {
+		t.Fatalf("expected slot to be released, got %d open", n)
+	}
+
+	dial(t, l)
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("expected a new connection to be accepted after release")
+	}
+}
+
+func TestPerIPListener_AllowlistBypassesLimit(t *testing.T) {
+	l := listen(t, 1, []string{"127.0.0.0/8"})
+	accepted := acceptAll(l)
+
+	for i := 0; i < 3; i++ {
+		dial(t, l)
+		select {
+		case <-accepted:
+		case <-time.After(time.Second):
+			t.Fatalf("allowlisted connection %d was not accepted", i)
+		}
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.10", "::1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(nets) != 3 {
+		t.Fatalf("expected 3 networks, got %d", len(nets))
+	}
+	if !nets[1].Contains(net.ParseIP("192.168.1.10")) || nets[1].Contains(net.ParseIP("192.168.1.11")) {
+		t.Error("bare IP must be parsed as a single-host network")
+	}
+
+	if _, err := ParseCIDRs([]string{"not-an-ip"}); err == nil {
+		t.Error("expected an error for an invalid entry")
+	}
+}
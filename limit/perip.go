@@ -0,0 +1,77 @@
+package limit
+
+import (
+	"net"
+	"sync"
+)
+
+// PerIPLimiter caps the number of requests a single client IP may have in
+// flight at once, whatever their rate, so one client cannot hold every
+// connection slot to the backends. Addresses matching the allowlist are never
+// limited.
+type PerIPLimiter struct {
+	max       int
+	allowlist []*net.IPNet
+
+	mux      sync.Mutex
+	inFlight map[string]int
+}
+
+// NewPerIPLimiter allows max requests in flight per IP. allowlist entries may
+// be single IPs or CIDRs.
+func NewPerIPLimiter(max int, allowlist []string) (*PerIPLimiter, error) {
+	nets, err := ParseCIDRs(allowlist)
+	if err != nil {
+		return nil, err
+	}
+	return &PerIPLimiter{
+		max:       max,
+		allowlist: nets,
+		inFlight:  make(map[string]int),
+	}, nil
+}
+
+// Acquire takes a slot for ip and returns the function giving it back, or
+// false when ip is at the limit. A nil ip (unparsable address) is not
+// limited.
+func (l *PerIPLimiter) Acquire(ip net.IP) (release func(), ok bool) {
+	if ip == nil || l.allowed(ip) {
+		return func() {}, true
+	}
+
+	key := ip.String()
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.inFlight[key] >= l.max {
+		return nil, false
+	}
+	l.inFlight[key]++
+
+	var once sync.Once
+	return func() { once.Do(func() { l.release(key) }) }, true
+}
+
+// InFlight returns how many limited requests ip currently has in flight.
+func (l *PerIPLimiter) InFlight(ip string) int {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.inFlight[ip]
+}
+
+func (l *PerIPLimiter) allowed(ip net.IP) bool {
+	for _, n := range l.allowlist {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *PerIPLimiter) release(key string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.inFlight[key]--
+	if l.inFlight[key] <= 0 {
+		delete(l.inFlight, key) // keep the map bounded by active clients
+	}
+}
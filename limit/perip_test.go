@@ -0,0 +1,44 @@
+package limit
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPerIPLimiter_CapsEachClient(t *testing.T) {
+	l, err := NewPerIPLimiter(2, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+
+	release1, ok1 := l.Acquire(a)
+	_, ok2 := l.Acquire(a)
+	if !ok1 || !ok2 {
+		t.Fatal("requests under the limit must be admitted")
+	}
+	if _, ok := l.Acquire(a); ok {
+		t.Error("third request of the same client must be rejected")
+	}
+	if _, ok := l.Acquire(b); !ok {
+		t.Error("another client must not be affected")
+	}
+
+	release1()
+	release1() // idempotent
+	if n := l.InFlight("192.0.2.1"); n != 1 {
+		t.Errorf("expected 1 request in flight after release, got %d", n)
+	}
+	if _, ok := l.Acquire(a); !ok {
+		t.Error("a released slot must be reusable")
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, ok := l.Acquire(net.ParseIP("10.1.2.3")); !ok {
+			t.Fatal("allowlisted clients must never be limited")
+		}
+	}
+	if _, err := NewPerIPLimiter(1, []string{"not-an-ip"}); err == nil {
+		t.Error("expected an error for an invalid allowlist entry")
+	}
+}
@@ -0,0 +1,142 @@
+package limit
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// budgetBuckets is the resolution of a RetryBudget's sliding window.
+const budgetBuckets = 10
+
+// RetryBudget caps the retries to a share of the requests over a sliding
+// window, so failovers cannot multiply the load on backends that are
+// already struggling. A few retries per window are always allowed, for low
+// traffic where a share of almost nothing would forbid any.
+type RetryBudget struct {
+	ratio      float64
+	minRetries int64
+	window     time.Duration
+	perBackend bool
+	denied     atomic.Int64
+
+	mux       sync.Mutex
+	windows   map[string]*budgetWindow // by backend URL; "" is the global one
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// NewRetryBudget allows retries up to ratio (0.2 = 20%) of the requests
+// seen over window, and at least minRetries per window. With perBackend,
+// the retries after failures of a backend are also capped by the requests
+// sent to that backend.
+func NewRetryBudget(ratio float64, minRetries int, window time.Duration, perBackend bool) *RetryBudget {
+	return &RetryBudget{
+		ratio:      ratio,
+		minRetries: int64(minRetries),
+		window:     window,
+		perBackend: perBackend,
+		windows:    map[string]*budgetWindow{"": {}},
+		now:        time.Now,
+	}
+}
+
+// Sent counts a request sent to backend: the first attempt of a client
+// request, or a retry when retry is set.
+func (b *RetryBudget) Sent(backend string, retry bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	now := b.now()
+	if !retry {
+		b.windows[""].bucket(now, b.window).requests++
+	}
+	if b.perBackend {
+		b.backendWindow(backend).bucket(now, b.window).requests++
+		b.prune(now)
+	}
+}
+
+// Withdraw takes a retry from the budget after a failure of backend. It
+// reports false, and counts a denial, when the budget is exhausted.
+func (b *RetryBudget) Withdraw(backend string) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	now := b.now()
+	windows := []*budgetWindow{b.windows[""]}
+	if b.perBackend {
+		windows = append(windows, b.backendWindow(backend))
+	}
+	for _, w := range windows {
+		requests, retries := w.totals(now, b.window)
+		if retries+1 > max(b.minRetries, int64(b.ratio*float64(requests))) {
+			b.denied.Add(1)
+			return false
+		}
+	}
+	for _, w := range windows {
+		w.bucket(now, b.window).retries++
+	}
+	return true
+}
+
+// Denied returns the number of retries refused so far.
+func (b *RetryBudget) Denied() int64 {
+	return b.denied.Load()
+}
+
+func (b *RetryBudget) backendWindow(backend string) *budgetWindow {
+	w := b.windows[backend]
+	if w == nil {
+		w = &budgetWindow{}
+		b.windows[backend] = w
+	}
+	return w
+}
+
+// prune forgets the backends idle for a whole window, e.g. removed ones.
+func (b *RetryBudget) prune(now time.Time) {
+	if now.Sub(b.lastPrune) < b.window {
+		return
+	}
+	b.lastPrune = now
+	for backend, w := range b.windows {
+		if backend == "" {
+			continue
+		}
+		if requests, retries := w.totals(now, b.window); requests == 0 && retries == 0 {
+			delete(b.windows, backend)
+		}
+	}
+}
+
+// budgetWindow counts requests and retries in buckets of window/10.
+type budgetWindow struct {
+	buckets [budgetBuckets]budgetBucket
+}
+
+type budgetBucket struct {
+	start             time.Time
+	requests, retries int64
+}
+
+// bucket returns the bucket of now, emptied if it held an older period.
+func (w *budgetWindow) bucket(now time.Time, window time.Duration) *budgetBucket {
+	size := window / budgetBuckets
+	start := now.Truncate(size)
+	b := &w.buckets[int(start.UnixNano()/int64(size))%budgetBuckets]
+	if !b.start.Equal(start) {
+		*b = budgetBucket{start: start}
+	}
+	return b
+}
+
+// totals sums the buckets of the last window.
+func (w *budgetWindow) totals(now time.Time, window time.Duration) (requests, retries int64) {
+	for _, b := range w.buckets {
+		if now.Sub(b.start) < window {
+			requests += b.requests
+			retries += b.retries
+		}
+	}
+	return requests, retries
+}
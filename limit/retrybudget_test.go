@@ -0,0 +1,60 @@
+package limit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryBudget_CapsRetriesToAShareOfTheRequests(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	b := NewRetryBudget(0.2, 1, 10*time.Second, false)
+	b.now = func() time.Time { return now }
+
+	for range 10 {
+		b.Sent("http://a", false)
+	}
+	if !b.Withdraw("http://a") || !b.Withdraw("http://a") {
+		t.Fatal("20% of 10 requests allows 2 retries")
+	}
+	if b.Withdraw("http://a") {
+		t.Fatal("a third retry must be denied")
+	}
+	if b.Denied() != 1 {
+		t.Errorf("expected 1 denial, got %d", b.Denied())
+	}
+
+	// Retries sent do not count as requests.
+	b.Sent("http://b", true)
+	if b.Withdraw("http://b") {
+		t.Error("a retry must not earn budget for more retries")
+	}
+
+	// The window slides: 11s later, only the minimum is left.
+	now = now.Add(11 * time.Second)
+	if !b.Withdraw("http://a") {
+		t.Error("min_retries must be allowed without traffic")
+	}
+	if b.Withdraw("http://a") {
+		t.Error("beyond min_retries, an idle window allows no retry")
+	}
+}
+
+func TestRetryBudget_PerBackend(t *testing.T) {
+	b := NewRetryBudget(0.5, 0, 10*time.Second, true)
+	for range 10 {
+		b.Sent("http://a", false)
+	}
+	b.Sent("http://b", false)
+	b.Sent("http://b", false)
+
+	// The global budget allows 6 retries, b's own traffic only 1.
+	if !b.Withdraw("http://b") {
+		t.Fatal("half of b's 2 requests allows a retry")
+	}
+	if b.Withdraw("http://b") {
+		t.Error("b's own budget must be exhausted")
+	}
+	if !b.Withdraw("http://a") {
+		t.Error("a's budget must be unaffected by b's")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"reverse-proxy/pool"
+	"reverse-proxy/proxy"
+)
+
+// runLoadTest exercises the selection and forwarding hot paths in-process:
+// it starts `backends` instant mock backends, builds a pool with the given
+// strategy and drives proxy.Handler directly from `concurrency` goroutines.
+// No sockets are opened on the proxy side, so the numbers reflect proxy
+// overhead only. Build with -race to surface data races under load.
+func runLoadTest(strategy string, backends, requests, concurrency int) error {
+	if backends <= 0 || requests <= 0 || concurrency <= 0 {
+		return fmt.Errorf("backends, requests and concurrency must all be > 0")
+	}
+
+	serverPool, err := pool.NewServerPool(pool.WithStrategy(strategy))
+	if err != nil {
+		return err
+	}
+	hits := make([]int64, backends)
+	for i := 0; i < backends; i++ {
+		counter := &hits[i]
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			atomic.AddInt64(counter, 1)
+			w.Write([]byte("ok"))
+		}))
+		defer srv.Close()
+
+		u, _ := url.Parse(srv.URL)
+		b := &pool.Backend{URL: u}
+		b.SetAlive(true)
+		serverPool.AddBackend(b)
+	}
+
+	handler := proxy.Handler(serverPool, 5*time.Second)
+
+	var (
+		mu        sync.Mutex
+		failures  int
+		latencies = make([]time.Duration, 0, requests)
+	)
+
+	jobs := make(chan struct{}, requests)
+	for i := 0; i < requests; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				rec := httptest.NewRecorder()
+
+				t0 := time.Now()
+				handler(rec, req)
+				elapsed := time.Since(t0)
+
+				mu.Lock()
+				latencies = append(latencies, elapsed)
+				if rec.Code != http.StatusOK {
+					failures++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) time.Duration {
+		idx := int(float64(len(latencies)-1) * p)
+		return latencies[idx]
+	}
+
+	log.Printf("Load test finished: %d requests, %d workers, strategy=%s", requests, concurrency, strategy)
+	log.Printf("  duration:    %v (%.0f req/s)", total, float64(requests)/total.Seconds())
+	log.Printf("  failures:    %d", failures)
+	log.Printf("  latency:     p50=%v p90=%v p99=%v max=%v",
+		percentile(0.50), percentile(0.90), percentile(0.99), latencies[len(latencies)-1])
+	log.Printf("  allocations: %d allocs/req, %d bytes/req",
+		(after.Mallocs-before.Mallocs)/uint64(requests),
+		(after.TotalAlloc-before.TotalAlloc)/uint64(requests))
+	for i, b := range serverPool.GetBackends() {
+		log.Printf("  backend %s: %d requests", b.URL.Host, atomic.LoadInt64(&hits[i]))
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d/%d requests failed", failures, requests)
+	}
+	return nil
+}
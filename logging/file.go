@@ -0,0 +1,145 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rotation tells when a File moves on to a fresh file. The zero value never
+// rotates.
+type Rotation struct {
+	// MaxBytes rotates before a write would grow the file beyond it.
+	MaxBytes int64
+	// Interval rotates at every multiple of it since the zero time (UTC):
+	// 24h rotates at midnight UTC, 1h on the hour.
+	Interval time.Duration
+	// MaxBackups is the number of rotated files kept, the oldest being
+	// removed; 0 keeps them all.
+	MaxBackups int
+}
+
+// backupLayout suffixes the rotated files, e.g. proxy.log.2026-10-16T12-00-00.000:
+// in this format, the name order is the age order.
+const backupLayout = "2006-01-02T15-04-05.000"
+
+// File is an append-only log file, rotated by renaming it with the time of
+// the rotation as a suffix. It is safe for concurrent use.
+type File struct {
+	path     string
+	rotation Rotation
+	mux      sync.Mutex
+	file     *os.File
+	size     int64
+	period   time.Time // Interval period of the current file
+	now      func() time.Time
+}
+
+// OpenFile opens (or creates) the log file at path. A file left by a
+// previous run is rotated first if it belongs to an earlier period.
+func OpenFile(path string, rotation Rotation) (*File, error) {
+	f := &File{path: path, rotation: rotation, now: time.Now}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	f.period = f.periodOf(f.now())
+	if f.size > 0 && f.periodOf(info.ModTime()).Before(f.period) {
+		return f.rotate()
+	}
+	return nil
+}
+
+func (f *File) periodOf(t time.Time) time.Time {
+	if f.rotation.Interval <= 0 {
+		return time.Time{}
+	}
+	return t.UTC().Truncate(f.rotation.Interval)
+}
+
+// Write appends p to the file, rotating it first when p would make it too
+// large or when a new period started.
+func (f *File) Write(p []byte) (int, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	tooLarge := f.rotation.MaxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.rotation.MaxBytes
+	if tooLarge || f.periodOf(f.now()).After(f.period) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file, opens a new one and removes the
+// backups beyond MaxBackups. When the rename fails (the file was moved
+// away, the directory is read-only), logging goes on in the file at path.
+func (f *File) rotate() error {
+	f.file.Close()
+	now := f.now()
+	os.Rename(f.path, f.path+"."+now.UTC().Format(backupLayout))
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		f.file = nil
+		return err
+	}
+	f.file, f.size, f.period = file, 0, f.periodOf(now)
+	if info, err := file.Stat(); err == nil {
+		f.size = info.Size()
+	}
+	if f.rotation.MaxBackups > 0 {
+		backups := f.backups()
+		for len(backups) > f.rotation.MaxBackups {
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	return nil
+}
+
+// backups returns the rotated files, oldest first.
+func (f *File) backups() []string {
+	matches, _ := filepath.Glob(f.path + ".*")
+	var backups []string
+	for _, m := range matches {
+		suffix := strings.TrimPrefix(m, f.path+".")
+		if _, err := time.Parse(backupLayout, suffix); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	slices.Sort(backups)
+	return backups
+}
+
+// Close closes the file; later writes fail.
+func (f *File) Close() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
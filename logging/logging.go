@@ -0,0 +1,30 @@
+// Package logging writes the proxy's logs to rotated files and to syslog,
+// so neither logrotate nor a log shipper is needed next to the proxy.
+package logging
+
+import (
+	"errors"
+	"io"
+)
+
+// Tee writes to every writer, even when one fails, and closes them all.
+// Unlike io.MultiWriter, a syslog daemon going down does not stop the file.
+type Tee []io.WriteCloser
+
+func (t Tee) Write(p []byte) (int, error) {
+	var errs []error
+	for _, w := range t {
+		if _, err := w.Write(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return len(p), errors.Join(errs...)
+}
+
+func (t Tee) Close() error {
+	var errs []error
+	for _, w := range t {
+		errs = append(errs, w.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,152 @@
+package logging
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFile_RotatesBySizeAndKeepsBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	f, err := OpenFile(path, Rotation{MaxBytes: 10, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { now = now.Add(time.Second); return now }
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, _ := os.ReadFile(path); string(data) != "fourth\n" {
+		t.Errorf("current file = %q, want the last line only", data)
+	}
+	backups := f.backups()
+	if len(backups) != 2 {
+		t.Fatalf("got backups %v, want the 2 most recent", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != "second\n" {
+		t.Errorf("oldest backup kept = %q, want the second line", data)
+	}
+}
+
+func TestFile_RotatesByInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	if err := os.WriteFile(path, []byte("yesterday\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-24 * time.Hour)
+	os.Chtimes(path, old, old)
+
+	// A file left from an earlier day is rotated on open.
+	f, err := OpenFile(path, Rotation{Interval: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if len(f.backups()) != 1 {
+		t.Fatalf("expected the old file to be rotated on open, got %v", f.backups())
+	}
+
+	f.Write([]byte("today\n"))
+	now := time.Now().Add(24 * time.Hour)
+	f.now = func() time.Time { return now }
+	f.Write([]byte("tomorrow\n"))
+	if data, _ := os.ReadFile(path); string(data) != "tomorrow\n" {
+		t.Errorf("current file = %q, want only tomorrow's line", data)
+	}
+	if len(f.backups()) != 2 {
+		t.Errorf("expected 2 backups, got %v", f.backups())
+	}
+}
+
+var rfc5424 = regexp.MustCompile(`(?s)^<(\d+)>1 \S+ \S+ (\S+) \d+ (\S+) - (.*)$`)
+
+func TestSyslog_UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s, err := DialSyslog(SyslogConfig{Address: "udp://" + conn.LocalAddr().String(), Facility: "local3", MsgID: "decision"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Write([]byte("backend down\n"))
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := rfc5424.FindStringSubmatch(string(buf[:n]))
+	if m == nil {
+		t.Fatalf("not an RFC 5424 message: %q", buf[:n])
+	}
+	// local3 (19) * 8 + info (6)
+	if m[1] != "158" || m[2] != "reverse-proxy" || m[3] != "decision" || m[4] != "backend down" {
+		t.Errorf("unexpected message %q", buf[:n])
+	}
+}
+
+func TestSyslog_TCPOctetCounting(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for range 2 {
+			size, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(size))
+			if err != nil {
+				return
+			}
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				return
+			}
+			received <- string(msg)
+		}
+	}()
+
+	s, err := DialSyslog(SyslogConfig{Address: "tcp://" + ln.Addr().String(), AppName: "edge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Write([]byte("one\n"))
+	s.Write([]byte("two with\nnewline\n"))
+	for _, want := range []string{"one", "two with\nnewline"} {
+		select {
+		case msg := <-received:
+			if m := rfc5424.FindStringSubmatch(msg); m == nil || m[2] != "edge" || m[3] != "-" || m[4] != want {
+				t.Errorf("got %q, want the message %q from edge", msg, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+}
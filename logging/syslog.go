@@ -0,0 +1,170 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Facilities are the syslog facilities accepted by SyslogConfig.
+var Facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "authpriv": 10,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// severityInfo is the severity of every message: the proxy's logs carry no
+// level.
+const severityInfo = 6
+
+// localSockets are where the local syslog daemon listens, by platform.
+var localSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogConfig describes a syslog destination.
+type SyslogConfig struct {
+	// Address is empty for the local daemon, or udp://host:port,
+	// tcp://host:port or unix:///path.
+	Address  string
+	Facility string // one of Facilities; defaults to "local0"
+	AppName  string // APP-NAME of the messages; defaults to "reverse-proxy"
+	MsgID    string // MSGID of the messages, e.g. "decision"; empty sends "-"
+}
+
+// Syslog sends each Write as one RFC 5424 message. Over TCP, the messages
+// are framed by octet counting (RFC 6587). A broken connection is dialed
+// again on the next write. It is safe for concurrent use.
+type Syslog struct {
+	network, address string
+	priority         int
+	hostname         string
+	appName          string
+	msgID            string
+	mux              sync.Mutex
+	conn             net.Conn
+	closed           bool
+}
+
+// ParseSyslogAddress returns the network and address of a
+// SyslogConfig.Address; the network is empty for the local daemon.
+func ParseSyslogAddress(address string) (network, addr string, err error) {
+	if address == "" {
+		return "", "", nil
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", err
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return "", "", err
+		}
+		return u.Scheme, u.Host, nil
+	case "unix":
+		return "unixgram", u.Path, nil
+	}
+	return "", "", fmt.Errorf("invalid syslog address %q: expected udp://, tcp:// or unix://", address)
+}
+
+// DialSyslog connects to the syslog destination.
+func DialSyslog(cfg SyslogConfig) (*Syslog, error) {
+	network, address, err := ParseSyslogAddress(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Facility == "" {
+		cfg.Facility = "local0"
+	}
+	facility, ok := Facilities[cfg.Facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", cfg.Facility)
+	}
+	if cfg.AppName == "" {
+		cfg.AppName = "reverse-proxy"
+	}
+	if cfg.MsgID == "" {
+		cfg.MsgID = "-"
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	s := &Syslog{
+		network:  network,
+		address:  address,
+		priority: facility*8 + severityInfo,
+		hostname: hostname,
+		appName:  cfg.AppName,
+		msgID:    cfg.MsgID,
+	}
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Syslog) dial() error {
+	if s.network != "" {
+		conn, err := net.DialTimeout(s.network, s.address, 5*time.Second)
+		s.conn = conn
+		return err
+	}
+	var errs []error
+	for _, path := range localSockets {
+		conn, err := net.Dial("unixgram", path)
+		if err == nil {
+			s.conn = conn
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("no local syslog daemon: %w", errors.Join(errs...))
+}
+
+// Write sends p, without its trailing newline, as one message.
+func (s *Syslog) Write(p []byte) (int, error) {
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s", s.priority,
+		time.Now().UTC().Format(time.RFC3339Nano), s.hostname, s.appName, os.Getpid(), s.msgID,
+		strings.TrimSuffix(string(p), "\n"))
+	if s.network == "tcp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.closed {
+		return 0, net.ErrClosed
+	}
+	if s.conn != nil {
+		if _, err := s.conn.Write([]byte(msg)); err == nil {
+			return len(p), nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.dial(); err != nil {
+		return 0, err
+	}
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the connection; later writes fail.
+func (s *Syslog) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -2,141 +2,108 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
-	"fmt"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
-	"reverse-proxy/admin"
-	"reverse-proxy/health"
-	"reverse-proxy/pool"
-	"reverse-proxy/proxy"
+	"reverse-proxy/reverseproxy"
 	"syscall"
 	"time"
 )
 
-type Config struct {
-	Port                 int      `json:"port"`
-	AdminPort            int      `json:"admin_port"`
-	Strategy             string   `json:"strategy"`
-	HealthCheckFrequency int      `json:"health_check_frequency"`
-	ProxyTimeout         int      `json:"proxy_timeout"` // seconds; defaults to 30 if omitted
-	Backends             []string `json:"backends"`
-}
-
-func loadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
-	var cfg Config
-	err = json.Unmarshal(data, &cfg)
-	if err != nil {
-		return nil, err
-	}
-
-	// Apply sensible defaults
-	if cfg.ProxyTimeout <= 0 {
-		cfg.ProxyTimeout = 30
-	}
-	if cfg.HealthCheckFrequency <= 0 {
-		cfg.HealthCheckFrequency = 10
-	}
-
-	return &cfg, nil
-}
-
 func main() {
 	// FIX: parse --config flag instead of hardcoding the path.
 	configPath := flag.String("config", "config/config.json", "path to config JSON file")
+	loadTest := flag.Bool("loadtest", false, "run an in-process load test of the selection/forwarding hot paths and exit")
+	loadTestBackends := flag.Int("loadtest-backends", 3, "number of mock backends used by --loadtest")
+	loadTestRequests := flag.Int("loadtest-requests", 10000, "total requests sent by --loadtest")
+	loadTestConcurrency := flag.Int("loadtest-concurrency", 50, "concurrent workers used by --loadtest")
+	selfTest := flag.Bool("self-test", false, "boot the proxy against an ephemeral mock backend, run a smoke test and exit")
+	replayFile := flag.String("replay", "", "replay the requests of a capture file (see capture in the config) and exit")
+	replayTargets := flag.String("replay-target", "http://localhost:8080", "comma-separated base URLs the --replay requests are sent to in turn")
+	replayRate := flag.Float64("replay-rate", 0, "requests per second sent by --replay; 0 keeps the recorded pacing")
+	replayConcurrency := flag.Int("replay-concurrency", 10, "requests in flight at most during --replay")
 	flag.Parse()
 
-	cfg, err := loadConfig(*configPath)
-	if err != nil {
-		log.Fatal("Failed to load config:", err)
+	if *replayFile != "" {
+		// Needs no config: the target may be any proxy or backend.
+		if err := runReplay(*replayFile, *replayTargets, *replayRate, *replayConcurrency); err != nil {
+			log.Fatal("Replay failed: ", err)
+		}
+		return
 	}
 
-	// Validate the strategy
-	if cfg.Strategy != "round-robin" && cfg.Strategy != "least-connections" {
-		log.Fatalf("Invalid strategy: %s (must be 'round-robin' or 'least-connections')", cfg.Strategy)
+	cfg, err := reverseproxy.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
 	}
 
-	serverPool := &pool.ServerPool{Strategy: cfg.Strategy}
-
-	log.Println("Validating backends...")
-	validBackendCount := 0
-
-	for _, b := range cfg.Backends {
-		u, err := url.Parse(b)
-		if err != nil || u.Host == "" {
-			log.Printf("Invalid backend URL: %s, skipping", b)
-			continue
+	if *loadTest {
+		if err := runLoadTest(cfg.Strategy, *loadTestBackends, *loadTestRequests, *loadTestConcurrency); err != nil {
+			log.Fatal("Load test failed: ", err)
 		}
+		return
+	}
 
-		isAlive := health.CheckBackend(u.String())
-
-		backend := &pool.Backend{
-			URL: u,
-		}
-		backend.SetAlive(isAlive)
-		serverPool.AddBackend(backend)
-
-		if isAlive {
-			validBackendCount++
-			log.Printf("✓ Backend %s is healthy", u.String())
-		} else {
-			log.Printf("✗ Backend %s is unreachable", u.String())
+	if *selfTest {
+		if !runSelfTest(cfg) {
+			os.Exit(1)
 		}
+		return
 	}
 
-	if validBackendCount == 0 {
-		log.Println("WARNING: No healthy backends found! Proxy will return 503 until backends become available.")
-	} else {
-		log.Printf("%d/%d backends are healthy\n", validBackendCount, len(cfg.Backends))
+	logs, err := cfg.ApplicationLog()
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	// Start background health checker
-	health.Start(serverPool, time.Duration(cfg.HealthCheckFrequency)*time.Second)
-
-	// Start admin API (runs in its own goroutine internally)
-	admin.Start(serverPool, cfg.AdminPort)
-
-	// Build the main proxy server
-	proxyTimeout := time.Duration(cfg.ProxyTimeout) * time.Second
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", proxy.Handler(serverPool, proxyTimeout))
-
-	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.Port),
-		Handler: mux,
+	if logs != nil {
+		log.SetOutput(logs)
+		defer logs.Close()
 	}
 
-	// Start proxy in background goroutine so we can listen for shutdown signals
-	go func() {
-		log.Printf("Reverse Proxy running on :%d (strategy: %s, proxy timeout: %ds)\n",
-			cfg.Port, cfg.Strategy, cfg.ProxyTimeout)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Proxy server error: %v", err)
-		}
-	}()
+	server, err := reverseproxy.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		log.Fatal(err)
+	}
 
-	// Graceful shutdown
+	// Graceful shutdown, or binary upgrade: a new process takes over the
+	// listeners and this one drains as for a shutdown.
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-
-	log.Println("Shutdown signal received — draining in-flight requests (up to 10s)...")
+	upgrade := make(chan os.Signal, 1)
+	if len(upgradeSignals) > 0 { // none would mean every signal
+		signal.Notify(upgrade, upgradeSignals...)
+	}
+wait:
+	for {
+		select {
+		case <-quit:
+			break wait
+		case <-upgrade:
+			log.Println("Upgrade signal received, starting the new binary")
+			if err := server.Upgrade(); err != nil {
+				log.Printf("Upgrade failed, still serving: %v", err)
+				continue
+			}
+			log.Println("New process is serving, draining this one")
+			break wait
+		case err := <-server.Err():
+			log.Fatal(err)
+		}
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// The drain gets its whole timeout after the shutdown delay.
+	timeout := time.Duration(cfg.Readiness.ShutdownDelay+cfg.ShutdownTimeout) * time.Second
+	log.Printf("Shutdown signal received (timeout %v)", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	if err := server.Shutdown(ctx); err != nil {
+	if _, err := server.Stop(ctx); err != nil {
 		log.Fatalf("Forced shutdown due to timeout: %v", err)
 	}
 
 	log.Println("Server stopped cleanly.")
-}
\ No newline at end of file
+}
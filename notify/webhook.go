@@ -0,0 +1,237 @@
+// Package notify pushes backend state changes to webhooks (Slack,
+// PagerDuty, an in-house alerting service), so alerts don't depend on
+// scraping the logs.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+
+	"reverse-proxy/pool"
+)
+
+// Config describes a webhook.
+type Config struct {
+	// URL is a text/template receiving the pool.Event, e.g.
+	// https://alerts.example.com/backend/{{query .URL}}/{{.Type}}.
+	URL string
+	// Body is a text/template for the JSON body; empty sends the event
+	// itself. {{json .X}} quotes a value, e.g. for Slack:
+	// {"text": {{json (printf "%s is %s" .URL .Type)}}}.
+	Body string
+	// Events filters the event types sent; empty sends them all.
+	Events []pool.EventType
+	// Retries is the number of extra attempts after a failed delivery,
+	// waiting 1s, 2s, 4s... in between.
+	Retries int
+	// Timeout bounds each attempt; defaults to 5s.
+	Timeout time.Duration
+}
+
+// queueSize is the number of events a webhook may lag behind. Beyond that,
+// events are dropped (and logged) rather than held in memory.
+const queueSize = 64
+
+// Webhook delivers events to one URL, in order, one at a time.
+type Webhook struct {
+	host    string // for the logs: the full URL may carry a token
+	url     *template.Template
+	body    *template.Template // nil sends the event as JSON
+	events  map[pool.EventType]bool
+	retries int
+	timeout time.Duration
+	backoff time.Duration // first wait between attempts, doubled each time
+	queue   chan pool.Event
+}
+
+var funcs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"query": url.QueryEscape,
+}
+
+// NewWebhook validates cfg: templates that do not parse, or do not render a
+// URL or valid JSON for a sample event, are rejected up front.
+func NewWebhook(cfg Config) (*Webhook, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("webhook url is required")
+	}
+	if cfg.Retries < 0 {
+		return nil, fmt.Errorf("webhook retries must not be negative (got %d)", cfg.Retries)
+	}
+	w := &Webhook{
+		retries: cfg.Retries,
+		timeout: cfg.Timeout,
+		backoff: time.Second,
+		queue:   make(chan pool.Event, queueSize),
+	}
+	if w.timeout <= 0 {
+		w.timeout = 5 * time.Second
+	}
+	var err error
+	if w.url, err = template.New("url").Funcs(funcs).Parse(cfg.URL); err != nil {
+		return nil, fmt.Errorf("invalid webhook url template: %w", err)
+	}
+	if cfg.Body != "" {
+		if w.body, err = template.New("body").Funcs(funcs).Parse(cfg.Body); err != nil {
+			return nil, fmt.Errorf("invalid webhook body template: %w", err)
+		}
+	}
+	if len(cfg.Events) > 0 {
+		w.events = make(map[pool.EventType]bool, len(cfg.Events))
+		for _, t := range cfg.Events {
+			if !knownEvent(t) {
+				return nil, fmt.Errorf("unknown webhook event type: %s", t)
+			}
+			w.events[t] = true
+		}
+	}
+
+	sample := pool.Event{Type: pool.EventDown, ID: "0123456789ab", URL: "http://backend:8080", Time: time.Now(), Detail: "sample"}
+	target, _, err := w.render(sample)
+	if err != nil {
+		return nil, err
+	}
+	parsed, _ := url.Parse(target) // checked by render
+	w.host = parsed.Host
+	return w, nil
+}
+
+func knownEvent(t pool.EventType) bool {
+	switch t {
+	case pool.EventAdded, pool.EventRemoved, pool.EventUp, pool.EventDown, pool.EventDisabled,
+		pool.EventEnabled, pool.EventEjected, pool.EventRestored, pool.EventWeighted:
+		return true
+	}
+	return false
+}
+
+// render builds the request URL and body for e.
+func (w *Webhook) render(e pool.Event) (string, []byte, error) {
+	var u bytes.Buffer
+	if err := w.url.Execute(&u, e); err != nil {
+		return "", nil, fmt.Errorf("webhook url template: %w", err)
+	}
+	if parsed, err := url.Parse(u.String()); err != nil || parsed.Host == "" {
+		return "", nil, fmt.Errorf("webhook url template does not produce a URL: %s", u.String())
+	}
+
+	if w.body == nil {
+		body, err := json.Marshal(e)
+		return u.String(), body, err
+	}
+	var body bytes.Buffer
+	if err := w.body.Execute(&body, e); err != nil {
+		return "", nil, fmt.Errorf("webhook body template: %w", err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return "", nil, fmt.Errorf("webhook body template does not produce valid JSON: %s", body.String())
+	}
+	return u.String(), body.Bytes(), nil
+}
+
+// Start delivers the events of every pool, outlier ejections included, to
+// the webhooks until ctx is cancelled. Deliveries still pending then are
+// dropped.
+func Start(ctx context.Context, hooks ...*Webhook) {
+	if len(hooks) == 0 {
+		return
+	}
+	events, cancel := pool.SubscribeAll(queueSize)
+	for _, w := range hooks {
+		go w.run(ctx)
+	}
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-events:
+				for _, w := range hooks {
+					w.enqueue(e)
+				}
+			}
+		}
+	}()
+}
+
+func (w *Webhook) enqueue(e pool.Event) {
+	if w.events != nil && !w.events[e.Type] {
+		return
+	}
+	select {
+	case w.queue <- e:
+	default:
+		log.Printf("Webhook to %s is lagging behind: dropping %s event of %s", w.host, e.Type, e.URL)
+	}
+}
+
+func (w *Webhook) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-w.queue:
+			if err := w.deliver(ctx, e); err != nil && ctx.Err() == nil {
+				log.Printf("Webhook to %s failed for %s event of %s: %v", w.host, e.Type, e.URL, err)
+			}
+		}
+	}
+}
+
+// deliver posts e, retrying with an exponential backoff.
+func (w *Webhook) deliver(ctx context.Context, e pool.Event) error {
+	target, body, err := w.render(e)
+	if err != nil {
+		return err
+	}
+	wait := w.backoff
+	for attempt := 0; ; attempt++ {
+		if err = w.post(ctx, target, body); err == nil || attempt == w.retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (w *Webhook) post(ctx context.Context, target string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err // without the URL, which may carry a token
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"reverse-proxy/pool"
+)
+
+type received struct {
+	path string
+	body string
+}
+
+// newReceiver records the webhook calls; the first failures calls answer 500.
+func newReceiver(t *testing.T, failures int32) (*httptest.Server, <-chan received) {
+	t.Helper()
+	calls := make(chan received, 16)
+	var n atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.Add(1) <= failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		calls <- received{path: r.URL.EscapedPath(), body: string(body)}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, calls
+}
+
+func next(t *testing.T, calls <-chan received) received {
+	t.Helper()
+	select {
+	case c := <-calls:
+		return c
+	case <-time.After(2 * time.Second):
+		t.Fatal("no webhook call")
+		return received{}
+	}
+}
+
+func TestNewWebhook_RejectsInvalidConfig(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"no url":           {},
+		"bad url template": {URL: "http://x/{{.Type"},
+		"not a url":        {URL: "{{.Type}}"},
+		"body not json":    {URL: "http://x", Body: `text={{.URL}}`},
+		"unknown event":    {URL: "http://x", Events: []pool.EventType{"exploded"}},
+		"negative retries": {URL: "http://x", Retries: -1},
+	} {
+		if _, err := NewWebhook(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// Pool events reach the webhook rendered through the templates, filtered by
+// type.
+func TestStart_DeliversFilteredEvents(t *testing.T) {
+	srv, calls := newReceiver(t, 0)
+	hook, err := NewWebhook(Config{
+		URL:    srv.URL + "/alert/{{.Type}}/{{query .URL}}",
+		Body:   `{"text": {{json (printf "%s is %s" .URL .Type)}}}`,
+		Events: []pool.EventType{pool.EventDown},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	Start(ctx, hook)
+
+	sp := &pool.ServerPool{Strategy: "round-robin"}
+	u, _ := url.Parse("http://web-1:8080")
+	sp.AddBackend(&pool.Backend{URL: u}) // "added": filtered out
+	sp.SetBackendStatus(u, true)         // "up": filtered out
+	sp.SetBackendStatus(u, false)
+
+	c := next(t, calls)
+	if c.path != "/alert/down/http%3A%2F%2Fweb-1%3A8080" {
+		t.Errorf("unexpected path %q", c.path)
+	}
+	var body struct{ Text string }
+	if err := json.Unmarshal([]byte(c.body), &body); err != nil || body.Text != "http://web-1:8080 is down" {
+		t.Errorf("unexpected body %q", c.body)
+	}
+	select {
+	case c := <-calls:
+		t.Errorf("only the down event should be sent, got %+v", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// A failed delivery is retried; without a body template the event itself is
+// posted.
+func TestDeliver_RetriesWithBackoff(t *testing.T) {
+	srv, calls := newReceiver(t, 2)
+	hook, err := NewWebhook(Config{URL: srv.URL, Retries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook.backoff = time.Millisecond
+
+	e := pool.Event{Type: pool.EventEjected, ID: "abc", URL: "http://web-2:8080", Detail: "error rate 40%"}
+	if err := hook.deliver(context.Background(), e); err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+	var got pool.Event
+	if c := next(t, calls); json.Unmarshal([]byte(c.body), &got) != nil || got.Type != pool.EventEjected || got.Detail != e.Detail {
+		t.Errorf("unexpected body %q", c.body)
+	}
+
+	srv, _ = newReceiver(t, 1)
+	hook, _ = NewWebhook(Config{URL: srv.URL})
+	if err := hook.deliver(context.Background(), e); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("without retries, expected the failure, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package pool
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Tuning for the bandit strategy. Every banditInterval the traffic weights
+// move banditStep of the way towards the current best allocation, and no
+// backend ever drops below banditFloor of an even share so it keeps being
+// explored (a recovering backend can win traffic back).
+const (
+	banditInterval = time.Second
+	banditStep     = 0.1
+	banditFloor    = 0.2
+)
+
+// bandit is an EXPERIMENTAL strategy that treats backends as arms of a
+// multi-armed bandit. Each backend is scored from its latency EWMA and error
+// rate; traffic fractions are shifted gradually towards the better scoring
+// backends, converging on the weighting that minimises observed latency and
+// errors. Good for heterogeneous or slowly degrading fleets.
+type bandit struct {
+	mux        sync.Mutex
+	weights    map[*Backend]float64
+	lastUpdate time.Time
+	interval   time.Duration // banditInterval; overridable in tests
+}
+
+func newBandit() *bandit {
+	return &bandit{weights: make(map[*Backend]float64), interval: banditInterval}
+}
+
+func (bd *bandit) Next(backends []*Backend) *Backend {
+	bd.mux.Lock()
+	defer bd.mux.Unlock()
+
+	available := make([]*Backend, 0, len(backends))
+	for _, b := range backends {
+		if b.IsAvailable() {
+			available = append(available, b)
+		}
+	}
+	if len(available) == 0 {
+		return nil
+	}
+
+	membershipChanged := len(available) != len(bd.weights)
+	for _, b := range available {
+		if _, ok := bd.weights[b]; !ok {
+			membershipChanged = true
+		}
+	}
+	if membershipChanged || time.Since(bd.lastUpdate) >= bd.interval {
+		bd.rebalance(available)
+	}
+
+	// Weighted random choice over the current allocation.
+	x := rand.Float64()
+	for _, b := range available {
+		x -= bd.weights[b]
+		if x < 0 {
+			return b
+		}
+	}
+	return available[len(available)-1]
+}
+
+// rebalance recomputes the traffic weights for the available backends.
+func (bd *bandit) rebalance(available []*Backend) {
+	n := float64(len(available))
+
+	// Score = throughput-like reward: fast and error-free is best. Backends
+	// without a latency sample get the average score (optimistic exploration).
+	scores := make([]float64, len(available))
+	var known, knownSum float64
+	for i, b := range available {
+		latency := b.LatencyEWMA()
+		if latency <= 0 {
+			scores[i] = -1
+			continue
+		}
+		success := 1 - b.ErrorRate()
+		scores[i] = success * success / latency.Seconds()
+		known++
+		knownSum += scores[i]
+	}
+	mean := 1.0
+	if known > 0 {
+		mean = knownSum / known
+	}
+	var total float64
+	for i := range scores {
+		if scores[i] < 0 {
+			scores[i] = mean
+		}
+		total += scores[i]
+	}
+
+	next := make(map[*Backend]float64, len(available))
+	var sum float64
+	for i, b := range available {
+		target := 1 / n
+		if total > 0 {
+			target = scores[i] / total
+		}
+		w, ok := bd.weights[b]
+		if !ok {
+			w = 1 / n
+		}
+		w += banditStep * (target - w)
+		if minShare := banditFloor / n; w < minShare {
+			w = minShare
+		}
+		next[b] = w
+		sum += w
+	}
+	for b := range next {
+		next[b] /= sum
+	}
+
+	// Removed or unavailable backends are dropped; they restart from an
+	// even share if they come back.
+	bd.weights = next
+	bd.lastUpdate = time.Now()
+}
@@ -0,0 +1,82 @@
+package pool
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Benchmarks for the selection hot path. Run with:
+//   go test -bench=. -benchmem ./pool/...
+// Add -race to surface lock contention issues under parallel load.
+
+func benchPool(strategy string, n int) *ServerPool {
+	p := &ServerPool{Strategy: strategy}
+	for i := 0; i < n; i++ {
+		p.AddBackend(newBackend(fmt.Sprintf("http://backend-%d:8080", i), true))
+	}
+	return p
+}
+
+func BenchmarkGetNextValidPeer_RoundRobin(b *testing.B) {
+	p := benchPool("round-robin", 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.GetNextValidPeer()
+	}
+}
+
+func BenchmarkGetNextValidPeer_LeastConnections(b *testing.B) {
+	p := benchPool("least-connections", 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.GetNextValidPeer()
+	}
+}
+
+func BenchmarkGetNextValidPeer_RoundRobin_Parallel(b *testing.B) {
+	p := benchPool("round-robin", 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			p.GetNextValidPeer()
+		}
+	})
+}
+
+func BenchmarkGetNextValidPeer_LeastConnections_Parallel(b *testing.B) {
+	p := benchPool("least-connections", 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			p.GetNextValidPeer()
+		}
+	})
+}
+
+// Half the backends dead: round-robin has to skip over them on every call.
+func BenchmarkGetNextValidPeer_RoundRobin_HalfDead(b *testing.B) {
+	p := benchPool("round-robin", 8)
+	for i, backend := range p.GetBackends() {
+		backend.SetAlive(i%2 == 0)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.GetNextValidPeer()
+	}
+}
+
+func BenchmarkGetNextValidPeer_P2C_Parallel(b *testing.B) {
+	p := benchPool("p2c", 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			p.GetNextValidPeer()
+		}
+	})
+}
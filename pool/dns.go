@@ -0,0 +1,191 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Address families of DNSConfig.Families.
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// DNSConfig makes the transport of a backend configured by host name dial
+// the host's addresses itself: they are re-resolved every Refresh (see
+// StartDNSRefresh) and tried in turn, so a backend behind round-robin DNS
+// follows its records instead of pinning to the first address resolved.
+type DNSConfig struct {
+	Refresh time.Duration
+	// Families lists the address families to use, preferred first:
+	// ["ipv6", "ipv4"] tries AAAA records before A ones, ["ipv4"] ignores
+	// AAAA records. Empty uses both in the order of the resolver.
+	Families []string
+}
+
+// hostResolver dials one backend host through its cached addresses.
+type hostResolver struct {
+	host     string
+	families []string
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+	lookup   func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+	mux   sync.Mutex
+	addrs []net.IP
+	next  int // rotates the first address tried, spreading connections
+}
+
+// newHostResolver returns nil when host needs no resolution (an IP).
+func newHostResolver(host string, cfg *DNSConfig, dial func(ctx context.Context, network, addr string) (net.Conn, error)) *hostResolver {
+	if cfg == nil || net.ParseIP(host) != nil {
+		return nil
+	}
+	return &hostResolver{host: host, families: cfg.Families, dial: dial, lookup: net.DefaultResolver.LookupIPAddr}
+}
+
+// resolve looks the host up, keeping the addresses of the wanted families
+// in order of preference.
+func (r *hostResolver) resolve(ctx context.Context) ([]net.IP, error) {
+	found, err := r.lookup(ctx, r.host)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []net.IP
+	if len(r.families) == 0 {
+		for _, a := range found {
+			addrs = append(addrs, a.IP)
+		}
+	}
+	for _, family := range r.families {
+		for _, a := range found {
+			if (a.IP.To4() != nil) == (family == FamilyIPv4) {
+				addrs = append(addrs, a.IP)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no %v address for %s", r.families, r.host)
+	}
+	return addrs, nil
+}
+
+// refresh resolves the host again and reports whether its addresses
+// changed. On failure the known addresses stay in use.
+func (r *hostResolver) refresh(ctx context.Context) ([]net.IP, bool, error) {
+	addrs, err := r.resolve(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	changed := len(r.addrs) > 0 && !slices.EqualFunc(r.addrs, addrs, net.IP.Equal)
+	r.addrs = addrs
+	return addrs, changed, nil
+}
+
+// candidates returns the addresses to try for the next connection: those of
+// the preferred family first, starting at a different one each time, then
+// the others.
+func (r *hostResolver) candidates(ctx context.Context) ([]net.IP, error) {
+	r.mux.Lock()
+	addrs := r.addrs
+	r.mux.Unlock()
+	if len(addrs) == 0 {
+		var err error
+		if addrs, _, err = r.refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	preferred := len(addrs)
+	for i, ip := range addrs {
+		if (ip.To4() != nil) != (addrs[0].To4() != nil) {
+			preferred = i
+			break
+		}
+	}
+	r.mux.Lock()
+	start := r.next % preferred
+	r.next++
+	r.mux.Unlock()
+	out := make([]net.IP, 0, len(addrs))
+	out = append(out, addrs[start:preferred]...)
+	out = append(out, addrs[:start]...)
+	return append(out, addrs[preferred:]...), nil
+}
+
+// DialContext dials the first address of the host that answers.
+func (r *hostResolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != r.host {
+		return r.dial(ctx, network, addr) // e.g. through an HTTP proxy
+	}
+	addrs, err := r.candidates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for i, ip := range addrs {
+		attempt := ctx
+		// Leave time for the next addresses, as net.Dialer does.
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			attempt, cancel = context.WithDeadline(ctx, time.Now().Add(time.Until(deadline)/time.Duration(len(addrs)-i)))
+			defer cancel()
+		}
+		conn, err := r.dial(attempt, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// StartDNSRefresh re-resolves the host names of target's backends every
+// interval in a background goroutine, until ctx is cancelled. When a host's
+// addresses change, the idle connections of its backend are closed so that
+// new requests reach the new records.
+func StartDNSRefresh(ctx context.Context, target BackendLister, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, b := range target.GetBackends() {
+				refreshBackendDNS(ctx, b)
+			}
+		}
+	}()
+}
+
+func refreshBackendDNS(ctx context.Context, b *Backend) {
+	if b.resolver == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, changed, err := b.resolver.refresh(ctx)
+	switch {
+	case err != nil:
+		log.Printf("DNS refresh of backend %s failed, keeping its addresses: %v", b.URL, err)
+	case changed:
+		log.Printf("Backend %s now resolves to %v", b.URL, addrs)
+		if b.Transport != nil {
+			b.Transport.CloseIdleConnections()
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package pool
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies a change in the pool.
+type EventType string
+
+const (
+	EventAdded    EventType = "added"
+	EventRemoved  EventType = "removed"
+	EventUp       EventType = "up"
+	EventDown     EventType = "down"
+	EventDisabled EventType = "disabled" // admin maintenance mode on
+	EventEnabled  EventType = "enabled"  // admin maintenance mode off
+	EventEjected  EventType = "ejected"  // taken out by outlier detection
+	EventRestored EventType = "restored" // back from an outlier ejection
+	EventWeighted EventType = "weighted" // weight changed, see SetBackendWeight
+)
+
+// Event describes a single pool change, for embedders mirroring pool state.
+type Event struct {
+	Type   EventType `json:"type"`
+	ID     string    `json:"id"` // see Backend.ID
+	URL    string    `json:"url"`
+	Time   time.Time `json:"time"`
+	Detail string    `json:"detail,omitempty"` // e.g. why a backend was ejected
+}
+
+// allEvents receives the events of every pool in the process, plus those of
+// outlier detection, which knows backends but not their pool.
+var allEvents eventHub
+
+// eventHub fans pool events out to subscribers. Sends never block: a
+// subscriber that does not keep up with its buffer misses events rather than
+// stalling AddBackend/SetBackendStatus on the request path.
+type eventHub struct {
+	mux  sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+func (h *eventHub) subscribe(buffer int) chan Event {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[chan Event]struct{})
+	}
+	ch := make(chan Event, buffer)
+	h.subs[ch] = struct{}{}
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan Event) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+func (h *eventHub) publish(e Event) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default: // slow subscriber: drop rather than block the pool
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every subsequent pool event and a
+// cancel func that unsubscribes and closes the channel. buffer is the channel
+// capacity; events are dropped for a subscriber whose buffer is full.
+func (s *ServerPool) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := s.events.subscribe(buffer)
+	var once sync.Once
+	return ch, func() { once.Do(func() { s.events.unsubscribe(ch) }) }
+}
+
+// SubscribeAll is like ServerPool.Subscribe for the events of every pool
+// (routes, groups, Ingress and TCP pools alike) and of outlier detection.
+func SubscribeAll(buffer int) (<-chan Event, func()) {
+	ch := allEvents.subscribe(buffer)
+	var once sync.Once
+	return ch, func() { once.Do(func() { allEvents.unsubscribe(ch) }) }
+}
+
+func (s *ServerPool) emit(t EventType, b *Backend) {
+	s.emitCause(t, b, "", "")
+}
+
+// emitCause publishes an event of b and records it in the history, cause
+// defaulting to the usual one of t.
+func (s *ServerPool) emitCause(t EventType, b *Backend, cause Cause, detail string) {
+	e := Event{Type: t, ID: b.ID(), URL: b.URL.String(), Time: time.Now(), Detail: detail}
+	s.publish(e)
+	recordTransition(e, b, cause)
+}
+
+// publish stamps (unless already done) and publishes e. It runs with s.mux
+// held, so the OnStateChange callback only gets it queued, for notify.
+func (s *ServerPool) publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	s.events.publish(e)
+	allEvents.publish(e)
+	if s.onChange != nil {
+		s.pending = append(s.pending, e)
+	}
+}
+
+// notify runs the OnStateChange callback on the queued events, once the
+// change that emitted them has released the pool lock. One caller delivers at
+// a time, draining what others queue meanwhile, which keeps the events in
+// order and lets the callback change the pool without deadlocking.
+func (s *ServerPool) notify() {
+	if s.onChange == nil {
+		return
+	}
+	s.mux.Lock()
+	if s.delivering {
+		s.mux.Unlock()
+		return
+	}
+	s.delivering = true
+	for len(s.pending) > 0 {
+		events := s.pending
+		s.pending = nil
+		s.mux.Unlock()
+		for _, e := range events {
+			s.onChange(e)
+		}
+		s.mux.Lock()
+	}
+	s.delivering = false
+	s.mux.Unlock()
+}
@@ -0,0 +1,251 @@
+package pool
+
+import (
+	"cmp"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Group is a named set of backends (e.g. "stable" or "canary") with its own
+// pool and a traffic weight that can be changed at runtime.
+type Group struct {
+	Name     string
+	Pool     *ServerPool
+	Priority int   // failover tier: lower is preferred, 0 by default
+	weight   int64 // atomic
+}
+
+// NewGroup creates a group with the given initial weight.
+func NewGroup(name string, weight int, p *ServerPool) *Group {
+	return &Group{Name: name, Pool: p, weight: int64(weight)}
+}
+
+// Weight returns the group's current relative traffic weight.
+func (g *Group) Weight() int {
+	return int(atomic.LoadInt64(&g.weight))
+}
+
+// SetWeight changes the group's relative traffic weight; it takes effect on
+// the next request.
+func (g *Group) SetWeight(w int) {
+	atomic.StoreInt64(&g.weight, int64(w))
+}
+
+// hasAvailable reports whether at least one backend of the group can serve.
+func (g *Group) hasAvailable() bool {
+	for _, b := range g.Pool.GetBackends() {
+		if b.IsAvailable() {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupedPool splits traffic across groups by weight (e.g. 95% stable, 5%
+// canary) and then lets each group's own strategy pick the backend. Groups
+// with no available backend are skipped, so a dead canary sends all traffic
+// back to stable. Only the groups of the most preferred priority tier that
+// can serve get traffic: when the primary tier has no backend left, the
+// next one (e.g. another region) takes over until the primary comes back.
+// It implements LoadBalancer.
+type GroupedPool struct {
+	Groups []*Group
+
+	mux     sync.Mutex
+	current map[*Group]int64 // smooth weighted round-robin state
+}
+
+// NewGroupedPool builds a pool splitting traffic across groups.
+func NewGroupedPool(groups ...*Group) *GroupedPool {
+	return &GroupedPool{Groups: groups, current: make(map[*Group]int64)}
+}
+
+// Group returns the group with the given name, or nil.
+func (gp *GroupedPool) Group(name string) *Group {
+	for _, g := range gp.Groups {
+		if g.Name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+// peekGroup returns the group pickGroup would choose, leaving the current
+// weights as they are.
+func (gp *GroupedPool) peekGroup(eligible []*Group) *Group {
+	gp.mux.Lock()
+	defer gp.mux.Unlock()
+
+	var best *Group
+	for _, g := range eligible {
+		if best == nil || gp.current[g]+int64(g.Weight()) > gp.current[best]+int64(best.Weight()) {
+			best = g
+		}
+	}
+	return best
+}
+
+// pickGroup runs smooth weighted round-robin (as in nginx) over the eligible
+// groups: the split is exact over any window of sum(weights) requests rather
+// than only on average.
+func (gp *GroupedPool) pickGroup(eligible []*Group) *Group {
+	gp.mux.Lock()
+	defer gp.mux.Unlock()
+
+	var best *Group
+	var total int64
+	for _, g := range eligible {
+		w := int64(g.Weight())
+		total += w
+		gp.current[g] += w
+		if best == nil || gp.current[g] > gp.current[best] {
+			best = g
+		}
+	}
+	if best != nil {
+		gp.current[best] -= total
+	}
+	return best
+}
+
+// ActiveTier returns the priority tier getting the traffic: the lowest
+// Priority among the weighted groups that can serve. ok is false when none
+// can.
+func (gp *GroupedPool) ActiveTier() (priority int, ok bool) {
+	for _, g := range gp.Groups {
+		if g.Weight() > 0 && g.hasAvailable() && (!ok || g.Priority < priority) {
+			priority, ok = g.Priority, true
+		}
+	}
+	return priority, ok
+}
+
+// eligible returns the weighted groups of the active tier that can serve.
+func (gp *GroupedPool) eligible() []*Group {
+	tier, ok := gp.ActiveTier()
+	eligible := make([]*Group, 0, len(gp.Groups))
+	for _, g := range gp.Groups {
+		if ok && g.Priority == tier && g.Weight() > 0 && g.hasAvailable() {
+			eligible = append(eligible, g)
+		}
+	}
+	return eligible
+}
+
+// byTier returns the groups, the preferred tiers first.
+func (gp *GroupedPool) byTier() []*Group {
+	return slices.SortedStableFunc(slices.Values(gp.Groups), func(a, b *Group) int {
+		return cmp.Compare(a.Priority, b.Priority)
+	})
+}
+
+// GetNextValidPeer picks a group of the active tier by weight, then a
+// backend inside it.
+func (gp *GroupedPool) GetNextValidPeer() *Backend {
+	eligible := gp.eligible()
+	if len(eligible) == 0 {
+		// Every weighted group is down: fall back to any group that can serve,
+		// even one at weight 0, rather than failing the request. The preferred
+		// tiers go first.
+		for _, g := range gp.byTier() {
+			if b := g.Pool.GetNextValidPeer(); b != nil {
+				return b
+			}
+		}
+		return nil
+	}
+
+	if b := gp.pickGroup(eligible).Pool.GetNextValidPeer(); b != nil {
+		return b
+	}
+	// Availability changed since the eligibility scan: try the others.
+	for _, g := range eligible {
+		if b := g.Pool.GetNextValidPeer(); b != nil {
+			return b
+		}
+	}
+	return nil
+}
+
+// PeekNextValidPeer returns the backend GetNextValidPeer would pick now,
+// without advancing the group split or the strategies, see
+// ServerPool.PeekNextValidPeer.
+func (gp *GroupedPool) PeekNextValidPeer() (*Backend, bool) {
+	groups := gp.byTier()
+	if eligible := gp.eligible(); len(eligible) > 0 {
+		groups = append([]*Group{gp.peekGroup(eligible)}, eligible...)
+	}
+	for _, g := range groups {
+		if b, ok := g.Pool.PeekNextValidPeer(); !ok || b != nil {
+			return b, ok
+		}
+	}
+	return nil, true
+}
+
+// SetStrategy switches the strategy of every group, see
+// ServerPool.SetStrategy.
+func (gp *GroupedPool) SetStrategy(name string) error {
+	if _, err := NewStrategy(name); err != nil {
+		return err
+	}
+	for _, g := range gp.Groups {
+		g.Pool.SetStrategy(name) // known, checked above
+	}
+	return nil
+}
+
+// StrategyName returns the strategy of the groups.
+func (gp *GroupedPool) StrategyName() string {
+	if len(gp.Groups) == 0 {
+		return ""
+	}
+	return gp.Groups[0].Pool.StrategyName()
+}
+
+// AddBackend adds to the first group.
+func (gp *GroupedPool) AddBackend(b *Backend) {
+	if len(gp.Groups) > 0 {
+		gp.Groups[0].Pool.AddBackend(b)
+	}
+}
+
+// GetBackends returns the backends of every group.
+func (gp *GroupedPool) GetBackends() []*Backend {
+	var all []*Backend
+	for _, g := range gp.Groups {
+		all = append(all, g.Pool.GetBackends()...)
+	}
+	return all
+}
+
+func (gp *GroupedPool) RemoveBackend(u *url.URL) bool {
+	for _, g := range gp.Groups {
+		if g.Pool.RemoveBackend(u) {
+			return true
+		}
+	}
+	return false
+}
+
+func (gp *GroupedPool) SetBackendStatus(u *url.URL, alive bool) {
+	gp.SetBackendStatusCause(u, alive, CauseHealthCheck, "")
+}
+
+func (gp *GroupedPool) SetBackendStatusCause(u *url.URL, alive bool, cause Cause, detail string) {
+	for _, g := range gp.Groups {
+		g.Pool.SetBackendStatusCause(u, alive, cause, detail)
+	}
+}
+
+func (gp *GroupedPool) SetBackendAdminDown(u *url.URL, down bool) bool {
+	found := false
+	for _, g := range gp.Groups {
+		if g.Pool.SetBackendAdminDown(u, down) {
+			found = true
+		}
+	}
+	return found
+}
@@ -0,0 +1,135 @@
+package pool
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Cause is why a backend changed state.
+type Cause string
+
+const (
+	CauseHealthCheck Cause = "health_check"      // an active check passed or failed
+	CauseProxyError  Cause = "proxy_error"       // a proxied request or connection failed
+	CauseAdmin       Cause = "admin"             // maintenance mode set through the admin API
+	CauseOutlier     Cause = "outlier_detection" // ejected for its error rate or latency
+)
+
+// Backend states, as reported by Transition.
+const (
+	StateUp          = "up"
+	StateDown        = "down"
+	StateMaintenance = "maintenance"
+	StateEjected     = "ejected"
+)
+
+// Transition is one change of a backend's state, kept by History.
+type Transition struct {
+	Time   time.Time `json:"time"`
+	ID     string    `json:"id"` // see Backend.ID
+	URL    string    `json:"url"`
+	From   string    `json:"from"` // one of the State constants
+	To     string    `json:"to"`
+	Cause  Cause     `json:"cause"`
+	Detail string    `json:"detail,omitempty"` // e.g. the proxy error
+}
+
+// HistorySize is how many transitions are kept, over every pool; the oldest
+// are dropped first.
+const HistorySize = 1000
+
+var history transitionRing
+
+// transitionRing keeps the last HistorySize transitions.
+type transitionRing struct {
+	mux     sync.Mutex
+	entries []Transition
+	next    int // where the next one goes once entries is full
+}
+
+func (h *transitionRing) add(t Transition) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if len(h.entries) < HistorySize {
+		h.entries = append(h.entries, t)
+		return
+	}
+	h.entries[h.next] = t
+	h.next = (h.next + 1) % HistorySize
+}
+
+// History returns the transitions at or after since, oldest first, keeping
+// the last limit ones (0 = all).
+func History(since time.Time, limit int) []Transition {
+	history.mux.Lock()
+	ordered := append(append([]Transition{}, history.entries[history.next:]...), history.entries[:history.next]...)
+	history.mux.Unlock()
+
+	out := []Transition{}
+	for _, t := range ordered {
+		if !t.Time.Before(since) {
+			out = append(out, t)
+		}
+	}
+	if limit > 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out
+}
+
+// recordTransition adds the transition announced by e to the history. Events
+// that are not a change of state (added, removed, weighted) are ignored.
+func recordTransition(e Event, b *Backend, cause Cause) {
+	t := Transition{Time: e.Time, ID: e.ID, URL: e.URL, Cause: cause, Detail: e.Detail}
+	health := StateDown
+	if b.IsAlive() {
+		health = StateUp
+	}
+	switch e.Type {
+	case EventUp:
+		t.From, t.To = StateDown, StateUp
+	case EventDown:
+		t.From, t.To = StateUp, StateDown
+	case EventDisabled:
+		t.From, t.To = health, StateMaintenance
+	case EventEnabled:
+		t.From, t.To = StateMaintenance, health
+	case EventEjected:
+		t.From, t.To = StateUp, StateEjected
+	case EventRestored:
+		t.From, t.To = StateEjected, StateUp
+	default:
+		return
+	}
+	if t.Cause == "" {
+		t.Cause = defaultCause(e.Type)
+	}
+	history.add(t)
+}
+
+func defaultCause(t EventType) Cause {
+	switch t {
+	case EventDisabled, EventEnabled:
+		return CauseAdmin
+	case EventEjected, EventRestored:
+		return CauseOutlier
+	}
+	return CauseHealthCheck
+}
+
+// statusCauser is implemented by the load balancers that record why a
+// backend went up or down.
+type statusCauser interface {
+	SetBackendStatusCause(u *url.URL, alive bool, cause Cause, detail string)
+}
+
+// SetStatusCause is lb.SetBackendStatus, recording cause and detail in the
+// history when lb supports it (the pools of this package do).
+func SetStatusCause(lb LoadBalancer, u *url.URL, alive bool, cause Cause, detail string) {
+	if c, ok := lb.(statusCauser); ok {
+		c.SetBackendStatusCause(u, alive, cause, detail)
+		return
+	}
+	lb.SetBackendStatus(u, alive)
+}
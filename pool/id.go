@@ -0,0 +1,27 @@
+package pool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ID identifies the backend in the admin API and in events. It is derived
+// from the exact URL, so it survives restarts and reloads, and tells apart
+// entries that only differ by a trailing slash or a default port, which
+// compare equal to the eye but not as strings.
+func (b *Backend) ID() string {
+	sum := sha256.Sum256([]byte(b.URL.String()))
+	return hex.EncodeToString(sum[:6])
+}
+
+// GetBackendByID returns the backend with the given ID, or nil.
+func (s *ServerPool) GetBackendByID(id string) *Backend {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	for _, b := range s.Backends {
+		if b.ID() == id {
+			return b
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package pool
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyDecay is the time constant of the latency EWMA: an observation's
+// weight halves roughly every 0.7*latencyDecay.
+const latencyDecay = 10 * time.Second
+
+// latencyTracker keeps a peak-sensitive exponentially weighted moving average
+// of response times. A slower-than-average sample replaces the average
+// immediately (so a degrading backend is penalised at once), faster samples
+// are blended in with a weight that depends on the time since the last one.
+type latencyTracker struct {
+	mux  sync.Mutex
+	ewma float64 // nanoseconds
+	last time.Time
+}
+
+func (l *latencyTracker) observe(rtt time.Duration, now time.Time) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	sample := float64(rtt)
+	switch {
+	case l.last.IsZero() || sample > l.ewma:
+		l.ewma = sample
+	default:
+		w := math.Exp(-float64(now.Sub(l.last)) / float64(latencyDecay))
+		l.ewma = l.ewma*w + sample*(1-w)
+	}
+	l.last = now
+}
+
+func (l *latencyTracker) value() time.Duration {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return time.Duration(l.ewma)
+}
+
+// latencyWindowSize is the number of recent samples kept for percentiles.
+const latencyWindowSize = 128
+
+// latencyWindow keeps the most recent response times in a ring buffer, for
+// percentiles the EWMA cannot give (a backend whose p95 explodes while its
+// average stays reasonable).
+type latencyWindow struct {
+	mux     sync.Mutex
+	samples [latencyWindowSize]time.Duration
+	n       int // samples observed since the last reset
+}
+
+func (w *latencyWindow) observe(rtt time.Duration) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.samples[w.n%latencyWindowSize] = rtt
+	w.n++
+}
+
+// percentile returns the p-th percentile (0 < p <= 1) of the window and the
+// number of samples it was computed from.
+func (w *latencyWindow) percentile(p float64) (time.Duration, int) {
+	w.mux.Lock()
+	count := min(w.n, latencyWindowSize)
+	sorted := make([]time.Duration, count)
+	copy(sorted, w.samples[:count])
+	w.mux.Unlock()
+
+	if count == 0 {
+		return 0, 0
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(math.Ceil(p*float64(count))) - 1
+	return sorted[max(idx, 0)], count
+}
+
+func (w *latencyWindow) reset() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.n = 0
+}
+
+// errorRateAlpha is the weight of each new outcome in the error-rate EWMA
+// (roughly the last 1/alpha = 20 requests dominate).
+const errorRateAlpha = 0.05
+
+// errorRateTracker keeps an EWMA of request outcomes: 0 = success, 1 = failure.
+type errorRateTracker struct {
+	mux   sync.Mutex
+	rate  float64
+	count int // outcomes observed since the last reset
+}
+
+func (e *errorRateTracker) observe(failed bool) {
+	sample := 0.0
+	if failed {
+		sample = 1
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.rate = e.rate*(1-errorRateAlpha) + sample*errorRateAlpha
+	e.count++
+}
+
+func (e *errorRateTracker) value() float64 {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.rate
+}
+
+func (e *errorRateTracker) observations() int {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.count
+}
+
+func (e *errorRateTracker) reset() {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.rate, e.count = 0, 0
+}
+
+// ObserveLatency feeds a successful response time into the backend's EWMA
+// and records a success in its error rate.
+func (b *Backend) ObserveLatency(rtt time.Duration) {
+	b.latency.observe(rtt, time.Now())
+	b.recent.observe(rtt)
+	b.errorRate.observe(false)
+}
+
+// ObserveFailure records a failed attempt (transport error, timeout, ...).
+func (b *Backend) ObserveFailure() {
+	b.errorRate.observe(true)
+}
+
+// ErrorRate returns the recent failure ratio in [0,1].
+func (b *Backend) ErrorRate() float64 {
+	return b.errorRate.value()
+}
+
+// LatencyPercentile returns the p-th percentile (e.g. 0.95) of the backend's
+// most recent response times, 0 if there is no sample yet.
+func (b *Backend) LatencyPercentile(p float64) time.Duration {
+	d, _ := b.recent.percentile(p)
+	return d
+}
+
+// LatencyEWMA returns the backend's current latency estimate (0 if unknown).
+func (b *Backend) LatencyEWMA() time.Duration {
+	return b.latency.value()
+}
+
+// leastLatency implements peak-EWMA: the expected cost of a backend is its
+// latency estimate multiplied by the number of requests it would have in
+// flight, so a fast backend that is already busy can lose to a slower idle
+// one. Backends without any sample yet have cost 0 and are probed first.
+type leastLatency struct {
+	tieBreaker uint64 // atomic counter
+}
+
+func (ll *leastLatency) Next(backends []*Backend) *Backend {
+	return ll.pick(backends, atomic.AddUint64(&ll.tieBreaker, 1))
+}
+
+func (ll *leastLatency) Peek(backends []*Backend) *Backend {
+	return ll.pick(backends, atomic.LoadUint64(&ll.tieBreaker)+1)
+}
+
+// pick returns the backend of lowest cost, k rotating among equal costs.
+func (ll *leastLatency) pick(backends []*Backend, k uint64) *Backend {
+	var best *Backend
+	bestCost := math.Inf(1)
+	ties := 0
+
+	for _, b := range backends {
+		if !b.IsAvailable() {
+			continue
+		}
+		inflight := float64(atomic.LoadInt64(&b.CurrentConns) + 1)
+		cost := float64(b.LatencyEWMA()) * inflight
+		switch {
+		case cost < bestCost:
+			best, bestCost, ties = b, cost, 1
+		case cost == bestCost:
+			// Reservoir-style rotation among equal costs, driven by the
+			// counter so it stays deterministic for a given call sequence.
+			ties++
+			if k%uint64(ties) == 0 {
+				best = b
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,94 @@
+package pool
+
+import (
+	"fmt"
+	"time"
+)
+
+// Option configures a ServerPool built by NewServerPool.
+type Option func(*ServerPool) error
+
+// NewServerPool builds a pool from options. Unlike a struct literal, whose
+// unknown strategy silently falls back to round-robin, it rejects invalid
+// settings up front.
+func NewServerPool(opts ...Option) (*ServerPool, error) {
+	s := &ServerPool{Strategy: "round-robin"}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// WithStrategy selects a registered strategy, see RegisterStrategy.
+func WithStrategy(name string) Option {
+	return func(s *ServerPool) error {
+		if _, err := NewStrategy(name); err != nil {
+			return err
+		}
+		s.Strategy = name
+		return nil
+	}
+}
+
+// WithTransport sets the transport of the backends added without one.
+// Options apply in order: put it before WithBackends.
+func WithTransport(cfg TransportConfig) Option {
+	return func(s *ServerPool) error {
+		s.TransportConfig = cfg
+		return nil
+	}
+}
+
+// WithSlowStart sets ServerPool.SlowStart.
+func WithSlowStart(window time.Duration) Option {
+	return func(s *ServerPool) error {
+		if window < 0 {
+			return fmt.Errorf("slow start window must not be negative (got %v)", window)
+		}
+		s.SlowStart = window
+		return nil
+	}
+}
+
+// WithBackends adds backends as they are, alive flag included.
+func WithBackends(backends ...*Backend) Option {
+	return func(s *ServerPool) error {
+		for _, b := range backends {
+			if !validBackendURL(b.URL) {
+				return fmt.Errorf("backend without a host: %v", b.URL)
+			}
+			s.AddBackend(b)
+		}
+		return nil
+	}
+}
+
+// WithBackendURLs adds a backend per URL. They start DOWN, waiting for a
+// health check, like backends added through the admin API.
+func WithBackendURLs(urls ...string) Option {
+	return func(s *ServerPool) error {
+		for _, raw := range urls {
+			u, err := ParseBackendURL(raw)
+			if err != nil {
+				return err
+			}
+			s.AddBackend(&Backend{URL: u})
+		}
+		return nil
+	}
+}
+
+// OnStateChange calls fn for every event of the pool: backends added or
+// removed, going up or down, disabled or enabled. Calls happen one at a
+// time, in order, after the pool lock is released, so fn may read or even
+// change the pool; it delays the caller that made the change, though, and
+// should be quick. Put it before WithBackends to see the initial backends
+// too. Outlier ejections are not pool events: see SubscribeAll.
+func OnStateChange(fn func(Event)) Option {
+	return func(s *ServerPool) error {
+		s.onChange = fn
+		return nil
+	}
+}
@@ -0,0 +1,246 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// OutlierConfig tunes outlier detection. Zero fields take the defaults
+// documented next to them.
+type OutlierConfig struct {
+	Interval           time.Duration // time between two analyses (10s)
+	MinRequests        int           // outcomes a backend needs before being judged (20)
+	ErrorRateStdev     float64       // eject above mean + k·stdev of the other backends' error rates (1.9)
+	MinErrorRate       float64       // ...and above this absolute error rate (0.1)
+	LatencyFactor      float64       // eject when p95 > factor × the pool's median p95 (3); <0 disables
+	BaseEjection       time.Duration // first ejection; the n-th consecutive one lasts n times longer (30s)
+	MaxEjection        time.Duration // cap on a single ejection (5m)
+	MaxEjectionPercent int           // never eject more than this share of the pool (50)
+	Ramp               time.Duration // traffic ramp after re-introduction (30s)
+}
+
+func (c OutlierConfig) withDefaults() OutlierConfig {
+	if c.Interval <= 0 {
+		c.Interval = 10 * time.Second
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 20
+	}
+	if c.ErrorRateStdev <= 0 {
+		c.ErrorRateStdev = 1.9
+	}
+	if c.MinErrorRate <= 0 {
+		c.MinErrorRate = 0.1
+	}
+	if c.LatencyFactor == 0 {
+		c.LatencyFactor = 3
+	}
+	if c.BaseEjection <= 0 {
+		c.BaseEjection = 30 * time.Second
+	}
+	if c.MaxEjection <= 0 {
+		c.MaxEjection = 5 * time.Minute
+	}
+	if c.MaxEjectionPercent <= 0 {
+		c.MaxEjectionPercent = 50
+	}
+	if c.Ramp <= 0 {
+		c.Ramp = 30 * time.Second
+	}
+	return c
+}
+
+// BackendLister is anything exposing backends, e.g. a LoadBalancer.
+type BackendLister interface {
+	GetBackends() []*Backend
+}
+
+// OutlierDetector compares the backends of a pool with each other and ejects
+// the statistical outliers: a backend whose error rate or p95 latency stands
+// out from its peers is taken out of rotation even though its health check
+// still passes. Consecutive ejections last longer and longer; once one ends
+// the backend gets its traffic back gradually.
+type OutlierDetector struct {
+	Target BackendLister
+	Config OutlierConfig
+}
+
+// StartOutlierDetection analyses target every cfg.Interval in a background
+// goroutine, until ctx is cancelled.
+func StartOutlierDetection(ctx context.Context, target BackendLister, cfg OutlierConfig) *OutlierDetector {
+	d := &OutlierDetector{Target: target, Config: cfg.withDefaults()}
+	ticker := time.NewTicker(d.Config.Interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				d.Analyse(now)
+			}
+		}
+	}()
+	log.Printf("Outlier detection started (interval: %v)", d.Config.Interval)
+	return d
+}
+
+// IsEjected reports whether outlier detection currently keeps the backend
+// out of rotation.
+func (b *Backend) IsEjected() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return time.Now().Before(b.ejectedUntil)
+}
+
+// outlier is a backend judged by Analyse and how far off it is.
+type outlier struct {
+	b        *Backend
+	severity float64
+	reason   string
+}
+
+// Analyse runs one detection pass and returns the backends it ejected.
+func (d *OutlierDetector) Analyse(now time.Time) []*Backend {
+	cfg := d.Config.withDefaults()
+	backends := d.Target.GetBackends()
+
+	var candidates []*Backend
+	ejected := 0
+	for _, b := range backends {
+		b.mux.Lock()
+		serving, isEjected := b.alive && !b.adminDown, now.Before(b.ejectedUntil)
+		restored := !isEjected && !b.ejectedUntil.IsZero()
+		if restored {
+			b.ejectedUntil = time.Time{} // report the return once
+		}
+		b.mux.Unlock()
+		if restored {
+			e := Event{Type: EventRestored, ID: b.ID(), URL: b.URL.String(), Time: now}
+			allEvents.publish(e)
+			recordTransition(e, b, CauseOutlier)
+		}
+		switch {
+		case isEjected:
+			ejected++
+		case serving && b.errorRate.observations() >= cfg.MinRequests:
+			candidates = append(candidates, b)
+		}
+	}
+
+	outliers := findOutliers(candidates, cfg)
+	flagged := make(map[*Backend]bool, len(outliers))
+	for _, o := range outliers {
+		flagged[o.b] = true
+	}
+	// A backend that behaves for a whole interval earns back one step of
+	// its ejection multiplier.
+	for _, b := range candidates {
+		if !flagged[b] {
+			b.mux.Lock()
+			if b.ejections > 0 {
+				b.ejections--
+			}
+			b.mux.Unlock()
+		}
+	}
+
+	budget := len(backends)*cfg.MaxEjectionPercent/100 - ejected
+	var out []*Backend
+	for _, o := range outliers {
+		if budget <= 0 {
+			break
+		}
+		d.eject(o, now, cfg)
+		out = append(out, o.b)
+		budget--
+	}
+	return out
+}
+
+// findOutliers returns the outliers among candidates, worst first. At least
+// three backends are needed for the comparison to mean anything.
+func findOutliers(candidates []*Backend, cfg OutlierConfig) []outlier {
+	if len(candidates) < 3 {
+		return nil
+	}
+
+	rates := make([]float64, len(candidates))
+	for i, b := range candidates {
+		rates[i] = b.ErrorRate()
+	}
+
+	p95s := make([]time.Duration, len(candidates))
+	for i, b := range candidates {
+		p95s[i] = b.LatencyPercentile(0.95)
+	}
+	sorted := append([]time.Duration(nil), p95s...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+
+	var outliers []outlier
+	for i, b := range candidates {
+		// Each backend is compared with its peers only, so a single bad
+		// backend does not inflate the spread it is judged against.
+		mean, stdev := meanStdev(rates, i)
+		threshold := mean + cfg.ErrorRateStdev*stdev
+		if rates[i] > cfg.MinErrorRate && rates[i] > threshold {
+			outliers = append(outliers, outlier{b, rates[i] / math.Max(threshold, 1e-9),
+				fmt.Sprintf("error rate %.0f%%", rates[i]*100)})
+			continue
+		}
+		if cfg.LatencyFactor > 0 && median > 0 && float64(p95s[i]) > cfg.LatencyFactor*float64(median) {
+			outliers = append(outliers, outlier{b, float64(p95s[i]) / (cfg.LatencyFactor * float64(median)),
+				"p95 latency " + p95s[i].Round(time.Millisecond).String()})
+		}
+	}
+	sort.Slice(outliers, func(i, j int) bool { return outliers[i].severity > outliers[j].severity })
+	return outliers
+}
+
+// meanStdev returns the mean and standard deviation of values, leaving out
+// the one at index skip.
+func meanStdev(values []float64, skip int) (float64, float64) {
+	var sum float64
+	for i, v := range values {
+		if i != skip {
+			sum += v
+		}
+	}
+	n := float64(len(values) - 1)
+	mean := sum / n
+	var variance float64
+	for i, v := range values {
+		if i != skip {
+			variance += (v - mean) * (v - mean)
+		}
+	}
+	return mean, math.Sqrt(variance / n)
+}
+
+// eject takes the backend out of rotation and schedules its warm-up. Its
+// statistics are reset so it is judged afresh once back.
+func (d *OutlierDetector) eject(o outlier, now time.Time, cfg OutlierConfig) {
+	b := o.b
+	b.mux.Lock()
+	b.ejections++
+	duration := cfg.BaseEjection * time.Duration(b.ejections)
+	if duration > cfg.MaxEjection {
+		duration = cfg.MaxEjection
+	}
+	b.ejectedUntil = now.Add(duration)
+	b.warmStart, b.warmWindow = b.ejectedUntil, cfg.Ramp
+	b.mux.Unlock()
+
+	b.errorRate.reset()
+	b.recent.reset()
+	log.Printf("✗ Backend %s ejected as an outlier for %v (%s)", b.URL, duration, o.reason)
+	e := Event{Type: EventEjected, ID: b.ID(), URL: b.URL.String(), Time: now,
+		Detail: fmt.Sprintf("%s, for %v", o.reason, duration)}
+	allEvents.publish(e)
+	recordTransition(e, b, CauseOutlier)
+}
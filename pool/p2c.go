@@ -0,0 +1,59 @@
+package pool
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// powerOfTwoChoices samples two distinct alive backends at random and routes
+// to the one with fewer in-flight requests. Selection is O(n) only for the
+// alive scan, with no global ordering, so bursts don't all herd onto the one
+// backend that strict least-connections currently ranks first.
+type powerOfTwoChoices struct{}
+
+func (powerOfTwoChoices) Next(backends []*Backend) *Backend {
+	alive := 0
+	for _, b := range backends {
+		if b.IsAvailable() {
+			alive++
+		}
+	}
+	switch alive {
+	case 0:
+		return nil
+	case 1:
+		return nthAlive(backends, 0)
+	}
+
+	i := rand.IntN(alive)
+	j := rand.IntN(alive - 1)
+	if j >= i {
+		j++ // distinct from i
+	}
+
+	a, b := nthAlive(backends, i), nthAlive(backends, j)
+	// Liveness may have flipped between the count and the lookup.
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if atomic.LoadInt64(&b.CurrentConns) < atomic.LoadInt64(&a.CurrentConns) {
+		return b
+	}
+	return a
+}
+
+// nthAlive returns the n-th (0-based) available backend, or nil.
+func nthAlive(backends []*Backend, n int) *Backend {
+	for _, b := range backends {
+		if b.IsAvailable() {
+			if n == 0 {
+				return b
+			}
+			n--
+		}
+	}
+	return nil
+}
@@ -1,23 +1,85 @@
 package pool
 
 import (
-	"math"
+	"context"
+	"crypto/tls"
+	"net/http"
 	"net/url"
 	"sync"
-	"sync/atomic"
+	"time"
 )
 
 // Backend represents a single upstream server.
 type Backend struct {
 	URL          *url.URL
-	alive        bool
-	CurrentConns int64 // tracked atomically for least-connections balancing
+	alive        bool            // driven by health checks and proxy errors
+	adminDown    bool            // maintenance mode, only changed through the admin API
+	CurrentConns int64           // tracked atomically for least-connections balancing
+	Transport    *http.Transport // long-lived, created once by ServerPool.AddBackend
+	tlsConfig    *tls.Config     // Transport's, as it was before its first use; see TLSConfig
 	mux          sync.RWMutex
+	latency      latencyTracker   // response-time EWMA fed by the proxy
+	errorRate    errorRateTracker // failure-ratio EWMA fed by the proxy
+	recent       latencyWindow    // last response times, for percentiles
+	stats        backendStats     // lifetime counters, see RecordRequest
+
+	weight       int // see SetWeight; DefaultWeight until weightSet
+	weightSet    bool
+	ejectedUntil time.Time // set by outlier detection; zeroed once the return is reported
+	ejections    int       // consecutive ejections, lengthens the next one
+	warmStart    time.Time // traffic ramps up from warmStart over warmWindow
+	warmWindow   time.Duration
+	uptime       uptimeTracker     // see Uptime
+	resolver     *hostResolver     // set with TransportConfig.DNS, see StartDNSRefresh
+	tags         map[string]string // see SetTags
+
+	abortOnce sync.Once // creates abortCtx, see Context
+	abortCtx  context.Context
+	abort     context.CancelFunc
+}
+
+// Context is done once Abort was called: the requests in flight to the
+// backend are cancelled with it.
+func (b *Backend) Context() context.Context {
+	b.initAbort()
+	return b.abortCtx
+}
+
+// Abort cancels the requests in flight to the backend, e.g. one removed
+// because it corrupts its responses. It cannot be undone.
+func (b *Backend) Abort() {
+	b.initAbort()
+	b.abort()
+}
+
+func (b *Backend) initAbort() {
+	b.abortOnce.Do(func() {
+		b.abortCtx, b.abort = context.WithCancel(context.Background())
+	})
+}
+
+// TLSConfig returns a copy of the client TLS config of the backend's
+// transport, for other clients of the backend such as the health checks;
+// nil for Go's defaults. Transport.TLSClientConfig itself must not be
+// shared: net/http writes to it when the transport first connects.
+func (b *Backend) TLSConfig() *tls.Config {
+	return b.tlsConfig.Clone()
+}
+
+// initTransport creates the backend's transport unless it was given one, and
+// keeps a copy of its TLS config for TLSConfig, taken before the transport
+// serves any request.
+func (b *Backend) initTransport(c TransportConfig) {
+	if b.Transport == nil {
+		b.Transport, b.resolver = c.newBackendTransport(b.URL)
+	}
+	b.tlsConfig = b.Transport.TLSClientConfig.Clone()
 }
 
 func (b *Backend) SetAlive(alive bool) {
 	b.mux.Lock()
 	defer b.mux.Unlock()
+	b.uptime.set(time.Now(), b.alive, alive)
 	b.alive = alive
 }
 
@@ -27,6 +89,28 @@ func (b *Backend) IsAlive() bool {
 	return b.alive
 }
 
+// SetAdminDown puts the backend in (or takes it out of) maintenance mode.
+// Unlike the alive flag, the health checker never touches it.
+func (b *Backend) SetAdminDown(down bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.adminDown = down
+}
+
+func (b *Backend) IsAdminDown() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.adminDown
+}
+
+// IsAvailable reports whether the backend may receive traffic: it must be
+// healthy, not administratively disabled and not ejected as an outlier.
+func (b *Backend) IsAvailable() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.alive && !b.adminDown && !time.Now().Before(b.ejectedUntil)
+}
+
 // LoadBalancer abstracts selection and management of backend servers.
 type LoadBalancer interface {
 	GetNextValidPeer() *Backend
@@ -34,84 +118,251 @@ type LoadBalancer interface {
 	GetBackends() []*Backend
 	RemoveBackend(*url.URL) bool
 	SetBackendStatus(*url.URL, bool)
+	SetBackendAdminDown(*url.URL, bool) bool
 }
 
 // ServerPool holds the list of backends and the chosen load-balancing strategy.
 type ServerPool struct {
 	Backends []*Backend
-	Current  uint64 // atomic counter for round-robin
-	Strategy string // "round-robin" | "least-connections"
-	mux      sync.RWMutex
+	Strategy string // name of a registered strategy, see RegisterStrategy
+
+	strategyOnce sync.Once
+	strategyImpl Strategy
+
+	events eventHub // see Subscribe
+
+	onChange   func(Event) // see OnStateChange
+	pending    []Event     // events waiting for onChange, guarded by mux
+	delivering bool        // a notify call is running onChange
+
+	// TransportConfig is applied to every backend added without a transport.
+	TransportConfig TransportConfig
+
+	// SlowStart ramps a backend's traffic share from 0% to 100% over this
+	// window when it comes back UP, instead of flooding a cold process
+	// (empty caches, JIT, connection pools). 0 disables the ramp.
+	SlowStart time.Duration
+
+	// Tags restricts the strategy to the backends with some tags, or makes
+	// it prefer them. Set it before the pool serves.
+	Tags TagPolicy
+	mux  sync.RWMutex
 }
 
-// AddBackend registers a new backend in the pool.
+// AddBackend registers a new backend in the pool. A dedicated transport is
+// created here, once, so the backend's idle connections are reused by every
+// request routed to it.
 func (s *ServerPool) AddBackend(b *Backend) {
+	defer s.notify() // after the unlock below
 	s.mux.Lock()
 	defer s.mux.Unlock()
+	b.initTransport(s.TransportConfig)
 	s.Backends = append(s.Backends, b)
+	s.emit(EventAdded, b)
 }
 
-// GetNextValidPeer returns the next alive backend using the configured strategy.
+// GetNextValidPeer returns the next alive backend using the configured
+// strategy, among the backends allowed by Tags. A backend still warming up
+// only takes part in its current share of the picks: it is left out of the
+// others before the strategy is called, once, so that its rotation and
+// weights are not skewed.
 func (s *ServerPool) GetNextValidPeer() *Backend {
 	s.mux.RLock()
 	defer s.mux.RUnlock()
+	strategy := s.strategy()
+	candidates := s.Tags.candidates(s.Backends)
+	admitted := admitted(candidates)
+	if b := strategy.Next(admitted); b != nil || len(admitted) == len(candidates) {
+		return b
+	}
+	return strategy.Next(candidates) // only warming backends can serve: better than nothing
+}
 
-	if s.Strategy == "least-connections" {
-		var best *Backend
-		minConns := int64(math.MaxInt64)
-		for _, b := range s.Backends {
-			conns := atomic.LoadInt64(&b.CurrentConns)
-			if b.IsAlive() && conns < minConns {
-				best = b
-				minConns = conns
-			}
-		}
-		return best
+// PeekNextValidPeer returns the backend GetNextValidPeer would pick now,
+// without advancing the strategy, for dry runs. ok is false when the
+// strategy picks at random (see Previewer). The random admission of
+// backends still warming up is not simulated.
+func (s *ServerPool) PeekNextValidPeer() (b *Backend, ok bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	previewer, ok := s.strategy().(Previewer)
+	if !ok {
+		return nil, false
 	}
+	return previewer.Peek(s.Tags.candidates(s.Backends)), true
+}
 
-	// Default: Round-Robin
-	length := len(s.Backends)
-	if length == 0 {
-		return nil
+// forget drops the strategy's state of b, which left the pool. The caller
+// holds s.mux.
+func (s *ServerPool) forget(b *Backend) {
+	if f, ok := s.strategy().(forgetter); ok {
+		f.forget(b)
 	}
-	start := (atomic.AddUint64(&s.Current, 1) - 1) % uint64(length)
-	for i := 0; i < length; i++ {
-		idx := (start + uint64(i)) % uint64(length)
-		if s.Backends[idx].IsAlive() {
-			return s.Backends[idx]
+}
+
+// strategy lazily resolves the Strategy name into an implementation from the
+// registry. Unknown names fall back to round-robin, which has always been the
+// default; callers wanting strict validation should use NewStrategy up front.
+// The caller holds s.mux.
+func (s *ServerPool) strategy() Strategy {
+	s.strategyOnce.Do(func() {
+		impl, err := NewStrategy(s.Strategy)
+		if err != nil {
+			impl = &roundRobin{}
 		}
+		s.strategyImpl = impl
+	})
+	return s.strategyImpl
+}
+
+// SetStrategy switches the pool to the registered strategy called name,
+// from the next pick on. The new strategy starts from a fresh state, but
+// the connection counts, latencies and weights it reads live on the
+// backends, so it decides with the same knowledge as the old one.
+func (s *ServerPool) SetStrategy(name string) error {
+	impl, err := NewStrategy(name)
+	if err != nil {
+		return err
 	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.strategyOnce.Do(func() {}) // impl replaces the lazy resolution
+	s.Strategy, s.strategyImpl = name, impl
 	return nil
 }
 
+// StrategyName returns the name of the strategy in use. Read Strategy
+// directly only before the pool serves.
+func (s *ServerPool) StrategyName() string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.Strategy
+}
+
 // SetBackendStatus updates the alive flag of the backend matching the given URL.
+// An up/down event is emitted only when the flag actually changes.
 func (s *ServerPool) SetBackendStatus(u *url.URL, alive bool) {
-	s.mux.Lock()            
+	s.SetBackendStatusCause(u, alive, CauseHealthCheck, "")
+}
+
+// SetBackendStatusCause is SetBackendStatus recording why the backend
+// changed in the event detail and the history.
+func (s *ServerPool) SetBackendStatusCause(u *url.URL, alive bool, cause Cause, detail string) {
+	defer s.notify() // after the unlock below
+	s.mux.Lock()
 	defer s.mux.Unlock()
 	for _, b := range s.Backends {
 		if b.URL.String() == u.String() {
+			if b.IsAlive() == alive {
+				return
+			}
 			b.SetAlive(alive) // backend's own mux handles its field
+			if alive {
+				if s.SlowStart > 0 {
+					b.StartWarmup(time.Now(), s.SlowStart)
+				}
+				s.emitCause(EventUp, b, cause, detail)
+			} else {
+				s.emitCause(EventDown, b, cause, detail)
+			}
 			return
 		}
 	}
 }
 
+// SetBackendAdminDown enables or disables (maintenance mode) the backend
+// matching the given URL. It returns false if no such backend exists.
+func (s *ServerPool) SetBackendAdminDown(u *url.URL, down bool) bool {
+	defer s.notify() // after the unlock below
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, b := range s.Backends {
+		if b.URL.String() == u.String() {
+			if b.IsAdminDown() != down {
+				b.SetAdminDown(down)
+				if down {
+					s.emit(EventDisabled, b)
+				} else {
+					s.emit(EventEnabled, b)
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
 // RemoveBackend removes the backend with the given URL from the pool.
 func (s *ServerPool) RemoveBackend(u *url.URL) bool {
+	defer s.notify() // after the unlock below
 	s.mux.Lock()
 	defer s.mux.Unlock()
 	for i, b := range s.Backends {
 		if b.URL.String() == u.String() {
 			s.Backends = append(s.Backends[:i], s.Backends[i+1:]...)
+			s.forget(b)
+			if b.Transport != nil {
+				b.Transport.CloseIdleConnections()
+			}
+			s.emit(EventRemoved, b)
 			return true
 		}
 	}
 	return false
 }
 
+// ReplaceBackends makes the pool serve exactly backends, in that order, in a
+// single step. Backends whose URL is already in the pool are kept as they are
+// (health, connections, statistics); the others are added, and the ones
+// missing from the list are removed.
+func (s *ServerPool) ReplaceBackends(backends []*Backend) (added, removed []*Backend) {
+	defer s.notify() // after the unlock below
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	current := make(map[string]*Backend, len(s.Backends))
+	for _, b := range s.Backends {
+		current[b.URL.String()] = b
+	}
+	next := make([]*Backend, 0, len(backends))
+	seen := make(map[string]bool, len(backends))
+	for _, b := range backends {
+		if seen[b.URL.String()] {
+			continue // listed twice
+		}
+		seen[b.URL.String()] = true
+		if existing, ok := current[b.URL.String()]; ok {
+			next = append(next, existing)
+			delete(current, b.URL.String())
+			continue
+		}
+		b.initTransport(s.TransportConfig)
+		next = append(next, b)
+		added = append(added, b)
+	}
+	for _, b := range s.Backends {
+		if _, gone := current[b.URL.String()]; gone {
+			removed = append(removed, b)
+		}
+	}
+	s.Backends = next
+
+	for _, b := range removed {
+		s.forget(b)
+		if b.Transport != nil {
+			b.Transport.CloseIdleConnections()
+		}
+		s.emit(EventRemoved, b)
+	}
+	for _, b := range added {
+		s.emit(EventAdded, b)
+	}
+	return added, removed
+}
+
 // GetBackends returns a snapshot copy of the backend slice (safe for concurrent iteration).
 func (s *ServerPool) GetBackends() []*Backend {
 	s.mux.RLock()
 	defer s.mux.RUnlock()
 	return append([]*Backend(nil), s.Backends...)
-}
\ No newline at end of file
+}
@@ -1,10 +1,20 @@
 package pool
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // helper: build a Backend with a given URL and alive status
@@ -180,4 +190,1141 @@ func TestConcurrentAccess_NoRace(t *testing.T) {
 		}(i)
 	}
 	wg.Wait()
-}
\ No newline at end of file
+}
+
+// ── Transport ────────────────────────────────────────────────────────────────
+
+func TestAddBackend_CreatesTransportFromConfig(t *testing.T) {
+	p := &ServerPool{
+		Strategy: "round-robin",
+		TransportConfig: TransportConfig{
+			MaxIdleConnsPerHost: 64,
+			IdleConnTimeout:     45 * time.Second,
+			InsecureSkipVerify:  true,
+		},
+	}
+	b := newBackend("http://a:8080", true)
+	p.AddBackend(b)
+
+	if b.Transport == nil {
+		t.Fatal("expected AddBackend to assign a transport")
+	}
+	if b.Transport.MaxIdleConnsPerHost != 64 {
+		t.Errorf("MaxIdleConnsPerHost = %d, want 64", b.Transport.MaxIdleConnsPerHost)
+	}
+	if b.Transport.IdleConnTimeout != 45*time.Second {
+		t.Errorf("IdleConnTimeout = %v, want 45s", b.Transport.IdleConnTimeout)
+	}
+	if b.Transport.TLSClientConfig == nil || !b.Transport.TLSClientConfig.InsecureSkipVerify {
+		t.Error("expected InsecureSkipVerify to be propagated to the TLS config")
+	}
+}
+
+func TestAddBackend_UsesTheBackendTLSConfig(t *testing.T) {
+	p := &ServerPool{
+		Strategy: "round-robin",
+		TransportConfig: TransportConfig{
+			TLS:        &tls.Config{ServerName: "default.internal"},
+			BackendTLS: map[string]*tls.Config{"mesh:8443": {ServerName: "payments.mesh"}},
+		},
+	}
+	mesh, other := newBackend("https://mesh:8443", true), newBackend("https://other:8443", true)
+	p.AddBackend(mesh)
+	p.AddBackend(other)
+
+	if got := mesh.Transport.TLSClientConfig.ServerName; got != "payments.mesh" {
+		t.Errorf("mesh backend: ServerName = %q, want payments.mesh", got)
+	}
+	if got := other.Transport.TLSClientConfig.ServerName; got != "default.internal" {
+		t.Errorf("other backend: ServerName = %q, want default.internal", got)
+	}
+}
+
+func TestAddBackend_KeepsExistingTransport(t *testing.T) {
+	p := &ServerPool{Strategy: "round-robin"}
+	own := &http.Transport{}
+	b := newBackend("http://a:8080", true)
+	b.Transport = own
+	p.AddBackend(b)
+
+	if b.Transport != own {
+		t.Error("AddBackend must not replace a transport that is already set")
+	}
+}
+
+// ── Strategy registry ────────────────────────────────────────────────────────
+
+// firstAlive is a trivial custom strategy used to exercise the registry.
+type firstAlive struct{}
+
+func (firstAlive) Next(backends []*Backend) *Backend {
+	for _, b := range backends {
+		if b.IsAvailable() {
+			return b
+		}
+	}
+	return nil
+}
+
+func TestRegisterStrategy_CustomStrategyIsUsedByPool(t *testing.T) {
+	RegisterStrategy("test-first-alive", func() Strategy { return firstAlive{} })
+
+	p := &ServerPool{Strategy: "test-first-alive"}
+	p.AddBackend(newBackend("http://dead:8080", false))
+	p.AddBackend(newBackend("http://first:8080", true))
+	p.AddBackend(newBackend("http://second:8080", true))
+
+	for i := 0; i < 3; i++ {
+		if b := p.GetNextValidPeer(); b == nil || b.URL.Host != "first:8080" {
+			t.Fatalf("expected custom strategy to pick first:8080, got %v", b)
+		}
+	}
+}
+
+func TestRegisterStrategy_DuplicatePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected RegisterStrategy to panic on a duplicate name")
+		}
+	}()
+	RegisterStrategy("round-robin", func() Strategy { return &roundRobin{} })
+}
+
+func TestSetStrategy_SwitchesWhileServing(t *testing.T) {
+	p := &ServerPool{Strategy: "round-robin"}
+	busy, idle := newBackend("http://busy:8080", true), newBackend("http://idle:8080", true)
+	p.AddBackend(busy)
+	p.AddBackend(idle)
+	atomic.StoreInt64(&busy.CurrentConns, 5)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					if p.GetNextValidPeer() == nil {
+						t.Error("no backend picked during a switch")
+						return
+					}
+				}
+			}
+		}()
+	}
+	for _, name := range []string{"p2c", "weighted-round-robin", "least-connections"} {
+		if err := p.SetStrategy(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	// The in-flight requests counted on the backends guide the new strategy.
+	if p.StrategyName() != "least-connections" {
+		t.Errorf("expected least-connections, got %s", p.StrategyName())
+	}
+	if b := p.GetNextValidPeer(); b != idle {
+		t.Errorf("expected the idle backend, got %v", b.URL)
+	}
+	if err := p.SetStrategy("does-not-exist"); err == nil || p.StrategyName() != "least-connections" {
+		t.Errorf("an unknown strategy must be rejected and change nothing, got %v", err)
+	}
+}
+
+func TestNewStrategy_UnknownNameFails(t *testing.T) {
+	if _, err := NewStrategy("does-not-exist"); err == nil {
+		t.Error("expected an error for an unregistered strategy")
+	}
+	for _, name := range []string{"round-robin", "least-connections"} {
+		if _, err := NewStrategy(name); err != nil {
+			t.Errorf("built-in strategy %s not registered: %v", name, err)
+		}
+	}
+}
+
+func TestLeastConn_TieBreakRotates(t *testing.T) {
+	p := &ServerPool{Strategy: "least-connections"}
+	p.AddBackend(newBackend("http://a:8080", true))
+	p.AddBackend(newBackend("http://b:8080", true))
+	p.AddBackend(newBackend("http://busy:8080", true))
+	atomic.StoreInt64(&p.Backends[2].CurrentConns, 3)
+
+	seen := map[string]int{}
+	for i := 0; i < 6; i++ {
+		seen[p.GetNextValidPeer().URL.Host]++
+	}
+	if seen["a:8080"] != 3 || seen["b:8080"] != 3 {
+		t.Errorf("expected ties to alternate evenly between a and b, got %v", seen)
+	}
+	if seen["busy:8080"] != 0 {
+		t.Errorf("busy backend must not be chosen while others have fewer conns")
+	}
+}
+
+// ── Least-latency (peak EWMA) ────────────────────────────────────────────────
+
+func TestLeastLatency_PrefersFasterBackend(t *testing.T) {
+	p := &ServerPool{Strategy: "least-latency"}
+	fast := newBackend("http://fast:8080", true)
+	slow := newBackend("http://slow:8080", true)
+	fast.ObserveLatency(10 * time.Millisecond)
+	slow.ObserveLatency(200 * time.Millisecond)
+	p.AddBackend(slow)
+	p.AddBackend(fast)
+
+	for i := 0; i < 5; i++ {
+		if b := p.GetNextValidPeer(); b.URL.Host != "fast:8080" {
+			t.Fatalf("expected fast backend, got %s", b.URL.Host)
+		}
+	}
+}
+
+func TestLeastLatency_AccountsForInFlightRequests(t *testing.T) {
+	p := &ServerPool{Strategy: "least-latency"}
+	fast := newBackend("http://fast:8080", true)
+	slow := newBackend("http://slow:8080", true)
+	fast.ObserveLatency(10 * time.Millisecond)
+	slow.ObserveLatency(30 * time.Millisecond)
+	// 10ms * (9+1) = 100 > 30ms * (0+1): the busy fast backend must lose.
+	atomic.StoreInt64(&fast.CurrentConns, 9)
+	p.AddBackend(fast)
+	p.AddBackend(slow)
+
+	if b := p.GetNextValidPeer(); b.URL.Host != "slow:8080" {
+		t.Errorf("expected idle slow backend to win over busy fast one, got %s", b.URL.Host)
+	}
+}
+
+func TestLeastLatency_SkipsDeadBackends(t *testing.T) {
+	p := &ServerPool{Strategy: "least-latency"}
+	dead := newBackend("http://dead:8080", false)
+	alive := newBackend("http://alive:8080", true)
+	alive.ObserveLatency(time.Second)
+	p.AddBackend(dead) // no samples → cost 0, but dead
+	p.AddBackend(alive)
+
+	if b := p.GetNextValidPeer(); b == nil || b.URL.Host != "alive:8080" {
+		t.Errorf("expected alive backend, got %v", b)
+	}
+}
+
+func TestLatencyEWMA_PeakThenDecay(t *testing.T) {
+	var l latencyTracker
+	now := time.Now()
+	l.observe(10*time.Millisecond, now)
+	l.observe(100*time.Millisecond, now.Add(time.Second))
+	if got := l.value(); got != 100*time.Millisecond {
+		t.Fatalf("a slower sample must replace the average immediately, got %v", got)
+	}
+
+	l.observe(10*time.Millisecond, now.Add(time.Minute))
+	if got := l.value(); got >= 20*time.Millisecond {
+		t.Errorf("average should decay towards fast samples after a long gap, got %v", got)
+	}
+}
+
+// ── Events ───────────────────────────────────────────────────────────────────
+
+func nextEvent(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for pool event")
+		return Event{}
+	}
+}
+
+func TestSubscribe_ReceivesPoolChanges(t *testing.T) {
+	p := &ServerPool{Strategy: "round-robin"}
+	events, cancel := p.Subscribe(8)
+	defer cancel()
+
+	u, _ := url.Parse("http://a:8080")
+	p.AddBackend(newBackend("http://a:8080", true))
+	p.SetBackendStatus(u, true) // no change → no event
+	p.SetBackendStatus(u, false)
+	p.SetBackendStatus(u, true)
+	p.RemoveBackend(u)
+
+	want := []EventType{EventAdded, EventDown, EventUp, EventRemoved}
+	for _, typ := range want {
+		e := nextEvent(t, events)
+		if e.Type != typ || e.URL != "http://a:8080" {
+			t.Fatalf("expected %s event for http://a:8080, got %+v", typ, e)
+		}
+	}
+}
+
+func TestSubscribe_CancelClosesChannel(t *testing.T) {
+	p := &ServerPool{Strategy: "round-robin"}
+	events, cancel := p.Subscribe(1)
+	cancel()
+	cancel() // idempotent
+
+	if _, ok := <-events; ok {
+		t.Error("expected channel to be closed after cancel")
+	}
+	p.AddBackend(newBackend("http://a:8080", true)) // must not panic on closed channel
+}
+
+func TestSubscribe_SlowSubscriberDoesNotBlock(t *testing.T) {
+	p := &ServerPool{Strategy: "round-robin"}
+	_, cancel := p.Subscribe(1)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			p.AddBackend(newBackend("http://a:8080", true))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("pool blocked on a full subscriber channel")
+	}
+}
+
+// ── Constructor ──────────────────────────────────────────────────────────────
+
+func TestNewServerPool_AppliesAndValidatesOptions(t *testing.T) {
+	p, err := NewServerPool(
+		WithStrategy("least-connections"),
+		WithTransport(TransportConfig{MaxIdleConnsPerHost: 7}),
+		WithBackends(newBackend("http://a:8080", true)),
+		WithBackendURLs("http://b:8080"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backends := p.GetBackends()
+	if p.Strategy != "least-connections" || len(backends) != 2 {
+		t.Fatalf("unexpected pool: %+v", p)
+	}
+	if !backends[0].IsAlive() || backends[1].IsAlive() {
+		t.Error("WithBackends keeps the alive flag, WithBackendURLs backends wait for a health check")
+	}
+	if backends[1].Transport.MaxIdleConnsPerHost != 7 {
+		t.Error("backends should get the transport of WithTransport")
+	}
+
+	if p, _ := NewServerPool(); p.Strategy != "round-robin" {
+		t.Errorf("default strategy: got %q", p.Strategy)
+	}
+	for name, opt := range map[string]Option{
+		"unknown strategy": WithStrategy("coin-flip"),
+		"invalid URL":      WithBackendURLs("http://a:8080", "not a url"),
+		"negative window":  WithSlowStart(-time.Second),
+		"backend w/o host": WithBackends(&Backend{URL: &url.URL{Path: "/x"}}),
+	} {
+		if _, err := NewServerPool(opt); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestOnStateChange_InOrderAndOutsideTheLock(t *testing.T) {
+	var got []string
+	var p *ServerPool
+	p, _ = NewServerPool(OnStateChange(func(e Event) {
+		got = append(got, string(e.Type)+" "+e.URL)
+		p.GetBackends() // would deadlock if called with the pool locked
+		if e.Type == EventDown {
+			p.RemoveBackend(mustParse(e.URL)) // changes from the callback are delivered next
+		}
+	}))
+
+	p.AddBackend(newBackend("http://a:8080", true))
+	p.SetBackendStatus(mustParse("http://a:8080"), false)
+	p.SetBackendStatus(mustParse("http://a:8080"), false) // no change, no event
+
+	want := []string{"added http://a:8080", "down http://a:8080", "removed http://a:8080"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+}
+
+func mustParse(raw string) *url.URL {
+	u, err := url.Parse(raw)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// ── Power of two choices ─────────────────────────────────────────────────────
+
+func TestP2C_NeverPicksTheBusierOfTwo(t *testing.T) {
+	p := &ServerPool{Strategy: "p2c"}
+	idle := newBackend("http://idle:8080", true)
+	busy := newBackend("http://busy:8080", true)
+	atomic.StoreInt64(&busy.CurrentConns, 5)
+	p.AddBackend(idle)
+	p.AddBackend(busy)
+
+	// With exactly two alive backends both are always sampled.
+	for i := 0; i < 20; i++ {
+		if b := p.GetNextValidPeer(); b.URL.Host != "idle:8080" {
+			t.Fatalf("expected idle backend, got %s", b.URL.Host)
+		}
+	}
+}
+
+func TestP2C_SkipsDeadAndHandlesSingleAlive(t *testing.T) {
+	p := &ServerPool{Strategy: "p2c"}
+	p.AddBackend(newBackend("http://dead1:8080", false))
+	p.AddBackend(newBackend("http://alive:8080", true))
+	p.AddBackend(newBackend("http://dead2:8080", false))
+
+	for i := 0; i < 10; i++ {
+		if b := p.GetNextValidPeer(); b == nil || b.URL.Host != "alive:8080" {
+			t.Fatalf("expected the only alive backend, got %v", b)
+		}
+	}
+
+	for _, b := range p.GetBackends() {
+		b.SetAlive(false)
+	}
+	if b := p.GetNextValidPeer(); b != nil {
+		t.Errorf("expected nil when all backends are dead, got %s", b.URL)
+	}
+}
+
+func TestP2C_SpreadsLoadAcrossEqualBackends(t *testing.T) {
+	p := &ServerPool{Strategy: "p2c"}
+	for _, host := range []string{"a", "b", "c", "d"} {
+		p.AddBackend(newBackend("http://"+host+":8080", true))
+	}
+
+	seen := map[string]int{}
+	for i := 0; i < 400; i++ {
+		seen[p.GetNextValidPeer().URL.Host]++
+	}
+	if len(seen) != 4 {
+		t.Errorf("expected all 4 backends to receive traffic, got %v", seen)
+	}
+}
+
+// ── Maintenance mode ─────────────────────────────────────────────────────────
+
+func TestAdminDown_ExcludedByEveryStrategy(t *testing.T) {
+	for _, strategy := range Strategies() {
+		p := &ServerPool{Strategy: strategy}
+		disabled := newBackend("http://disabled:8080", true)
+		p.AddBackend(disabled)
+		p.AddBackend(newBackend("http://ok:8080", true))
+
+		if !p.SetBackendAdminDown(disabled.URL, true) {
+			t.Fatalf("%s: expected backend to be found", strategy)
+		}
+		for i := 0; i < 10; i++ {
+			if b := p.GetNextValidPeer(); b == nil || b.URL.Host != "ok:8080" {
+				t.Fatalf("%s: expected only the enabled backend, got %v", strategy, b)
+			}
+		}
+	}
+}
+
+func TestPeekNextValidPeer_MatchesTheNextPick(t *testing.T) {
+	for _, strategy := range Strategies() {
+		p := &ServerPool{Strategy: strategy}
+		for _, raw := range []string{"http://a:8080", "http://b:8080", "http://c:8080"} {
+			p.AddBackend(newBackend(raw, true))
+		}
+		p.Backends[0].SetWeight(3)
+
+		for i := 0; i < 10; i++ {
+			peeked, ok := p.PeekNextValidPeer()
+			if !ok {
+				if slices.Contains([]string{
+					"round-robin", "least-connections", "least-latency",
+					"weighted-round-robin", "weighted-least-connections",
+				}, strategy) {
+					t.Fatalf("%s: expected a preview", strategy)
+				}
+				break
+			}
+			if again, _ := p.PeekNextValidPeer(); again != peeked {
+				t.Fatalf("%s: peeking twice gave %v then %v", strategy, peeked, again)
+			}
+			if next := p.GetNextValidPeer(); next != peeked {
+				t.Fatalf("%s: pick %d: peeked %v, got %v", strategy, i, peeked, next)
+			}
+		}
+	}
+
+	gp := NewGroupedPool(
+		NewGroup("stable", 3, &ServerPool{Strategy: "round-robin", Backends: []*Backend{newBackend("http://s1:8080", true), newBackend("http://s2:8080", true)}}),
+		NewGroup("canary", 1, &ServerPool{Strategy: "round-robin", Backends: []*Backend{newBackend("http://c1:8080", true)}}),
+	)
+	for i := 0; i < 8; i++ {
+		peeked, ok := gp.PeekNextValidPeer()
+		if next := gp.GetNextValidPeer(); !ok || next != peeked {
+			t.Fatalf("grouped pick %d: peeked %v (%v), got %v", i, peeked, ok, next)
+		}
+	}
+}
+
+func TestAdminDown_SurvivesHealthStatusChanges(t *testing.T) {
+	p := &ServerPool{Strategy: "round-robin"}
+	b := newBackend("http://a:8080", false)
+	p.AddBackend(b)
+	p.SetBackendAdminDown(b.URL, true)
+
+	p.SetBackendStatus(b.URL, true) // health check reports UP
+	if b.IsAvailable() {
+		t.Error("a healthy backend in maintenance mode must not be available")
+	}
+	if !b.IsAdminDown() {
+		t.Error("health status change must not clear the admin-down flag")
+	}
+
+	u, _ := url.Parse("http://ghost:8080")
+	if p.SetBackendAdminDown(u, true) {
+		t.Error("expected false for an unknown backend")
+	}
+}
+
+// ── Weights ──────────────────────────────────────────────────────────────────
+
+func TestWeightedRoundRobin_SmoothProportionalSpread(t *testing.T) {
+	p := &ServerPool{Strategy: "weighted-round-robin"}
+	a, b, c := newBackend("http://a:8080", true), newBackend("http://b:8080", true), newBackend("http://c:8080", true)
+	p.AddBackend(a)
+	p.AddBackend(b)
+	p.AddBackend(c)
+	p.SetBackendWeight(a.URL, 5)
+	// b and c keep DefaultWeight
+
+	var order []string
+	for i := 0; i < 7; i++ {
+		order = append(order, p.GetNextValidPeer().URL.Host[:1])
+	}
+	// nginx's smooth WRR for {5, 1, 1}: interleaved, not "aaaaabc".
+	if got := strings.Join(order, ""); got != "aabacaa" {
+		t.Errorf("got sequence %s, want aabacaa", got)
+	}
+}
+
+func TestWeights_ZeroDrainsUntilNothingElseIsLeft(t *testing.T) {
+	for _, strategy := range []string{"weighted-round-robin", "weighted-least-connections"} {
+		p := &ServerPool{Strategy: strategy}
+		drained, other := newBackend("http://drained:8080", true), newBackend("http://other:8080", true)
+		p.AddBackend(drained)
+		p.AddBackend(other)
+
+		events, cancel := p.Subscribe(4)
+		if !p.SetBackendWeight(drained.URL, 0) {
+			t.Fatalf("%s: expected backend to be found", strategy)
+		}
+		if e := nextEvent(t, events); e.Type != EventWeighted || e.Detail != "weight 0" {
+			t.Errorf("%s: unexpected event %+v", strategy, e)
+		}
+		cancel()
+
+		for i := 0; i < 10; i++ {
+			if b := p.GetNextValidPeer(); b != other {
+				t.Fatalf("%s: a backend of weight 0 must get no traffic, got %v", strategy, b.URL)
+			}
+		}
+		p.SetBackendStatus(other.URL, false)
+		if b := p.GetNextValidPeer(); b != drained {
+			t.Errorf("%s: with no weighted backend left, expected the drained one, got %v", strategy, b)
+		}
+	}
+	if p := (&ServerPool{}); p.SetBackendWeight(&url.URL{Host: "ghost:8080"}, 1) {
+		t.Error("expected false for an unknown backend")
+	}
+}
+
+func TestWeightedLeastConnections_LoadPerWeight(t *testing.T) {
+	heavy, light := newBackend("http://heavy:8080", true), newBackend("http://light:8080", true)
+	heavy.SetWeight(3)
+	wlc := &weightedLeastConnections{}
+	backends := []*Backend{light, heavy}
+
+	// Place 8 requests that never finish: the heavy backend takes 3 of every 4.
+	for i := 0; i < 8; i++ {
+		atomic.AddInt64(&wlc.Next(backends).CurrentConns, 1)
+	}
+	if heavy.CurrentConns != 6 || light.CurrentConns != 2 {
+		t.Errorf("expected 6/2, got heavy=%d light=%d", heavy.CurrentConns, light.CurrentConns)
+	}
+
+	// A 4x machine at 8 connections beats a 1x one at 3, unlike with plain
+	// least-connections.
+	heavy.SetWeight(4)
+	atomic.StoreInt64(&heavy.CurrentConns, 8)
+	atomic.StoreInt64(&light.CurrentConns, 3)
+	if b := wlc.Next(backends); b != heavy {
+		t.Errorf("expected the 4x backend, got %v", b.URL)
+	}
+	if b := (&leastConnections{}).Next(backends); b != light {
+		t.Errorf("plain least-connections should pick the 1x backend, got %v", b.URL)
+	}
+}
+
+// ── Bandit (experimental) ────────────────────────────────────────────────────
+
+func TestBandit_ShiftsTrafficAwayFromBadBackend(t *testing.T) {
+	good := newBackend("http://good:8080", true)
+	bad := newBackend("http://bad:8080", true)
+	for i := 0; i < 50; i++ {
+		good.ObserveLatency(10 * time.Millisecond)
+		bad.ObserveLatency(10 * time.Millisecond)
+		bad.ObserveFailure()
+	}
+	backends := []*Backend{good, bad}
+
+	bd := newBandit()
+	bd.interval = 0 // rebalance on every call
+	for i := 0; i < 200; i++ {
+		bd.Next(backends)
+	}
+
+	if bd.weights[good] <= bd.weights[bad] {
+		t.Fatalf("expected good backend to gain weight, got good=%.2f bad=%.2f", bd.weights[good], bd.weights[bad])
+	}
+	if floor := banditFloor / 2; bd.weights[bad] < floor-1e-9 {
+		t.Errorf("bad backend weight %.3f fell below exploration floor %.3f", bd.weights[bad], floor)
+	}
+}
+
+func TestBandit_PrefersLowerLatency(t *testing.T) {
+	fast := newBackend("http://fast:8080", true)
+	slow := newBackend("http://slow:8080", true)
+	fast.ObserveLatency(5 * time.Millisecond)
+	slow.ObserveLatency(100 * time.Millisecond)
+
+	p := &ServerPool{Strategy: "bandit"}
+	p.AddBackend(fast)
+	p.AddBackend(slow)
+	bd := p.strategy().(*bandit)
+	bd.interval = 0
+
+	seen := map[string]int{}
+	for i := 0; i < 2000; i++ {
+		seen[p.GetNextValidPeer().URL.Host]++
+	}
+	if seen["fast:8080"] <= seen["slow:8080"] {
+		t.Errorf("expected most traffic on the fast backend, got %v", seen)
+	}
+	if seen["slow:8080"] == 0 {
+		t.Error("slow backend must still be explored")
+	}
+}
+
+func TestBandit_SkipsUnavailable(t *testing.T) {
+	p := &ServerPool{Strategy: "bandit"}
+	p.AddBackend(newBackend("http://dead:8080", false))
+	p.AddBackend(newBackend("http://alive:8080", true))
+	for i := 0; i < 20; i++ {
+		if b := p.GetNextValidPeer(); b == nil || b.URL.Host != "alive:8080" {
+			t.Fatalf("expected alive backend, got %v", b)
+		}
+	}
+}
+
+func TestErrorRate_TracksOutcomes(t *testing.T) {
+	b := newBackend("http://a:8080", true)
+	for i := 0; i < 100; i++ {
+		b.ObserveFailure()
+	}
+	if r := b.ErrorRate(); r < 0.9 {
+		t.Errorf("expected error rate near 1 after repeated failures, got %.2f", r)
+	}
+	for i := 0; i < 100; i++ {
+		b.ObserveLatency(time.Millisecond)
+	}
+	if r := b.ErrorRate(); r > 0.1 {
+		t.Errorf("expected error rate to decay after successes, got %.2f", r)
+	}
+}
+
+// ── Backend tags ─────────────────────────────────────────────────────────────
+
+func TestTagPolicy_RequiresAndPrefers(t *testing.T) {
+	p := &ServerPool{Strategy: "round-robin"}
+	for _, spec := range []struct{ url, region, version string }{
+		{"http://eu-v1:8080", "eu", "v1"},
+		{"http://eu-v2:8080", "eu", "v2"},
+		{"http://us-v2:8080", "us", "v2"},
+	} {
+		b := newBackend(spec.url, true)
+		b.SetTags(map[string]string{"region": spec.region, "version": spec.version})
+		p.AddBackend(b)
+	}
+	p.Tags = TagPolicy{Require: map[string]string{"version": "v2"}, Prefer: map[string]string{"region": "us"}}
+
+	for i := 0; i < 4; i++ {
+		if b := p.GetNextValidPeer(); b == nil || b.URL.Host != "us-v2:8080" {
+			t.Fatalf("expected the preferred region, got %v", b)
+		}
+	}
+
+	// Without a preferred backend available, any one with the required tags.
+	p.Backends[2].SetAlive(false)
+	for i := 0; i < 4; i++ {
+		if b := p.GetNextValidPeer(); b == nil || b.URL.Host != "eu-v2:8080" {
+			t.Fatalf("expected the other v2 backend, got %v", b)
+		}
+	}
+
+	p.Backends[1].SetAlive(false)
+	if b := p.GetNextValidPeer(); b != nil {
+		t.Fatalf("a backend without the required tags must not be picked, got %v", b)
+	}
+}
+
+// ── Weighted groups (canary) ─────────────────────────────────────────────────
+
+func newGroup(name string, weight int, backends ...*Backend) *Group {
+	p := &ServerPool{Strategy: "round-robin"}
+	for _, b := range backends {
+		p.AddBackend(b)
+	}
+	return NewGroup(name, weight, p)
+}
+
+func TestGroupedPool_SplitsByWeight(t *testing.T) {
+	gp := NewGroupedPool(
+		newGroup("stable", 95, newBackend("http://stable:8080", true)),
+		newGroup("canary", 5, newBackend("http://canary:8080", true)),
+	)
+
+	seen := map[string]int{}
+	for i := 0; i < 100; i++ {
+		seen[gp.GetNextValidPeer().URL.Host]++
+	}
+	if seen["stable:8080"] != 95 || seen["canary:8080"] != 5 {
+		t.Errorf("expected an exact 95/5 split over 100 requests, got %v", seen)
+	}
+
+	gp.Group("canary").SetWeight(0)
+	for i := 0; i < 20; i++ {
+		if b := gp.GetNextValidPeer(); b.URL.Host != "stable:8080" {
+			t.Fatalf("canary at weight 0 must get no traffic, got %s", b.URL.Host)
+		}
+	}
+}
+
+func TestGroupedPool_SkipsGroupWithoutAvailableBackend(t *testing.T) {
+	gp := NewGroupedPool(
+		newGroup("stable", 50, newBackend("http://stable:8080", true)),
+		newGroup("canary", 50, newBackend("http://canary:8080", false)),
+	)
+	for i := 0; i < 10; i++ {
+		if b := gp.GetNextValidPeer(); b == nil || b.URL.Host != "stable:8080" {
+			t.Fatalf("expected stable backend while canary is down, got %v", b)
+		}
+	}
+
+	// Only a weight-0 group can serve: better than failing the request.
+	gp.Group("stable").SetWeight(0)
+	gp.Group("canary").Pool.GetBackends()[0].SetAlive(false)
+	if b := gp.GetNextValidPeer(); b == nil || b.URL.Host != "stable:8080" {
+		t.Fatalf("expected fallback to the weight-0 group, got %v", b)
+	}
+}
+
+func TestGroupedPool_FailsOverToNextTier(t *testing.T) {
+	primary := newGroup("eu", 1, newBackend("http://eu-1:8080", true), newBackend("http://eu-2:8080", true))
+	backup := newGroup("us", 1, newBackend("http://us-1:8080", true))
+	backup.Priority = 1
+	gp := NewGroupedPool(primary, backup)
+
+	for i := 0; i < 10; i++ {
+		if b := gp.GetNextValidPeer(); b == nil || b.URL.Host == "us-1:8080" {
+			t.Fatalf("the backup tier must get no traffic while the primary can serve, got %v", b)
+		}
+	}
+
+	// One primary backend left is enough to keep the traffic.
+	primary.Pool.GetBackends()[0].SetAlive(false)
+	if b := gp.GetNextValidPeer(); b == nil || b.URL.Host != "eu-2:8080" {
+		t.Fatalf("expected the last primary backend, got %v", b)
+	}
+
+	primary.Pool.GetBackends()[1].SetAlive(false)
+	if tier, ok := gp.ActiveTier(); !ok || tier != 1 {
+		t.Fatalf("expected tier 1 to be active, got %d (ok=%v)", tier, ok)
+	}
+	if b := gp.GetNextValidPeer(); b == nil || b.URL.Host != "us-1:8080" {
+		t.Fatalf("expected failover to the backup tier, got %v", b)
+	}
+
+	primary.Pool.GetBackends()[0].SetAlive(true)
+	if b := gp.GetNextValidPeer(); b == nil || b.URL.Host != "eu-1:8080" {
+		t.Fatalf("expected fail-back to the primary tier, got %v", b)
+	}
+}
+
+// ── Outlier detection & warm-up ──────────────────────────────────────────────
+
+// feed records n outcomes of the given latency, failing every failEvery-th
+// one (0 = never).
+func feed(b *Backend, n int, rtt time.Duration, failEvery int) {
+	for i := 1; i <= n; i++ {
+		if failEvery > 0 && i%failEvery == 0 {
+			b.ObserveFailure()
+			continue
+		}
+		b.ObserveLatency(rtt)
+	}
+}
+
+func TestOutlierDetection_EjectsErrorOutlier(t *testing.T) {
+	p := &ServerPool{Strategy: "round-robin"}
+	for _, host := range []string{"a", "b", "c"} {
+		b := newBackend("http://"+host+":8080", true)
+		feed(b, 50, 10*time.Millisecond, 0)
+		p.AddBackend(b)
+	}
+	bad := newBackend("http://bad:8080", true)
+	feed(bad, 50, 10*time.Millisecond, 2)
+	p.AddBackend(bad)
+
+	events, cancel := SubscribeAll(8)
+	defer cancel()
+
+	d := &OutlierDetector{Target: p, Config: OutlierConfig{BaseEjection: time.Minute}}
+	now := time.Now()
+	if ejected := d.Analyse(now); len(ejected) != 1 || ejected[0] != bad {
+		t.Fatalf("expected only the failing backend to be ejected, got %v", ejected)
+	}
+	if !bad.IsEjected() || bad.IsAvailable() {
+		t.Fatal("an ejected backend must not be available")
+	}
+	for i := 0; i < 12; i++ {
+		if p.GetNextValidPeer() == bad {
+			t.Fatal("ejected backend must receive no traffic")
+		}
+	}
+
+	// Once back, a second offence is punished longer.
+	bad.mux.Lock()
+	bad.ejectedUntil = now
+	bad.mux.Unlock()
+	feed(bad, 50, 10*time.Millisecond, 2)
+	d.Analyse(now)
+	if got := bad.ejectedUntil.Sub(now); got != 2*time.Minute {
+		t.Errorf("second ejection lasts %v, want 2m", got)
+	}
+
+	for _, want := range []EventType{EventEjected, EventRestored, EventEjected} {
+		if e := <-events; e.Type != want || e.URL != "http://bad:8080" {
+			t.Errorf("expected %s event for the bad backend, got %+v", want, e)
+		}
+	}
+}
+
+func TestOutlierDetection_EjectsLatencyOutlier(t *testing.T) {
+	p := &ServerPool{Strategy: "round-robin"}
+	for _, host := range []string{"a", "b", "c"} {
+		b := newBackend("http://"+host+":8080", true)
+		feed(b, 30, 10*time.Millisecond, 0)
+		p.AddBackend(b)
+	}
+	slow := newBackend("http://slow:8080", true)
+	feed(slow, 30, 200*time.Millisecond, 0)
+	p.AddBackend(slow)
+
+	d := &OutlierDetector{Target: p}
+	if ejected := d.Analyse(time.Now()); len(ejected) != 1 || ejected[0] != slow {
+		t.Fatalf("expected the slow backend to be ejected, got %v", ejected)
+	}
+}
+
+func TestOutlierDetection_RespectsMaxEjectionPercent(t *testing.T) {
+	p := &ServerPool{Strategy: "round-robin"}
+	for _, host := range []string{"a", "b", "c", "d"} {
+		b := newBackend("http://"+host+":8080", true)
+		feed(b, 30, 10*time.Millisecond, 0)
+		p.AddBackend(b)
+	}
+	for _, host := range []string{"slow1", "slow2"} {
+		b := newBackend("http://"+host+":8080", true)
+		feed(b, 30, time.Second, 0)
+		p.AddBackend(b)
+	}
+
+	d := &OutlierDetector{Target: p, Config: OutlierConfig{MaxEjectionPercent: 20}}
+	if ejected := d.Analyse(time.Now()); len(ejected) != 1 {
+		t.Fatalf("20%% of 6 backends allows 1 ejection, got %d", len(ejected))
+	}
+}
+
+func TestWarmup_RampsTrafficShare(t *testing.T) {
+	b := newBackend("http://a:8080", true)
+	if s := b.TrafficShare(); s != 1 {
+		t.Fatalf("expected full share outside warm-up, got %.2f", s)
+	}
+	b.StartWarmup(time.Now().Add(-5*time.Second), 10*time.Second)
+	if s := b.TrafficShare(); s < 0.45 || s > 0.55 {
+		t.Errorf("expected about half the traffic mid-ramp, got %.2f", s)
+	}
+
+	// A backend at the very start of its ramp gets nothing while another can serve.
+	p := &ServerPool{Strategy: "round-robin"}
+	cold := newBackend("http://cold:8080", true)
+	cold.StartWarmup(time.Now().Add(time.Hour), time.Minute)
+	p.AddBackend(cold)
+	p.AddBackend(newBackend("http://warm:8080", true))
+	for i := 0; i < 10; i++ {
+		if p.GetNextValidPeer() == cold {
+			t.Fatal("cold backend must not receive traffic at 0% share")
+		}
+	}
+}
+
+func TestSlowStart_RampsBackendComingBackUp(t *testing.T) {
+	for _, strategy := range []string{"round-robin", "least-connections"} {
+		p := &ServerPool{Strategy: strategy, SlowStart: time.Hour}
+		warm := newBackend("http://warm:8080", true)
+		cold := newBackend("http://cold:8080", true)
+		p.AddBackend(warm)
+		p.AddBackend(cold)
+
+		p.SetBackendStatus(cold.URL, false)
+		p.SetBackendStatus(cold.URL, true)
+		if s := cold.TrafficShare(); s > 0.01 {
+			t.Fatalf("%s: expected a ramp starting near 0%%, got %.2f", strategy, s)
+		}
+		for i := 0; i < 20; i++ {
+			if p.GetNextValidPeer() == cold {
+				t.Fatalf("%s: backend that just came UP must not get a full share", strategy)
+			}
+		}
+		if warm.TrafficShare() != 1 {
+			t.Errorf("%s: backend that never went DOWN must not ramp", strategy)
+		}
+	}
+}
+
+func TestSlowStart_KeepsTheSplitOfTheOthers(t *testing.T) {
+	for strategy, want := range map[string][2]int{"round-robin": {150, 150}, "weighted-round-robin": {200, 100}} {
+		p := &ServerPool{Strategy: strategy, SlowStart: time.Hour}
+		a, b, cold := newBackend("http://a:8080", true), newBackend("http://b:8080", true), newBackend("http://cold:8080", true)
+		p.AddBackend(a)
+		p.AddBackend(b)
+		p.AddBackend(cold)
+		p.SetBackendWeight(a.URL, 2) // ignored by round-robin
+		p.SetBackendStatus(cold.URL, false)
+		p.SetBackendStatus(cold.URL, true)
+
+		counts := map[*Backend]int{}
+		for i := 0; i < 300; i++ {
+			counts[p.GetNextValidPeer()]++
+		}
+		if counts[a] != want[0] || counts[b] != want[1] {
+			t.Errorf("%s: got a=%d b=%d cold=%d, want a=%d b=%d", strategy, counts[a], counts[b], counts[cold], want[0], want[1])
+		}
+	}
+}
+
+func TestWeightedRoundRobin_ForgetsRemovedBackendsOnly(t *testing.T) {
+	p := &ServerPool{Strategy: "weighted-round-robin"}
+	a, b := newBackend("http://a:8080", true), newBackend("http://b:8080", true)
+	a.SetTags(map[string]string{"region": "eu"})
+	p.AddBackend(a)
+	p.AddBackend(b)
+	p.GetNextValidPeer()
+	w := p.strategy().(*weightedRoundRobin)
+
+	p.Tags = TagPolicy{Require: map[string]string{"region": "eu"}} // a filtered call keeps b's state
+	p.GetNextValidPeer()
+	if len(w.current) != 2 {
+		t.Fatalf("expected the state of both backends, got %d", len(w.current))
+	}
+	p.RemoveBackend(b.URL)
+	if _, ok := w.current[b]; ok || len(w.current) != 1 {
+		t.Errorf("expected only the removed backend to be forgotten, got %v", w.current)
+	}
+}
+
+func TestBackendStats_CountsAndPercentiles(t *testing.T) {
+	b := newBackend("http://a:8080", true)
+	if s := b.Stats(); s.Requests != 0 || s.P99 != 0 {
+		t.Fatalf("expected empty stats, got %+v", s)
+	}
+	for i := 0; i < 90; i++ {
+		b.RecordRequest(RequestResult{Latency: 3 * time.Millisecond, BytesIn: 10, BytesOut: 100})
+	}
+	for i := 0; i < 10; i++ {
+		b.RecordRequest(RequestResult{Latency: 400 * time.Millisecond, Failed: true})
+	}
+
+	s := b.Stats()
+	if s.Requests != 100 || s.Successes != 90 || s.Failures != 10 {
+		t.Errorf("unexpected counters: %+v", s)
+	}
+	if s.BytesIn != 900 || s.BytesOut != 9000 {
+		t.Errorf("unexpected byte counts: in=%d out=%d", s.BytesIn, s.BytesOut)
+	}
+	if s.P50 != 5 || s.P90 != 5 || s.P99 != 500 {
+		t.Errorf("unexpected percentiles: p50=%v p90=%v p99=%v", s.P50, s.P90, s.P99)
+	}
+
+	// Slower than the last bucket still reports a finite bound.
+	b.RecordRequest(RequestResult{Latency: time.Minute})
+	for i := 0; i < 200; i++ {
+		b.RecordRequest(RequestResult{Latency: time.Minute})
+	}
+	if s := b.Stats(); s.P99 != 10000 {
+		t.Errorf("expected the last finite bound, got %v", s.P99)
+	}
+}
+
+// ── State history ────────────────────────────────────────────────────────────
+
+func TestHistory_RecordsTransitionsWithCause(t *testing.T) {
+	start := time.Now()
+	p := &ServerPool{Strategy: "round-robin"}
+	u, _ := url.Parse("http://history:8080")
+	p.AddBackend(newBackend(u.String(), true))
+
+	SetStatusCause(p, u, false, CauseProxyError, "connection refused")
+	p.SetBackendStatus(u, false) // no change, no transition
+	p.SetBackendStatus(u, true)
+	p.SetBackendAdminDown(u, true)
+
+	var got []string
+	for _, tr := range History(start, 0) {
+		if tr.URL == u.String() {
+			got = append(got, tr.From+">"+tr.To+" "+string(tr.Cause)+" "+tr.Detail)
+		}
+	}
+	want := []string{
+		"up>down proxy_error connection refused",
+		"down>up health_check ",
+		"up>maintenance admin ",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("unexpected history:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestHistory_IsBounded(t *testing.T) {
+	p := &ServerPool{Strategy: "round-robin"}
+	u, _ := url.Parse("http://flapping:8080")
+	p.AddBackend(newBackend(u.String(), true))
+	for i := 0; i < HistorySize; i++ {
+		p.SetBackendStatus(u, i%2 == 1)
+	}
+	p.SetBackendAdminDown(u, true)
+
+	all := History(time.Time{}, 0)
+	if len(all) != HistorySize || all[len(all)-1].To != StateMaintenance {
+		t.Fatalf("expected %d transitions ending with the last one, got %d", HistorySize, len(all))
+	}
+	if last := History(time.Time{}, 2); len(last) != 2 || last[1].To != StateMaintenance {
+		t.Errorf("limit must keep the most recent, got %+v", last)
+	}
+}
+
+// ── Uptime ───────────────────────────────────────────────────────────────────
+
+func TestUptime_CumulativeAndRollingWindows(t *testing.T) {
+	start := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	var u uptimeTracker
+	u.set(start, false, true)
+	u.set(start.Add(22*time.Hour), true, false)                // down for 30 minutes
+	u.set(start.Add(22*time.Hour+30*time.Minute), false, true) // then up again
+	u.set(start.Add(23*time.Hour+30*time.Minute), true, true)  // no change
+
+	now := start.Add(24 * time.Hour)
+	got := u.report(now, true)
+	if got.UpSeconds != (23*time.Hour+30*time.Minute).Seconds() || got.DownSeconds != (30*time.Minute).Seconds() {
+		t.Errorf("unexpected totals: up %v s, down %v s", got.UpSeconds, got.DownSeconds)
+	}
+	if got.Availability1h != 100 {
+		t.Errorf("expected 100%% over the last hour, got %v", got.Availability1h)
+	}
+	if want := 100 * 23.5 / 24; got.Availability24h != want {
+		t.Errorf("expected %v%% over the day, got %v", want, got.Availability24h)
+	}
+
+	// A day later, the outage left the 24h window.
+	if got := u.report(now.Add(24*time.Hour), true); got.Availability24h != 100 {
+		t.Errorf("expected 100%% once the outage is older than a day, got %v", got.Availability24h)
+	}
+	// Younger than the window: over the tracked time only.
+	var young uptimeTracker
+	young.set(start, false, true)
+	young.set(start.Add(15*time.Minute), true, false)
+	if got := young.report(start.Add(30*time.Minute), false); got.Availability1h != 50 {
+		t.Errorf("expected 50%% over the first half hour, got %v", got.Availability1h)
+	}
+}
+
+// ── DNS re-resolution ────────────────────────────────────────────────────────
+
+func TestHostResolver_FamiliesRotationAndFailover(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	records := []net.IPAddr{{IP: net.ParseIP("127.0.0.2")}, {IP: net.ParseIP("::1")}, {IP: net.ParseIP("127.0.0.1")}}
+	var dialed []string
+	r := newHostResolver("backend.test", &DNSConfig{Families: []string{FamilyIPv4}}, func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		if !strings.HasPrefix(addr, "127.0.0.1:") {
+			return nil, errors.New("refused")
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	})
+	r.lookup = func(context.Context, string) ([]net.IPAddr, error) { return records, nil }
+
+	u, _ := url.Parse("http://backend.test:" + port)
+	transport := &http.Transport{DialContext: r.DialContext}
+	defer transport.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: transport}).Get(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != u.Host {
+		t.Errorf("the Host header must keep the name, got %q", body)
+	}
+	if want := []string{"127.0.0.2:" + port, "127.0.0.1:" + port}; !slices.Equal(dialed, want) {
+		t.Errorf("expected the IPv4 records only, failing over: want %v, got %v", want, dialed)
+	}
+
+	// Preferred family first, rotating among its records.
+	r.families = []string{FamilyIPv4, FamilyIPv6}
+	if _, _, err := r.refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := r.candidates(t.Context())
+	second, _ := r.candidates(t.Context())
+	if first[0].Equal(second[0]) || first[2].String() != "::1" || second[2].String() != "::1" {
+		t.Errorf("unexpected candidates %v then %v", first, second)
+	}
+	r.families = []string{FamilyIPv6, FamilyIPv4}
+	r.refresh(t.Context())
+	if first, _ := r.candidates(t.Context()); first[0].String() != "::1" {
+		t.Errorf("expected IPv6 first, got %v", first)
+	}
+
+	records = records[2:]
+	if addrs, changed, err := r.refresh(t.Context()); err != nil || !changed || len(addrs) != 1 {
+		t.Errorf("expected the record change to be reported, got %v %t %v", addrs, changed, err)
+	}
+	if _, changed, _ := r.refresh(t.Context()); changed {
+		t.Error("the same records must not count as a change")
+	}
+	if newHostResolver("10.0.0.1", &DNSConfig{}, nil) != nil {
+		t.Error("IP backends need no resolver")
+	}
+}
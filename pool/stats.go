@@ -0,0 +1,109 @@
+package pool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the latency histogram; a last,
+// implicit bucket holds everything slower.
+var latencyBuckets = [...]time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// backendStats are the lifetime counters of a backend. Everything is atomic:
+// they are updated on the request path and read by the admin API.
+type backendStats struct {
+	requests  int64
+	failures  int64
+	bytesIn   int64
+	bytesOut  int64
+	histogram [len(latencyBuckets) + 1]int64
+}
+
+// RequestResult is the outcome of one request (or TCP connection) handled
+// by a backend.
+type RequestResult struct {
+	Latency  time.Duration
+	BytesIn  int64 // request body sent to the backend
+	BytesOut int64 // response body received from it
+	Failed   bool  // transport error or 5xx
+}
+
+// BackendStats is a snapshot of a backend's counters. Percentiles are
+// estimated from the histogram: each is the upper bound of its bucket.
+type BackendStats struct {
+	Requests  int64   `json:"requests"`
+	Successes int64   `json:"successes"`
+	Failures  int64   `json:"failures"`
+	BytesIn   int64   `json:"bytes_in"`
+	BytesOut  int64   `json:"bytes_out"`
+	P50       float64 `json:"latency_p50_ms"`
+	P90       float64 `json:"latency_p90_ms"`
+	P99       float64 `json:"latency_p99_ms"`
+}
+
+// RecordRequest adds one request to the backend's statistics.
+func (b *Backend) RecordRequest(r RequestResult) {
+	s := &b.stats
+	atomic.AddInt64(&s.requests, 1)
+	if r.Failed {
+		atomic.AddInt64(&s.failures, 1)
+	}
+	atomic.AddInt64(&s.bytesIn, r.BytesIn)
+	atomic.AddInt64(&s.bytesOut, r.BytesOut)
+	i := 0
+	for i < len(latencyBuckets) && r.Latency > latencyBuckets[i] {
+		i++
+	}
+	atomic.AddInt64(&s.histogram[i], 1)
+}
+
+// Stats returns a snapshot of the backend's statistics.
+func (b *Backend) Stats() BackendStats {
+	s := &b.stats
+	out := BackendStats{
+		Requests: atomic.LoadInt64(&s.requests),
+		Failures: atomic.LoadInt64(&s.failures),
+		BytesIn:  atomic.LoadInt64(&s.bytesIn),
+		BytesOut: atomic.LoadInt64(&s.bytesOut),
+	}
+	out.Successes = out.Requests - out.Failures
+
+	var counts [len(latencyBuckets) + 1]int64
+	var total int64
+	for i := range counts {
+		counts[i] = atomic.LoadInt64(&s.histogram[i])
+		total += counts[i]
+	}
+	out.P50 = bucketPercentile(counts[:], total, 0.50)
+	out.P90 = bucketPercentile(counts[:], total, 0.90)
+	out.P99 = bucketPercentile(counts[:], total, 0.99)
+	return out
+}
+
+// bucketPercentile returns, in milliseconds, the upper bound of the bucket
+// holding the p-th observation. The open last bucket reports the largest
+// finite bound.
+func bucketPercentile(counts []int64, total int64, p float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	rank := int64(p*float64(total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range counts {
+		seen += n
+		if seen >= rank {
+			if i >= len(latencyBuckets) {
+				i = len(latencyBuckets) - 1
+			}
+			return float64(latencyBuckets[i]) / float64(time.Millisecond)
+		}
+	}
+	return float64(latencyBuckets[len(latencyBuckets)-1]) / float64(time.Millisecond)
+}
@@ -0,0 +1,179 @@
+package pool
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Strategy selects the backend that should serve the next request.
+// Next is called with the pool's read lock held: implementations must not
+// retain or modify the slice, and must only return backends for which
+// IsAvailable is true (or nil).
+// Any per-strategy state (counters, ...) lives in the implementation and must
+// be safe for concurrent use.
+type Strategy interface {
+	Next(backends []*Backend) *Backend
+}
+
+// Previewer is implemented by the strategies that can tell which backend
+// Next would return without advancing their state (counters, weights), for
+// dry runs. The random strategies, p2c and bandit, cannot.
+type Previewer interface {
+	Peek(backends []*Backend) *Backend
+}
+
+// forgetter is implemented by the strategies keeping per-backend state, so
+// the pool can drop it when a backend leaves.
+type forgetter interface {
+	forget(b *Backend)
+}
+
+// StrategyFactory returns a fresh Strategy instance; each ServerPool gets its own.
+type StrategyFactory func() Strategy
+
+var (
+	registryMux sync.RWMutex
+	registry    = map[string]StrategyFactory{}
+)
+
+func init() {
+	RegisterStrategy("round-robin", func() Strategy { return &roundRobin{} })
+	RegisterStrategy("least-connections", func() Strategy { return &leastConnections{} })
+	RegisterStrategy("least-latency", func() Strategy { return &leastLatency{} })
+	RegisterStrategy("p2c", func() Strategy { return powerOfTwoChoices{} })
+	RegisterStrategy("bandit", func() Strategy { return newBandit() }) // experimental
+	RegisterStrategy("weighted-round-robin", func() Strategy { return newWeightedRoundRobin() })
+	RegisterStrategy("weighted-least-connections", func() Strategy { return &weightedLeastConnections{} })
+}
+
+// RegisterStrategy makes a load-balancing algorithm available under name.
+// Like database/sql.Register, it panics if name is empty, factory is nil or
+// the name is already taken, since that is always a programming error.
+func RegisterStrategy(name string, factory StrategyFactory) {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	if name == "" || factory == nil {
+		panic("pool: RegisterStrategy called with empty name or nil factory")
+	}
+	if _, dup := registry[name]; dup {
+		panic("pool: RegisterStrategy called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// NewStrategy instantiates the registered strategy called name.
+func NewStrategy(name string) (Strategy, error) {
+	registryMux.RLock()
+	factory, ok := registry[name]
+	registryMux.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q (available: %v)", name, Strategies())
+	}
+	return factory(), nil
+}
+
+// Strategies returns the sorted names of all registered strategies.
+func Strategies() []string {
+	registryMux.RLock()
+	defer registryMux.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// roundRobin cycles through alive backends.
+type roundRobin struct {
+	current uint64 // atomic counter
+}
+
+func (rr *roundRobin) Next(backends []*Backend) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+	return rr.pick(backends, atomic.AddUint64(&rr.current, 1)-1)
+}
+
+func (rr *roundRobin) Peek(backends []*Backend) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+	return rr.pick(backends, atomic.LoadUint64(&rr.current))
+}
+
+// pick returns the first available backend from the turn-th one on.
+func (rr *roundRobin) pick(backends []*Backend, turn uint64) *Backend {
+	length := len(backends)
+	start := turn % uint64(length)
+	for i := 0; i < length; i++ {
+		idx := (start + uint64(i)) % uint64(length)
+		if backends[idx].IsAvailable() {
+			return backends[idx]
+		}
+	}
+	return nil
+}
+
+// leastConnections picks the alive backend with the fewest in-flight requests.
+// Ties are broken by rotating through the tied backends, so sequential traffic
+// (where every backend sits at 0) is still spread instead of always hitting
+// the first one.
+type leastConnections struct {
+	tieBreaker uint64 // atomic counter
+}
+
+func (lc *leastConnections) Next(backends []*Backend) *Backend {
+	return lc.pick(backends, func() uint64 { return atomic.AddUint64(&lc.tieBreaker, 1) - 1 })
+}
+
+func (lc *leastConnections) Peek(backends []*Backend) *Backend {
+	return lc.pick(backends, func() uint64 { return atomic.LoadUint64(&lc.tieBreaker) })
+}
+
+// pick returns the turn-th backend among those with the fewest requests,
+// turn only being taken when there is a tie to break.
+func (lc *leastConnections) pick(backends []*Backend, turn func() uint64) *Backend {
+	minConns := int64(math.MaxInt64)
+	ties := 0
+	for _, b := range backends {
+		if !b.IsAvailable() {
+			continue
+		}
+		conns := atomic.LoadInt64(&b.CurrentConns)
+		if conns < minConns {
+			minConns = conns
+			ties = 1
+		} else if conns == minConns {
+			ties++
+		}
+	}
+	if ties == 0 {
+		return nil
+	}
+
+	// Second pass: return the k-th backend among those at the minimum.
+	k := int(turn() % uint64(ties))
+	for _, b := range backends {
+		if b.IsAvailable() && atomic.LoadInt64(&b.CurrentConns) == minConns {
+			if k == 0 {
+				return b
+			}
+			k--
+		}
+	}
+	// Counters moved between the two passes; the first pass winner is gone,
+	// fall back to a plain scan.
+	var best *Backend
+	minConns = math.MaxInt64
+	for _, b := range backends {
+		if conns := atomic.LoadInt64(&b.CurrentConns); b.IsAvailable() && conns < minConns {
+			best, minConns = b, conns
+		}
+	}
+	return best
+}
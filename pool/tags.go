@@ -0,0 +1,65 @@
+package pool
+
+import "maps"
+
+// SetTags replaces the backend's metadata, e.g. {"region": "eu-west",
+// "version": "v2"}, which routes use to filter or prefer backends.
+func (b *Backend) SetTags(tags map[string]string) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.tags = maps.Clone(tags)
+}
+
+// Tags returns a copy of the backend's metadata; nil when it has none.
+func (b *Backend) Tags() map[string]string {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return maps.Clone(b.tags)
+}
+
+// HasTags reports whether the backend carries every key of want with the
+// same value. Any backend has an empty set.
+func (b *Backend) HasTags(want map[string]string) bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return MatchTags(b.tags, want)
+}
+
+// MatchTags reports whether have holds every key of want with the same
+// value.
+func MatchTags(have, want map[string]string) bool {
+	for k, v := range want {
+		if got, ok := have[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// TagPolicy narrows the backends a pool's strategy chooses from by their
+// tags. The zero value leaves them all.
+type TagPolicy struct {
+	Require map[string]string // only the backends carrying all of these
+	Prefer  map[string]string // among them, those carrying all of these while one is available
+}
+
+// candidates returns the backends the strategy may pick under p.
+func (p TagPolicy) candidates(backends []*Backend) []*Backend {
+	if len(p.Require) == 0 && len(p.Prefer) == 0 {
+		return backends
+	}
+	var required, preferred []*Backend
+	for _, b := range backends {
+		if !b.HasTags(p.Require) {
+			continue
+		}
+		required = append(required, b)
+		if len(p.Prefer) > 0 && b.HasTags(p.Prefer) && b.IsAvailable() {
+			preferred = append(preferred, b)
+		}
+	}
+	if len(preferred) > 0 {
+		return preferred
+	}
+	return required
+}
@@ -0,0 +1,127 @@
+package pool
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"time"
+
+	"reverse-proxy/proxyproto"
+)
+
+// TransportConfig tunes the long-lived *http.Transport each Backend gets when
+// it is added to a ServerPool. Zero values keep http.DefaultTransport's settings.
+type TransportConfig struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	TLSHandshakeTimeout time.Duration
+	InsecureSkipVerify  bool // only for self-signed dev backends
+
+	// TLS is the client TLS config of https:// backends: trusted CAs,
+	// client certificate for mutual TLS, server name. nil uses the system
+	// roots.
+	TLS *tls.Config
+	// BackendTLS replaces TLS for the backends at these host:port.
+	BackendTLS map[string]*tls.Config
+
+	// H2C speaks HTTP/2 without TLS (prior knowledge) to http:// backends,
+	// as plaintext gRPC servers expect. https:// backends negotiate HTTP/2
+	// through ALPN either way.
+	H2C bool
+
+	// ProxyProtocol (1 or 2) starts every backend connection with a PROXY
+	// protocol header carrying the client address, for backends that expect
+	// it. Keep-alives are disabled: a connection describes a single client.
+	ProxyProtocol int
+
+	// DNS re-resolves the backends configured by host name, see DNSConfig.
+	// nil leaves resolution to each new connection's dial.
+	DNS *DNSConfig
+}
+
+// TLSConfig returns the client TLS config of the backend at u (nil for any
+// backend), or nil for Go's defaults.
+func (c TransportConfig) TLSConfig(u *url.URL) *tls.Config {
+	cfg := c.TLS
+	if u != nil {
+		if backend, ok := c.BackendTLS[u.Host]; ok {
+			cfg = backend
+		}
+	}
+	if !c.InsecureSkipVerify {
+		return cfg
+	}
+	if cfg == nil {
+		return &tls.Config{InsecureSkipVerify: true}
+	}
+	cfg = cfg.Clone()
+	cfg.InsecureSkipVerify = true
+	return cfg
+}
+
+// NewTransport builds a dedicated transport from the config. Each backend owns
+// its transport so idle connections are pooled per upstream and survive across
+// requests instead of being rebuilt per attempt.
+func (c TransportConfig) NewTransport() *http.Transport {
+	return c.newTransport(c.TLSConfig(nil))
+}
+
+// NewBackendTransport is NewTransport with the TLS config of the backend at
+// u, see BackendTLS.
+func (c TransportConfig) NewBackendTransport(u *url.URL) *http.Transport {
+	t, _ := c.newBackendTransport(u)
+	return t
+}
+
+// newBackendTransport also returns the resolver dialing u's host, nil
+// without DNS or for an IP.
+func (c TransportConfig) newBackendTransport(u *url.URL) (*http.Transport, *hostResolver) {
+	t := c.newTransport(c.TLSConfig(u))
+	if u.Scheme == SchemeUnix {
+		socket, _ := UnixSocket(u)
+		t.DialContext = UnixDialContext(socket)
+		if c.ProxyProtocol > 0 {
+			t.DialContext = proxyproto.DialContext(c.ProxyProtocol, t.DialContext)
+		}
+		return t, nil
+	}
+	// Each address is dialed like the host would have been, PROXY protocol
+	// header included.
+	resolver := newHostResolver(u.Hostname(), c.DNS, t.DialContext)
+	if resolver != nil {
+		t.DialContext = resolver.DialContext
+	}
+	return t, resolver
+}
+
+func (c TransportConfig) newTransport(tlsConfig *tls.Config) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if c.MaxIdleConns > 0 {
+		t.MaxIdleConns = c.MaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+	if c.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = c.IdleConnTimeout
+	}
+	if c.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = c.TLSHandshakeTimeout
+	}
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig.Clone()
+	}
+	if c.ProxyProtocol > 0 {
+		t.DisableKeepAlives = true
+		t.DialContext = proxyproto.DialContext(c.ProxyProtocol, t.DialContext)
+	}
+	if c.H2C {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		t.Protocols = protocols
+	}
+	return t
+}
@@ -0,0 +1,61 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// SchemeUnix is the scheme of the backends served over a Unix domain
+// socket: unix:///var/run/app.sock, or unix:///var/run/app.sock:/api to
+// prefix the request paths with /api, like the path of an http:// URL.
+const SchemeUnix = "unix"
+
+// ParseBackendURL parses a backend URL: unix:// ones need a socket path,
+// the others a host.
+func ParseBackendURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || !validBackendURL(u) {
+		return nil, fmt.Errorf("invalid backend URL: %s", raw)
+	}
+	return u, nil
+}
+
+func validBackendURL(u *url.URL) bool {
+	if u == nil {
+		return false
+	}
+	if u.Scheme == SchemeUnix {
+		socket, _ := UnixSocket(u)
+		return u.Host == "" && strings.HasPrefix(socket, "/")
+	}
+	return u.Host != ""
+}
+
+// UnixSocket splits the path of a unix:// URL into the socket path and the
+// HTTP path prefix.
+func UnixSocket(u *url.URL) (socket, httpPath string) {
+	socket, httpPath, _ = strings.Cut(u.Path, ":")
+	return socket, httpPath
+}
+
+// HTTPTarget is the URL the requests to the backend at u are sent to: u
+// itself, or for a Unix socket an http://localhost URL with its HTTP path,
+// dialed through the socket by the backend's transport.
+func HTTPTarget(u *url.URL) *url.URL {
+	if u.Scheme != SchemeUnix {
+		return u
+	}
+	_, httpPath := UnixSocket(u)
+	return &url.URL{Scheme: "http", Host: "localhost", Path: httpPath}
+}
+
+// UnixDialContext dials the socket whatever address is asked for.
+func UnixDialContext(socket string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return d.DialContext(ctx, "unix", socket)
+	}
+}
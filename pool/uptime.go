@@ -0,0 +1,122 @@
+package pool
+
+import "time"
+
+// uptimeWindow is the longest rolling availability window; older changes
+// of the alive flag are forgotten.
+const uptimeWindow = 24 * time.Hour
+
+// uptimeTracker accumulates the time a backend spent up and down, from the
+// changes of its alive flag. It is guarded by Backend.mux.
+type uptimeTracker struct {
+	since    time.Time     // first SetAlive: tracking starts there
+	changed  time.Time     // last change of the flag
+	up, down time.Duration // accumulated until changed
+	changes  []aliveChange // over the last uptimeWindow, plus the one before
+}
+
+type aliveChange struct {
+	at    time.Time
+	alive bool
+}
+
+// Uptime is the availability of a backend since it joined the pool, as its
+// health checks and proxy errors saw it. Maintenance and outlier ejections
+// do not count as downtime.
+type Uptime struct {
+	Since       time.Time `json:"since"`
+	UpSeconds   float64   `json:"up_seconds"`
+	DownSeconds float64   `json:"down_seconds"`
+	// Rolling availability in percent over the last hour and day, or over
+	// the time since Since when shorter.
+	Availability1h  float64 `json:"availability_1h_percent"`
+	Availability24h float64 `json:"availability_24h_percent"`
+}
+
+// set records the alive flag at now; was is its previous value.
+func (u *uptimeTracker) set(now time.Time, was, alive bool) {
+	if u.since.IsZero() {
+		u.since, u.changed = now, now
+		u.changes = []aliveChange{{now, alive}}
+		return
+	}
+	if was == alive {
+		return
+	}
+	u.add(was, now.Sub(u.changed))
+	u.changed = now
+	u.changes = append(u.changes, aliveChange{now, alive})
+	// Keep the last change before the window: it gives the state at its
+	// start.
+	cutoff := now.Add(-uptimeWindow)
+	drop := 0
+	for drop+1 < len(u.changes) && !u.changes[drop+1].at.After(cutoff) {
+		drop++
+	}
+	u.changes = u.changes[drop:]
+}
+
+func (u *uptimeTracker) add(alive bool, d time.Duration) {
+	if alive {
+		u.up += d
+	} else {
+		u.down += d
+	}
+}
+
+// report computes the Uptime at now, alive being the current flag.
+func (u *uptimeTracker) report(now time.Time, alive bool) Uptime {
+	if u.since.IsZero() {
+		return Uptime{}
+	}
+	up, down := u.up, u.down
+	if alive {
+		up += now.Sub(u.changed)
+	} else {
+		down += now.Sub(u.changed)
+	}
+	return Uptime{
+		Since:           u.since,
+		UpSeconds:       up.Seconds(),
+		DownSeconds:     down.Seconds(),
+		Availability1h:  u.availability(now, time.Hour),
+		Availability24h: u.availability(now, 24*time.Hour),
+	}
+}
+
+// availability is the percentage of the window ending at now spent up.
+func (u *uptimeTracker) availability(now time.Time, window time.Duration) float64 {
+	start := now.Add(-window)
+	if start.Before(u.since) {
+		start = u.since
+	}
+	total := now.Sub(start)
+	if total <= 0 {
+		if u.changes[len(u.changes)-1].alive {
+			return 100
+		}
+		return 0
+	}
+	var up time.Duration
+	for i, c := range u.changes {
+		end := now
+		if i+1 < len(u.changes) {
+			end = u.changes[i+1].at
+		}
+		from := c.at
+		if from.Before(start) {
+			from = start
+		}
+		if c.alive && end.After(from) {
+			up += end.Sub(from)
+		}
+	}
+	return 100 * float64(up) / float64(total)
+}
+
+// Uptime reports how long the backend has been up and down.
+func (b *Backend) Uptime() Uptime {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.uptime.report(time.Now(), b.alive)
+}
@@ -0,0 +1,63 @@
+package pool
+
+import (
+	"math/rand"
+	"time"
+)
+
+// StartWarmup ramps the backend's share of traffic from 0% to 100% over
+// window, starting at from. A zero window cancels any ramp in progress.
+func (b *Backend) StartWarmup(from time.Time, window time.Duration) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.warmStart, b.warmWindow = from, window
+}
+
+// TrafficShare returns the fraction of its normal traffic the backend should
+// currently receive: 1 outside a warm-up, rising linearly during one.
+func (b *Backend) TrafficShare() float64 {
+	b.mux.RLock()
+	start, window := b.warmStart, b.warmWindow
+	b.mux.RUnlock()
+	if window <= 0 {
+		return 1
+	}
+	elapsed := time.Since(start)
+	if elapsed >= window {
+		return 1
+	}
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(elapsed) / float64(window)
+}
+
+// admit decides whether the backend takes part in the next pick, given its
+// current share.
+func (b *Backend) admit() bool {
+	share := b.TrafficShare()
+	return share >= 1 || rand.Float64() < share
+}
+
+// admitted returns backends without those warming up that are turned away
+// from the next pick, or backends itself when none was.
+func admitted(backends []*Backend) []*Backend {
+	for i, b := range backends {
+		if !b.admit() {
+			return admittedFrom(backends, i)
+		}
+	}
+	return backends
+}
+
+// admittedFrom is admitted once backends[skip] was turned away.
+func admittedFrom(backends []*Backend, skip int) []*Backend {
+	out := make([]*Backend, 0, len(backends)-1)
+	out = append(out, backends[:skip]...)
+	for _, b := range backends[skip+1:] {
+		if b.admit() {
+			out = append(out, b)
+		}
+	}
+	return out
+}
@@ -0,0 +1,160 @@
+package pool
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultWeight is the weight of a backend whose weight was never set.
+const DefaultWeight = 1
+
+// SetWeight sets the backend's share of traffic relative to the other
+// backends of its pool, under the weighted strategies. 0 takes it out of
+// their rotation, e.g. to drain it gradually before maintenance.
+func (b *Backend) SetWeight(weight int) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.weight, b.weightSet = weight, true
+}
+
+// Weight returns the backend's weight, DefaultWeight unless set.
+func (b *Backend) Weight() int {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	if !b.weightSet {
+		return DefaultWeight
+	}
+	return b.weight
+}
+
+// SetBackendWeight changes the weight of the backend matching the given URL,
+// taking effect on the next request. It returns false if no such backend
+// exists.
+func (s *ServerPool) SetBackendWeight(u *url.URL, weight int) bool {
+	defer s.notify() // after the unlock below
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, b := range s.Backends {
+		if b.URL.String() == u.String() {
+			if b.Weight() != weight {
+				b.SetWeight(weight)
+				s.publish(Event{Type: EventWeighted, ID: b.ID(), URL: b.URL.String(), Detail: fmt.Sprintf("weight %d", weight)})
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// weightedRoundRobin spreads requests in proportion to the backends'
+// weights, interleaved rather than in bursts: nginx's smooth weighted
+// round-robin. Backends of weight 0 only get traffic when no weighted backend
+// is available.
+type weightedRoundRobin struct {
+	mux      sync.Mutex
+	current  map[*Backend]int
+	fallback roundRobin
+}
+
+func newWeightedRoundRobin() *weightedRoundRobin {
+	return &weightedRoundRobin{current: make(map[*Backend]int)}
+}
+
+func (w *weightedRoundRobin) Next(backends []*Backend) *Backend {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	var best *Backend
+	total := 0
+	for _, b := range backends {
+		weight := b.Weight()
+		if weight <= 0 || !b.IsAvailable() {
+			continue
+		}
+		w.current[b] += weight
+		total += weight
+		if best == nil || w.current[b] > w.current[best] {
+			best = b
+		}
+	}
+	if best == nil {
+		return w.fallback.Next(backends)
+	}
+	w.current[best] -= total
+	return best
+}
+
+// Peek returns the backend Next would pick, leaving the current weights as
+// they are.
+func (w *weightedRoundRobin) Peek(backends []*Backend) *Backend {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	var best *Backend
+	for _, b := range backends {
+		weight := b.Weight()
+		if weight <= 0 || !b.IsAvailable() {
+			continue
+		}
+		if best == nil || w.current[b]+weight > w.current[best]+best.Weight() {
+			best = b
+		}
+	}
+	if best == nil {
+		return w.fallback.Peek(backends)
+	}
+	return best
+}
+
+// forget drops the state of a backend that left the pool. It is not pruned
+// from the backends Next is given, which may be a subset of the pool (tags,
+// backends warming up).
+func (w *weightedRoundRobin) forget(b *Backend) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	delete(w.current, b)
+}
+
+// weightedLeastConnections picks the available backend with the fewest
+// in-flight requests per unit of weight, so a backend of weight 2 carries
+// twice the concurrent load of one of weight 1. Backends of weight 0 only get
+// traffic when no weighted backend is available.
+type weightedLeastConnections struct {
+	fallback leastConnections
+}
+
+func (w *weightedLeastConnections) Next(backends []*Backend) *Backend {
+	if best := w.lightest(backends); best != nil {
+		return best
+	}
+	return w.fallback.Next(backends)
+}
+
+func (w *weightedLeastConnections) Peek(backends []*Backend) *Backend {
+	if best := w.lightest(backends); best != nil {
+		return best
+	}
+	return w.fallback.Peek(backends)
+}
+
+// lightest returns the weighted backend with the fewest requests per unit
+// of weight, or nil if none is available.
+func (w *weightedLeastConnections) lightest(backends []*Backend) *Backend {
+	var best *Backend
+	var bestScore float64
+	for _, b := range backends {
+		weight := b.Weight()
+		if weight <= 0 || !b.IsAvailable() {
+			continue
+		}
+		// +1 counts the request being placed: among idle backends, the
+		// heaviest wins.
+		score := float64(atomic.LoadInt64(&b.CurrentConns)+1) / float64(weight)
+		if best == nil || score < bestScore {
+			best, bestScore = b, score
+		}
+	}
+	return best
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"reverse-proxy/limit"
+	"reverse-proxy/pool"
+	"slices"
+)
+
+// ClientClass is a set of clients sharing one bandwidth limit, e.g. the
+// batch jobs of a partner: the requests from Clients carrying Header. An
+// empty criterion matches every request.
+type ClientClass struct {
+	Name        string
+	Clients     *limit.IPFilter // allowlist of the members' IPs; nil = any client
+	Header      string          // canonical header name; "" = no header check
+	HeaderValue string
+	Routes      []string // routes where the limit applies; empty = every route
+	Bandwidth   *limit.Bandwidth
+}
+
+func (c *ClientClass) matches(route string, r *http.Request) bool {
+	if len(c.Routes) > 0 && !slices.Contains(c.Routes, route) {
+		return false
+	}
+	if c.Header != "" && r.Header.Get(c.Header) != c.HeaderValue {
+		return false
+	}
+	if c.Clients != nil {
+		if ok, _ := c.Clients.Check(net.ParseIP(clientIP(r))); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// throttleChunk is the most a throttled writer sends at once, so a large
+// buffered body goes out at the allowed pace rather than in one burst.
+const throttleChunk = 32 << 10
+
+// throttle returns w limited by the bandwidth of the route, of backend and
+// of the client classes r belongs to, or w itself when none applies.
+func throttle(w http.ResponseWriter, r *http.Request, backend *pool.Backend, opts Options) http.ResponseWriter {
+	var limits []*limit.Bandwidth
+	if opts.Bandwidth != nil {
+		limits = append(limits, opts.Bandwidth)
+	}
+	if b := opts.BackendBandwidth[backend.URL.String()]; b != nil {
+		limits = append(limits, b)
+	}
+	for _, c := range opts.ClientClasses {
+		if c.matches(opts.Route, r) {
+			limits = append(limits, c.Bandwidth)
+		}
+	}
+	if len(limits) == 0 {
+		return w
+	}
+	chunk := throttleChunk
+	for _, l := range limits {
+		chunk = min(chunk, int(max(l.Rate()/10, 1)))
+	}
+	return &throttledWriter{ResponseWriter: w, ctx: r.Context(), limits: limits, chunk: chunk}
+}
+
+// throttledWriter writes the response body at the pace its limits allow.
+// It gives up when the client goes away.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	limits []*limit.Bandwidth
+	chunk  int
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), t.chunk)
+		for _, l := range t.limits {
+			if err := l.WaitN(t.ctx, n); err != nil {
+				return written, err
+			}
+		}
+		m, err := t.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Unwrap lets http.ResponseController flush the underlying writer.
+func (t *throttledWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
@@ -0,0 +1,129 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"reverse-proxy/pool"
+	"reverse-proxy/proxy"
+)
+
+// Benchmarks for the forwarding hot path. Backends answer immediately (no
+// injected latency) so the numbers reflect proxy overhead only. Run with:
+//   go test -bench=. -benchmem ./proxy/...
+
+// benchPool starts n instant backends and returns a pool pointing at them
+// plus a cleanup func that closes every server.
+func benchPool(b *testing.B, strategy string, n int) (*pool.ServerPool, func()) {
+	b.Helper()
+	sp := &pool.ServerPool{Strategy: strategy}
+	servers := make([]*httptest.Server, 0, n)
+	for i := 0; i < n; i++ {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+		servers = append(servers, srv)
+
+		u, _ := url.Parse(srv.URL)
+		backend := &pool.Backend{URL: u}
+		backend.SetAlive(true)
+		sp.AddBackend(backend)
+	}
+	return sp, func() {
+		for _, srv := range servers {
+			srv.Close()
+		}
+	}
+}
+
+func benchmarkHandler(b *testing.B, strategy string) {
+	sp, cleanup := benchPool(b, strategy, 3)
+	defer cleanup()
+
+	handler := proxy.Handler(sp, 5*time.Second)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("unexpected status %d", rec.Code)
+		}
+	}
+}
+
+func benchmarkHandlerParallel(b *testing.B, strategy string) {
+	sp, cleanup := benchPool(b, strategy, 3)
+	defer cleanup()
+
+	handler := proxy.Handler(sp, 5*time.Second)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != http.StatusOK {
+				b.Errorf("unexpected status %d", rec.Code)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkHandler_RoundRobin(b *testing.B) { benchmarkHandler(b, "round-robin") }
+
+func BenchmarkHandler_LeastConnections(b *testing.B) { benchmarkHandler(b, "least-connections") }
+
+func BenchmarkHandler_RoundRobin_Parallel(b *testing.B) {
+	benchmarkHandlerParallel(b, "round-robin")
+}
+
+func BenchmarkHandler_LeastConnections_Parallel(b *testing.B) {
+	benchmarkHandlerParallel(b, "least-connections")
+}
+
+// benchmarkStreaming streams a 256 KiB body through the proxy, with or
+// without a buffer pool, to compare the allocations per request.
+func benchmarkStreaming(b *testing.B, buffers *proxy.BufferPool) {
+	body := make([]byte, 256<<10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(body)
+	}))
+	defer srv.Close()
+	sp := &pool.ServerPool{Strategy: "round-robin"}
+	u, _ := url.Parse(srv.URL)
+	backend := &pool.Backend{URL: u}
+	backend.SetAlive(true)
+	sp.AddBackend(backend)
+
+	handler := proxy.NewHandler(sp, proxy.Options{Timeout: 5 * time.Second, FlushInterval: -1, BufferPool: buffers})
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			handler(discardWriter{header: http.Header{}}, req)
+		}
+	})
+}
+
+// discardWriter is a ResponseWriter dropping the body, so that the
+// benchmarks measure the proxy's allocations rather than a recorder's.
+type discardWriter struct{ header http.Header }
+
+func (d discardWriter) Header() http.Header         { return d.header }
+func (d discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (d discardWriter) WriteHeader(int)             {}
+
+func BenchmarkHandler_Streaming_NoBufferPool(b *testing.B) { benchmarkStreaming(b, nil) }
+
+func BenchmarkHandler_Streaming_BufferPool(b *testing.B) {
+	benchmarkStreaming(b, proxy.NewBufferPool(proxy.DefaultBufferSize))
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import "sync"
+
+// DefaultBufferSize is the size of the buffers of a BufferPool, unless
+// NewBufferPool is given another one: the one httputil.ReverseProxy
+// allocates for each response without a pool.
+const DefaultBufferSize = 32 << 10
+
+// BufferPool recycles the buffers copying response bodies from the backends
+// to the clients, instead of allocating one per response. It is an
+// httputil.BufferPool, safe for concurrent use.
+type BufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// NewBufferPool returns a pool of buffers of size bytes; DefaultBufferSize
+// when size is not positive. Larger buffers mean fewer reads and writes for
+// large bodies, smaller ones less memory per response in flight.
+func NewBufferPool(size int) *BufferPool {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	return &BufferPool{size: size}
+}
+
+// Size returns the size of the buffers.
+func (p *BufferPool) Size() int {
+	return p.size
+}
+
+func (p *BufferPool) Get() []byte {
+	if b, ok := p.pool.Get().(*[]byte); ok {
+		return *b
+	}
+	return make([]byte, p.size)
+}
+
+func (p *BufferPool) Put(b []byte) {
+	if cap(b) < p.size {
+		return // not one of ours
+	}
+	b = b[:p.size]
+	p.pool.Put(&b)
+}
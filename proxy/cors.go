@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultCORSMethods are allowed when a CORSPolicy lists no methods.
+var defaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// CORSPolicy answers cross-origin requests at the proxy: preflights never
+// reach the backends, and actual responses get the policy's headers in place
+// of whatever CORS headers the backend sent.
+type CORSPolicy struct {
+	Origins          []string `json:"allowed_origins"`   // "*", exact origins, or "https://*.example.com"
+	Methods          []string `json:"allowed_methods"`   // defaults to GET, HEAD, POST, PUT, PATCH, DELETE
+	Headers          []string `json:"allowed_headers"`   // request headers allowed; empty or "*" allows what the preflight asks for
+	ExposeHeaders    []string `json:"expose_headers"`    // response headers readable by the page
+	AllowCredentials bool     `json:"allow_credentials"` // cookies/Authorization; the origin is then echoed, never "*"
+	MaxAge           int      `json:"max_age"`           // seconds a preflight may be cached; 0 leaves the browser default
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" when the origin is not allowed.
+func (p *CORSPolicy) allowOrigin(origin string) string {
+	for _, allowed := range p.Origins {
+		switch {
+		case allowed == "*":
+			if p.AllowCredentials {
+				return origin // browsers reject "*" with credentials
+			}
+			return "*"
+		case strings.EqualFold(allowed, origin):
+			return origin
+		case strings.Contains(allowed, "*."):
+			scheme, suffix, _ := strings.Cut(allowed, "*.")
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, "."+suffix) {
+				return origin
+			}
+		}
+	}
+	return ""
+}
+
+func (p *CORSPolicy) methods() []string {
+	if len(p.Methods) == 0 {
+		return defaultCORSMethods
+	}
+	return p.Methods
+}
+
+// preflight answers an OPTIONS preflight: 204 with the allowed methods and
+// headers, or 403 when the origin or method is not allowed.
+func (p *CORSPolicy) preflight(w http.ResponseWriter, r *http.Request) (bool, string) {
+	origin := r.Header.Get("Origin")
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	allowOrigin := p.allowOrigin(origin)
+	if allowOrigin == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return false, "origin " + origin + " not allowed"
+	}
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !slices.ContainsFunc(p.methods(), func(m string) bool { return strings.EqualFold(m, method) }) {
+		w.WriteHeader(http.StatusForbidden)
+		return false, "method " + method + " not allowed"
+	}
+
+	h.Set("Access-Control-Allow-Origin", allowOrigin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(p.methods(), ", "))
+	if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		if len(p.Headers) == 0 || slices.Contains(p.Headers, "*") {
+			h.Set("Access-Control-Allow-Headers", requested)
+		} else {
+			h.Set("Access-Control-Allow-Headers", strings.Join(p.Headers, ", "))
+		}
+	}
+	if p.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(p.MaxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true, ""
+}
+
+// isPreflight tells a CORS preflight from a plain OPTIONS request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// corsWriter replaces the CORS headers of the response, whoever wrote it
+// (backend or proxy error), just before they are sent. Without an origin,
+// the response only gets Vary: Origin.
+type corsWriter struct {
+	http.ResponseWriter
+	policy      *CORSPolicy
+	origin      string // empty for a request without Origin
+	wroteHeader bool
+}
+
+func (w *corsWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= 200 {
+		w.wroteHeader = true
+		h := w.Header()
+		for key := range h {
+			if strings.HasPrefix(key, "Access-Control-") {
+				delete(h, key)
+			}
+		}
+		h.Add("Vary", "Origin")
+		if allowOrigin := w.policy.allowOrigin(w.origin); w.origin != "" && allowOrigin != "" {
+			h.Set("Access-Control-Allow-Origin", allowOrigin)
+			if w.policy.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if len(w.policy.ExposeHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(w.policy.ExposeHeaders, ", "))
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *corsWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer's Flush.
+func (w *corsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
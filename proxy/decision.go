@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Decision records why a request was routed (or rejected) the way it was:
+// the route matched, every check that allowed or denied it, each backend
+// attempt and the final outcome. It is written to the decision log, which is
+// separate from any access log and meant for auditing routing behaviour.
+type Decision struct {
+	Time     time.Time         `json:"time"`
+	Method   string            `json:"method"`
+	Host     string            `json:"host"`
+	Path     string            `json:"path"`
+	ClientIP string            `json:"client_ip"`
+	Route    string            `json:"route,omitempty"`
+	Checks   []DecisionCheck   `json:"checks,omitempty"`
+	Attempts []DecisionAttempt `json:"attempts,omitempty"`
+	Backend  string            `json:"backend,omitempty"` // backend that served the response
+	Status   int               `json:"status"`
+	Duration float64           `json:"duration_ms"`
+
+	mux sync.Mutex
+}
+
+// DecisionCheck is the verdict of one middleware or limit.
+type DecisionCheck struct {
+	Name    string `json:"name"`
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// DecisionAttempt is one try against a backend; Error is empty on success.
+type DecisionAttempt struct {
+	Backend string `json:"backend"`
+	Error   string `json:"error,omitempty"`
+}
+
+type decisionKey struct{}
+
+// DecisionFromContext returns the decision being recorded for the request, or
+// nil when decision logging is off. All Decision methods are nil-safe, so
+// callers can record unconditionally.
+func DecisionFromContext(ctx context.Context) *Decision {
+	d, _ := ctx.Value(decisionKey{}).(*Decision)
+	return d
+}
+
+// SetRoute records the name of the route that matched the request.
+func (d *Decision) SetRoute(name string) {
+	if d == nil {
+		return
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.Route = name
+}
+
+// Check records a middleware verdict.
+func (d *Decision) Check(name string, allowed bool, reason string) {
+	if d == nil {
+		return
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.Checks = append(d.Checks, DecisionCheck{Name: name, Allowed: allowed, Reason: reason})
+}
+
+// Attempt records a backend attempt; err is nil on success.
+func (d *Decision) Attempt(backend string, err error) {
+	if d == nil {
+		return
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	a := DecisionAttempt{Backend: backend}
+	if err != nil {
+		a.Error = err.Error()
+	} else {
+		d.Backend = backend
+	}
+	d.Attempts = append(d.Attempts, a)
+}
+
+// DecisionLog writes one JSON line per request to an io.Writer.
+type DecisionLog struct {
+	mux sync.Mutex
+	enc *json.Encoder
+}
+
+// NewDecisionLog returns a decision log writing to w.
+func NewDecisionLog(w io.Writer) *DecisionLog {
+	return &DecisionLog{enc: json.NewEncoder(w)}
+}
+
+// Middleware starts a Decision for every request, makes it available to the
+// rest of the chain through the request context, and writes it once the
+// response has been sent.
+func (l *DecisionLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := &Decision{
+			Time:     time.Now(),
+			Method:   r.Method,
+			Host:     r.Host,
+			Path:     r.URL.Path,
+			ClientIP: clientIP(r),
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), decisionKey{}, d)))
+
+		d.mux.Lock()
+		d.Status = sw.status
+		d.Duration = float64(time.Since(d.Time).Microseconds()) / 1000
+		d.mux.Unlock()
+		l.write(d)
+	})
+}
+
+func (l *DecisionLog) write(d *Decision) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if err := l.enc.Encode(d); err != nil {
+		log.Printf("Decision log write failed: %v", err)
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusWriter remembers the status code written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader && !informational(code) {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer's Flush.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"text/template"
+)
+
+// errResponseStalled is reported when a backend sent headers but then stopped
+// sending body bytes for longer than Options.ResponseIdleTimeout.
+var errResponseStalled = errors.New("upstream response stalled")
+
+// errBackendAborted is reported for an attempt cancelled by
+// pool.Backend.Abort, e.g. when the backend was removed by force.
+var errBackendAborted = errors.New("backend removed: request cancelled")
+
+// classifyError maps an upstream failure to the status returned to the client:
+// 504 when the per-attempt deadline fired or the body stalled, 502 for
+// connection/protocol errors.
+func classifyError(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errResponseStalled) {
+		return http.StatusGatewayTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+func upstreamMessage(status int) string {
+	if status == http.StatusGatewayTimeout {
+		return "upstream did not respond in time"
+	}
+	return "upstream connection failed"
+}
+
+// ErrorResponder renders the bodies of errors generated by the proxy itself
+// (as opposed to error responses forwarded from a backend).
+// A nil *ErrorResponder writes the classic plain-text http.Error body.
+type ErrorResponder struct {
+	tmpl *template.Template
+}
+
+// errorData is what a custom JSON template can reference.
+// Status is a number, Error and Message are pre-quoted JSON strings.
+type errorData struct {
+	Status  int
+	Error   string
+	Message string
+}
+
+const defaultErrorTemplate = `{"status":{{.Status}},"error":{{.Error}},"message":{{.Message}}}`
+
+// NewErrorResponder builds a JSON error responder. tmpl is a text/template
+// producing the body, e.g. {"error":{"code":{{.Status}},"text":{{.Message}}}};
+// an empty tmpl selects the default {"status":..,"error":..,"message":..} body.
+func NewErrorResponder(tmpl string) (*ErrorResponder, error) {
+	if tmpl == "" {
+		tmpl = defaultErrorTemplate
+	}
+	t, err := template.New("error").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid error template: %w", err)
+	}
+
+	// Render once with sample data so a template producing invalid JSON is
+	// rejected at startup rather than on the first failing request.
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, newErrorData(http.StatusBadGateway, "sample")); err != nil {
+		return nil, fmt.Errorf("invalid error template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("error template does not produce valid JSON: %s", buf.String())
+	}
+	return &ErrorResponder{tmpl: t}, nil
+}
+
+func newErrorData(status int, message string) errorData {
+	return errorData{
+		Status:  status,
+		Error:   jsonString(http.StatusText(status)),
+		Message: jsonString(message),
+	}
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// Write sends a proxy-generated error with the given status.
+func (e *ErrorResponder) Write(w http.ResponseWriter, status int, message string) {
+	if e == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := e.tmpl.Execute(&buf, newErrorData(status, message)); err != nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// UnavailableResponse replaces the generic 503 sent when no backend is
+// available, e.g. with a maintenance page.
+type UnavailableResponse struct {
+	Status      int    // defaults to 503
+	RetryAfter  int    // seconds, sent as Retry-After; 0 omits the header
+	ContentType string // sniffed from Body when empty
+	Body        []byte // nil keeps the body of Options.Errors
+}
+
+// writeUnavailable answers a request that no backend can take.
+func writeUnavailable(w http.ResponseWriter, opts Options, message string) {
+	u := opts.Unavailable
+	if u == nil {
+		opts.Errors.Write(w, http.StatusServiceUnavailable, message)
+		return
+	}
+
+	status := u.Status
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	if u.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(u.RetryAfter))
+	}
+	if u.Body == nil {
+		opts.Errors.Write(w, status, message)
+		return
+	}
+	contentType := u.ContentType
+	switch {
+	case contentType != "":
+	case json.Valid(u.Body):
+		contentType = "application/json"
+	default:
+		contentType = http.DetectContentType(u.Body)
+	}
+	// A cache in front of the proxy must not keep serving the page once the
+	// backends are back.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(u.Body)
+}
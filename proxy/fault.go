@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"reverse-proxy/pool"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrFaultAbort is the error of an attempt aborted by fault injection. The
+// proxy treats it like a connection reset: the backend is marked DOWN and
+// the request retried when it can be.
+var ErrFaultAbort = errors.New("fault injection: connection aborted")
+
+// FaultRule injects faults into a share of the attempts matching its route
+// and backend. The delay comes first and counts against the proxy timeout;
+// then the attempt is aborted, answered with Status, or forwarded.
+type FaultRule struct {
+	Route   string  `json:"route,omitempty"`   // route name; "" = every route
+	Backend string  `json:"backend,omitempty"` // backend URL or ID; "" = every backend
+	Percent float64 `json:"percent"`           // share of the matching attempts, (0, 100]
+	DelayMs int     `json:"delay_ms,omitempty"`
+	Abort   bool    `json:"abort,omitempty"`
+	Status  int     `json:"status,omitempty"` // answered instead of the backend's response
+}
+
+func (r FaultRule) validate() error {
+	switch {
+	case r.Percent <= 0 || r.Percent > 100:
+		return fmt.Errorf("percent must be in (0, 100], got %v", r.Percent)
+	case r.DelayMs < 0:
+		return fmt.Errorf("delay_ms must not be negative")
+	case r.Status != 0 && (r.Status < 200 || r.Status > 599):
+		return fmt.Errorf("invalid status %d", r.Status)
+	case r.Abort && r.Status != 0:
+		return fmt.Errorf("abort and status are exclusive")
+	case r.DelayMs == 0 && !r.Abort && r.Status == 0:
+		return fmt.Errorf("a rule needs delay_ms, abort or status")
+	}
+	return nil
+}
+
+func (r FaultRule) matches(route string, b *pool.Backend) bool {
+	return (r.Route == "" || r.Route == route) &&
+		(r.Backend == "" || r.Backend == b.URL.String() || r.Backend == b.ID())
+}
+
+// FaultInjector holds the fault rules of every route, replaced at runtime
+// through the admin API. The first matching rule whose percentage is drawn
+// applies to an attempt.
+type FaultInjector struct {
+	mux   sync.RWMutex
+	rules []FaultRule
+}
+
+// Rules returns a copy of the current rules.
+func (f *FaultInjector) Rules() []FaultRule {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	return append([]FaultRule{}, f.rules...)
+}
+
+// SetRules replaces the rules; none clears them.
+func (f *FaultInjector) SetRules(rules []FaultRule) error {
+	for i, r := range rules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.rules = append([]FaultRule{}, rules...)
+	return nil
+}
+
+// pick draws the rule applying to an attempt, or nil.
+func (f *FaultInjector) pick(route string, b *pool.Backend) *FaultRule {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	for _, r := range f.rules {
+		if r.matches(route, b) && rand.Float64()*100 < r.Percent {
+			return &r
+		}
+	}
+	return nil
+}
+
+// faultTransport applies the rules to the attempts on one backend.
+type faultTransport struct {
+	next    http.RoundTripper
+	faults  *FaultInjector
+	route   string
+	backend *pool.Backend
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rule := t.faults.pick(t.route, t.backend)
+	if rule == nil {
+		return t.next.RoundTrip(req)
+	}
+	if rule.DelayMs > 0 {
+		timer := time.NewTimer(time.Duration(rule.DelayMs) * time.Millisecond)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+	switch {
+	case rule.Abort:
+		return nil, ErrFaultAbort
+	case rule.Status != 0:
+		body := fmt.Sprintf("fault injection: %d\n", rule.Status)
+		return &http.Response{
+			Status:     fmt.Sprintf("%d %s", rule.Status, http.StatusText(rule.Status)),
+			StatusCode: rule.Status,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header: http.Header{
+				"Content-Type":     {"text/plain; charset=utf-8"},
+				"X-Fault-Injected": {"status"},
+			},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	return t.next.RoundTrip(req)
+}
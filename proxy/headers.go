@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// HeaderRules describes header manipulation applied to a request before it is
+// forwarded or to a response before it is returned. Rules run in the order
+// Remove, Set, Add so a header can be stripped and replaced in one rule set.
+type HeaderRules struct {
+	Add    map[string]string `json:"add"`    // appended, keeping existing values
+	Set    map[string]string `json:"set"`    // replaces any existing values
+	Remove []string          `json:"remove"` // exact names, or a prefix ending in "*" (e.g. "X-Internal-*")
+}
+
+// IsEmpty reports whether the rules would leave headers unchanged.
+func (h HeaderRules) IsEmpty() bool {
+	return len(h.Add) == 0 && len(h.Set) == 0 && len(h.Remove) == 0
+}
+
+// Apply mutates hdr according to the rules.
+func (h HeaderRules) Apply(hdr http.Header) {
+	for _, name := range h.Remove {
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			prefix = http.CanonicalHeaderKey(prefix)
+			for key := range hdr {
+				if strings.HasPrefix(key, prefix) {
+					hdr.Del(key)
+				}
+			}
+			continue
+		}
+		hdr.Del(name)
+	}
+	for name, value := range h.Set {
+		hdr.Set(name, value)
+	}
+	for name, value := range h.Add {
+		hdr.Add(name, value)
+	}
+}
+
+// SecurityHeaders are standard hardening headers added to every proxied
+// response, so each backend does not have to set them. An empty field sends
+// nothing; "off" (in a route's block) drops a header set globally.
+type SecurityHeaders struct {
+	StrictTransportSecurity string `json:"strict_transport_security"` // e.g. "max-age=31536000; includeSubDomains"
+	ContentTypeOptions      string `json:"content_type_options"`      // "nosniff"
+	FrameOptions            string `json:"frame_options"`             // "DENY" or "SAMEORIGIN"
+	ContentSecurityPolicy   string `json:"content_security_policy"`   // e.g. "default-src 'self'"
+	ReferrerPolicy          string `json:"referrer_policy"`           // e.g. "strict-origin-when-cross-origin"
+	// Override replaces the headers a backend sets itself; by default the
+	// backend's own value wins, e.g. a page needing a looser CSP.
+	Override bool `json:"override"`
+}
+
+// headers lists the configured headers by name.
+func (s *SecurityHeaders) headers() [][2]string {
+	return [][2]string{
+		{"Strict-Transport-Security", s.StrictTransportSecurity},
+		{"X-Content-Type-Options", s.ContentTypeOptions},
+		{"X-Frame-Options", s.FrameOptions},
+		{"Content-Security-Policy", s.ContentSecurityPolicy},
+		{"Referrer-Policy", s.ReferrerPolicy},
+	}
+}
+
+// With returns s overridden by the non-empty fields of route, for a route
+// that tunes the global headers. Either may be nil.
+func (s *SecurityHeaders) With(route *SecurityHeaders) *SecurityHeaders {
+	if route == nil {
+		return s
+	}
+	if s == nil {
+		return route
+	}
+	merged := *s
+	for dst, src := range map[*string]string{
+		&merged.StrictTransportSecurity: route.StrictTransportSecurity,
+		&merged.ContentTypeOptions:      route.ContentTypeOptions,
+		&merged.FrameOptions:            route.FrameOptions,
+		&merged.ContentSecurityPolicy:   route.ContentSecurityPolicy,
+		&merged.ReferrerPolicy:          route.ReferrerPolicy,
+	} {
+		if src != "" {
+			*dst = src
+		}
+	}
+	merged.Override = merged.Override || route.Override
+	return &merged
+}
+
+// apply adds the headers to a backend response header; s may be nil.
+func (s *SecurityHeaders) apply(hdr http.Header) {
+	if s == nil {
+		return
+	}
+	for _, h := range s.headers() {
+		name, value := h[0], h[1]
+		if value == "" || value == "off" || (!s.Override && hdr.Get(name) != "") {
+			continue
+		}
+		hdr.Set(name, value)
+	}
+}
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reverse-proxy/pool"
+	"sync/atomic"
+	"time"
+)
+
+// errHedgeLost is the error recorded for the slower of two hedged attempts,
+// cancelled once the other one answered.
+var errHedgeLost = errors.New("hedged attempt cancelled: another backend answered first")
+
+// hedgeable reports whether r may be hedged: a GET or HEAD without a body,
+// on a route with a hedging delay.
+func hedgeable(r *http.Request, opts Options) bool {
+	return opts.HedgeDelay > 0 &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		(r.Body == nil || r.Body == http.NoBody)
+}
+
+// hedgeResult is the outcome of one hedged attempt.
+type hedgeResult struct {
+	id      int
+	backend *pool.Backend
+	aw      *attemptWriter
+	err     error
+	started time.Time
+}
+
+// hedge sends r to first and, if it has not answered within
+// opts.HedgeDelay, to a second backend as well. The first attempt to
+// succeed, or to start streaming its response to the client, wins and the
+// other one is cancelled. Without a winner, it returns the last failure; an
+// earlier one, while the other attempt was still running, goes to failed.
+func hedge(w http.ResponseWriter, r *http.Request, serverPool pool.LoadBalancer, first *pool.Backend, opts Options, failed func(hedgeResult)) hedgeResult {
+	decision := DecisionFromContext(r.Context())
+	gate := &hedgeGate{claimed: make(chan int, 1)}
+	gate.winner.Store(-1)
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+	var backends []*pool.Backend
+	decided := false
+
+	start := func(b *pool.Backend) {
+		ctx, cancel := context.WithCancel(r.Context())
+		res := hedgeResult{id: len(cancels), backend: b, started: time.Now()}
+		cancels = append(cancels, cancel)
+		backends = append(backends, b)
+		out := &gateWriter{ResponseWriter: throttle(w, r, b, opts), gate: gate, id: res.id}
+		go func() {
+			atomic.AddInt64(&b.CurrentConns, 1)
+			defer atomic.AddInt64(&b.CurrentConns, -1)
+			defer func() {
+				// A body cut in the middle, by the backend or by the
+				// cancellation of a loser, makes the ReverseProxy panic.
+				if p := recover(); p != nil {
+					if p != http.ErrAbortHandler {
+						panic(p)
+					}
+					res.aw, res.err = newAttemptWriter(out, opts), io.ErrUnexpectedEOF
+					if ctx.Err() != nil {
+						res.err = errHedgeLost
+					}
+				}
+				results <- res
+			}()
+			res.aw, res.err = attemptBackend(out, r.WithContext(ctx), b, opts)
+			if res.err == nil && !res.aw.committed {
+				gate.claim(res.id)
+			}
+		}()
+	}
+	cancelLosers := func(winner int) {
+		if decided {
+			return
+		}
+		decided = true
+		for i, cancel := range cancels {
+			if i != winner {
+				cancel()
+				decision.Attempt(backends[i].URL.String(), errHedgeLost)
+			}
+		}
+	}
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+
+	start(first)
+	timer := time.NewTimer(opts.HedgeDelay)
+	defer timer.Stop()
+	running := 1
+	for {
+		select {
+		case <-timer.C:
+			if second := otherPeer(serverPool, first); second != nil {
+				start(second)
+				running++
+			}
+		case id := <-gate.claimed:
+			cancelLosers(id)
+		case res := <-results:
+			running--
+			winner := int(gate.winner.Load())
+			switch {
+			case winner == res.id:
+				cancelLosers(winner)
+				return res
+			case winner >= 0:
+				// The loser, done: wait for the winner.
+			case running == 0:
+				return res
+			default:
+				failed(res)
+			}
+		}
+	}
+}
+
+// otherPeer returns a backend other than b, or nil when there is none.
+func otherPeer(serverPool pool.LoadBalancer, b *pool.Backend) *pool.Backend {
+	for range len(serverPool.GetBackends()) {
+		if next := serverPool.GetNextValidPeer(); next != nil && next != b {
+			return next
+		}
+	}
+	return nil
+}
+
+// hedgeGate elects the hedged attempt whose response reaches the client.
+type hedgeGate struct {
+	winner  atomic.Int32 // id of the winning attempt, -1 until there is one
+	claimed chan int     // receives the winner
+}
+
+// claim makes id the winner if there is none yet; it reports whether id is
+// the winner.
+func (g *hedgeGate) claim(id int) bool {
+	if g.winner.CompareAndSwap(-1, int32(id)) {
+		g.claimed <- id
+		return true
+	}
+	return g.winner.Load() == int32(id)
+}
+
+// gateWriter lets the response of a hedged attempt through to the client
+// only if the attempt wins: a streamed response wins by arriving first.
+type gateWriter struct {
+	http.ResponseWriter
+	gate   *hedgeGate
+	id     int
+	header http.Header // for a loser, whose headers go nowhere
+}
+
+func (g *gateWriter) Header() http.Header {
+	if g.gate.claim(g.id) {
+		return g.ResponseWriter.Header()
+	}
+	if g.header == nil {
+		g.header = make(http.Header)
+	}
+	return g.header
+}
+
+func (g *gateWriter) WriteHeader(code int) {
+	if g.gate.claim(g.id) {
+		g.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (g *gateWriter) Write(p []byte) (int, error) {
+	if !g.gate.claim(g.id) {
+		return 0, errHedgeLost
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+func (g *gateWriter) FlushError() error {
+	if !g.gate.claim(g.id) {
+		return errHedgeLost
+	}
+	return http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the client's writer.
+func (g *gateWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
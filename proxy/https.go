@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// HSTSPolicy tells browsers to only ever reach the site over HTTPS
+// (Strict-Transport-Security), so a user typing the bare domain is not
+// exposed to a downgrade before the redirect.
+type HSTSPolicy struct {
+	MaxAge            int  `json:"max_age"` // seconds browsers remember the policy; defaults to one year
+	IncludeSubdomains bool `json:"include_subdomains"`
+	Preload           bool `json:"preload"` // opt in to the browsers' preload lists
+}
+
+// Header is the Strict-Transport-Security value of the policy.
+func (p *HSTSPolicy) Header() string {
+	maxAge := p.MaxAge
+	if maxAge <= 0 {
+		maxAge = 365 * 24 * 60 * 60
+	}
+	value := "max-age=" + strconv.Itoa(maxAge)
+	if p.IncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if p.Preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// Middleware adds the header to every response served over TLS, in place of
+// any the backend sent. Browsers ignore it over plain HTTP, so it is not
+// sent there.
+func (p *HSTSPolicy) Middleware(next http.Handler) http.Handler {
+	value := p.Header()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&hstsWriter{ResponseWriter: w, value: value}, r)
+	})
+}
+
+// hstsWriter sets the header just before the response goes out, once the
+// backend headers have been copied.
+type hstsWriter struct {
+	http.ResponseWriter
+	value string
+	wrote bool
+}
+
+func (w *hstsWriter) WriteHeader(code int) {
+	if !w.wrote && code >= 200 {
+		w.wrote = true
+		w.Header().Set("Strict-Transport-Security", w.value)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *hstsWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer's Flush.
+func (w *hstsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// RedirectToHTTPS answers every request with a 301 to the same host, path
+// and query on the HTTPS port (left out when it is 443).
+func RedirectToHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, "Use HTTPS", http.StatusBadRequest)
+			return
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			host = "[" + host + "]" // IPv6 literal
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"io"
+	"time"
+)
+
+// idleTimeoutBody wraps an upstream response body and calls onStall if no
+// bytes are read for the configured duration. The timer is armed as soon as
+// the headers arrive and re-armed on every successful read.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration, onStall func()) *idleTimeoutBody {
+	return &idleTimeoutBody{
+		ReadCloser: body,
+		timeout:    timeout,
+		timer:      time.AfterFunc(timeout, onStall),
+	}
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"reverse-proxy/auth"
+	"reverse-proxy/limit"
+	"slices"
+)
+
+// Middleware is one step of the proxy pipeline: it may answer the request
+// itself (a rejected check) or hand it, possibly rewritten, to next.
+type Middleware func(next http.Handler) http.Handler
+
+// Stage is a named pipeline step. The names of the built-in stages are
+// listed by Stages; they also key the checks of the decision log.
+type Stage struct {
+	Name       string
+	Middleware Middleware
+}
+
+// Chain wraps h with the stages so that the first one listed sees the
+// request first.
+func Chain(h http.Handler, stages ...Stage) http.Handler {
+	for _, s := range slices.Backward(stages) {
+		h = s.Middleware(h)
+	}
+	return h
+}
+
+// InsertBefore returns stages with s inserted before the stage named
+// before, or appended when there is none.
+func InsertBefore(stages []Stage, before string, s Stage) []Stage {
+	i := slices.IndexFunc(stages, func(st Stage) bool { return st.Name == before })
+	if i < 0 {
+		i = len(stages)
+	}
+	return slices.Insert(slices.Clone(stages), i, s)
+}
+
+// Stages returns the built-in stages enabled by opts, in the order
+// NewHandler runs them: route, cors, ip_filter, client_concurrency, jwt,
+// body_size, concurrency, request_headers and rewrite, followed by
+// opts.Middleware.
+// Programs embedding the proxy can splice their own stages in anywhere and
+// end the chain with Balancer:
+//
+//	stages := proxy.InsertBefore(proxy.Stages(opts), "jwt", proxy.Stage{Name: "tenant", Middleware: tenant})
+//	handler := proxy.Chain(proxy.Balancer(lb, opts), stages...)
+func Stages(opts Options) []Stage {
+	stages := []Stage{{"route", routeStage(opts)}}
+	if opts.CORS != nil {
+		stages = append(stages, Stage{"cors", corsStage(opts)})
+	}
+	if len(opts.IPFilters) > 0 {
+		stages = append(stages, Stage{"ip_filter", ipFilterStage(opts)})
+	}
+	if opts.ClientConcurrency != nil {
+		stages = append(stages, Stage{"client_concurrency", clientConcurrencyStage(opts)})
+	}
+	if opts.JWT != nil {
+		stages = append(stages, Stage{"jwt", jwtStage(opts)})
+	}
+	if opts.MaxBodyBytes > 0 {
+		stages = append(stages, Stage{"body_size", bodySizeStage(opts)})
+	}
+	if opts.Concurrency != nil {
+		stages = append(stages, Stage{"concurrency", concurrencyStage(opts)})
+	}
+	if !opts.RequestHeaders.IsEmpty() {
+		stages = append(stages, Stage{"request_headers", requestHeadersStage(opts)})
+	}
+	if opts.Rewrite != nil {
+		stages = append(stages, Stage{"rewrite", rewriteStage(opts)})
+	}
+	for _, mw := range opts.Middleware {
+		stages = append(stages, Stage{"custom", mw})
+	}
+	return stages
+}
+
+// routeStage names the route in the decision log, even when a later check
+// rejects the request.
+func routeStage(opts Options) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			DecisionFromContext(r.Context()).SetRoute(opts.Route)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func corsStage(opts Options) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Same-origin requests are wrapped too: their response must
+			// carry Vary: Origin, or a cache would serve it cross-origin.
+			origin := r.Header.Get("Origin")
+			if isPreflight(r) {
+				allowed, reason := opts.CORS.preflight(w, r)
+				DecisionFromContext(r.Context()).Check("cors", allowed, reason)
+				return
+			}
+			// Wrapped first, so errors from the checks below carry the
+			// headers too and the page can read them.
+			next.ServeHTTP(&corsWriter{ResponseWriter: w, policy: opts.CORS, origin: origin}, r)
+		})
+	}
+}
+
+func ipFilterStage(opts Options) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !checkIP(w, r, opts.IPFilters, opts.Errors) {
+				return
+			}
+			DecisionFromContext(r.Context()).Check("ip_filter", true, "")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IPGate applies the IP filters ahead of the middlewares that may answer
+// before the route's pipeline runs, like the response cache serving a hit.
+// The ip_filter stage still checks the requests that reach the route.
+type IPGate struct {
+	// Filters returns the filters applying to r: the global one and the
+	// one of the route r matches.
+	Filters func(r *http.Request) []*limit.IPFilter
+	Errors  *ErrorResponder // nil writes plain-text errors
+}
+
+func (g *IPGate) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if checkIP(w, r, g.Filters(r), g.Errors) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// checkIP answers 403 and reports false when a filter denies the client.
+func checkIP(w http.ResponseWriter, r *http.Request, filters []*limit.IPFilter, errs *ErrorResponder) bool {
+	ip := net.ParseIP(clientIP(r))
+	for _, f := range filters {
+		if ok, reason := f.Check(ip); !ok {
+			DecisionFromContext(r.Context()).Check("ip_filter", false, reason)
+			log.Printf("IP filter: rejecting %s %s (%s)", r.Method, r.URL.Path, reason)
+			errs.Write(w, http.StatusForbidden, "access denied")
+			return false
+		}
+	}
+	return true
+}
+
+func clientConcurrencyStage(opts Options) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			decision := DecisionFromContext(r.Context())
+			release, ok := opts.ClientConcurrency.Acquire(net.ParseIP(clientIP(r)))
+			if !ok {
+				decision.Check("client_concurrency", false, "too many requests in flight")
+				log.Printf("Client concurrency limit: rejecting %s %s from %s", r.Method, r.URL.Path, clientIP(r))
+				w.Header().Set("Retry-After", "1")
+				opts.Errors.Write(w, http.StatusTooManyRequests, "too many concurrent requests")
+				return
+			}
+			defer release()
+			decision.Check("client_concurrency", true, "")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func jwtStage(opts Options) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			decision := DecisionFromContext(r.Context())
+			authed, err := opts.JWT.Authenticate(r)
+			if err != nil {
+				decision.Check("jwt", false, err.Error())
+				challenge := `Bearer error="invalid_token"`
+				if errors.Is(err, auth.ErrMissingToken) {
+					challenge = "Bearer" // RFC 6750: no error code without a token
+				}
+				w.Header().Set("WWW-Authenticate", challenge)
+				opts.Errors.Write(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			decision.Check("jwt", true, "")
+			next.ServeHTTP(w, authed)
+		})
+	}
+}
+
+func bodySizeStage(opts Options) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > opts.MaxBodyBytes {
+				rejectBodyTooLarge(w, r, opts)
+				return
+			}
+			r = r.WithContext(r.Context()) // don't swap the body of the caller's request
+			r.Body = http.MaxBytesReader(w, r.Body, opts.MaxBodyBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func concurrencyStage(opts Options) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			decision := DecisionFromContext(r.Context())
+			if err := opts.Concurrency.Acquire(r.Context()); err != nil {
+				decision.Check("concurrency", false, err.Error())
+				log.Printf("Concurrency limit: rejecting %s %s (%v)", r.Method, r.URL.Path, err)
+				w.Header().Set("Retry-After", "1")
+				opts.Errors.Write(w, http.StatusServiceUnavailable, err.Error())
+				return
+			}
+			defer opts.Concurrency.Release()
+			decision.Check("concurrency", true, "")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func requestHeadersStage(opts Options) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Clone so the rules run once, on our own copy, however many
+			// retries follow.
+			r = r.Clone(r.Context())
+			opts.RequestHeaders.Apply(r.Header)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,88 +2,471 @@ package proxy
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	"net/http/httptest"
 	"net/http/httputil"
+	"reverse-proxy/auth"
+	"reverse-proxy/limit"
 	"reverse-proxy/pool"
+	"reverse-proxy/proxyproto"
 	"sync/atomic"
 	"time"
 )
 
-// transportWrapper wraps http.DefaultTransport and records whether the
+// transportWrapper wraps the backend's transport and records the error if the
 // RoundTrip call failed with a connection-level error.
 // A new instance is created per request attempt — zero shared state between
 // concurrent goroutines.
 type transportWrapper struct {
 	transport http.RoundTripper
-	failed    bool
+	err       error
+
+	// Mid-body stall detection: when idleTimeout > 0 the response body is
+	// wrapped so that no progress for idleTimeout cancels the attempt.
+	idleTimeout time.Duration
+	cancel      context.CancelFunc
+	stalled     atomic.Bool
+
+	// schemeFailover retries once with https:// (or http://) when the
+	// backend turns out to speak the other scheme.
+	schemeFailover bool
+
+	// Response validation, see Options.MaxResponseBytes: tooLarge is set
+	// when a body without Content-Length goes past the limit.
+	maxResponseBytes int64
+	contentTypes     []string
+	tooLarge         atomic.Bool
 }
 
 func (t *transportWrapper) RoundTrip(req *http.Request) (*http.Response, error) {
 	resp, err := t.transport.RoundTrip(req)
+	if t.schemeFailover && canReplay(req) {
+		var scheme string
+		if resp, scheme = schemeMismatch(req, resp, err); scheme != "" {
+			resp, err = retryWithScheme(t.transport, req, scheme)
+		}
+	}
 	if err != nil {
-		t.failed = true
+		t.err = err
+		return resp, err
+	}
+	if err := checkResponse(req, resp, t.maxResponseBytes, t.contentTypes); err != nil {
+		resp.Body.Close()
+		t.err = err
+		return nil, err
 	}
-	return resp, err
+	if t.maxResponseBytes > 0 {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: t.maxResponseBytes, exceeded: func() {
+			t.tooLarge.Store(true)
+		}}
+	}
+	if t.idleTimeout > 0 {
+		resp.Body = newIdleTimeoutBody(resp.Body, t.idleTimeout, func() {
+			t.stalled.Store(true)
+			t.cancel()
+		})
+	}
+	return resp, nil
+}
+
+// Options configures the proxy handler.
+type Options struct {
+	Route               string          // name reported in the decision log
+	Timeout             time.Duration   // per-attempt deadline
+	ResponseIdleTimeout time.Duration   // abort if the body makes no progress for this long (0 = off)
+	Errors              *ErrorResponder // nil writes plain-text errors
+
+	// Unavailable, when set, customizes the 503 answered when no backend is
+	// available (none configured, or none healthy).
+	Unavailable *UnavailableResponse
+
+	// Concurrency caps in-flight requests to this pool (nil = unlimited),
+	// protecting fragile backends independently of any global limit.
+	Concurrency *limit.ConcurrencyLimiter
+
+	// ClientConcurrency caps the requests each client IP may have in flight
+	// (nil = unlimited); the ones beyond get 429. Shared by every route, as
+	// it protects the whole proxy.
+	ClientConcurrency *limit.PerIPLimiter
+
+	// SchemeFailover retries once with the other scheme when a backend
+	// configured as http:// speaks TLS (or vice versa), logging a warning.
+	SchemeFailover bool
+
+	// FlushInterval streams every response to the client instead of
+	// buffering it, flushing at this interval (negative = after each write).
+	// 0 buffers responses, except text/event-stream which always streams.
+	FlushInterval time.Duration
+
+	// BufferPool provides the buffers copying response bodies; nil
+	// allocates one per response.
+	BufferPool *BufferPool
+
+	// CORS answers preflights at the proxy and sets the CORS headers of
+	// every response to cross-origin requests (nil = pass through).
+	CORS *CORSPolicy
+
+	// IPFilters are checked in order before load balancing (e.g. the global
+	// filter, then the route's); a client rejected by any of them gets 403.
+	IPFilters []*limit.IPFilter
+
+	// JWT, when set, requires a valid bearer token (401 otherwise) and
+	// forwards the configured claims to the backend as headers.
+	JWT *auth.Validator
+
+	// MaxBodyBytes caps the request body (0 = unlimited). Larger bodies are
+	// rejected with 413, from Content-Length up front or while streaming.
+	MaxBodyBytes int64
+
+	// MaxResponseBytes caps the response body of the backends (0 =
+	// unlimited), so a buggy backend cannot fill the proxy's memory, and
+	// ResponseContentTypes lists the media types they may answer with
+	// (empty = any), e.g. "application/json" or "text/*". A response
+	// breaking them is a failure of the backend: 502, unless another
+	// backend answers, and the backend is marked DOWN. A stream already
+	// started is cut.
+	MaxResponseBytes     int64
+	ResponseContentTypes []string
+
+	// RetryBodyBytes buffers request bodies up to this size so a failed
+	// attempt can be retried with the same body (0 = never buffer; larger
+	// bodies are not retried).
+	RetryBodyBytes int64
+
+	// RetryNonIdempotent also retries POST, PATCH, ... after a failure that
+	// may have reached the backend. Without it they are only retried when the
+	// connection was refused, or when they carry an Idempotency-Key.
+	RetryNonIdempotent bool
+
+	// RetryBudget caps the retries to a share of the traffic (nil =
+	// unlimited): once it is spent, a failed request is answered with its
+	// error instead of being retried. Shared by every route.
+	RetryBudget *limit.RetryBudget
+
+	// HostHeader is the Host sent to the backends: "" or HostPreserve keeps
+	// the client's, HostBackend uses the backend URL's host, and any other
+	// value is sent as is (name-based virtual hosts behind the proxy).
+	HostHeader string
+
+	RequestHeaders  HeaderRules // applied before forwarding
+	ResponseHeaders HeaderRules // applied to backend responses before returning them
+
+	// SecurityHeaders are added to every backend response (nil = none).
+	SecurityHeaders *SecurityHeaders
+
+	// Rewrite maps the request path to the backends' (nil = unchanged); it
+	// must have been compiled.
+	Rewrite *PathRewrite
+
+	// Bandwidth caps the bytes per second of the route's responses, shared
+	// by all of them (nil = unlimited). BackendBandwidth does the same for
+	// the responses of each backend, by URL, and ClientClasses for those of
+	// the matching clients; a response goes at the pace of its slowest limit.
+	Bandwidth        *limit.Bandwidth
+	BackendBandwidth map[string]*limit.Bandwidth
+	ClientClasses    []*ClientClass
+
+	// HedgeDelay, when positive, sends a GET or HEAD without a body to a
+	// second backend if the first has not answered within it; the first
+	// response wins and the other attempt is cancelled.
+	HedgeDelay time.Duration
+
+	// Faults injects latency, aborts and error statuses into the attempts
+	// matching its rules (nil = never), to exercise clients and timeouts.
+	Faults *FaultInjector
+
+	// Middleware runs after the built-in checks, just before load
+	// balancing, e.g. to add a check of an embedding program; see Stages to
+	// insert it elsewhere.
+	Middleware []Middleware
 }
 
 // attemptBackend tries to forward the request to the given backend within the
-// specified timeout. It returns the buffered response and whether the attempt
-// succeeded. Using a dedicated function means defer cancel() fires at the end
-// of each attempt — not at the end of the outer Handler function — which
-// prevents context/timer goroutine leaks when the retry loop runs multiple times.
-func attemptBackend(r *http.Request, backend *pool.Backend, proxyTimeout time.Duration) (*httptest.ResponseRecorder, bool) {
-	ctx, cancel := context.WithTimeout(r.Context(), proxyTimeout)
+// specified timeout. It returns the attempt's writer, holding the buffered
+// response (or, for a streaming response, already committed to w), and the
+// error if the attempt failed (nil on success). Using a dedicated function
+// means the deadline is released at the end of each attempt — not at the end
+// of the outer Handler function — which prevents context/timer goroutine
+// leaks when the retry loop runs multiple times.
+func attemptBackend(w http.ResponseWriter, r *http.Request, backend *pool.Backend, opts Options) (aw *attemptWriter, err error) {
+	// The deadline covers the whole response when it is buffered, but only
+	// the wait for headers when it streams: an event stream may stay open
+	// far longer than Timeout.
+	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel() // ✅ fires when this function returns, once per attempt
+	var timedOut atomic.Bool
+	deadline := time.AfterFunc(opts.Timeout, func() {
+		timedOut.Store(true)
+		cancel()
+	})
+	defer deadline.Stop()
+	var aborted atomic.Bool
+	stopAbort := context.AfterFunc(backend.Context(), func() {
+		aborted.Store(true)
+		cancel()
+	})
+	defer stopAbort()
 
-	req := r.WithContext(ctx)
-	recorder := httptest.NewRecorder()
+	req := r.WithContext(proxyproto.WithClientAddr(ctx, r.RemoteAddr))
+	aw = newAttemptWriter(w, opts)
+	aw.onCommit = func() { deadline.Stop() }
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &countingBody{ReadCloser: req.Body, n: &aw.bytesIn}
+	}
 
-	tw := &transportWrapper{transport: http.DefaultTransport}
-	rp := httputil.NewSingleHostReverseProxy(backend.URL)
+	// Reuse the backend's long-lived transport so keep-alive connections are
+	// pooled across requests; fall back to the default one for backends that
+	// were not registered through ServerPool.AddBackend.
+	var transport http.RoundTripper = http.DefaultTransport
+	if backend.Transport != nil {
+		transport = backend.Transport
+	}
+	if opts.Faults != nil {
+		transport = &faultTransport{next: transport, faults: opts.Faults, route: opts.Route, backend: backend}
+	}
+	tw := &transportWrapper{
+		transport:        transport,
+		idleTimeout:      opts.ResponseIdleTimeout,
+		cancel:           cancel,
+		schemeFailover:   opts.SchemeFailover,
+		maxResponseBytes: opts.MaxResponseBytes,
+		contentTypes:     opts.ResponseContentTypes,
+	}
+	rp := httputil.NewSingleHostReverseProxy(pool.HTTPTarget(backend.URL))
+	if host := upstreamHost(opts.HostHeader, backend); host != "" {
+		director := rp.Director
+		rp.Director = func(out *http.Request) {
+			director(out)
+			// Keep the name the client asked for, since the backend no
+			// longer sees it.
+			if out.Header.Get("X-Forwarded-Host") == "" {
+				out.Header.Set("X-Forwarded-Host", out.Host)
+			}
+			out.Host = host
+		}
+	}
 	rp.Transport = tw
+	rp.FlushInterval = opts.FlushInterval
+	if opts.BufferPool != nil {
+		rp.BufferPool = opts.BufferPool
+	}
 
-	rp.ServeHTTP(recorder, req)
-	return recorder, !tw.failed
+	// When the body copy fails inside a real server, ReverseProxy aborts with
+	// http.ErrAbortHandler. If nothing has reached the client yet (the
+	// response is buffered), a stall or timeout can still become a clean 504;
+	// a committed stream is reported as aborted and the caller cuts the
+	// client connection.
+	defer func() {
+		if aw.committed || tw.stalled.Load() || tw.tooLarge.Load() || timedOut.Load() || aborted.Load() {
+			if p := recover(); p != nil && p != http.ErrAbortHandler {
+				panic(p)
+			}
+		}
+		switch {
+		case aw.committed && (err != nil || tw.stalled.Load() || tw.tooLarge.Load()):
+			err = errStreamAborted
+		case tw.stalled.Load():
+			err = errResponseStalled
+		case tw.tooLarge.Load():
+			err = fmt.Errorf("%w: limit %d bytes", errResponseTooLarge, opts.MaxResponseBytes)
+		case aborted.Load():
+			// Even a complete response is dropped: the backend was
+			// removed because its responses cannot be trusted.
+			err = errBackendAborted
+		case err != nil && timedOut.Load():
+			err = context.DeadlineExceeded
+		}
+	}()
+
+	rp.ServeHTTP(aw, req)
+	if aw.committed && tw.err == nil {
+		aw.writeTrailers(w)
+	}
+	return aw, tw.err
+}
+
+// Values of Options.HostHeader with a special meaning.
+const (
+	HostPreserve = "preserve"
+	HostBackend  = "backend"
+)
+
+// upstreamHost returns the Host to send to backend, or "" to keep the
+// client's.
+func upstreamHost(mode string, backend *pool.Backend) string {
+	switch mode {
+	case "", HostPreserve:
+		return ""
+	case HostBackend:
+		return pool.HTTPTarget(backend.URL).Host
+	}
+	return mode
 }
 
 // Handler returns an http.HandlerFunc that forwards requests to a healthy backend.
 func Handler(serverPool pool.LoadBalancer, proxyTimeout time.Duration) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+	return NewHandler(serverPool, Options{Timeout: proxyTimeout})
+}
+
+// NewHandler is like Handler but accepts the full set of options: it runs
+// the stages returned by Stages, then Balancer.
+func NewHandler(serverPool pool.LoadBalancer, opts Options) http.HandlerFunc {
+	return Chain(Balancer(serverPool, opts), Stages(opts)...).ServeHTTP
+}
+
+// Balancer is the last step of the pipeline: it forwards the request to a
+// healthy backend, retrying on another one after a failure. Failures are
+// reported as 503 when no backend is available, 504 when the per-attempt
+// deadline fired and 502 for any other upstream error.
+func Balancer(serverPool pool.LoadBalancer, opts Options) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decision := DecisionFromContext(r.Context())
+
 		maxAttempts := len(serverPool.GetBackends())
 		if maxAttempts == 0 {
-			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			writeUnavailable(w, opts, "no backend available")
+			return
+		}
+
+		r, replayable, err := bufferBody(r, opts.RetryBodyBytes)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				rejectBodyTooLarge(w, r, opts)
+				return
+			}
+			log.Printf("Failed to read request body of %s %s: %v", r.Method, r.URL.Path, err)
+			opts.Errors.Write(w, http.StatusBadRequest, "failed to read request body")
 			return
 		}
 
+		// hedgeFailed reports a hedged attempt that failed while the other
+		// one was still running.
+		hedgeFailed := func(res hedgeResult) {
+			decision.Attempt(res.backend.URL.String(), res.err)
+			res.backend.ObserveFailure()
+			recordStats(res.backend, res.aw, time.Since(res.started), res.err)
+			log.Printf("Backend %s error: %v — marking DOWN, hedged attempt still running", res.backend.URL, res.err)
+			pool.SetStatusCause(serverPool, res.backend.URL, false, pool.CauseProxyError, res.err.Error())
+		}
+
+		var lastErr error
+
 		for attempt := 0; attempt < maxAttempts; attempt++ {
 			backend := serverPool.GetNextValidPeer()
 			if backend == nil {
 				break
 			}
+			if attempt > 0 {
+				rewindBody(r)
+			}
+			if opts.RetryBudget != nil {
+				opts.RetryBudget.Sent(backend.URL.String(), attempt > 0)
+			}
 
-			atomic.AddInt64(&backend.CurrentConns, 1)
-			recorder, ok := attemptBackend(r, backend, proxyTimeout)
-			atomic.AddInt64(&backend.CurrentConns, -1)
+			var aw *attemptWriter
+			var out http.ResponseWriter
+			started := time.Now()
+			if hedgeable(r, opts) {
+				res := hedge(w, r, serverPool, backend, opts, hedgeFailed)
+				backend, aw, err, started = res.backend, res.aw, res.err, res.started
+				out = aw.client
+			} else {
+				atomic.AddInt64(&backend.CurrentConns, 1)
+				out = throttle(w, r, backend, opts)
+				aw, err = attemptBackend(out, r, backend, opts)
+				atomic.AddInt64(&backend.CurrentConns, -1)
+			}
+			decision.Attempt(backend.URL.String(), err)
 
-			if ok {
-				// Only flush the buffered response to the real writer on success
-				for key, vals := range recorder.Header() {
-					for _, val := range vals {
-						w.Header().Add(key, val)
-					}
+			if aw.committed {
+				// A stream is judged on its time to first byte, not on how
+				// long the client kept it open.
+				backend.ObserveLatency(aw.headerAt.Sub(started))
+				recordStats(backend, aw, aw.headerAt.Sub(started), err)
+				if err != nil {
+					log.Printf("Backend %s: stream to client interrupted: %v", backend.URL, err)
+					panic(http.ErrAbortHandler) // truncate the client response
 				}
-				w.WriteHeader(recorder.Code)
-				recorder.Body.WriteTo(w)
 				return
 			}
 
-			log.Printf("Backend %s error — marking DOWN, retrying (attempt %d/%d)",
-				backend.URL, attempt+1, maxAttempts)
-			backend.SetAlive(false)
+			if err == nil {
+				backend.ObserveLatency(time.Since(started))
+				recordStats(backend, aw, time.Since(started), nil)
+				// Only flush the buffered response to the real writer on success
+				aw.flushTo(out)
+				return
+			}
+
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				// The client's fault, not the backend's: no retry, no DOWN.
+				rejectBodyTooLarge(w, r, opts)
+				return
+			}
+
+			// A backend removed by force is gone: its cancelled requests
+			// neither count against it nor take from the retry budget.
+			aborted := err == errBackendAborted
+			if !aborted {
+				backend.ObserveFailure()
+			}
+			recordStats(backend, aw, time.Since(started), err)
+			retry, reason := retryable(r, err, replayable, opts)
+			if retry && !aborted && attempt+1 < maxAttempts && opts.RetryBudget != nil && !opts.RetryBudget.Withdraw(backend.URL.String()) {
+				retry, reason = false, "retry budget exhausted"
+			}
+			next := "retrying"
+			if !retry {
+				next = "not retrying: " + reason
+			}
+			switch {
+			case aborted:
+				log.Printf("Backend %s removed by force — request cancelled, %s (attempt %d/%d)",
+					backend.URL, next, attempt+1, maxAttempts)
+			case err == errResponseStalled:
+				log.Printf("Backend %s stalled mid-response (no data for %v) — aborted upstream, marking DOWN, %s (attempt %d/%d)",
+					backend.URL, opts.ResponseIdleTimeout, next, attempt+1, maxAttempts)
+			default:
+				log.Printf("Backend %s error: %v — marking DOWN, %s (attempt %d/%d)",
+					backend.URL, err, next, attempt+1, maxAttempts)
+			}
+			if !aborted {
+				pool.SetStatusCause(serverPool, backend.URL, false, pool.CauseProxyError, err.Error())
+			}
+			lastErr = err
+			if !retry {
+				decision.Check("retry", false, reason)
+				break
+			}
 		}
 
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
-	}
-}
\ No newline at end of file
+		if lastErr == nil {
+			writeUnavailable(w, opts, "no healthy backend available")
+			return
+		}
+		status := classifyError(lastErr)
+		opts.Errors.Write(w, status, upstreamMessage(status))
+	})
+}
+
+// recordStats adds an attempt to the backend's statistics. A 5xx from the
+// backend counts as a failure there, even though it is passed on as is.
+func recordStats(backend *pool.Backend, aw *attemptWriter, latency time.Duration, err error) {
+	backend.RecordRequest(pool.RequestResult{
+		Latency:  latency,
+		BytesIn:  atomic.LoadInt64(&aw.bytesIn),
+		BytesOut: aw.bytesOut,
+		Failed:   err != nil || aw.code >= 500,
+	})
+}
+
+func rejectBodyTooLarge(w http.ResponseWriter, r *http.Request, opts Options) {
+	reason := fmt.Sprintf("request body exceeds %d bytes", opts.MaxBodyBytes)
+	DecisionFromContext(r.Context()).Check("body_size", false, reason)
+	log.Printf("Body size limit: rejecting %s %s (%s)", r.Method, r.URL.Path, reason)
+	opts.Errors.Write(w, http.StatusRequestEntityTooLarge, reason)
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// RedirectRule answers the requests it matches with a redirect instead of
+// proxying them. Exactly one of Path (exact), Prefix (on a segment
+// boundary, the rest of the path is appended to Target) or Regex (Target
+// may use $1, ${name}) is required. The query string is appended unless
+// Target has its own.
+type RedirectRule struct {
+	Host   string `json:"host"` // optional, port ignored
+	Path   string `json:"path"`
+	Prefix string `json:"prefix"`
+	Regex  string `json:"regex"`
+	Target string `json:"target"` // a path or an absolute URL
+	Status int    `json:"status"` // 301 (default), 302, 307 or 308
+}
+
+// Redirects is a compiled list of redirect rules, tried in order.
+type Redirects struct {
+	rules []RedirectRule
+	res   []*regexp.Regexp // per rule, nil unless Regex is set
+}
+
+// NewRedirects checks and compiles rules.
+func NewRedirects(rules []RedirectRule) (*Redirects, error) {
+	rs := &Redirects{rules: make([]RedirectRule, len(rules)), res: make([]*regexp.Regexp, len(rules))}
+	for i, rule := range rules {
+		matchers := 0
+		for _, m := range []string{rule.Path, rule.Prefix, rule.Regex} {
+			if m != "" {
+				matchers++
+			}
+		}
+		if matchers != 1 {
+			return nil, fmt.Errorf("redirects[%d]: exactly one of path, prefix or regex is required", i)
+		}
+		if rule.Target == "" {
+			return nil, fmt.Errorf("redirects[%d]: target is required", i)
+		}
+		switch rule.Status {
+		case 0:
+			rule.Status = http.StatusMovedPermanently
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return nil, fmt.Errorf("redirects[%d]: status must be 301, 302, 307 or 308 (got %d)", i, rule.Status)
+		}
+		if rule.Regex != "" {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("redirects[%d]: %w", i, err)
+			}
+			rs.res[i] = re
+		}
+		if rule.Prefix != "" {
+			if rule.Prefix = strings.TrimSuffix(rule.Prefix, "/"); rule.Prefix == "" {
+				return nil, fmt.Errorf("redirects[%d]: prefix \"/\" would redirect everything, use regex \"^/(.*)$\"", i)
+			}
+		}
+		rs.rules[i] = rule
+	}
+	return rs, nil
+}
+
+// Match returns the redirect target and status for r, or "" when no rule
+// matches.
+func (rs *Redirects) Match(r *http.Request) (string, int) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	path := r.URL.Path
+	for i, rule := range rs.rules {
+		if rule.Host != "" && !strings.EqualFold(rule.Host, host) {
+			continue
+		}
+		var target string
+		switch {
+		case rule.Path != "":
+			if path != rule.Path {
+				continue
+			}
+			target = rule.Target
+		case rule.Prefix != "":
+			rest, ok := strings.CutPrefix(path, rule.Prefix)
+			if !ok || (rest != "" && rest[0] != '/') {
+				continue
+			}
+			target = strings.TrimSuffix(rule.Target, "/") + rest
+			if target == "" {
+				target = "/"
+			}
+		default:
+			match := rs.res[i].FindStringSubmatchIndex(path)
+			if match == nil {
+				continue
+			}
+			target = string(rs.res[i].ExpandString(nil, rule.Target, path, match))
+		}
+		if r.URL.RawQuery != "" && !strings.Contains(target, "?") {
+			target += "?" + r.URL.RawQuery
+		}
+		return target, rule.Status
+	}
+	return "", 0
+}
+
+// Middleware answers matching requests with their redirect, before any
+// routing or load balancing.
+func (rs *Redirects) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, status := rs.Match(r)
+		if target == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		DecisionFromContext(r.Context()).Check("redirect", false, fmt.Sprintf("%d to %s", status, target))
+		http.Redirect(w, r, target, status)
+	})
+}
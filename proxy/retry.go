@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+)
+
+// bufferBody reads a request body of up to limit bytes into memory, so that
+// every attempt can send it again. It returns the request to forward and
+// whether its body can be replayed: bodiless requests always can, larger
+// bodies are streamed to the first attempt only.
+func bufferBody(r *http.Request, limit int64) (*http.Request, bool, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return r, true, nil
+	}
+	if limit <= 0 || r.ContentLength > limit {
+		return r, false, nil
+	}
+
+	orig := r.Body
+	buf, err := io.ReadAll(io.LimitReader(orig, limit+1))
+	if err != nil {
+		return r, false, err
+	}
+	r = r.WithContext(r.Context()) // don't swap the body of the caller's request
+	if int64(len(buf)) > limit {
+		// Chunked upload past the limit: hand back what was read, then the rest.
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), orig), orig}
+		return r, false, nil
+	}
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	r.Body, _ = r.GetBody()
+	return r, true, nil
+}
+
+// rewindBody gives a replayable request a fresh copy of its body.
+func rewindBody(r *http.Request) {
+	if r.GetBody != nil && r.Body != nil && r.Body != http.NoBody {
+		r.Body, _ = r.GetBody()
+	}
+}
+
+// retryable tells whether a request whose attempt failed with err may be sent
+// to another backend, and if not, why. Idempotent methods (RFC 9110) and
+// requests carrying an Idempotency-Key can be repeated safely; others only
+// when the connection was refused, since then the backend never saw them.
+func retryable(r *http.Request, err error, replayable bool, opts Options) (bool, string) {
+	if !replayable {
+		return false, "request body too large to replay"
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true, ""
+	}
+	if opts.RetryNonIdempotent || r.Header.Get("Idempotency-Key") != "" || r.Header.Get("X-Idempotency-Key") != "" {
+		return true, ""
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true, ""
+	}
+	return false, r.Method + " is not idempotent"
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// PathRewrite maps the public path of a route to the one its backends
+// know, in this order: StripPrefix is removed (on a segment boundary),
+// Regex is replaced by Replacement ($1, ${name}), then AddPrefix is
+// prepended. The query string is kept as is. Compile must be called before
+// use.
+type PathRewrite struct {
+	StripPrefix string `json:"strip_prefix"` // "/api/v1": /api/v1/users → /users
+	AddPrefix   string `json:"add_prefix"`   // "/internal": /users → /internal/users
+	Regex       string `json:"regex"`        // matched against the escaped path
+	Replacement string `json:"replacement"`
+
+	re *regexp.Regexp
+}
+
+// Compile checks the rewrite and compiles its regex.
+func (pr *PathRewrite) Compile() error {
+	for _, prefix := range []string{pr.StripPrefix, pr.AddPrefix} {
+		if prefix != "" && !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("rewrite: prefix %q must start with /", prefix)
+		}
+	}
+	if pr.Regex == "" {
+		if pr.Replacement != "" {
+			return fmt.Errorf("rewrite: replacement needs a regex")
+		}
+		return nil
+	}
+	re, err := regexp.Compile(pr.Regex)
+	if err != nil {
+		return fmt.Errorf("rewrite: %w", err)
+	}
+	pr.re = re
+	return nil
+}
+
+// Rewrite returns the backend path for the escaped path p.
+func (pr *PathRewrite) Rewrite(p string) string {
+	p, _ = pr.strip(p)
+	if pr.re != nil {
+		p = pr.re.ReplaceAllString(p, pr.Replacement)
+	}
+	if pr.AddPrefix != "" {
+		p = strings.TrimSuffix(pr.AddPrefix, "/") + p
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
+
+// strip removes StripPrefix from p, reporting whether it was there.
+func (pr *PathRewrite) strip(p string) (string, bool) {
+	prefix := strings.TrimSuffix(pr.StripPrefix, "/")
+	if prefix == "" {
+		return p, false
+	}
+	// "/api" strips "/api" and "/api/x", not "/apix".
+	if rest, ok := strings.CutPrefix(p, prefix); ok && (rest == "" || rest[0] == '/') {
+		return rest, true
+	}
+	return p, false
+}
+
+func rewriteStage(opts Options) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			escaped := opts.Rewrite.Rewrite(r.URL.EscapedPath())
+			path, err := url.PathUnescape(escaped)
+			if err != nil {
+				DecisionFromContext(r.Context()).Check("rewrite", false, err.Error())
+				opts.Errors.Write(w, http.StatusBadRequest, "invalid rewritten path")
+				return
+			}
+			r = r.Clone(r.Context())
+			if _, stripped := opts.Rewrite.strip(r.URL.EscapedPath()); stripped {
+				// Lets the backend build its public links.
+				r.Header.Set("X-Forwarded-Prefix", strings.TrimSuffix(opts.Rewrite.StripPrefix, "/"))
+			}
+			r.URL.Path, r.URL.RawPath = path, ""
+			if r.URL.EscapedPath() != escaped {
+				r.URL.RawPath = escaped // keep encodings such as %2F
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
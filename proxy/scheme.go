@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// plainToTLSBody is what Go's (and several other) TLS servers answer when
+// they receive plaintext HTTP on a TLS port, with a 400 status.
+const plainToTLSBody = "Client sent an HTTP request to an HTTPS server"
+
+// schemeWarned remembers backends we already warned about, so a
+// misconfigured backend logs once instead of on every request.
+var schemeWarned sync.Map
+
+// schemeMismatch reports whether a round trip failed because the backend
+// speaks the other scheme: HTTPS towards a plain HTTP server, or plain HTTP
+// towards a TLS server. It returns the scheme that should have been used.
+// resp may be rewritten (its body is peeked), so callers must use the
+// returned response.
+func schemeMismatch(req *http.Request, resp *http.Response, err error) (*http.Response, string) {
+	if err != nil {
+		var recErr tls.RecordHeaderError
+		if req.URL.Scheme == "https" && (errors.As(err, &recErr) ||
+			strings.Contains(err.Error(), "server gave HTTP response to HTTPS client")) {
+			return resp, "http"
+		}
+		// Some TLS servers answer plaintext with a raw TLS alert record.
+		if req.URL.Scheme == "http" && strings.Contains(err.Error(), `malformed HTTP response "\x15\x03`) {
+			return resp, "https"
+		}
+		return resp, ""
+	}
+
+	if req.URL.Scheme != "http" || resp.StatusCode != http.StatusBadRequest {
+		return resp, ""
+	}
+	peek := make([]byte, len(plainToTLSBody))
+	n, _ := io.ReadFull(resp.Body, peek)
+	peek = peek[:n]
+	if string(peek) == plainToTLSBody {
+		resp.Body.Close()
+		return nil, "https"
+	}
+	// Not our case: hand back a body that still starts with the peeked bytes.
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peek), resp.Body), resp.Body}
+	return resp, ""
+}
+
+// canReplay reports whether the request body can be sent a second time.
+func canReplay(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// retryWithScheme re-sends req once using scheme, logging a misconfiguration
+// warning the first time it happens for a backend.
+func retryWithScheme(rt http.RoundTripper, req *http.Request, scheme string) (*http.Response, error) {
+	retry := req.Clone(req.Context())
+	retry.URL.Scheme = scheme
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+
+	if _, warned := schemeWarned.LoadOrStore(req.URL.Host, true); !warned {
+		log.Printf("WARNING: backend %s expects %s but is configured with %s:// — retrying with %s://, please fix the backend URL",
+			req.URL.Host, strings.ToUpper(scheme), req.URL.Scheme, scheme)
+	}
+	return rt.RoundTrip(retry)
+}
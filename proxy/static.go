@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// StaticDir serves the requests under Prefix from the local directory Root
+// instead of proxying them: /assets/app.js with prefix /assets is read from
+// Root/app.js. Files cannot escape Root, even through symlinks, and
+// directories are never listed.
+type StaticDir struct {
+	Host   string   `json:"host"`   // optional, port ignored
+	Prefix string   `json:"prefix"` // matched on path segment boundaries
+	Root   string   `json:"root"`
+	Index  []string `json:"index"`   // files tried for a directory; defaults to index.html
+	MaxAge int      `json:"max_age"` // seconds of Cache-Control: public, max-age; 0 sends no Cache-Control
+}
+
+// StaticFiles serves a list of static directories, the first matching one
+// winning.
+type StaticFiles struct {
+	dirs []StaticDir
+
+	// Errors renders the 404 and 405 answers (nil writes plain text).
+	Errors *ErrorResponder
+}
+
+// NewStaticFiles checks dirs and fills in their defaults.
+func NewStaticFiles(dirs []StaticDir) (*StaticFiles, error) {
+	sf := &StaticFiles{}
+	for i, d := range dirs {
+		if !strings.HasPrefix(d.Prefix, "/") {
+			return nil, fmt.Errorf("static[%d]: prefix must start with /", i)
+		}
+		info, err := os.Stat(d.Root)
+		if err != nil {
+			return nil, fmt.Errorf("static[%d]: %w", i, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("static[%d]: root %s is not a directory", i, d.Root)
+		}
+		if d.MaxAge < 0 {
+			return nil, fmt.Errorf("static[%d]: max_age must not be negative", i)
+		}
+		if len(d.Index) == 0 {
+			d.Index = []string{"index.html"}
+		}
+		d.Prefix = strings.TrimSuffix(d.Prefix, "/")
+		sf.dirs = append(sf.dirs, d)
+	}
+	return sf, nil
+}
+
+// match returns the directory serving r and the path relative to it.
+func (sf *StaticFiles) match(r *http.Request) (*StaticDir, string, bool) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for i := range sf.dirs {
+		d := &sf.dirs[i]
+		if d.Host != "" && !strings.EqualFold(d.Host, host) {
+			continue
+		}
+		// "/assets" serves "/assets" and "/assets/x", not "/assetsx".
+		if rest, ok := strings.CutPrefix(r.URL.Path, d.Prefix); ok && (rest == "" || rest[0] == '/') {
+			return d, rest, true
+		}
+	}
+	return nil, "", false
+}
+
+// Middleware serves the matching requests from disk and passes the others
+// to next.
+func (sf *StaticFiles) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, rel, ok := sf.match(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		DecisionFromContext(r.Context()).Check("static", true, d.Root)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			sf.Errors.Write(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sf.serve(w, r, d, rel)
+	})
+}
+
+func (sf *StaticFiles) serve(w http.ResponseWriter, r *http.Request, d *StaticDir, rel string) {
+	name := strings.TrimPrefix(path.Clean("/"+rel), "/")
+	if name == "" {
+		name = "."
+	}
+	f, err := os.OpenInRoot(d.Root, name)
+	if err != nil {
+		sf.Errors.Write(w, http.StatusNotFound, "not found")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		sf.Errors.Write(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			// Relative links in the index resolve against the directory.
+			target := r.URL.Path + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		f.Close()
+		f, info = nil, nil
+		for _, index := range d.Index {
+			if f, err = os.OpenInRoot(d.Root, path.Join(name, index)); err != nil {
+				continue
+			}
+			if info, err = f.Stat(); err == nil && info.Mode().IsRegular() {
+				break
+			}
+			f.Close()
+			f, info = nil, nil
+		}
+		if f == nil {
+			sf.Errors.Write(w, http.StatusNotFound, "not found")
+			return
+		}
+		defer f.Close()
+	} else if !info.Mode().IsRegular() {
+		sf.Errors.Write(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if d.MaxAge > 0 {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(d.MaxAge))
+	}
+	w.Header().Set("ETag", etag(info))
+	// Content-Type from the extension, Last-Modified, conditional and range
+	// requests.
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// etag identifies a file version by its size and modification time.
+func etag(info fs.FileInfo) string {
+	return fmt.Sprintf(`W/"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// errStreamAborted reports that a streamed response broke after it had been
+// committed to the client, so it can neither be retried nor replaced by an
+// error page.
+var errStreamAborted = errors.New("streamed response aborted")
+
+// attemptWriter is the ResponseWriter handed to the ReverseProxy for one
+// attempt. It buffers the response so a failed attempt can be retried on
+// another backend, except for streaming responses (text/event-stream, or
+// every response when Options.FlushInterval is set): those are committed to
+// the client as soon as their headers arrive and flushed as data comes in.
+type attemptWriter struct {
+	client http.ResponseWriter
+	opts   Options
+
+	header    http.Header
+	code      int
+	body      bytes.Buffer
+	committed bool            // headers sent to the client, writes go straight through
+	headerAt  time.Time       // when the backend's headers arrived
+	sent      map[string]bool // header keys present at WriteHeader; later ones are trailers
+	bytesIn   int64           // request body read by the transport, atomic: its writer may still run
+	bytesOut  int64           // response body received from the backend
+
+	// onCommit runs when the response starts streaming, e.g. to lift the
+	// per-attempt deadline that would otherwise cut a long-lived stream.
+	onCommit func()
+}
+
+func newAttemptWriter(client http.ResponseWriter, opts Options) *attemptWriter {
+	return &attemptWriter{client: client, opts: opts, header: make(http.Header)}
+}
+
+func (a *attemptWriter) Header() http.Header {
+	return a.header
+}
+
+func (a *attemptWriter) WriteHeader(code int) {
+	if informational(code) {
+		a.forwardInformational(code)
+		return
+	}
+	if a.code != 0 {
+		return
+	}
+	a.code = code
+	a.headerAt = time.Now()
+	a.sent = make(map[string]bool, len(a.header))
+	for key := range a.header {
+		a.sent[key] = true
+	}
+	if a.isStreaming() {
+		a.commit()
+	}
+}
+
+func (a *attemptWriter) Write(p []byte) (int, error) {
+	if a.code == 0 {
+		a.WriteHeader(http.StatusOK)
+	}
+	a.bytesOut += int64(len(p))
+	if a.committed {
+		return a.client.Write(p)
+	}
+	return a.body.Write(p)
+}
+
+// FlushError is what http.ResponseController (used by the ReverseProxy on
+// every FlushInterval tick) calls. Buffered responses have nothing to flush.
+func (a *attemptWriter) FlushError() error {
+	if !a.committed {
+		return nil
+	}
+	return http.NewResponseController(a.client).Flush()
+}
+
+func (a *attemptWriter) isStreaming() bool {
+	if a.opts.FlushInterval != 0 {
+		return true
+	}
+	ct, _, _ := mime.ParseMediaType(a.header.Get("Content-Type"))
+	// gRPC responses are streams too (server and bidirectional streaming
+	// calls), and their status only comes in the trailers.
+	return ct == "text/event-stream" || ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+")
+}
+
+// informational reports whether code is a 1xx response followed by the
+// final one: all but 101 Switching Protocols.
+func informational(code int) bool {
+	return code >= 100 && code < 200 && code != http.StatusSwitchingProtocols
+}
+
+// forwardInformational sends a 1xx response of the backend, e.g. 103 Early
+// Hints, to the client at once, with the headers the ReverseProxy set for
+// it; they are taken out of the client's header map again, since they do
+// not belong to the final response. 100 Continue is left to the server,
+// which sends it when the request body is first read, and a hedged attempt
+// has not won yet: its 1xx are dropped.
+func (a *attemptWriter) forwardInformational(code int) {
+	if _, hedged := a.client.(*gateWriter); hedged || code == http.StatusContinue || a.code != 0 {
+		return
+	}
+	h := a.client.Header()
+	saved := h.Clone()
+	maps.Copy(h, a.header)
+	a.client.WriteHeader(code)
+	clear(h)
+	maps.Copy(h, saved)
+}
+
+// commit sends the headers to the client; from then on the attempt owns the
+// response.
+func (a *attemptWriter) commit() {
+	a.committed = true
+	if a.onCommit != nil {
+		a.onCommit()
+	}
+	copyResponseHeader(a.client.Header(), a.header, a.opts)
+	a.client.WriteHeader(a.code)
+	// Deliver the headers now: an event stream may not send data for a while.
+	http.NewResponseController(a.client).Flush()
+}
+
+// flushTo writes a buffered response to the client.
+func (a *attemptWriter) flushTo(w http.ResponseWriter) {
+	copyResponseHeader(w.Header(), a.header, a.opts)
+	if a.code == 0 {
+		a.code = http.StatusOK
+	}
+	w.WriteHeader(a.code)
+	a.body.WriteTo(w)
+	a.writeTrailers(w)
+}
+
+// trailers returns what the ReverseProxy set after the body: either under
+// http.TrailerPrefix or under names that were not there at WriteHeader.
+func (a *attemptWriter) trailers() http.Header {
+	trailers := make(http.Header)
+	if a.sent == nil {
+		return trailers // headers never written, nothing can follow them
+	}
+	for key, vals := range a.header {
+		if name, ok := strings.CutPrefix(key, http.TrailerPrefix); ok {
+			trailers[name] = vals
+		} else if !a.sent[key] {
+			trailers[key] = vals
+		}
+	}
+	return trailers
+}
+
+// writeTrailers sends the backend's trailers (e.g. grpc-status) once the
+// body has been written to w.
+func (a *attemptWriter) writeTrailers(w http.ResponseWriter) {
+	trailers := a.trailers()
+	if len(trailers) == 0 {
+		return
+	}
+	// Flushing forces chunked encoding on HTTP/1.1, which trailers need.
+	http.NewResponseController(w).Flush()
+	for key, vals := range trailers {
+		for _, val := range vals {
+			w.Header().Add(http.TrailerPrefix+key, val)
+		}
+	}
+}
+
+// copyResponseHeader applies the security headers and the response header
+// rules to the backend's headers and adds the result to dst. src comes from
+// the ReverseProxy, which has already removed the hop-by-hop headers
+// (Connection and the headers it names, Keep-Alive, Proxy-*, TE,
+// Transfer-Encoding, Upgrade), as it does on the request, so buffered and
+// streamed responses need no extra pass.
+func copyResponseHeader(dst, src http.Header, opts Options) {
+	opts.SecurityHeaders.apply(src) // before the rules, which may still remove them
+	opts.ResponseHeaders.Apply(src)
+	for key, vals := range src {
+		for _, val := range vals {
+			dst.Add(key, val)
+		}
+	}
+}
+
+// countingBody counts the request body bytes the transport sends upstream.
+// The transport reads it from its own goroutine, hence the atomic counter.
+type countingBody struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Tracker counts requests flowing through the proxy so shutdown can report
+// how many were drained and how many were still in flight when it gave up.
+// With Max set, it also sheds the load beyond Max requests in flight.
+type Tracker struct {
+	// Max caps the requests served at once (0 = unlimited). The ones beyond
+	// get an immediate 503 with Retry-After rather than waiting, so an
+	// overloaded proxy keeps answering the requests it already took.
+	Max int64
+	// Errors formats the shedding 503; nil writes a plain-text error.
+	Errors *ErrorResponder
+
+	inFlight  int64
+	completed int64
+	shed      int64
+}
+
+// Wrap returns a handler that records every request passing through next.
+func (t *Tracker) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := atomic.AddInt64(&t.inFlight, 1); t.Max > 0 && n > t.Max {
+			atomic.AddInt64(&t.inFlight, -1)
+			atomic.AddInt64(&t.shed, 1)
+			w.Header().Set("Retry-After", "1")
+			t.Errors.Write(w, http.StatusServiceUnavailable, "proxy overloaded")
+			return
+		}
+		defer func() {
+			atomic.AddInt64(&t.inFlight, -1)
+			atomic.AddInt64(&t.completed, 1)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// InFlight returns the number of requests currently being served.
+func (t *Tracker) InFlight() int64 {
+	return atomic.LoadInt64(&t.inFlight)
+}
+
+// Completed returns the number of requests that have finished since start.
+func (t *Tracker) Completed() int64 {
+	return atomic.LoadInt64(&t.completed)
+}
+
+// Shed returns the number of requests rejected beyond Max since start.
+func (t *Tracker) Shed() int64 {
+	return atomic.LoadInt64(&t.shed)
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// errResponseTooLarge is reported when a backend's response body exceeds
+// Options.MaxResponseBytes.
+var errResponseTooLarge = errors.New("upstream response too large")
+
+// errResponseContentType is reported when the media type of a backend's
+// response is not in Options.ResponseContentTypes.
+var errResponseContentType = errors.New("upstream response content type not allowed")
+
+// ValidContentTypes checks the media ranges of Options.ResponseContentTypes:
+// a media type such as "application/json", or "text/*".
+func ValidContentTypes(types []string) error {
+	for _, t := range types {
+		mediaType, _, err := mime.ParseMediaType(t)
+		major, minor, ok := strings.Cut(mediaType, "/")
+		if err != nil || !ok || major == "*" || minor == "" {
+			return fmt.Errorf("invalid content type %q: expected type/subtype or type/*", t)
+		}
+	}
+	return nil
+}
+
+// checkResponse rejects a response before its body is read: a
+// Content-Length above maxBytes (when positive), or a media type out of
+// contentTypes (when not empty). A response without a body needs no
+// Content-Type.
+func checkResponse(req *http.Request, resp *http.Response, maxBytes int64, contentTypes []string) error {
+	if maxBytes > 0 && resp.ContentLength > maxBytes {
+		return fmt.Errorf("%w: %d bytes, limit %d", errResponseTooLarge, resp.ContentLength, maxBytes)
+	}
+	if len(contentTypes) == 0 {
+		return nil
+	}
+	header := resp.Header.Get("Content-Type")
+	if header == "" && (req.Method == http.MethodHead || resp.ContentLength == 0 ||
+		resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified) {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(header)
+	for _, allowed := range contentTypes {
+		allowed, _, _ = mime.ParseMediaType(allowed) // validated by ValidContentTypes
+		if mediaType == allowed || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", errResponseContentType, header)
+}
+
+// limitedBody fails the read that goes past its limit, for responses whose
+// length is not known up front, and calls exceeded.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  func()
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		b.exceeded()
+		return 0, errResponseTooLarge
+	}
+	return n, err
+}
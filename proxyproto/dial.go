@@ -0,0 +1,42 @@
+package proxyproto
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+type clientAddrKey struct{}
+
+// WithClientAddr records the address of the client a request comes from
+// (http.Request.RemoteAddr), for a dialer emitting PROXY protocol headers.
+func WithClientAddr(ctx context.Context, remoteAddr string) context.Context {
+	return context.WithValue(ctx, clientAddrKey{}, remoteAddr)
+}
+
+// DialFunc is the signature of http.Transport.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// DialContext wraps dial so every new connection starts with a PROXY protocol
+// header (version 1 or 2) describing the client recorded by WithClientAddr and
+// the proxy address it connected to. The header describes one client, so the
+// transport using it must not reuse connections across requests.
+func DialContext(version int, dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		var src, dst net.Addr
+		if raw, ok := ctx.Value(clientAddrKey{}).(string); ok {
+			src, _ = net.ResolveTCPAddr("tcp", raw)
+		}
+		dst, _ = ctx.Value(http.LocalAddrContextKey).(net.Addr)
+		if _, err := c.Write(Header(version, src, dst)); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+}
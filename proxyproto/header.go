@@ -0,0 +1,161 @@
+// Package proxyproto implements the HAProxy PROXY protocol (v1 and v2), used
+// by L4 load balancers such as AWS NLB to pass on the real client address.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// v2Signature starts every PROXY protocol v2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1MaxLen is the longest possible v1 header, CRLF included.
+const v1MaxLen = 107
+
+var errNoHeader = errors.New("proxyproto: connection did not start with a PROXY protocol header")
+
+// readHeader consumes a v1 or v2 header from r and returns the source and
+// destination it announces. Both are nil for LOCAL (v2) and UNKNOWN (v1)
+// headers, sent by health checks of the balancer itself.
+func readHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	peek, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(peek, v2Signature) {
+		return readV2(r)
+	}
+	if bytes.HasPrefix(peek, []byte("PROXY ")) {
+		return readV1(r)
+	}
+	return nil, nil, errNoHeader
+}
+
+func readV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, nil, fmt.Errorf("proxyproto: v1 header too long or not CRLF terminated")
+	}
+
+	fields := strings.Fields(text)
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("proxyproto: malformed v1 header %q", text)
+	}
+	src, err := tcpAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := tcpAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func tcpAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	if ip == nil || err != nil || p < 0 || p > 65535 {
+		return nil, fmt.Errorf("proxyproto: invalid address %s:%s", host, port)
+	}
+	return &net.TCPAddr{IP: ip, Port: p}, nil
+}
+
+func readV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, nil, err
+	}
+	verCmd, family := fixed[12], fixed[13]
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+
+	if verCmd>>4 != 2 {
+		return nil, nil, fmt.Errorf("proxyproto: unsupported v2 version %d", verCmd>>4)
+	}
+	switch verCmd & 0xF {
+	case 0x0: // LOCAL
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("proxyproto: unsupported v2 command %d", verCmd&0xF)
+	}
+
+	// Address blocks; anything after them is TLVs, which we ignore.
+	var size int
+	switch family {
+	case 0x11: // TCP over IPv4
+		size = 4
+	case 0x21: // TCP over IPv6
+		size = 16
+	default:
+		return nil, nil, nil // UDP, unix sockets, unspec: keep the socket address
+	}
+	if len(payload) < 2*size+4 {
+		return nil, nil, fmt.Errorf("proxyproto: truncated v2 address block")
+	}
+	src := &net.TCPAddr{IP: net.IP(payload[:size]), Port: int(binary.BigEndian.Uint16(payload[2*size:]))}
+	dst := &net.TCPAddr{IP: net.IP(payload[size : 2*size]), Port: int(binary.BigEndian.Uint16(payload[2*size+2:]))}
+	return src, dst, nil
+}
+
+// Header encodes a PROXY protocol header of the given version (1 or 2) for a
+// TCP connection from src to dst. Non-TCP addresses produce the UNKNOWN (v1)
+// or LOCAL (v2) form.
+func Header(version int, src, dst net.Addr) []byte {
+	s, sok := src.(*net.TCPAddr)
+	d, dok := dst.(*net.TCPAddr)
+	ok := sok && dok && s != nil && d != nil
+	ipv4 := ok && s.IP.To4() != nil && d.IP.To4() != nil
+
+	if version == 1 {
+		switch {
+		case !ok:
+			return []byte("PROXY UNKNOWN\r\n")
+		case ipv4:
+			return fmt.Appendf(nil, "PROXY TCP4 %s %s %d %d\r\n", s.IP.To4(), d.IP.To4(), s.Port, d.Port)
+		default:
+			return fmt.Appendf(nil, "PROXY TCP6 %s %s %d %d\r\n", s.IP.To16(), d.IP.To16(), s.Port, d.Port)
+		}
+	}
+
+	hdr := append([]byte(nil), v2Signature...)
+	if !ok {
+		return append(hdr, 0x20, 0x00, 0x00, 0x00) // LOCAL, no address
+	}
+	var family byte = 0x21
+	srcIP, dstIP := s.IP.To16(), d.IP.To16()
+	if ipv4 {
+		family, srcIP, dstIP = 0x11, s.IP.To4(), d.IP.To4()
+	}
+	hdr = append(hdr, 0x21, family)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(2*len(srcIP)+4))
+	hdr = append(hdr, srcIP...)
+	hdr = append(hdr, dstIP...)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(s.Port))
+	return binary.BigEndian.AppendUint16(hdr, uint16(d.Port))
+}
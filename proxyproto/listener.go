@@ -0,0 +1,154 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"reverse-proxy/limit"
+)
+
+// Listener reads a PROXY protocol header on every connection coming from a
+// trusted peer (the L4 balancer) and reports the client it announces as the
+// connection's RemoteAddr. Connections from other peers are passed through
+// untouched, so clients cannot spoof their address by sending a header.
+//
+// Headers are read in a goroutine per connection, bounded by a timeout, so a
+// peer that connects and stays silent never blocks the accept loop.
+type Listener struct {
+	net.Listener
+	trusted []*net.IPNet // empty trusts every peer
+	timeout time.Duration
+
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener wraps ln. trusted lists the IPs/CIDRs of the balancers allowed
+// to send headers (empty = every peer must send one); timeout bounds the wait
+// for a header and defaults to 5s.
+func NewListener(ln net.Listener, trusted []string, timeout time.Duration) (*Listener, error) {
+	nets, err := limit.ParseCIDRs(trusted)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	l := &Listener{
+		Listener: ln,
+		trusted:  nets,
+		timeout:  timeout,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l, nil
+}
+
+func (l *Listener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			l.errs <- err
+			return
+		}
+		go l.handshake(c)
+	}
+}
+
+func (l *Listener) handshake(c net.Conn) {
+	if !l.isTrusted(c.RemoteAddr()) {
+		l.deliver(c)
+		return
+	}
+
+	c.SetReadDeadline(time.Now().Add(l.timeout))
+	reader := bufio.NewReader(c)
+	src, dst, err := readHeader(reader)
+	if err != nil {
+		log.Printf("PROXY protocol: rejecting connection from %s: %v", c.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+	c.SetReadDeadline(time.Time{})
+	l.deliver(&Conn{Conn: c, reader: reader, src: src, dst: dst})
+}
+
+func (l *Listener) deliver(c net.Conn) {
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	if len(l.trusted) == 0 {
+		return true
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.trusted {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accept returns the next connection whose header (if any) has been read.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case err := <-l.errs:
+		l.errs <- err // keep reporting it to later callers
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// Conn is a connection whose PROXY protocol header has been consumed.
+type Conn struct {
+	net.Conn
+	reader   *bufio.Reader // may hold bytes read past the header
+	src, dst net.Addr      // nil for LOCAL/UNKNOWN headers
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// RemoteAddr returns the client announced by the header, or the peer's
+// address when the header carried none.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// DestinationAddr returns the address the client originally connected to
+// (on the balancer), or nil when the header carried none.
+func (c *Conn) DestinationAddr() net.Addr {
+	return c.dst
+}
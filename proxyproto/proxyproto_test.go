@@ -0,0 +1,145 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHeader_RoundTrip(t *testing.T) {
+	cases := []struct {
+		name     string
+		src, dst *net.TCPAddr
+	}{
+		{"ipv4", &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234}, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}},
+		{"ipv6", &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 51234}, &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}},
+	}
+	for _, c := range cases {
+		for _, version := range []int{1, 2} {
+			payload := append(Header(version, c.src, c.dst), "GET / HTTP/1.1\r\n"...)
+			r := bufio.NewReader(bytes.NewReader(payload))
+			src, dst, err := readHeader(r)
+			if err != nil {
+				t.Fatalf("%s v%d: %v", c.name, version, err)
+			}
+			if src.String() != c.src.String() || dst.String() != c.dst.String() {
+				t.Errorf("%s v%d: got %v -> %v, want %v -> %v", c.name, version, src, dst, c.src, c.dst)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "GET / HTTP/1.1\r\n" {
+				t.Errorf("%s v%d: header consumed too much or too little, rest %q", c.name, version, rest)
+			}
+		}
+	}
+}
+
+func TestHeader_RejectsGarbage(t *testing.T) {
+	for _, payload := range []string{"GET / HTTP/1.1\r\n\r\n", "PROXY TCP4 nope\r\n\r\n"} {
+		if _, _, err := readHeader(bufio.NewReader(bytes.NewReader([]byte(payload)))); err == nil {
+			t.Errorf("expected an error for %q", payload)
+		}
+	}
+}
+
+func listen(t *testing.T, trusted []string) *Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewListener(ln, trusted, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestListener_UsesAnnouncedClientAddress(t *testing.T) {
+	l := listen(t, []string{"127.0.0.1"})
+
+	// A silent peer must not hold up the next connection.
+	silent, _ := net.Dial("tcp", l.Addr().String())
+	defer silent.Close()
+
+	c, _ := net.Dial("tcp", l.Addr().String())
+	defer c.Close()
+	src := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 40000}
+	c.Write(append(Header(2, src, c.RemoteAddr()), "hello"...))
+
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+	if got := accepted.RemoteAddr().String(); got != src.String() {
+		t.Errorf("RemoteAddr = %s, want %s", got, src)
+	}
+	buf := make([]byte, 5)
+	io.ReadFull(accepted, buf)
+	if string(buf) != "hello" {
+		t.Errorf("payload after the header = %q", buf)
+	}
+}
+
+func TestListener_UntrustedPeerIsNotParsed(t *testing.T) {
+	l := listen(t, []string{"10.0.0.0/8"})
+
+	c, _ := net.Dial("tcp", l.Addr().String())
+	defer c.Close()
+	spoofed := Header(1, &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1}, c.RemoteAddr())
+	c.Write(spoofed)
+
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+	if got := accepted.RemoteAddr().(*net.TCPAddr).IP.String(); got != "127.0.0.1" {
+		t.Errorf("untrusted peer spoofed its address: %s", got)
+	}
+	buf := make([]byte, len(spoofed))
+	io.ReadFull(accepted, buf)
+	if !bytes.Equal(buf, spoofed) {
+		t.Errorf("header bytes from an untrusted peer must be left in the stream")
+	}
+}
+
+func TestDialContext_SendsClientAddress(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan net.Addr, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		src, _, _ := readHeader(bufio.NewReader(c))
+		got <- src
+	}()
+
+	ctx := WithClientAddr(context.Background(), "198.51.100.9:1234")
+	ctx = context.WithValue(ctx, http.LocalAddrContextKey, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80})
+	c, err := DialContext(1, (&net.Dialer{}).DialContext)(ctx, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	select {
+	case src := <-got:
+		if src == nil || src.String() != "198.51.100.9:1234" {
+			t.Errorf("backend saw client %v, want 198.51.100.9:1234", src)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("backend never received a header")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+
+	"reverse-proxy/capture"
+)
+
+// runReplay sends the requests of a capture file to the targets and prints
+// how they were answered. Ctrl+C stops it early, with the report so far.
+func runReplay(path, targets string, rate float64, concurrency int) error {
+	opts := capture.ReplayOptions{Rate: rate, Concurrency: concurrency}
+	for _, raw := range strings.Split(targets, ",") {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid replay target %q", raw)
+		}
+		opts.Targets = append(opts.Targets, u)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := capture.Replay(ctx, f, opts)
+
+	log.Printf("Replay finished: %d requests in %v", report.Sent, report.Duration)
+	log.Printf("  failures:    %d", report.Failed)
+	log.Printf("  latency:     p50=%v p90=%v p99=%v",
+		report.Percentile(0.50), report.Percentile(0.90), report.Percentile(0.99))
+	codes := make([]int, 0, len(report.Statuses))
+	for code := range report.Statuses {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	for _, code := range codes {
+		log.Printf("  status %d: %d", code, report.Statuses[code])
+	}
+	return err
+}
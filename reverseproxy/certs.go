@@ -0,0 +1,123 @@
+package reverseproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"os"
+	"reverse-proxy/admin"
+	"sync"
+	"time"
+)
+
+// certReloader serves the listener certificate from tls_cert_file and
+// tls_key_file, re-reading them when they change on disk or on POST
+// /certs/reload. Handshakes pick up the new certificate while established
+// connections carry on, so a renewal drops nothing.
+type certReloader struct {
+	certFile, keyFile string
+
+	mux    sync.RWMutex
+	cert   *tls.Certificate
+	loaded fileStamp // of the files cert was read from
+	failed fileStamp // last pair that failed to load, logged once
+}
+
+// fileStamp identifies a version of the certificate and key files.
+type fileStamp struct {
+	cert, key time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate is the tls.Config hook serving the current certificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.cert, nil
+}
+
+// reload reads the files again. On failure, e.g. a key that does not match
+// the certificate, the current certificate stays in use.
+func (r *certReloader) reload() (admin.CertificateStatus, error) {
+	stamp, err := r.stamp()
+	if err != nil {
+		return admin.CertificateStatus{}, err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		r.mux.Lock()
+		r.failed = stamp
+		r.mux.Unlock()
+		return admin.CertificateStatus{}, err
+	}
+	r.mux.Lock()
+	r.cert, r.loaded = &cert, stamp
+	r.mux.Unlock()
+	return r.status(), nil
+}
+
+// stamp returns the modification times of the files. Kubernetes secret
+// volumes swap a symlink: Stat follows it to the new files.
+func (r *certReloader) stamp() (fileStamp, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{cert: certInfo.ModTime(), key: keyInfo.ModTime()}, nil
+}
+
+func (r *certReloader) status() admin.CertificateStatus {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return admin.CertificateStatus{
+		File:     r.certFile,
+		Subject:  r.cert.Leaf.Subject.String(),
+		DNSNames: r.cert.Leaf.DNSNames,
+		NotAfter: r.cert.Leaf.NotAfter,
+	}
+}
+
+// watch reloads the certificate whenever the files change, checking every
+// interval until ctx is cancelled. A renewal writing the certificate and
+// the key one after the other may be seen half done: that pair fails to
+// load and is retried once the second file lands.
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		stamp, err := r.stamp()
+		r.mux.RLock()
+		unchanged := stamp == r.loaded || stamp == r.failed
+		r.mux.RUnlock()
+		if err != nil || unchanged {
+			continue
+		}
+		if status, err := r.reload(); err != nil {
+			log.Printf("TLS certificate reload failed, keeping the current one: %v", err)
+		} else {
+			log.Printf("TLS certificate reloaded from %s (%s, expires %s)",
+				status.File, status.Subject, status.NotAfter.Format(time.RFC3339))
+		}
+	}
+}
+
+// tlsConfig is the listener TLS config serving the reloaded certificate.
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{GetCertificate: r.GetCertificate}
+}
@@ -0,0 +1,1644 @@
+// Package reverseproxy assembles the proxy from its configuration: pools,
+// routes, handlers, admin API and listeners. The reverse-proxy command is a
+// thin wrapper around it, and other Go programs can embed the proxy the same
+// way, e.g. to drive it from integration tests:
+//
+//	cfg, err := reverseproxy.LoadConfig("config/config.json")
+//	srv, err := reverseproxy.New(cfg)
+//	err = srv.Start()
+//	defer srv.Stop(ctx)
+package reverseproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reverse-proxy/acme"
+	"reverse-proxy/admin"
+	"reverse-proxy/auth"
+	"reverse-proxy/extension"
+	"reverse-proxy/health"
+	"reverse-proxy/limit"
+	"reverse-proxy/logging"
+	"reverse-proxy/notify"
+	"reverse-proxy/pool"
+	"reverse-proxy/proxy"
+	"reverse-proxy/route"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config is the proxy configuration, as read from config/config.json.
+type Config struct {
+	Port                 int               `json:"port"`
+	AdminPort            int               `json:"admin_port"`
+	Strategy             string            `json:"strategy"`
+	HealthCheckFrequency int               `json:"health_check_frequency"`
+	HealthCheckWorkers   int               `json:"health_check_workers"`  // checks run at once per pool; defaults to health.DefaultMaxConcurrent
+	HealthCheckBackoff   int               `json:"health_check_backoff"`  // seconds: max interval between checks of a DOWN backend, doubling up to it; 0 disables
+	HealthCheckType      string            `json:"health_check_type"`     // "http" (default): GET /health; "tcp": connect only; "grpc": grpc.health.v1
+	ProxyTimeout         int               `json:"proxy_timeout"`         // seconds; defaults to 30 if omitted
+	ResponseIdleTimeout  int               `json:"response_idle_timeout"` // seconds without body progress before aborting; 0 disables
+	Backends             []string          `json:"backends"`
+	Transport            TransportSettings `json:"transport"`
+	ShutdownWebhook      string            `json:"shutdown_webhook"` // optional URL receiving the shutdown report
+	Webhooks             []WebhookSettings `json:"webhooks"`         // notified of backend state changes
+	ShutdownTimeout      int               `json:"shutdown_timeout"` // seconds for the whole shutdown sequence; defaults to 10
+	ErrorResponse        ErrorSettings     `json:"error_response"`
+	ClientLimits         ClientLimits      `json:"client_limits"`
+	Concurrency          ConcurrencyLimits `json:"concurrency"`
+	Headers              HeaderSettings    `json:"headers"`
+	DecisionLog          string            `json:"decision_log"`    // file path, "stdout", or empty to disable
+	SchemeFailover       bool              `json:"scheme_failover"` // retry once with https/http on scheme mismatch
+	Routes               []RouteConfig     `json:"routes"`          // matched before the catch-all "backends"
+	Ingress              IngressSettings   `json:"ingress"`
+	OutlierDetection     OutlierSettings   `json:"outlier_detection"`
+	FlushInterval        int               `json:"flush_interval"` // ms; streams every response (-1 = flush after each write); 0 buffers all but text/event-stream
+	BufferSize           int               `json:"buffer_size"`    // bytes of the pooled buffers copying response bodies; defaults to proxy.DefaultBufferSize
+	Listener             ListenerSettings  `json:"listener"`
+	SlowStart            int               `json:"slow_start"` // seconds to ramp a backend back to full traffic after DOWN→UP; 0 disables
+	TCP                  []TCPProxyConfig  `json:"tcp"`        // layer 4 listeners, next to the HTTP one
+	Cache                CacheSettings     `json:"cache"`
+	Compression          CompressionConfig `json:"compression"`
+	MaxBodyBytes         int64             `json:"max_body_bytes"` // request body limit, 413 beyond; 0 = unlimited
+	IPFilter             IPFilterSettings  `json:"ip_filter"`      // checked for every route
+	CORS                 *proxy.CORSPolicy `json:"cors"`           // answered at the proxy; nil passes CORS through to the backends
+	StateFile            string            `json:"state_file"`     // keeps admin API changes across restarts; empty disables
+	Readiness            ReadinessSettings `json:"readiness"`
+	Retries              RetrySettings     `json:"retries"`
+	HostHeader           string            `json:"host_header"` // "preserve" (default), "backend" or an explicit host
+
+	// SecurityHeaders are added to every proxied response; routes may tune
+	// them with their own block.
+	SecurityHeaders *proxy.SecurityHeaders `json:"security_headers"`
+
+	// Redirects are answered before routing, e.g. for URLs moved by
+	// marketing; the first matching rule wins.
+	Redirects []proxy.RedirectRule `json:"redirects"`
+
+	// Static serves path prefixes from local directories instead of
+	// proxying them (assets, maintenance page).
+	Static []proxy.StaticDir `json:"static"`
+
+	// Admin secures the admin API listening on admin_port.
+	Admin AdminSettings `json:"admin"`
+
+	// FaultInjection enables /faults on the admin API, to inject latency,
+	// aborts and error statuses into the proxied requests. For staging.
+	FaultInjection bool `json:"fault_injection"`
+
+	// Capture records a sample of the requests to a file, for replaying
+	// with --replay.
+	Capture CaptureSettings `json:"capture"`
+
+	// StatsD pushes the metrics to a StatsD or DogStatsD agent over UDP.
+	StatsD *StatsDSettings `json:"statsd"`
+
+	// Bandwidth throttles the responses of some backends and client
+	// classes, next to the bandwidth of each route.
+	Bandwidth BandwidthSettings `json:"bandwidth"`
+
+	// BackendTags is the metadata of the backends, by URL, e.g.
+	// {"http://10.0.1.5:8080": {"region": "eu-west", "version": "v2"}}. Routes
+	// filter or prefer backends by it with tags and prefer_tags.
+	BackendTags map[string]map[string]string `json:"backend_tags"`
+
+	// BackendWeights is the initial weight of the backends, by URL, for the
+	// weighted strategies: e.g. 4 for a machine with four times the capacity
+	// of the others, which default to 1. The admin API can change them.
+	BackendWeights map[string]int `json:"backend_weights"`
+
+	// HealthCheckAuth adds credentials to the HTTP and gRPC health checks,
+	// for backends answering 401 on /health without them.
+	HealthCheckAuth *HealthCheckAuth `json:"health_check_auth"`
+
+	// MaxResponseBytes caps the response bodies of the backends (0 =
+	// unlimited) and ResponseContentTypes lists the media types they may
+	// answer with (empty = any), e.g. "application/json" or "text/*". A
+	// response breaking them gets 502 and its backend is marked DOWN.
+	MaxResponseBytes     int64    `json:"max_response_bytes"`
+	ResponseContentTypes []string `json:"response_content_types"`
+
+	// HealthCheckClient tunes the HTTP client of the health checks, apart
+	// from the transport of the proxied requests: e.g. a shorter timeout,
+	// or skip-verify for the self-signed certificates of dev backends.
+	HealthCheckClient HealthCheckClientSettings `json:"health_check_client"`
+
+	// Logging sends the application log to a file or syslog and rotates the
+	// log files, decision_log included.
+	Logging LogSettings `json:"logging"`
+
+	// Listeners are more addresses serving the same routes as port, each
+	// with its own TLS certificate (or none), e.g. ":80" next to a TLS
+	// port, or one address per interface.
+	Listeners []ListenerAddress `json:"listeners"`
+
+	// Middleware is for programs embedding the proxy: it runs on every route
+	// after the built-in checks, just before load balancing.
+	Middleware []proxy.Middleware `json:"-"`
+
+	path          string                 // file the config was loaded from, re-read by the admin API's /reload
+	healthHeaders map[string]http.Header // by route, "" for the global one; see prepare
+	healthTLS     *tls.Config            // of health_check_client.tls; nil keeps the backends' own
+	healthProxy   func(*http.Request) (*url.URL, error)
+}
+
+// AdminSettings configures access to the admin API.
+type AdminSettings struct {
+	// Token is required as a bearer token by every endpoint but the health
+	// probes; empty leaves the API open to whoever reaches admin_port.
+	Token string `json:"token"`
+	// Tokens are named credentials with a role: "read-only" tokens may only
+	// read (GET), "operator" ones may also change backends and config. Token
+	// counts as an operator token.
+	Tokens []admin.Token `json:"tokens"`
+	// Debug exposes /debug/pprof/ and /debug/runtime; it requires an
+	// operator token.
+	Debug bool `json:"debug"`
+	// AuditLog is the file every admin mutation is appended to, read back
+	// by GET /audit; empty disables auditing.
+	AuditLog string `json:"audit_log"`
+
+	// Bind is the address the admin API listens on, with admin_port;
+	// defaults to 127.0.0.1 so that only the host reaches it. "0.0.0.0" or
+	// "::" listens on every interface.
+	Bind string `json:"bind"`
+	// TLSCertFile and TLSKeyFile serve the admin API over HTTPS with its own
+	// certificate, reloaded like the listener's. ClientCAFile further
+	// requires a client certificate signed by one of its CAs (mutual TLS).
+	TLSCertFile  string `json:"tls_cert_file"`
+	TLSKeyFile   string `json:"tls_key_file"`
+	ClientCAFile string `json:"client_ca_file"`
+}
+
+func (a AdminSettings) validate() error {
+	names := map[string]bool{}
+	secrets := map[string]bool{a.Token: a.Token != ""}
+	operator := a.Token != ""
+	for i, t := range a.Tokens {
+		if t.Name == "" || t.Secret == "" {
+			return fmt.Errorf("admin.tokens[%d]: name and token are required", i)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("admin.tokens[%d]: duplicate name %q", i, t.Name)
+		}
+		if secrets[t.Secret] {
+			return fmt.Errorf("admin.tokens[%d] (%s): token already used", i, t.Name)
+		}
+		switch t.Role {
+		case admin.RoleOperator:
+			operator = true
+		case admin.RoleReadOnly:
+		default:
+			return fmt.Errorf("admin.tokens[%d] (%s): unknown role %q, expected read-only or operator", i, t.Name, t.Role)
+		}
+		names[t.Name], secrets[t.Secret] = true, true
+	}
+	if a.Debug && !operator {
+		return errors.New("admin.debug requires an operator token: profiles expose the process memory")
+	}
+	if (a.TLSCertFile == "") != (a.TLSKeyFile == "") {
+		return errors.New("admin: tls_cert_file and tls_key_file go together")
+	}
+	if a.ClientCAFile != "" && a.TLSCertFile == "" {
+		return errors.New("admin.client_ca_file requires tls_cert_file and tls_key_file")
+	}
+	if _, err := a.clientCAs(); err != nil {
+		return fmt.Errorf("admin.client_ca_file: %w", err)
+	}
+	return nil
+}
+
+// clientCAs reads client_ca_file; nil when client certificates are not
+// required.
+func (a AdminSettings) clientCAs() (*x509.CertPool, error) {
+	if a.ClientCAFile == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(a.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no PEM certificate found", a.ClientCAFile)
+	}
+	return pool, nil
+}
+
+// CaptureSettings enables the request capture when File is set.
+type CaptureSettings struct {
+	File          string   `json:"file"`           // JSON lines, appended to
+	SamplePercent float64  `json:"sample_percent"` // default 100
+	BodyBytes     int64    `json:"body_bytes"`     // larger bodies are not recorded; 0 records none
+	RedactHeaders []string `json:"redact_headers"` // default capture.DefaultRedactHeaders
+}
+
+// StatsDSettings configures the push of the metrics, see statsd.Exporter.
+type StatsDSettings struct {
+	Address   string   `json:"address"`   // host:port of the agent, e.g. "127.0.0.1:8125"
+	Prefix    string   `json:"prefix"`    // e.g. "reverse_proxy"
+	Interval  int      `json:"interval"`  // seconds between two pushes; defaults to 10
+	DogStatsD bool     `json:"dogstatsd"` // send route and backend as tags
+	Tags      []string `json:"tags"`      // "key:value" added to every metric; DogStatsD only
+}
+
+// BandwidthSettings are the bandwidth limits that are not tied to a route.
+type BandwidthSettings struct {
+	Backends map[string]int64      `json:"backends"` // backend URL → bytes per second, shared across routes
+	Classes  []ClientClassSettings `json:"classes"`
+}
+
+// ClientClassSettings configures a proxy.ClientClass: the requests from
+// clients, carrying header, on routes share bytes_per_second.
+type ClientClassSettings struct {
+	Name           string   `json:"name"`
+	Clients        []string `json:"clients"` // IPs or CIDRs; empty = any client
+	Header         string   `json:"header"`  // "Name: value"; empty = no header check
+	Routes         []string `json:"routes"`  // empty = every route
+	BytesPerSecond int64    `json:"bytes_per_second"`
+}
+
+func (bw BandwidthSettings) validate(routes map[string]bool) error {
+	for backend, rate := range bw.Backends {
+		if _, err := url.Parse(backend); err != nil || rate <= 0 {
+			return fmt.Errorf("bandwidth.backends: %q needs a valid URL and a positive rate", backend)
+		}
+	}
+	seen := map[string]bool{}
+	for i, c := range bw.Classes {
+		if c.Name == "" || seen[c.Name] {
+			return fmt.Errorf("bandwidth.classes[%d]: a unique name is required", i)
+		}
+		seen[c.Name] = true
+		if c.BytesPerSecond <= 0 {
+			return fmt.Errorf("bandwidth class %s: bytes_per_second must be positive", c.Name)
+		}
+		if len(c.Clients) == 0 && c.Header == "" {
+			return fmt.Errorf("bandwidth class %s: clients or header is required", c.Name)
+		}
+		if _, err := limit.NewIPFilter(c.Clients, nil); err != nil {
+			return fmt.Errorf("bandwidth class %s: clients: %w", c.Name, err)
+		}
+		if name, _, ok := strings.Cut(c.Header, ":"); c.Header != "" && (!ok || strings.TrimSpace(name) == "") {
+			return fmt.Errorf("bandwidth class %s: header must be \"Name: value\"", c.Name)
+		}
+		for _, r := range c.Routes {
+			if !routes[r] {
+				return fmt.Errorf("bandwidth class %s: unknown route %q", c.Name, r)
+			}
+		}
+	}
+	return nil
+}
+
+// backends builds the per-backend limits, keyed by normalized URL.
+func (bw BandwidthSettings) backends() map[string]*limit.Bandwidth {
+	if len(bw.Backends) == 0 {
+		return nil
+	}
+	limits := make(map[string]*limit.Bandwidth, len(bw.Backends))
+	for backend, rate := range bw.Backends {
+		u, _ := url.Parse(backend) // validated by prepare
+		limits[u.String()] = limit.NewBandwidth(rate)
+	}
+	return limits
+}
+
+func (bw BandwidthSettings) classes() []*proxy.ClientClass {
+	var classes []*proxy.ClientClass
+	for _, c := range bw.Classes {
+		class := &proxy.ClientClass{
+			Name:      c.Name,
+			Routes:    c.Routes,
+			Bandwidth: limit.NewBandwidth(c.BytesPerSecond),
+		}
+		if len(c.Clients) > 0 {
+			class.Clients, _ = limit.NewIPFilter(c.Clients, nil) // validated by prepare
+		}
+		if name, value, ok := strings.Cut(c.Header, ":"); ok {
+			class.Header = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			class.HeaderValue = strings.TrimSpace(value)
+		}
+		classes = append(classes, class)
+	}
+	return classes
+}
+
+// LogSettings configures the log outputs. The application log goes to file
+// (stderr without one) and, with syslog, to syslog as well.
+type LogSettings struct {
+	File     string           `json:"file"`
+	Rotation RotationSettings `json:"rotation"` // of file and of a decision_log file
+	Syslog   *SyslogSettings  `json:"syslog"`
+}
+
+// RotationSettings rotate a log file by size and by age, see
+// logging.Rotation. The zero value never rotates.
+type RotationSettings struct {
+	MaxSizeMB  int `json:"max_size_mb"` // 0 = no size limit
+	Interval   int `json:"interval"`    // hours, e.g. 24 rotates at midnight UTC; 0 = never by age
+	MaxBackups int `json:"max_backups"` // rotated files kept; 0 keeps them all
+}
+
+// SyslogSettings configures the RFC 5424 syslog output, see
+// logging.SyslogConfig.
+type SyslogSettings struct {
+	Address     string `json:"address"`      // empty = local daemon, or udp://host:port, tcp://host:port, unix:///path
+	Facility    string `json:"facility"`     // defaults to "local0"
+	AppName     string `json:"app_name"`     // defaults to "reverse-proxy"
+	DecisionLog bool   `json:"decision_log"` // send the decision log too, with MSGID "decision"
+}
+
+// ApplicationLog opens the output of the application log (the log
+// package) configured in logging, for the command to pass to log.SetOutput.
+// It returns nil when logging keeps the default, stderr.
+func (cfg *Config) ApplicationLog() (io.WriteCloser, error) {
+	var outputs logging.Tee
+	if cfg.Logging.File != "" {
+		f, err := logging.OpenFile(cfg.Logging.File, cfg.Logging.Rotation.rotation())
+		if err != nil {
+			return nil, fmt.Errorf("logging.file: %w", err)
+		}
+		outputs = append(outputs, f)
+	}
+	if sl := cfg.Logging.Syslog; sl != nil {
+		if len(outputs) == 0 {
+			outputs = append(outputs, nopCloser{os.Stderr})
+		}
+		w, err := sl.dial("")
+		if err != nil {
+			outputs.Close()
+			return nil, err
+		}
+		outputs = append(outputs, w)
+	}
+	if len(outputs) == 0 {
+		return nil, nil
+	}
+	return outputs, nil
+}
+
+// decisionLog opens the outputs of the decision log, nil when it is off.
+func (cfg *Config) decisionLog() (io.WriteCloser, error) {
+	var outputs logging.Tee
+	switch cfg.DecisionLog {
+	case "":
+	case "stdout":
+		outputs = append(outputs, nopCloser{os.Stdout})
+	default:
+		f, err := logging.OpenFile(cfg.DecisionLog, cfg.Logging.Rotation.rotation())
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, f)
+	}
+	if sl := cfg.Logging.Syslog; sl != nil && sl.DecisionLog {
+		w, err := sl.dial("decision")
+		if err != nil {
+			outputs.Close()
+			return nil, err
+		}
+		outputs = append(outputs, w)
+	}
+	if len(outputs) == 0 {
+		return nil, nil
+	}
+	return outputs, nil
+}
+
+func (r RotationSettings) rotation() logging.Rotation {
+	return logging.Rotation{
+		MaxBytes:   int64(r.MaxSizeMB) << 20,
+		Interval:   time.Duration(r.Interval) * time.Hour,
+		MaxBackups: r.MaxBackups,
+	}
+}
+
+func (sl *SyslogSettings) dial(msgID string) (*logging.Syslog, error) {
+	w, err := logging.DialSyslog(logging.SyslogConfig{
+		Address:  sl.Address,
+		Facility: sl.Facility,
+		AppName:  sl.AppName,
+		MsgID:    msgID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("logging.syslog: %w", err)
+	}
+	return w, nil
+}
+
+// nopCloser keeps stdout and stderr open when the log outputs are closed.
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// ListenerAddress is one more proxy listener. It shares the settings of
+// listener (timeouts, h2c, PROXY protocol, HSTS) and client_limits, but not
+// its certificate: without tls_cert_file and tls_key_file it is plain HTTP.
+type ListenerAddress struct {
+	Address     string `json:"address"` // "host:port" or ":port"
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+}
+
+func (cfg *Config) validateListeners() error {
+	seen := map[string]bool{}
+	for i, l := range cfg.Listeners {
+		if _, port, err := net.SplitHostPort(l.Address); err != nil || port == "" {
+			return fmt.Errorf("listeners[%d]: invalid address %q, expected host:port or :port", i, l.Address)
+		}
+		if seen[l.Address] {
+			return fmt.Errorf("listeners[%d]: %s listed twice", i, l.Address)
+		}
+		seen[l.Address] = true
+		if (l.TLSCertFile == "") != (l.TLSKeyFile == "") {
+			return fmt.Errorf("listeners[%d]: tls_cert_file and tls_key_file go together", i)
+		}
+	}
+	return nil
+}
+
+// RetrySettings decides which failed requests are sent to another backend.
+type RetrySettings struct {
+	BufferBytes   int64 `json:"buffer_bytes"`   // request bodies kept in memory for replay; defaults to 64 KiB, -1 disables
+	NonIdempotent bool  `json:"non_idempotent"` // also retry POST/PATCH after a failure that may have reached the backend
+
+	// Budget caps the retries to a share of the requests, so failovers
+	// cannot amplify the load on struggling backends.
+	Budget *RetryBudgetSettings `json:"budget"`
+}
+
+// RetryBudgetSettings configures limit.RetryBudget.
+type RetryBudgetSettings struct {
+	Percent    float64 `json:"percent"`     // retries allowed, in % of the requests, e.g. 20
+	MinRetries int     `json:"min_retries"` // per window, whatever the traffic; defaults to 10
+	Window     int     `json:"window"`      // seconds of the sliding window; defaults to 10
+	PerBackend bool    `json:"per_backend"` // also cap the retries after failures of each backend by its own traffic
+}
+
+// ReadinessSettings drives the admin API's /readyz probe.
+type ReadinessSettings struct {
+	MinBackends int `json:"min_backends"` // available backends needed to be ready; defaults to 1
+
+	// ShutdownDelay is how long, in seconds, the proxy keeps serving with
+	// /readyz failing before it drains, so the load balancers in front of
+	// it stop sending traffic first; shutdown_timeout starts after it.
+	ShutdownDelay int `json:"shutdown_delay"`
+}
+
+// IPFilterSettings lists client IPs/CIDRs to admit or reject. The denylist
+// wins; a non-empty allowlist admits only its members.
+type IPFilterSettings struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// CompressionConfig enables gzip/deflate compression of uncompressed backend
+// responses for clients that accept it.
+type CompressionConfig struct {
+	Enabled bool     `json:"enabled"`
+	MinSize int      `json:"min_size"` // bytes; defaults to 1024
+	Types   []string `json:"types"`    // content types to compress ("text/*" allowed); defaults to compression.DefaultTypes
+	Level   int      `json:"level"`    // 1 (fast) to 9 (small); 0 = default
+}
+
+// CacheSettings configures the in-memory response cache.
+type CacheSettings struct {
+	Enabled    bool `json:"enabled"`
+	MaxSizeMB  int  `json:"max_size_mb"`  // defaults to 64
+	MaxEntryKB int  `json:"max_entry_kb"` // larger responses are not cached; defaults to 1024
+	DefaultTTL int  `json:"default_ttl"`  // seconds for responses without Cache-Control/Expires; 0 = don't cache them
+	Coalesce   bool `json:"coalesce"`     // concurrent misses on a resource wait for a single backend fetch; routes may override it
+}
+
+// TCPProxyConfig is a layer 4 listener balancing raw TCP connections
+// (databases, MQTT, custom protocols) across its own backends.
+type TCPProxyConfig struct {
+	Name        string   `json:"name"`
+	Port        int      `json:"port"`
+	Strategy    string   `json:"strategy"`     // defaults to the top-level strategy
+	Backends    []string `json:"backends"`     // host:port or tcp://host:port
+	DialTimeout int      `json:"dial_timeout"` // seconds; defaults to 5
+}
+
+// ListenerSettings controls the protocols accepted by the proxy listener.
+// With a certificate, HTTP/2 is negotiated through ALPN; H2C accepts
+// cleartext HTTP/2 (prior knowledge), e.g. from gRPC clients without TLS.
+type ListenerSettings struct {
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+	H2C         bool   `json:"h2c"`
+
+	// TLSReloadInterval is how often, in seconds, the certificate files are
+	// checked for changes (default 10, -1 disables); POST /certs/reload
+	// reloads them on demand.
+	TLSReloadInterval int `json:"tls_reload_interval"`
+
+	// HTTPRedirectPort opens a plain HTTP listener that answers every request
+	// with a 301 to the HTTPS listener; 0 disables it. With acme, its
+	// http_port listener redirects already: leave this at 0 or set both the
+	// same.
+	HTTPRedirectPort int `json:"http_redirect_port"`
+	// HSTS adds Strict-Transport-Security to the responses served over TLS.
+	HSTS *proxy.HSTSPolicy `json:"hsts"`
+
+	// ProxyProtocol reads PROXY protocol v1/v2 headers sent by an L4
+	// balancer (e.g. AWS NLB) to recover the real client address.
+	ProxyProtocol ProxyProtocolSettings `json:"proxy_protocol"`
+
+	// ACME obtains and renews the certificate of the listener from Let's
+	// Encrypt (or another ACME CA) instead of tls_cert_file/tls_key_file.
+	ACME *ACMESettings `json:"acme"`
+
+	// Server timeouts, in seconds. ReadHeaderTimeout (default 10) and
+	// IdleTimeout (default 120) keep slowloris-style clients from holding
+	// connections; -1 disables them. ReadTimeout and WriteTimeout bound whole
+	// requests and responses, so they are off by default: they would cut
+	// long uploads and streams (SSE, gRPC).
+	ReadTimeout       int `json:"read_timeout"`
+	ReadHeaderTimeout int `json:"read_header_timeout"`
+	WriteTimeout      int `json:"write_timeout"`
+	IdleTimeout       int `json:"idle_timeout"`
+	MaxHeaderBytes    int `json:"max_header_bytes"` // 0 keeps the net/http default (1 MB)
+
+	// ReusePort binds the listeners with SO_REUSEPORT, so that a second
+	// process can bind the same ports during a deploy (Linux and BSDs).
+	ReusePort bool `json:"reuse_port"`
+}
+
+// configure applies the timeouts and header limit to the proxy server.
+func (l ListenerSettings) configure(server *http.Server) {
+	seconds := func(v int) time.Duration {
+		if v <= 0 {
+			return 0 // disabled
+		}
+		return time.Duration(v) * time.Second
+	}
+	server.ReadTimeout = seconds(l.ReadTimeout)
+	server.ReadHeaderTimeout = seconds(l.ReadHeaderTimeout)
+	server.WriteTimeout = seconds(l.WriteTimeout)
+	server.IdleTimeout = seconds(l.IdleTimeout)
+	server.MaxHeaderBytes = l.MaxHeaderBytes
+}
+
+// ACMESettings configures automatic certificates.
+type ACMESettings struct {
+	Domains      []string `json:"domains"`
+	Email        string   `json:"email"`         // contact for expiry notices
+	CacheDir     string   `json:"cache_dir"`     // account key and certificates; defaults to "acme-cache"
+	DirectoryURL string   `json:"directory_url"` // defaults to Let's Encrypt production
+	Challenge    string   `json:"challenge"`     // "tls-alpn-01" (default) or "http-01"
+	HTTPPort     int      `json:"http_port"`     // http-01 challenges and redirects to https; defaults to 80, -1 disables
+}
+
+// manager builds the ACME manager of the listener, nil when ACME is off.
+func (a *ACMESettings) manager() (*acme.Manager, error) {
+	if a == nil {
+		return nil, nil
+	}
+	if len(a.Domains) == 0 {
+		return nil, errors.New("listener.acme.domains is required")
+	}
+	for _, d := range a.Domains {
+		if d == "" || strings.ContainsAny(d, "*/: ") {
+			return nil, fmt.Errorf("listener.acme.domains: invalid domain %q (wildcards need a DNS-01 challenge)", d)
+		}
+	}
+	if c := a.Challenge; c != "" && c != acme.ChallengeTLSALPN01 && c != acme.ChallengeHTTP01 {
+		return nil, fmt.Errorf("invalid listener.acme.challenge: %s (must be '%s' or '%s')", c, acme.ChallengeTLSALPN01, acme.ChallengeHTTP01)
+	}
+	if a.Challenge == acme.ChallengeHTTP01 && a.HTTPPort < 0 {
+		return nil, errors.New("listener.acme.http_port is required by the http-01 challenge")
+	}
+	cacheDir := a.CacheDir
+	if cacheDir == "" {
+		cacheDir = "acme-cache"
+	}
+	domains := make([]string, len(a.Domains))
+	for i, d := range a.Domains {
+		domains[i] = strings.ToLower(d)
+	}
+	return &acme.Manager{
+		Domains:      domains,
+		Email:        a.Email,
+		CacheDir:     cacheDir,
+		DirectoryURL: a.DirectoryURL,
+		Challenge:    a.Challenge,
+	}, nil
+}
+
+// ProxyProtocolSettings configures inbound PROXY protocol.
+type ProxyProtocolSettings struct {
+	Enabled bool     `json:"enabled"`
+	Trusted []string `json:"trusted"` // balancer IPs/CIDRs; empty = every peer must send a header
+	Timeout int      `json:"timeout"` // seconds to wait for the header; defaults to 5
+}
+
+// OutlierSettings configures outlier ejection; zero values take the
+// defaults of pool.OutlierConfig. Durations are in seconds.
+type OutlierSettings struct {
+	Enabled            bool    `json:"enabled"`
+	Interval           int     `json:"interval"`
+	MinRequests        int     `json:"min_requests"`
+	ErrorRateStdev     float64 `json:"error_rate_stdev"`
+	MinErrorRate       float64 `json:"min_error_rate"`
+	LatencyFactor      float64 `json:"latency_factor"`
+	BaseEjection       int     `json:"base_ejection"`
+	MaxEjection        int     `json:"max_ejection"`
+	MaxEjectionPercent int     `json:"max_ejection_percent"`
+	Ramp               int     `json:"ramp"`
+}
+
+// IngressSettings enables the Kubernetes Ingress controller mode: Ingresses
+// of the given class become routes, next to the ones of this file.
+type IngressSettings struct {
+	Enabled      bool   `json:"enabled"`
+	Class        string `json:"class"`         // IngressClass name; defaults to "reverse-proxy"
+	Namespace    string `json:"namespace"`     // empty watches every namespace
+	SyncInterval int    `json:"sync_interval"` // seconds between syncs; defaults to 10
+	APIServer    string `json:"api_server"`    // out-of-cluster API URL (e.g. kubectl proxy); in-cluster credentials if empty
+}
+
+// RouteConfig sends requests matching Host and PathPrefix to their own
+// backends, optionally split across weighted groups (canary releases).
+type RouteConfig struct {
+	Name            string            `json:"name"`
+	Host            string            `json:"host"`        // empty matches any host
+	PathPrefix      string            `json:"path_prefix"` // defaults to "/"
+	Backends        []string          `json:"backends"`    // used when no groups are given
+	Groups          []GroupConfig     `json:"groups"`
+	ProxyTimeout    int               `json:"proxy_timeout"`     // seconds; overrides the global proxy_timeout for this route
+	HealthCheckType string            `json:"health_check_type"` // overrides the global health_check_type for this route
+	H2C             bool              `json:"h2c"`               // cleartext HTTP/2 to the backends (plaintext gRPC servers)
+	ProxyProtocol   int               `json:"proxy_protocol"`    // overrides transport.proxy_protocol for this route
+	MaxBodyBytes    int64             `json:"max_body_bytes"`    // overrides the global limit; -1 = unlimited
+	IPFilter        IPFilterSettings  `json:"ip_filter"`         // checked after the global one
+	JWT             *JWTSettings      `json:"jwt"`               // require a valid bearer token on this route
+	CORS            *proxy.CORSPolicy `json:"cors"`              // replaces the global CORS policy for this route
+	HostHeader      string            `json:"host_header"`       // overrides the global host_header for this route
+	Bandwidth       int64             `json:"bandwidth"`         // bytes per second shared by the route's responses; 0 = unlimited
+	HedgeDelay      int               `json:"hedge_delay"`       // ms; GET/HEAD not answered by then are also sent to a second backend; 0 = off
+	CacheCoalesce   *bool             `json:"cache_coalesce"`    // overrides cache.coalesce for this route
+	HealthCheckAuth *HealthCheckAuth  `json:"health_check_auth"` // replaces the global health_check_auth for this route
+	Tags            map[string]string `json:"tags"`              // only the backends carrying these backend_tags get the route's traffic
+	PreferTags      map[string]string `json:"prefer_tags"`       // the backends carrying these get it while any is available, e.g. the same region
+
+	// MaxResponseBytes overrides the global max_response_bytes (-1 =
+	// unlimited); ResponseContentTypes replaces the global
+	// response_content_types.
+	MaxResponseBytes     int64    `json:"max_response_bytes"`
+	ResponseContentTypes []string `json:"response_content_types"`
+
+	// SecurityHeaders overrides the non-empty fields of the global
+	// security_headers for this route; "off" drops a header.
+	SecurityHeaders *proxy.SecurityHeaders `json:"security_headers"`
+
+	// Extensions run their OnRequest/OnResponse hooks on this route, in
+	// order, after the built-in checks (see package extension).
+	Extensions []extension.Config `json:"extensions"`
+
+	// Rule further restricts the route to the requests it matches, e.g.
+	// header("X-Beta") == "true"; see route.Rule for the syntax.
+	Rule string `json:"rule"`
+
+	// Rewrite maps the public path to the one the backends know, e.g.
+	// strip_prefix "/api/v1" forwards /api/v1/users as /users.
+	Rewrite *proxy.PathRewrite `json:"rewrite"`
+}
+
+// backendURLs returns the backends of the route, those of its groups
+// included.
+func (rc RouteConfig) backendURLs() []string {
+	urls := rc.Backends
+	for _, g := range rc.Groups {
+		urls = append(urls, g.Backends...)
+	}
+	return urls
+}
+
+// tagPolicy returns how the route narrows its backends by their tags.
+func (rc RouteConfig) tagPolicy() pool.TagPolicy {
+	return pool.TagPolicy{Require: rc.Tags, Prefer: rc.PreferTags}
+}
+
+// middleware loads the route's extensions.
+func (rc RouteConfig) middleware() ([]proxy.Middleware, error) {
+	var mws []proxy.Middleware
+	for i, ec := range rc.Extensions {
+		e, err := extension.Load(ec)
+		if err != nil {
+			return nil, fmt.Errorf("extensions[%d]: %w", i, err)
+		}
+		mws = append(mws, e.Middleware)
+	}
+	return mws, nil
+}
+
+// JWTSettings configures token verification for a route. Exactly one of
+// Secret (HS256), PublicKeyFile (RS256, PEM) or JWKSURL is required.
+type JWTSettings struct {
+	Secret        string            `json:"secret"`
+	PublicKeyFile string            `json:"public_key_file"`
+	JWKSURL       string            `json:"jwks_url"`
+	Issuer        string            `json:"issuer"`
+	Audience      string            `json:"audience"`
+	Leeway        int               `json:"leeway"`        // seconds of clock skew allowed on exp/nbf
+	ClaimHeaders  map[string]string `json:"claim_headers"` // claim → header forwarded to the backend, e.g. {"sub": "X-User-Id"}
+}
+
+func (j *JWTSettings) validator() (*auth.Validator, error) {
+	return auth.NewValidator(auth.Config{
+		Secret:        j.Secret,
+		PublicKeyFile: j.PublicKeyFile,
+		JWKSURL:       j.JWKSURL,
+		Issuer:        j.Issuer,
+		Audience:      j.Audience,
+		Leeway:        time.Duration(j.Leeway) * time.Second,
+		ClaimHeaders:  j.ClaimHeaders,
+	})
+}
+
+// GroupConfig is one weighted set of backends of a route, e.g. "stable" at 95
+// and "canary" at 5. Weights are relative and can be changed through the admin API.
+// Groups of a higher priority only get traffic when every group of the lower
+// ones is down, e.g. a backup region.
+type GroupConfig struct {
+	Name     string   `json:"name"`
+	Weight   int      `json:"weight"`
+	Priority int      `json:"priority"` // failover tier: 0 (default) is tried first
+	Backends []string `json:"backends"`
+}
+
+// HeaderSettings holds the global header manipulation rules.
+type HeaderSettings struct {
+	Request  proxy.HeaderRules `json:"request"`
+	Response proxy.HeaderRules `json:"response"`
+}
+
+// HealthCheckAuth holds the credentials sent with the health checks: a
+// bearer token, basic auth, and/or any headers, e.g. an API key.
+type HealthCheckAuth struct {
+	BearerToken *Secret           `json:"bearer_token"`
+	Username    string            `json:"username"` // basic auth, with password
+	Password    *Secret           `json:"password"`
+	Headers     map[string]Secret `json:"headers"`
+}
+
+// header resolves the credentials into the headers of the checks.
+func (a *HealthCheckAuth) header() (http.Header, error) {
+	h := make(http.Header)
+	if a.BearerToken != nil && a.Username != "" {
+		return nil, errors.New("use either bearer_token or username/password, not both")
+	}
+	if a.BearerToken != nil {
+		token, err := a.BearerToken.resolve()
+		if err != nil {
+			return nil, fmt.Errorf("bearer_token: %w", err)
+		}
+		h.Set("Authorization", "Bearer "+token)
+	}
+	if (a.Username == "") != (a.Password == nil) {
+		return nil, errors.New("basic auth needs both username and password")
+	}
+	if a.Username != "" {
+		password, err := a.Password.resolve()
+		if err != nil {
+			return nil, fmt.Errorf("password: %w", err)
+		}
+		h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(a.Username+":"+password)))
+	}
+	for name, secret := range a.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		value, err := secret.resolve()
+		if err != nil {
+			return nil, fmt.Errorf("headers[%s]: %w", name, err)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("headers[%s]: the value must fit on one line", name)
+		}
+		h.Set(name, value)
+	}
+	return h, nil
+}
+
+// Secret is a credential given inline (value), or read from the
+// environment variable env or from file, e.g. a mounted Kubernetes secret.
+// Exactly one of them is set. It is read when the config is loaded.
+type Secret struct {
+	Value string `json:"value"`
+	Env   string `json:"env"`
+	File  string `json:"file"`
+}
+
+func (s Secret) resolve() (string, error) {
+	set := 0
+	for _, field := range []string{s.Value, s.Env, s.File} {
+		if field != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return "", errors.New("set exactly one of value, env or file")
+	}
+	switch {
+	case s.Env != "":
+		v, ok := os.LookupEnv(s.Env)
+		if !ok || v == "" {
+			return "", fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+		return v, nil
+	case s.File != "":
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return s.Value, nil
+}
+
+// ConcurrencyLimits caps in-flight requests forwarded to the backend pool,
+// and those served by the proxy as a whole.
+type ConcurrencyLimits struct {
+	MaxConcurrent int `json:"max_concurrent"` // 0 disables the ceiling
+	MaxQueue      int `json:"max_queue"`      // requests allowed to wait for a slot; beyond that → 503
+	QueueTimeout  int `json:"queue_timeout"`  // seconds a queued request may wait; defaults to 5
+	MaxInFlight   int `json:"max_in_flight"`  // requests served at once, cache hits included; beyond that → immediate 503; 0 disables
+}
+
+// concurrencyLimiter builds the limiter of one route from the concurrency
+// settings, so a saturated route does not reject the requests of the others.
+// It returns nil without a ceiling.
+func (cfg *Config) concurrencyLimiter() *limit.ConcurrencyLimiter {
+	c := cfg.Concurrency
+	if c.MaxConcurrent <= 0 {
+		return nil
+	}
+	return limit.NewConcurrencyLimiter(c.MaxConcurrent, c.MaxQueue, time.Duration(c.QueueTimeout)*time.Second)
+}
+
+// ClientLimits protects the proxy itself from a single misbehaving client.
+type ClientLimits struct {
+	MaxConnsPerIP    int      `json:"max_conns_per_ip"`    // 0 disables the limit
+	MaxRequestsPerIP int      `json:"max_requests_per_ip"` // in flight at once, 429 beyond; 0 disables the limit
+	Allowlist        []string `json:"allowlist"`           // IPs/CIDRs exempt from the limits
+}
+
+// WebhookSettings configures a webhook notified of backend state changes,
+// see notify.Config.
+type WebhookSettings struct {
+	URL     string   `json:"url"`     // text/template, e.g. with {{.Type}} or {{query .URL}}
+	Body    string   `json:"body"`    // JSON text/template; empty posts the event itself
+	Events  []string `json:"events"`  // e.g. ["down", "ejected"]; empty sends every event
+	Retries int      `json:"retries"` // extra attempts after a failure, 1s, 2s, 4s... apart
+	Timeout int      `json:"timeout"` // seconds per attempt; defaults to 5
+}
+
+// webhooks builds the configured webhooks.
+func (cfg *Config) webhooks() ([]*notify.Webhook, error) {
+	var hooks []*notify.Webhook
+	for i, wc := range cfg.Webhooks {
+		events := make([]pool.EventType, len(wc.Events))
+		for j, e := range wc.Events {
+			events[j] = pool.EventType(e)
+		}
+		hook, err := notify.NewWebhook(notify.Config{
+			URL:     wc.URL,
+			Body:    wc.Body,
+			Events:  events,
+			Retries: wc.Retries,
+			Timeout: time.Duration(wc.Timeout) * time.Second,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("webhooks[%d]: %w", i, err)
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// ErrorSettings controls the body of errors generated by the proxy (502/503/504).
+type ErrorSettings struct {
+	Format      string              `json:"format"`   // "text" (default) or "json"
+	Template    string              `json:"template"` // optional JSON template, see proxy.NewErrorResponder
+	Unavailable UnavailableSettings `json:"unavailable"`
+}
+
+// UnavailableSettings customizes the 503 answered when no backend is
+// available, e.g. to serve a maintenance page.
+type UnavailableSettings struct {
+	Status      int    `json:"status"`       // defaults to 503
+	RetryAfter  int    `json:"retry_after"`  // seconds, sent as Retry-After; 0 omits it
+	Body        string `json:"body"`         // HTML, JSON or text; empty keeps the error_response body
+	File        string `json:"file"`         // page read from disk at startup, instead of body
+	ContentType string `json:"content_type"` // guessed from the file extension or the body when empty
+}
+
+// response loads the file, if any, into a proxy.UnavailableResponse; nil
+// when nothing is customized.
+func (u UnavailableSettings) response() (*proxy.UnavailableResponse, error) {
+	if u == (UnavailableSettings{}) {
+		return nil, nil
+	}
+	resp := &proxy.UnavailableResponse{Status: u.Status, RetryAfter: u.RetryAfter, ContentType: u.ContentType}
+	switch {
+	case u.File != "":
+		body, err := os.ReadFile(u.File)
+		if err != nil {
+			return nil, fmt.Errorf("error_response.unavailable.file: %w", err)
+		}
+		resp.Body = body
+		if resp.ContentType == "" {
+			resp.ContentType = mime.TypeByExtension(filepath.Ext(u.File))
+		}
+	case u.Body != "":
+		resp.Body = []byte(u.Body)
+	}
+	return resp, nil
+}
+
+// HealthCheckClientSettings configures the client of the health checks.
+type HealthCheckClientSettings struct {
+	Timeout    int          `json:"timeout"`     // ms per check; defaults to 2000
+	TLS        *TLSSettings `json:"tls"`         // replaces transport.tls and backend_tls for the checks
+	KeepAlives bool         `json:"keep_alives"` // reuse the connection to a backend between checks; a new one each time otherwise
+	Proxy      string       `json:"proxy"`       // URL of an HTTP proxy, "direct" for none; HTTP_PROXY & co. if omitted
+}
+
+// proxy returns the http.Transport.Proxy of h.Proxy; nil for the
+// environment's.
+func (h *HealthCheckClientSettings) proxy() (func(*http.Request) (*url.URL, error), error) {
+	switch h.Proxy {
+	case "":
+		return nil, nil
+	case "direct":
+		return func(*http.Request) (*url.URL, error) { return nil, nil }, nil
+	}
+	u, err := url.Parse(h.Proxy)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: expected \"direct\" or an http:// or https:// URL", h.Proxy)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// TransportSettings tunes the per-backend HTTP transport (connection pooling & TLS).
+type TransportSettings struct {
+	MaxIdleConns          int                    `json:"max_idle_conns"`
+	MaxIdleConnsPerHost   int                    `json:"max_idle_conns_per_host"` // defaults to 32 if omitted
+	IdleConnTimeout       int                    `json:"idle_conn_timeout"`       // seconds; defaults to 90 if omitted
+	TLSHandshakeTimeout   int                    `json:"tls_handshake_timeout"`   // seconds; Go default (10) if omitted
+	TLSInsecureSkipVerify bool                   `json:"tls_insecure_skip_verify"`
+	ProxyProtocol         int                    `json:"proxy_protocol"` // 1 or 2: send a PROXY protocol header to backends; 0 disables
+	TLS                   *TLSSettings           `json:"tls"`            // towards every https:// backend
+	BackendTLS            map[string]TLSSettings `json:"backend_tls"`    // by backend host:port, replaces tls for those backends
+
+	// DNS re-resolves the backends configured by host name and spreads
+	// their connections over the addresses; nil leaves it to each dial.
+	DNS *DNSSettings `json:"dns"`
+}
+
+// DNSSettings configures the re-resolution of backend host names.
+type DNSSettings struct {
+	Refresh  int      `json:"refresh"`  // seconds between resolutions; defaults to 30
+	Families []string `json:"families"` // "ipv4", "ipv6", preferred first; both by default
+}
+
+// TLSSettings configures the TLS connections to https:// backends, e.g.
+// those of a zero-trust mesh requiring a client certificate.
+type TLSSettings struct {
+	CAFile             string `json:"ca_file"`              // PEM bundle trusted instead of the system roots
+	CertFile           string `json:"cert_file"`            // client certificate (PEM) for mutual TLS, with key_file
+	KeyFile            string `json:"key_file"`             // its private key (PEM)
+	ServerName         string `json:"server_name"`          // SNI and name verified in the certificate; defaults to the URL host
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"` // accepts any certificate: discouraged, logged at startup
+}
+
+// config loads the files of s into a TLS client config.
+func (s *TLSSettings) config() (*tls.Config, error) {
+	c := &tls.Config{ServerName: s.ServerName, InsecureSkipVerify: s.InsecureSkipVerify}
+	if s.CAFile != "" {
+		pem, err := os.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificate found", s.CAFile)
+		}
+	}
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		return nil, errors.New("cert_file and key_file go together")
+	}
+	if s.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// backendTLS builds the TLS config of every https:// backend and the ones of
+// the backends listed in transport.backend_tls.
+func (cfg *Config) backendTLS() (*tls.Config, map[string]*tls.Config, error) {
+	var global *tls.Config
+	if cfg.Transport.TLS != nil {
+		var err error
+		if global, err = cfg.Transport.TLS.config(); err != nil {
+			return nil, nil, fmt.Errorf("transport.tls: %w", err)
+		}
+	}
+	var byHost map[string]*tls.Config
+	for host, settings := range cfg.Transport.BackendTLS {
+		if strings.Contains(host, "/") {
+			return nil, nil, fmt.Errorf("transport.backend_tls: %q must be the host:port of a backend URL", host)
+		}
+		c, err := settings.config()
+		if err != nil {
+			return nil, nil, fmt.Errorf("transport.backend_tls[%s]: %w", host, err)
+		}
+		if byHost == nil {
+			byHost = make(map[string]*tls.Config)
+		}
+		byHost[host] = c
+	}
+	return global, byHost, nil
+}
+
+// LoadConfig reads a JSON config file, applies the defaults and validates it.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.prepare(); err != nil {
+		return nil, err
+	}
+	cfg.path = path
+	return &cfg, nil
+}
+
+// prepare applies the defaults and validates the config. It is idempotent,
+// so New can run it again on a config that came from LoadConfig.
+func (cfg *Config) prepare() error {
+	if _, err := pool.NewStrategy(cfg.Strategy); err != nil {
+		return fmt.Errorf("invalid strategy: %w", err)
+	}
+	if f := cfg.ErrorResponse.Format; f != "" && f != "text" && f != "json" {
+		return fmt.Errorf("invalid error_response.format: %s (must be 'text' or 'json')", f)
+	}
+	if u := cfg.ErrorResponse.Unavailable; u.Status != 0 && (u.Status < 400 || u.Status > 599) {
+		return fmt.Errorf("error_response.unavailable.status must be a 4xx or 5xx code (got %d)", u.Status)
+	}
+	if u := cfg.ErrorResponse.Unavailable; u.Body != "" && u.File != "" {
+		return errors.New("error_response.unavailable: use either body or file, not both")
+	}
+	if _, err := cfg.ErrorResponse.Unavailable.response(); err != nil {
+		return err
+	}
+	if _, _, err := cfg.backendTLS(); err != nil {
+		return err
+	}
+	if err := cfg.Transport.DNS.prepare(); err != nil {
+		return err
+	}
+	if _, err := cfg.webhooks(); err != nil {
+		return err
+	}
+	if err := cfg.Admin.validate(); err != nil {
+		return err
+	}
+	if err := cfg.validateListeners(); err != nil {
+		return err
+	}
+	if c := &cfg.Capture; c.File != "" {
+		if c.SamplePercent == 0 {
+			c.SamplePercent = 100
+		}
+		if c.SamplePercent < 0 || c.SamplePercent > 100 || c.BodyBytes < 0 {
+			return fmt.Errorf("capture: sample_percent must be in (0, 100] and body_bytes not negative")
+		}
+	}
+	if b := cfg.Retries.Budget; b != nil {
+		if b.Percent < 0 || b.MinRetries < 0 || b.Window < 0 {
+			return errors.New("retries.budget: percent, min_retries and window must not be negative")
+		}
+		if b.MinRetries == 0 {
+			b.MinRetries = 10
+		}
+		if b.Window == 0 {
+			b.Window = 10
+		}
+	}
+	if r := cfg.Logging.Rotation; r.MaxSizeMB < 0 || r.Interval < 0 || r.MaxBackups < 0 {
+		return errors.New("logging.rotation: max_size_mb, interval and max_backups must not be negative")
+	}
+	if sl := cfg.Logging.Syslog; sl != nil {
+		if _, _, err := logging.ParseSyslogAddress(sl.Address); err != nil {
+			return fmt.Errorf("logging.syslog.address: %w", err)
+		}
+		if _, ok := logging.Facilities[sl.Facility]; sl.Facility != "" && !ok {
+			return fmt.Errorf("logging.syslog.facility: unknown facility %q", sl.Facility)
+		}
+	}
+	if sd := cfg.StatsD; sd != nil {
+		if _, _, err := net.SplitHostPort(sd.Address); err != nil {
+			return fmt.Errorf("statsd.address: %w", err)
+		}
+		if sd.Interval < 0 {
+			return errors.New("statsd.interval must not be negative")
+		}
+		if sd.Interval == 0 {
+			sd.Interval = 10
+		}
+		if len(sd.Tags) > 0 && !sd.DogStatsD {
+			return errors.New("statsd.tags require dogstatsd")
+		}
+		for _, t := range sd.Tags {
+			if k, _, ok := strings.Cut(t, ":"); !ok || k == "" {
+				return fmt.Errorf("statsd.tags: invalid tag %q, expected key:value", t)
+			}
+		}
+	}
+	if l := cfg.Listener; l.HTTPRedirectPort != 0 || l.HSTS != nil {
+		if l.TLSCertFile == "" && l.ACME == nil {
+			return errors.New("listener.http_redirect_port and listener.hsts require TLS (tls_cert_file or acme)")
+		}
+		if l.HTTPRedirectPort < 0 || l.HTTPRedirectPort > 65535 {
+			return fmt.Errorf("invalid listener.http_redirect_port: %d", l.HTTPRedirectPort)
+		}
+		if l.HTTPRedirectPort != 0 && (l.HTTPRedirectPort == cfg.Port || l.HTTPRedirectPort == cfg.AdminPort) {
+			return fmt.Errorf("listener.http_redirect_port %d is already used by the proxy or the admin API", l.HTTPRedirectPort)
+		}
+	}
+	if cfg.Listener.ACME != nil {
+		if cfg.Listener.TLSCertFile != "" || cfg.Listener.TLSKeyFile != "" {
+			return errors.New("listener: use either acme or tls_cert_file/tls_key_file, not both")
+		}
+		if _, err := cfg.Listener.ACME.manager(); err != nil {
+			return err
+		}
+		if cfg.Listener.ACME.HTTPPort == 0 {
+			cfg.Listener.ACME.HTTPPort = 80
+		}
+		if p := cfg.Listener.HTTPRedirectPort; p != 0 && p != cfg.Listener.ACME.HTTPPort {
+			return fmt.Errorf("listener.http_redirect_port (%d) must match listener.acme.http_port (%d): one listener serves both", p, cfg.Listener.ACME.HTTPPort)
+		}
+	}
+
+	// Apply sensible defaults
+	if cfg.ProxyTimeout <= 0 {
+		cfg.ProxyTimeout = 30
+	}
+	if cfg.Retries.BufferBytes == 0 {
+		cfg.Retries.BufferBytes = 64 << 10
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = 10
+	}
+	if cfg.MaxResponseBytes < 0 {
+		return fmt.Errorf("max_response_bytes must not be negative (got %d)", cfg.MaxResponseBytes)
+	}
+	if err := proxy.ValidContentTypes(cfg.ResponseContentTypes); err != nil {
+		return fmt.Errorf("response_content_types: %w", err)
+	}
+	if cfg.BufferSize < 0 {
+		return fmt.Errorf("buffer_size must not be negative (got %d)", cfg.BufferSize)
+	}
+	if cfg.Readiness.ShutdownDelay < 0 {
+		return fmt.Errorf("readiness.shutdown_delay must not be negative (got %d)", cfg.Readiness.ShutdownDelay)
+	}
+	if cfg.Listener.ReadHeaderTimeout == 0 {
+		cfg.Listener.ReadHeaderTimeout = 10
+	}
+	if cfg.Listener.IdleTimeout == 0 {
+		cfg.Listener.IdleTimeout = 120
+	}
+	if cfg.Listener.TLSReloadInterval == 0 {
+		cfg.Listener.TLSReloadInterval = 10
+	}
+	if cfg.Admin.Bind == "" {
+		cfg.Admin.Bind = "127.0.0.1"
+	}
+	if cfg.Listener.MaxHeaderBytes < 0 {
+		return fmt.Errorf("listener.max_header_bytes must not be negative (got %d)", cfg.Listener.MaxHeaderBytes)
+	}
+	if cfg.HealthCheckFrequency <= 0 {
+		cfg.HealthCheckFrequency = 10
+	}
+	if cfg.HealthCheckType == "" {
+		cfg.HealthCheckType = health.CheckHTTP
+	}
+	if err := validHealthCheckType(cfg.HealthCheckType); err != nil {
+		return err
+	}
+	if cfg.HealthCheckWorkers < 0 {
+		return fmt.Errorf("health_check_workers must not be negative (got %d)", cfg.HealthCheckWorkers)
+	}
+	if cfg.HealthCheckBackoff < 0 {
+		return fmt.Errorf("health_check_backoff must not be negative (got %d)", cfg.HealthCheckBackoff)
+	}
+	cfg.healthHeaders = map[string]http.Header{}
+	if cfg.HealthCheckAuth != nil {
+		h, err := cfg.HealthCheckAuth.header()
+		if err != nil {
+			return fmt.Errorf("health_check_auth: %w", err)
+		}
+		cfg.healthHeaders[""] = h
+	}
+	if cfg.HealthCheckClient.Timeout < 0 {
+		return fmt.Errorf("health_check_client.timeout must not be negative (got %d)", cfg.HealthCheckClient.Timeout)
+	}
+	if cfg.HealthCheckClient.Timeout == 0 {
+		cfg.HealthCheckClient.Timeout = int(health.DefaultTimeout / time.Millisecond)
+	}
+	if cfg.HealthCheckClient.TLS != nil {
+		c, err := cfg.HealthCheckClient.TLS.config()
+		if err != nil {
+			return fmt.Errorf("health_check_client.tls: %w", err)
+		}
+		cfg.healthTLS = c
+	}
+	var err error
+	if cfg.healthProxy, err = cfg.HealthCheckClient.proxy(); err != nil {
+		return fmt.Errorf("health_check_client: %w", err)
+	}
+	if cfg.Transport.MaxIdleConnsPerHost <= 0 {
+		cfg.Transport.MaxIdleConnsPerHost = 32
+	}
+	if cfg.Transport.IdleConnTimeout <= 0 {
+		cfg.Transport.IdleConnTimeout = 90
+	}
+	if cfg.Concurrency.QueueTimeout <= 0 {
+		cfg.Concurrency.QueueTimeout = 5
+	}
+	if cfg.Concurrency.MaxInFlight < 0 {
+		return fmt.Errorf("concurrency.max_in_flight must not be negative (got %d)", cfg.Concurrency.MaxInFlight)
+	}
+	if t := cfg.Transport.ProxyProtocol; t < 0 || t > 2 {
+		return fmt.Errorf("transport.proxy_protocol must be 0, 1 or 2 (got %d)", t)
+	}
+	if cfg.Cache.MaxSizeMB <= 0 {
+		cfg.Cache.MaxSizeMB = 64
+	}
+	if cfg.Cache.MaxEntryKB <= 0 {
+		cfg.Cache.MaxEntryKB = 1024
+	}
+	if l := cfg.Compression.Level; l < 0 || l > 9 {
+		return fmt.Errorf("compression.level must be between 0 and 9 (got %d)", l)
+	}
+	if cfg.Ingress.Class == "" {
+		cfg.Ingress.Class = "reverse-proxy"
+	}
+	if cfg.Ingress.SyncInterval <= 0 {
+		cfg.Ingress.SyncInterval = 10
+	}
+
+	if _, err := proxy.NewRedirects(cfg.Redirects); err != nil {
+		return err
+	}
+	if _, err := proxy.NewStaticFiles(cfg.Static); err != nil {
+		return err
+	}
+	if err := validHostHeader(cfg.HostHeader); err != nil {
+		return err
+	}
+	if _, err := limit.NewIPFilter(cfg.IPFilter.Allow, cfg.IPFilter.Deny); err != nil {
+		return fmt.Errorf("ip_filter: %w", err)
+	}
+
+	if len(cfg.BackendTags) > 0 {
+		tags := make(map[string]map[string]string, len(cfg.BackendTags))
+		for raw, t := range cfg.BackendTags {
+			u, err := pool.ParseBackendURL(raw)
+			if err != nil {
+				return fmt.Errorf("backend_tags: %w", err)
+			}
+			if _, ok := t[""]; ok {
+				return fmt.Errorf("backend_tags: %s: tag names must not be empty", raw)
+			}
+			tags[u.String()] = t // the key newServerPool looks up
+		}
+		cfg.BackendTags = tags
+	}
+	if len(cfg.BackendWeights) > 0 {
+		weights := make(map[string]int, len(cfg.BackendWeights))
+		for raw, w := range cfg.BackendWeights {
+			u, err := pool.ParseBackendURL(raw)
+			if err != nil {
+				return fmt.Errorf("backend_weights: %w", err)
+			}
+			if w < 0 {
+				return fmt.Errorf("backend_weights: %s: weight must not be negative", raw)
+			}
+			weights[u.String()] = w
+		}
+		cfg.BackendWeights = weights
+	}
+
+	seen := map[string]bool{"default": true, "global": true}
+	for i, rc := range cfg.Routes {
+		if rc.Name == "" {
+			return fmt.Errorf("routes[%d]: name is required", i)
+		}
+		if seen[rc.Name] {
+			return fmt.Errorf("routes[%d]: duplicate route name %q", i, rc.Name)
+		}
+		seen[rc.Name] = true
+		if _, err := limit.NewIPFilter(rc.IPFilter.Allow, rc.IPFilter.Deny); err != nil {
+			return fmt.Errorf("route %s: ip_filter: %w", rc.Name, err)
+		}
+		if err := validHostHeader(rc.HostHeader); err != nil {
+			return fmt.Errorf("route %s: %w", rc.Name, err)
+		}
+		if err := proxy.ValidContentTypes(rc.ResponseContentTypes); err != nil {
+			return fmt.Errorf("route %s: response_content_types: %w", rc.Name, err)
+		}
+		if rc.JWT != nil {
+			if _, err := rc.JWT.validator(); err != nil {
+				return fmt.Errorf("route %s: %w", rc.Name, err)
+			}
+		}
+		if rc.HealthCheckType != "" {
+			if err := validHealthCheckType(rc.HealthCheckType); err != nil {
+				return fmt.Errorf("route %s: %w", rc.Name, err)
+			}
+		}
+		if rc.HealthCheckAuth != nil {
+			h, err := rc.HealthCheckAuth.header()
+			if err != nil {
+				return fmt.Errorf("route %s: health_check_auth: %w", rc.Name, err)
+			}
+			cfg.healthHeaders[rc.Name] = h
+		}
+		if _, err := rc.middleware(); err != nil {
+			return fmt.Errorf("route %s: %w", rc.Name, err)
+		}
+		if rc.Rule != "" {
+			if _, err := route.CompileRule(rc.Rule); err != nil {
+				return fmt.Errorf("route %s: %w", rc.Name, err)
+			}
+		}
+		if rc.Rewrite != nil {
+			if err := rc.Rewrite.Compile(); err != nil {
+				return fmt.Errorf("route %s: %w", rc.Name, err)
+			}
+		}
+		if rc.ProxyTimeout < 0 {
+			return fmt.Errorf("route %s: proxy_timeout must not be negative (got %d)", rc.Name, rc.ProxyTimeout)
+		}
+		if rc.ProxyProtocol < 0 || rc.ProxyProtocol > 2 {
+			return fmt.Errorf("route %s: proxy_protocol must be 0, 1 or 2", rc.Name)
+		}
+		if len(rc.Groups) > 0 && len(rc.Backends) > 0 {
+			return fmt.Errorf("route %s: use either backends or groups, not both", rc.Name)
+		}
+		total := 0
+		for _, g := range rc.Groups {
+			if g.Name == "" || g.Weight < 0 {
+				return fmt.Errorf("route %s: every group needs a name and a non-negative weight", rc.Name)
+			}
+			if g.Priority < 0 {
+				return fmt.Errorf("route %s: group %s: priority must not be negative", rc.Name, g.Name)
+			}
+			total += g.Weight
+		}
+		if len(rc.Groups) > 0 && total == 0 {
+			return fmt.Errorf("route %s: at least one group needs a positive weight", rc.Name)
+		}
+		if rc.Bandwidth < 0 || rc.HedgeDelay < 0 {
+			return fmt.Errorf("route %s: bandwidth and hedge_delay must not be negative", rc.Name)
+		}
+		if len(rc.Tags) > 0 && !cfg.anyTagged(rc.backendURLs(), rc.Tags) {
+			return fmt.Errorf("route %s: no backend of the route carries the tags %v", rc.Name, rc.Tags)
+		}
+	}
+	if err := cfg.Bandwidth.validate(seen); err != nil {
+		return err
+	}
+
+	for i, tc := range cfg.TCP {
+		if tc.Name == "" || tc.Port <= 0 {
+			return fmt.Errorf("tcp[%d]: name and port are required", i)
+		}
+		if tc.Strategy == "" {
+			cfg.TCP[i].Strategy = cfg.Strategy
+		} else if _, err := pool.NewStrategy(tc.Strategy); err != nil {
+			return fmt.Errorf("tcp %s: %w", tc.Name, err)
+		}
+		for j, b := range tc.Backends {
+			if !strings.Contains(b, "://") {
+				cfg.TCP[i].Backends[j] = "tcp://" + b
+			}
+		}
+	}
+
+	return nil
+}
+
+func validHealthCheckType(t string) error {
+	if t != health.CheckHTTP && t != health.CheckTCP && t != health.CheckGRPC {
+		return fmt.Errorf("invalid health_check_type: %s (must be 'http', 'tcp' or 'grpc')", t)
+	}
+	return nil
+}
+
+// validHostHeader rejects host_header values that cannot be a Host.
+func validHostHeader(v string) error {
+	if strings.ContainsAny(v, "/ \t") {
+		return fmt.Errorf("host_header must be \"preserve\", \"backend\" or a host name (got %q)", v)
+	}
+	return nil
+}
+
+// TransportConfig converts the transport settings into a pool.TransportConfig.
+func (cfg *Config) TransportConfig() pool.TransportConfig {
+	global, byHost, _ := cfg.backendTLS() // validated by prepare
+	return pool.TransportConfig{
+		TLS:                 global,
+		BackendTLS:          byHost,
+		MaxIdleConns:        cfg.Transport.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.Transport.MaxIdleConnsPerHost,
+		IdleConnTimeout:     time.Duration(cfg.Transport.IdleConnTimeout) * time.Second,
+		TLSHandshakeTimeout: time.Duration(cfg.Transport.TLSHandshakeTimeout) * time.Second,
+		InsecureSkipVerify:  cfg.Transport.TLSInsecureSkipVerify,
+		ProxyProtocol:       cfg.Transport.ProxyProtocol,
+		DNS:                 cfg.Transport.DNS.config(),
+	}
+}
+
+// config is the pool's DNSConfig; nil when d is.
+func (d *DNSSettings) config() *pool.DNSConfig {
+	if d == nil {
+		return nil
+	}
+	return &pool.DNSConfig{Refresh: time.Duration(d.Refresh) * time.Second, Families: d.Families}
+}
+
+func (d *DNSSettings) prepare() error {
+	if d == nil {
+		return nil
+	}
+	if d.Refresh < 0 {
+		return fmt.Errorf("transport.dns.refresh must not be negative (got %d)", d.Refresh)
+	}
+	if d.Refresh == 0 {
+		d.Refresh = 30
+	}
+	for i, f := range d.Families {
+		if f != pool.FamilyIPv4 && f != pool.FamilyIPv6 {
+			return fmt.Errorf("transport.dns.families: unknown family %q (must be 'ipv4' or 'ipv6')", f)
+		}
+		if slices.Contains(d.Families[:i], f) {
+			return fmt.Errorf("transport.dns.families: %s listed twice", f)
+		}
+	}
+	return nil
+}
+
+// healthCheckType is the check type of a route, its own or the global one.
+// anyTagged reports whether one of urls carries want in backend_tags.
+func (cfg *Config) anyTagged(urls []string, want map[string]string) bool {
+	for _, raw := range urls {
+		u, err := pool.ParseBackendURL(raw)
+		if err != nil {
+			continue
+		}
+		if pool.MatchTags(cfg.BackendTags[u.String()], want) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *Config) healthCheckType(route string) string {
+	for _, rc := range cfg.Routes {
+		if rc.Name == route && rc.HealthCheckType != "" {
+			return rc.HealthCheckType
+		}
+	}
+	return cfg.HealthCheckType
+}
+
+// healthOptions configures the health checker of a route's pool; names of
+// no configured route ("default", ingress pools) get the global settings.
+func (cfg *Config) healthOptions(route string) health.Options {
+	return health.Options{
+		Type:          cfg.healthCheckType(route),
+		Interval:      time.Duration(cfg.HealthCheckFrequency) * time.Second,
+		MaxConcurrent: cfg.HealthCheckWorkers,
+		MaxBackoff:    time.Duration(cfg.HealthCheckBackoff) * time.Second,
+		Header:        cfg.healthHeader(route),
+		Timeout:       time.Duration(cfg.HealthCheckClient.Timeout) * time.Millisecond,
+		TLS:           cfg.healthTLS,
+		Proxy:         cfg.healthProxy,
+		KeepAlives:    cfg.HealthCheckClient.KeepAlives,
+	}
+}
+
+// healthProbe is how the backends of a route's pool are checked before it
+// serves; see healthOptions.
+func (cfg *Config) healthProbe(route string) health.Probe {
+	return health.Probe{
+		Type:    cfg.healthCheckType(route),
+		TLS:     cfg.healthTLS,
+		Timeout: time.Duration(cfg.HealthCheckClient.Timeout) * time.Millisecond,
+		Header:  cfg.healthHeader(route),
+		Proxy:   cfg.healthProxy,
+	}
+}
+
+// healthHeader returns the credentials of the health checks of a route.
+func (cfg *Config) healthHeader(route string) http.Header {
+	if h, ok := cfg.healthHeaders[route]; ok {
+		return h
+	}
+	return cfg.healthHeaders[""]
+}
+
+func (cfg *Config) outlierConfig() pool.OutlierConfig {
+	o := cfg.OutlierDetection
+	return pool.OutlierConfig{
+		Interval:           time.Duration(o.Interval) * time.Second,
+		MinRequests:        o.MinRequests,
+		ErrorRateStdev:     o.ErrorRateStdev,
+		MinErrorRate:       o.MinErrorRate,
+		LatencyFactor:      o.LatencyFactor,
+		BaseEjection:       time.Duration(o.BaseEjection) * time.Second,
+		MaxEjection:        time.Duration(o.MaxEjection) * time.Second,
+		MaxEjectionPercent: o.MaxEjectionPercent,
+		Ramp:               time.Duration(o.Ramp) * time.Second,
+	}
+}
+
+// ProxyOptions converts the config into proxy.Options.
+func (cfg *Config) ProxyOptions() (proxy.Options, error) {
+	opts := proxy.Options{
+		Route:               "default",
+		Timeout:             time.Duration(cfg.ProxyTimeout) * time.Second,
+		ResponseIdleTimeout: time.Duration(cfg.ResponseIdleTimeout) * time.Second,
+		SchemeFailover:      cfg.SchemeFailover,
+		FlushInterval:       time.Duration(cfg.FlushInterval) * time.Millisecond,
+		BufferPool:          proxy.NewBufferPool(cfg.BufferSize),
+		MaxBodyBytes:        cfg.MaxBodyBytes,
+		HostHeader:          cfg.HostHeader,
+		RetryBodyBytes:      cfg.Retries.BufferBytes,
+		RetryNonIdempotent:  cfg.Retries.NonIdempotent,
+		CORS:                cfg.CORS,
+		RequestHeaders:      cfg.Headers.Request,
+		ResponseHeaders:     cfg.Headers.Response,
+		SecurityHeaders:     cfg.SecurityHeaders,
+		Middleware:          cfg.Middleware,
+	}
+	if cfg.ErrorResponse.Format == "json" {
+		responder, err := proxy.NewErrorResponder(cfg.ErrorResponse.Template)
+		if err != nil {
+			return opts, fmt.Errorf("failed to configure error responses: %w", err)
+		}
+		opts.Errors = responder
+	}
+	unavailable, err := cfg.ErrorResponse.Unavailable.response()
+	if err != nil {
+		return opts, err
+	}
+	opts.Unavailable = unavailable
+	if cfg.FaultInjection {
+		opts.Faults = &proxy.FaultInjector{}
+	}
+	if b := cfg.Retries.Budget; b != nil {
+		opts.RetryBudget = limit.NewRetryBudget(b.Percent/100, b.MinRetries, time.Duration(b.Window)*time.Second, b.PerBackend)
+	}
+	opts.MaxResponseBytes = cfg.MaxResponseBytes
+	opts.ResponseContentTypes = cfg.ResponseContentTypes
+	opts.BackendBandwidth = cfg.Bandwidth.backends()
+	opts.ClientClasses = cfg.Bandwidth.classes()
+	opts.Concurrency = cfg.concurrencyLimiter()
+	if c := cfg.ClientLimits; c.MaxRequestsPerIP > 0 {
+		opts.ClientConcurrency, err = limit.NewPerIPLimiter(c.MaxRequestsPerIP, c.Allowlist)
+		if err != nil {
+			return opts, fmt.Errorf("invalid client_limits.allowlist: %w", err)
+		}
+	}
+	return opts, nil
+}
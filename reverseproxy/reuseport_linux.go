@@ -0,0 +1,6 @@
+//go:build linux && !(mips || mipsle || mips64 || mips64le)
+
+package reverseproxy
+
+// soReusePort is SO_REUSEPORT, missing from syscall on some architectures.
+const soReusePort = 0xf
@@ -0,0 +1,12 @@
+//go:build !((linux && !(mips || mipsle || mips64 || mips64le)) || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package reverseproxy
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("listener.reuse_port is not supported on this platform")
+}
@@ -0,0 +1,19 @@
+//go:build (linux && !(mips || mipsle || mips64 || mips64le)) || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package reverseproxy
+
+import "syscall"
+
+// reusePort sets SO_REUSEPORT, so that another process can bind the same
+// port while this one still listens: the kernel spreads the new connections
+// between them.
+func reusePort(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,899 @@
+package reverseproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"reverse-proxy/acme"
+	"reverse-proxy/admin"
+	"reverse-proxy/cache"
+	"reverse-proxy/capture"
+	"reverse-proxy/compression"
+	"reverse-proxy/health"
+	"reverse-proxy/ingress"
+	"reverse-proxy/limit"
+	"reverse-proxy/notify"
+	"reverse-proxy/pool"
+	"reverse-proxy/proxy"
+	"reverse-proxy/proxyproto"
+	"reverse-proxy/route"
+	"reverse-proxy/state"
+	"reverse-proxy/statsd"
+	"reverse-proxy/tcpproxy"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Server is a proxy built from a Config: the HTTP proxy, its admin API, the
+// TCP listeners and their background tasks (health checks, outlier
+// detection, Ingress sync).
+type Server struct {
+	cfg *Config
+
+	pool       *pool.ServerPool // the "default" route
+	routes     *route.Table
+	tcpServers []*tcpproxy.Server
+	controller *ingress.Controller
+	tracker    *proxy.Tracker
+	handler    http.Handler
+	server     *http.Server
+	admin      *http.Server
+	acme       *acme.Manager // nil unless listener.acme is set
+	certs      *certReloader // nil unless listener.tls_cert_file is set
+	adminCerts *certReloader // nil unless admin.tls_cert_file is set
+	extra      []*extraListener
+	redirect   *http.Server // plain HTTP: redirects to https, acme http-01 challenges
+	decisions  io.Closer    // decision log outputs, nil when off
+	capture    io.Closer    // capture file, nil when off
+	audit      *admin.AuditLog
+	webhooks   []*notify.Webhook
+
+	stopBackground context.CancelFunc
+	proxyAddr      net.Addr
+	extraAddrs     []net.Addr
+	adminAddr      net.Addr
+	inherited      map[string]net.Listener // from the process being upgraded, see Upgrade
+	sockets        []socket                // opened by Start, in order
+	upgraded       bool                    // a new process serves the listeners, see Upgrade
+	draining       atomic.Bool             // set by Stop: /readyz fails
+	errs           chan error
+	stopOnce       sync.Once
+}
+
+// extraListener is one of cfg.Listeners.
+type extraListener struct {
+	server *http.Server
+	certs  *certReloader // nil for plain HTTP
+}
+
+// New builds the pools, routes and handlers described by cfg, filling in
+// its defaults. Backends are checked once so the pools start with accurate
+// health. Nothing listens or runs in the background until Start.
+func New(cfg *Config) (*Server, error) {
+	if err := cfg.prepare(); err != nil {
+		return nil, err
+	}
+	if cfg.Transport.TLSInsecureSkipVerify || (cfg.Transport.TLS != nil && cfg.Transport.TLS.InsecureSkipVerify) {
+		log.Println("WARNING: TLS certificate verification is disabled for backend connections")
+	}
+	for host, settings := range cfg.Transport.BackendTLS {
+		if settings.InsecureSkipVerify {
+			log.Printf("WARNING: TLS certificate verification is disabled for backend %s", host)
+		}
+	}
+	if cfg.HealthCheckClient.TLS != nil && cfg.HealthCheckClient.TLS.InsecureSkipVerify {
+		log.Println("WARNING: TLS certificate verification is disabled for health checks")
+	}
+	s := &Server{cfg: cfg, tracker: &proxy.Tracker{}, errs: make(chan error, 1)}
+	s.webhooks, _ = cfg.webhooks() // validated by prepare
+	s.acme, _ = cfg.Listener.ACME.manager()
+
+	// Backend changes made through the admin API win over the config file.
+	var saved *state.State
+	var err error
+	if cfg.Listener.TLSCertFile != "" || cfg.Listener.TLSKeyFile != "" {
+		if s.certs, err = newCertReloader(cfg.Listener.TLSCertFile, cfg.Listener.TLSKeyFile); err != nil {
+			return nil, fmt.Errorf("listener TLS certificate: %w", err)
+		}
+	}
+	if cfg.Admin.TLSCertFile != "" {
+		if s.adminCerts, err = newCertReloader(cfg.Admin.TLSCertFile, cfg.Admin.TLSKeyFile); err != nil {
+			return nil, fmt.Errorf("admin TLS certificate: %w", err)
+		}
+	}
+	stateFile := &state.File{Path: cfg.StateFile}
+	if cfg.StateFile != "" {
+		if saved, err = stateFile.Load(); err != nil {
+			return nil, fmt.Errorf("failed to load state file: %w", err)
+		}
+	}
+	backendURLs := cfg.Backends
+	if saved != nil {
+		backendURLs = saved.URLs()
+		log.Printf("Restoring %d backends from %s", len(backendURLs), cfg.StateFile)
+	}
+
+	log.Println("Validating backends...")
+	s.pool = cfg.newServerPool(cfg.Strategy, cfg.healthProbe("default"), backendURLs, cfg.TransportConfig())
+
+	// Build the routes and their proxy handlers
+	proxyOpts, err := cfg.ProxyOptions()
+	if err != nil {
+		return nil, err
+	}
+	s.tracker.Max = int64(cfg.Concurrency.MaxInFlight)
+	s.tracker.Errors = proxyOpts.Errors
+	if s.tracker.Max > 0 {
+		log.Printf("Load shedding beyond %d requests in flight", s.tracker.Max)
+	}
+	ipFilters := cfg.ipFilters()
+	s.routes = cfg.buildRoutes(s.pool, proxyOpts, ipFilters)
+	if saved != nil {
+		saved.Apply(s.pool, s.routes)
+	}
+
+	if cfg.Ingress.Enabled {
+		client := &ingress.Client{BaseURL: cfg.Ingress.APIServer}
+		if cfg.Ingress.APIServer == "" {
+			if client, err = ingress.InClusterClient(); err != nil {
+				return nil, fmt.Errorf("ingress mode: %w", err)
+			}
+		}
+		s.controller = &ingress.Controller{
+			Client:    client,
+			Class:     cfg.Ingress.Class,
+			Namespace: cfg.Ingress.Namespace,
+			Table:     s.routes,
+			Static:    s.routes.Routes(),
+			NewPool: func() *pool.ServerPool {
+				lb, _ := pool.NewServerPool( // settings validated by prepare
+					pool.WithStrategy(cfg.Strategy),
+					pool.WithTransport(cfg.TransportConfig()),
+					pool.WithSlowStart(time.Duration(cfg.SlowStart)*time.Second),
+				)
+				return lb
+			},
+			NewHandler: func(name string, lb pool.LoadBalancer) http.Handler {
+				opts := proxyOpts
+				opts.Route = name
+				opts.IPFilters = []*limit.IPFilter{ipFilters["global"]}
+				opts.Concurrency = cfg.concurrencyLimiter()
+				return proxy.NewHandler(lb, opts)
+			},
+		}
+	}
+
+	s.tcpServers = cfg.buildTCPProxies()
+
+	var handler http.Handler = s.routes
+	adminOpts := admin.Options{
+		Routes:           s.routes,
+		TCP:              s.tcpServers,
+		IPFilters:        ipFilters,
+		Faults:           proxyOpts.Faults,
+		Tracker:          s.tracker,
+		ReadyMinBackends: cfg.Readiness.MinBackends,
+		Draining:         s.draining.Load,
+		Token:            cfg.Admin.Token,
+		Tokens:           cfg.Admin.Tokens,
+		Debug:            cfg.Admin.Debug,
+	}
+	if cfg.path != "" {
+		adminOpts.Reload = func() ([]string, error) {
+			reloaded, err := LoadConfig(cfg.path)
+			if err != nil {
+				return nil, err
+			}
+			return reloaded.Backends, nil
+		}
+	}
+	if s.certs != nil {
+		adminOpts.ReloadCerts = s.certs.reload
+	}
+	if cfg.Admin.AuditLog != "" {
+		if s.audit, err = admin.OpenAuditLog(cfg.Admin.AuditLog); err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		adminOpts.Audit = s.audit
+		log.Printf("Admin changes audited to %s", cfg.Admin.AuditLog)
+	}
+	if cfg.StateFile != "" {
+		adminOpts.OnChange = func() {
+			if err := stateFile.Save(state.Capture(s.pool, s.routes)); err != nil {
+				log.Printf("Failed to save state file: %v", err)
+			}
+		}
+		log.Printf("Admin changes persisted to %s", cfg.StateFile)
+	}
+	if cfg.Cache.Enabled {
+		responseCache := cache.New(int64(cfg.Cache.MaxSizeMB)<<20, int64(cfg.Cache.MaxEntryKB)<<10,
+			time.Duration(cfg.Cache.DefaultTTL)*time.Second)
+		coalesce := map[string]bool{}
+		for _, rc := range cfg.Routes {
+			if rc.CacheCoalesce != nil {
+				coalesce[rc.Name] = *rc.CacheCoalesce
+			}
+		}
+		responseCache.Coalesce = func(r *http.Request) bool {
+			if rt := s.routes.Match(r); rt != nil {
+				if on, ok := coalesce[rt.Name]; ok {
+					return on
+				}
+			}
+			return cfg.Cache.Coalesce
+		}
+		handler = responseCache.Middleware(handler)
+		// Outside the cache, or a denied client would be served its hits.
+		gate := &proxy.IPGate{Filters: func(r *http.Request) []*limit.IPFilter {
+			filters := []*limit.IPFilter{ipFilters["global"]}
+			if rt := s.routes.Match(r); rt != nil && ipFilters[rt.Name] != nil {
+				filters = append(filters, ipFilters[rt.Name])
+			}
+			return filters
+		}, Errors: proxyOpts.Errors}
+		handler = gate.Middleware(handler)
+		adminOpts.Cache = responseCache
+		log.Printf("Response cache enabled (%d MB, default TTL %ds)", cfg.Cache.MaxSizeMB, cfg.Cache.DefaultTTL)
+	}
+	if len(cfg.Static) > 0 {
+		// Outside the cache, as files are read cheaply from disk, but inside
+		// the compression.
+		static, _ := proxy.NewStaticFiles(cfg.Static) // validated by prepare
+		static.Errors = proxyOpts.Errors
+		handler = static.Middleware(handler)
+		for _, d := range cfg.Static {
+			log.Printf("Serving %s from %s", d.Prefix, d.Root)
+		}
+	}
+	if c := cfg.Compression; c.Enabled {
+		// Outside the cache, which keeps one uncompressed copy for every client.
+		handler = compression.New(compression.Options{MinSize: c.MinSize, Types: c.Types, Level: c.Level}).Middleware(handler)
+		log.Println("Response compression enabled")
+	}
+	if len(cfg.Redirects) > 0 {
+		// Outside the cache: redirects are cheaper to answer than to look up.
+		redirects, _ := proxy.NewRedirects(cfg.Redirects) // validated by prepare
+		handler = redirects.Middleware(handler)
+		log.Printf("%d redirect rules loaded", len(cfg.Redirects))
+	}
+	s.admin = admin.NewServer(s.pool, adminOpts)
+	if s.adminCerts != nil {
+		s.admin.TLSConfig = s.adminCerts.tlsConfig()
+		if cas, _ := cfg.Admin.clientCAs(); cas != nil { // validated by prepare
+			s.admin.TLSConfig.ClientCAs = cas
+			s.admin.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	decisions, err := cfg.decisionLog()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open decision log: %w", err)
+	}
+	if decisions != nil {
+		s.decisions = decisions
+		handler = proxy.NewDecisionLog(decisions).Middleware(handler)
+		if cfg.DecisionLog != "" {
+			log.Printf("Decision log enabled (%s)", cfg.DecisionLog)
+		} else {
+			log.Printf("Decision log enabled (syslog)")
+		}
+	}
+
+	if c := cfg.Capture; c.File != "" {
+		out, err := os.OpenFile(c.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open capture file: %w", err)
+		}
+		s.capture = out
+		handler = capture.New(out, capture.Options{
+			SamplePercent: c.SamplePercent,
+			BodyBytes:     c.BodyBytes,
+			RedactHeaders: c.RedactHeaders,
+		}).Middleware(handler)
+		log.Printf("Capturing %v%% of the requests to %s", c.SamplePercent, c.File)
+	}
+
+	mux := http.NewServeMux()
+	if cfg.Listener.HSTS != nil {
+		handler = cfg.Listener.HSTS.Middleware(handler)
+	}
+	mux.Handle("/", s.tracker.Wrap(handler))
+	s.handler = mux
+
+	s.server = &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Handler: mux,
+	}
+	cfg.Listener.configure(s.server)
+	if cfg.Listener.H2C {
+		s.server.Protocols = new(http.Protocols)
+		s.server.Protocols.SetHTTP1(true)
+		s.server.Protocols.SetHTTP2(true)
+		s.server.Protocols.SetUnencryptedHTTP2(true)
+	}
+	for _, l := range cfg.Listeners {
+		extra := &extraListener{server: &http.Server{Addr: l.Address, Handler: mux, Protocols: s.server.Protocols}}
+		cfg.Listener.configure(extra.server)
+		if l.TLSCertFile != "" {
+			if extra.certs, err = newCertReloader(l.TLSCertFile, l.TLSKeyFile); err != nil {
+				return nil, fmt.Errorf("listener %s TLS certificate: %w", l.Address, err)
+			}
+			extra.server.TLSConfig = extra.certs.tlsConfig()
+		}
+		s.extra = append(s.extra, extra)
+	}
+	if s.certs != nil {
+		s.server.TLSConfig = s.certs.tlsConfig()
+	}
+	redirectPort := cfg.Listener.HTTPRedirectPort
+	if s.acme != nil {
+		s.server.TLSConfig = s.acme.TLSConfig()
+		redirectPort = max(cfg.Listener.ACME.HTTPPort, 0) // same port, validated by prepare
+	}
+	if redirectPort > 0 {
+		// Its handler needs the HTTPS port, known once bound: see Start.
+		s.redirect = &http.Server{Addr: fmt.Sprintf(":%d", redirectPort), ReadHeaderTimeout: 10 * time.Second}
+	}
+	return s, nil
+}
+
+// Start opens the listeners (proxy, admin API, TCP) and starts the
+// background tasks. It returns once every listener is bound; a port of 0
+// picks a free one, see Addr and AdminAddr.
+func (s *Server) Start() error {
+	cfg := s.cfg
+	var err error
+	if s.inherited, err = inheritListeners(); err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		for _, sock := range s.sockets {
+			sock.ln.Close()
+		}
+		for _, ln := range s.inherited {
+			ln.Close()
+		}
+		s.sockets = nil
+		return err
+	}
+
+	ln, err := s.listen(s.server.Addr)
+	if err != nil {
+		return fail(fmt.Errorf("proxy listener: %w", err))
+	}
+	s.proxyAddr = ln.Addr()
+	proxyLn, err := cfg.wrapListener(ln)
+	if err != nil {
+		return fail(err)
+	}
+	if pp := cfg.Listener.ProxyProtocol; pp.Enabled {
+		log.Printf("PROXY protocol enabled (%d trusted ranges)", len(pp.Trusted))
+	}
+	if cfg.ClientLimits.MaxConnsPerIP > 0 {
+		log.Printf("Client connection limit: %d per IP (%d allowlisted ranges)",
+			cfg.ClientLimits.MaxConnsPerIP, len(cfg.ClientLimits.Allowlist))
+	}
+	if cfg.ClientLimits.MaxRequestsPerIP > 0 {
+		log.Printf("Client request limit: %d in flight per IP", cfg.ClientLimits.MaxRequestsPerIP)
+	}
+
+	extraLns := make([]net.Listener, len(s.extra))
+	s.extraAddrs = make([]net.Addr, len(s.extra))
+	for i, extra := range s.extra {
+		ln, err := s.listen(extra.server.Addr)
+		if err != nil {
+			return fail(fmt.Errorf("proxy listener %s: %w", extra.server.Addr, err))
+		}
+		s.extraAddrs[i] = ln.Addr()
+		if extraLns[i], err = cfg.wrapListener(ln); err != nil {
+			return fail(err)
+		}
+	}
+
+	adminLn, err := s.listen(net.JoinHostPort(cfg.Admin.Bind, strconv.Itoa(cfg.AdminPort)))
+	if err != nil {
+		return fail(fmt.Errorf("admin listener: %w", err))
+	}
+	s.adminAddr = adminLn.Addr()
+
+	var redirectLn net.Listener
+	if s.redirect != nil {
+		if redirectLn, err = s.listen(s.redirect.Addr); err != nil {
+			return fail(fmt.Errorf("HTTP redirect listener: %w", err))
+		}
+		s.redirect.Handler = proxy.RedirectToHTTPS(s.proxyAddr.(*net.TCPAddr).Port)
+		if s.acme != nil {
+			s.redirect.Handler = s.acme.HTTPHandler(s.redirect.Handler)
+		}
+	}
+
+	tcpListeners := make([]net.Listener, len(s.tcpServers))
+	for i, tc := range cfg.TCP {
+		if tcpListeners[i], err = s.listen(fmt.Sprintf(":%d", tc.Port)); err != nil {
+			return fail(fmt.Errorf("TCP listener %s: %w", tc.Name, err))
+		}
+	}
+	// Sockets of listeners removed from the config since the upgrade.
+	for addr, ln := range s.inherited {
+		log.Printf("Closing inherited listener %s, no longer configured", addr)
+		ln.Close()
+	}
+
+	// Background tasks (health checks, outlier detection, ingress sync,
+	// webhooks) all stop when background is cancelled, first thing at
+	// shutdown.
+	background, stopBackground := context.WithCancel(context.Background())
+	s.stopBackground = stopBackground
+	notify.Start(background, s.webhooks...)
+	if s.certs != nil && cfg.Listener.TLSReloadInterval > 0 {
+		go s.certs.watch(background, time.Duration(cfg.Listener.TLSReloadInterval)*time.Second)
+	}
+	if s.adminCerts != nil && cfg.Listener.TLSReloadInterval > 0 {
+		go s.adminCerts.watch(background, time.Duration(cfg.Listener.TLSReloadInterval)*time.Second)
+	}
+	for _, extra := range s.extra {
+		if extra.certs != nil && cfg.Listener.TLSReloadInterval > 0 {
+			go extra.certs.watch(background, time.Duration(cfg.Listener.TLSReloadInterval)*time.Second)
+		}
+	}
+	if s.acme != nil {
+		if err := s.acme.Start(background); err != nil {
+			stopBackground()
+			return fail(fmt.Errorf("ACME: %w", err))
+		}
+		log.Printf("ACME certificates for %v (challenge: %s, cache: %s)",
+			s.acme.Domains, s.acme.Challenge, s.acme.CacheDir)
+	}
+
+	// Start background health checkers (and outlier detection, DNS
+	// refresh), one per route pool
+	dns := cfg.Transport.DNS
+	for _, rt := range s.routes.Routes() {
+		health.StartWithOptions(background, rt.Pool, cfg.healthOptions(rt.Name))
+		if cfg.OutlierDetection.Enabled {
+			pool.StartOutlierDetection(background, rt.Pool, cfg.outlierConfig())
+		}
+		if dns != nil {
+			pool.StartDNSRefresh(background, rt.Pool, time.Duration(dns.Refresh)*time.Second)
+		}
+	}
+	if s.controller != nil {
+		s.controller.Run(background, time.Duration(cfg.Ingress.SyncInterval)*time.Second)
+		health.StartWithOptions(background, s.controller, cfg.healthOptions(""))
+		if cfg.OutlierDetection.Enabled {
+			pool.StartOutlierDetection(background, s.controller, cfg.outlierConfig())
+		}
+		if dns != nil {
+			pool.StartDNSRefresh(background, s.controller, time.Duration(dns.Refresh)*time.Second)
+		}
+	}
+	if dns != nil {
+		log.Printf("Backend DNS refresh every %ds (families: %v)", dns.Refresh, dns.Families)
+	}
+
+	if sd := cfg.StatsD; sd != nil {
+		exporter, err := statsd.New(statsd.Config{
+			Address:   sd.Address,
+			Prefix:    sd.Prefix,
+			Interval:  time.Duration(sd.Interval) * time.Second,
+			DogStatsD: sd.DogStatsD,
+			Tags:      sd.Tags,
+		}, s.statsdPools, s.tracker)
+		if err != nil {
+			stopBackground()
+			return fail(fmt.Errorf("StatsD: %w", err))
+		}
+		exporter.Start(background)
+		log.Printf("StatsD metrics to %s every %ds", sd.Address, sd.Interval)
+	}
+
+	for i, srv := range s.tcpServers {
+		tc := cfg.TCP[i]
+		health.StartWithOptions(background, srv.Pool, cfg.healthOptions("")) // tcp:// backends: connect only
+		go func() {
+			log.Printf("TCP proxy %s running on :%d (strategy: %s)", tc.Name, tc.Port, tc.Strategy)
+			if err := srv.Serve(tcpListeners[i]); err != nil && !errors.Is(err, net.ErrClosed) {
+				s.fail(fmt.Errorf("TCP proxy %s: %w", tc.Name, err))
+			}
+		}()
+	}
+
+	if s.admin.TLSConfig != nil {
+		log.Printf("Admin API running on %s (TLS, client certificates required: %t)",
+			s.adminAddr, s.admin.TLSConfig.ClientCAs != nil)
+	} else {
+		log.Printf("Admin API running on %s\n", s.adminAddr)
+	}
+	go func() {
+		var err error
+		if s.admin.TLSConfig != nil {
+			err = s.admin.ServeTLS(adminLn, "", "")
+		} else {
+			err = s.admin.Serve(adminLn)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("Admin server error: %v", err)
+		}
+	}()
+
+	if s.redirect != nil {
+		go func() {
+			log.Printf("HTTP to HTTPS redirect running on %s", redirectLn.Addr())
+			if err := s.redirect.Serve(redirectLn); err != nil && err != http.ErrServerClosed {
+				s.fail(fmt.Errorf("HTTP redirect listener: %w", err))
+			}
+		}()
+	}
+
+	go func() {
+		log.Printf("Reverse Proxy running on %s (strategy: %s, proxy timeout: %ds)\n",
+			s.proxyAddr, cfg.Strategy, cfg.ProxyTimeout)
+		var err error
+		if s.server.TLSConfig != nil {
+			err = s.server.ServeTLS(proxyLn, "", "") // certificates from acme or certs
+		} else {
+			err = s.server.Serve(proxyLn)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			s.fail(fmt.Errorf("proxy server: %w", err))
+		}
+	}()
+	for i, extra := range s.extra {
+		go func() {
+			var err error
+			if extra.server.TLSConfig != nil {
+				log.Printf("Reverse Proxy also running on %s (TLS)", s.extraAddrs[i])
+				err = extra.server.ServeTLS(extraLns[i], "", "")
+			} else {
+				log.Printf("Reverse Proxy also running on %s", s.extraAddrs[i])
+				err = extra.server.Serve(extraLns[i])
+			}
+			if err != nil && err != http.ErrServerClosed {
+				s.fail(fmt.Errorf("proxy server %s: %w", extra.server.Addr, err))
+			}
+		}()
+	}
+	signalReady()
+	return nil
+}
+
+// wrapListener applies the PROXY protocol and the per-IP connection limit
+// of cfg to a proxy listener.
+func (cfg *Config) wrapListener(ln net.Listener) (net.Listener, error) {
+	var err error
+	if pp := cfg.Listener.ProxyProtocol; pp.Enabled {
+		// Inside the per-IP limit, so the limit applies to the real client.
+		ln, err = proxyproto.NewListener(ln, pp.Trusted, time.Duration(pp.Timeout)*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listener.proxy_protocol.trusted: %w", err)
+		}
+	}
+	if cfg.ClientLimits.MaxConnsPerIP > 0 {
+		ln, err = limit.NewPerIPListener(ln, cfg.ClientLimits.MaxConnsPerIP, cfg.ClientLimits.Allowlist)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client_limits.allowlist: %w", err)
+		}
+	}
+	return ln, nil
+}
+
+// fail reports a listener that stopped on its own; only the first error is
+// kept.
+func (s *Server) fail(err error) {
+	select {
+	case s.errs <- err:
+	default:
+	}
+}
+
+// Err delivers the error of a listener that stopped serving before Stop,
+// e.g. a TLS certificate that could not be loaded.
+func (s *Server) Err() <-chan error {
+	return s.errs
+}
+
+// Addr is the address the proxy listens on, once started.
+func (s *Server) Addr() net.Addr {
+	return s.proxyAddr
+}
+
+// ListenerAddrs are the addresses of cfg.Listeners, in order, once started.
+func (s *Server) ListenerAddrs() []net.Addr {
+	return s.extraAddrs
+}
+
+// AdminAddr is the address of the admin API, once started.
+func (s *Server) AdminAddr() net.Addr {
+	return s.adminAddr
+}
+
+// Handler is the full proxy handler chain (routes, cache, compression,
+// decision log), for use without the listener, e.g. with httptest.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// Pool is the backend pool of the "default" route.
+func (s *Server) Pool() *pool.ServerPool {
+	return s.pool
+}
+
+// Routes is the routing table, the "default" route included.
+func (s *Server) Routes() *route.Table {
+	return s.routes
+}
+
+// statsdPools lists the pools reported to StatsD: the routes, then the
+// ingress backends and the TCP proxies under their names.
+func (s *Server) statsdPools() []statsd.Pool {
+	var pools []statsd.Pool
+	for _, rt := range s.routes.Routes() {
+		pools = append(pools, statsd.Pool{Route: rt.Name, Backends: rt.Pool})
+	}
+	if s.controller != nil {
+		pools = append(pools, statsd.Pool{Route: "ingress", Backends: s.controller})
+	}
+	for i, srv := range s.tcpServers {
+		pools = append(pools, statsd.Pool{Route: s.cfg.TCP[i].Name, Backends: srv.Pool})
+	}
+	return pools
+}
+
+// Stop shuts the server down gracefully. /readyz fails at once; with
+// readiness.shutdown_delay, the proxy keeps serving for that long, closing
+// client connections after their current request, so that the load
+// balancers in front of it stop routing to it. In-flight requests are then
+// drained until ctx expires, and cut. It returns the shutdown report, which
+// is also logged and, with shutdown_webhook, posted. The error is non-nil
+// when the drain did not finish in time.
+func (s *Server) Stop(ctx context.Context) (ShutdownReport, error) {
+	s.draining.Store(true)
+	// After an upgrade, the new process answers on the same listeners:
+	// there is no one to steer traffic away from.
+	if delay := time.Duration(s.cfg.Readiness.ShutdownDelay) * time.Second; delay > 0 && !s.upgraded {
+		log.Printf("Shutting down — not ready, still serving for %v", delay)
+		s.server.SetKeepAlivesEnabled(false)
+		for _, extra := range s.extra {
+			extra.server.SetKeepAlivesEnabled(false)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+
+	shutdownStarted := time.Now()
+	inFlightAtStop, completedAtStop := s.tracker.InFlight(), s.tracker.Completed()
+	log.Printf("Shutting down — draining %d in-flight requests...", inFlightAtStop)
+
+	var shutdownErr error
+	s.stopOnce.Do(func() {
+		// In order, under one deadline: background tasks first (no health check
+		// flips a backend mid-drain), then the admin API, then the proxy itself.
+		if s.stopBackground != nil {
+			s.stopBackground()
+		}
+		if err := s.admin.Shutdown(ctx); err != nil {
+			s.admin.Close()
+		}
+		if s.redirect != nil {
+			s.redirect.Close() // redirects and challenges: nothing to drain
+		}
+		log.Println("Health checks and admin API stopped")
+
+		servers := []*http.Server{s.server}
+		for _, extra := range s.extra {
+			servers = append(servers, extra.server)
+		}
+		var wg sync.WaitGroup
+		errs := make([]error, len(servers))
+		for i, srv := range servers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if errs[i] = srv.Shutdown(ctx); errs[i] != nil {
+					// Deadline hit: cut the remaining connections so the report reflects reality.
+					srv.Close()
+				}
+			}()
+		}
+		wg.Wait()
+		shutdownErr = errors.Join(errs...)
+		// Raw TCP streams have no request boundary to drain at.
+		for _, srv := range s.tcpServers {
+			srv.Close()
+		}
+		if s.decisions != nil {
+			s.decisions.Close()
+		}
+		if s.capture != nil {
+			s.capture.Close()
+		}
+		if s.audit != nil {
+			s.audit.Close()
+		}
+	})
+
+	var pools []pool.LoadBalancer
+	for _, rt := range s.routes.Routes() {
+		pools = append(pools, rt.Pool)
+	}
+	report := buildShutdownReport(shutdownStarted, inFlightAtStop, completedAtStop, s.tracker, pools)
+	emitShutdownReport(report, s.cfg.ShutdownWebhook)
+	return report, shutdownErr
+}
+
+// newServerPool builds a pool from backend URLs, checking each one once so
+// the pool starts with accurate health.
+func (cfg *Config) newServerPool(strategy string, probe health.Probe, urls []string, transport pool.TransportConfig) *pool.ServerPool {
+	serverPool, _ := pool.NewServerPool( // settings validated by prepare
+		pool.WithStrategy(strategy),
+		pool.WithTransport(transport),
+		pool.WithSlowStart(time.Duration(cfg.SlowStart)*time.Second),
+	)
+	validBackendCount := 0
+
+	for _, b := range urls {
+		u, err := pool.ParseBackendURL(b)
+		if err != nil {
+			log.Printf("Invalid backend URL: %s, skipping", b)
+			continue
+		}
+
+		check := probe
+		if check.TLS == nil { // no health_check_client.tls
+			check.TLS = transport.TLSConfig(u)
+		}
+		isAlive := check.Check(u.String())
+
+		backend := &pool.Backend{
+			URL: u,
+		}
+		backend.SetAlive(isAlive)
+		backend.SetTags(cfg.BackendTags[u.String()])
+		if w, ok := cfg.BackendWeights[u.String()]; ok {
+			backend.SetWeight(w)
+		}
+		serverPool.AddBackend(backend)
+
+		if isAlive {
+			validBackendCount++
+			log.Printf("✓ Backend %s is healthy", u.String())
+		} else {
+			log.Printf("✗ Backend %s is unreachable", u.String())
+		}
+	}
+
+	if validBackendCount == 0 {
+		log.Println("WARNING: No healthy backends found! Proxy will return 503 until backends become available.")
+	} else {
+		log.Printf("%d/%d backends are healthy\n", validBackendCount, len(urls))
+	}
+	return serverPool
+}
+
+// ipFilters builds the global IP filter and one per route, "default"
+// included, so every scope can be managed at runtime even if it starts empty.
+// The config was validated by prepare.
+func (cfg *Config) ipFilters() map[string]*limit.IPFilter {
+	filters := map[string]*limit.IPFilter{}
+	filters["global"], _ = limit.NewIPFilter(cfg.IPFilter.Allow, cfg.IPFilter.Deny)
+	filters["default"], _ = limit.NewIPFilter(nil, nil)
+	for _, rc := range cfg.Routes {
+		filters[rc.Name], _ = limit.NewIPFilter(rc.IPFilter.Allow, rc.IPFilter.Deny)
+	}
+	return filters
+}
+
+// buildRoutes creates a pool and a proxy handler for every configured route,
+// plus the catch-all "default" route serving the top-level backends.
+func (cfg *Config) buildRoutes(defaultPool pool.LoadBalancer, opts proxy.Options, filters map[string]*limit.IPFilter) *route.Table {
+	defaultOpts := opts
+	defaultOpts.IPFilters = []*limit.IPFilter{filters["global"], filters["default"]}
+	routes := []*route.Route{{
+		Name:    "default",
+		Pool:    defaultPool,
+		Handler: proxy.NewHandler(defaultPool, defaultOpts),
+	}}
+
+	for _, rc := range cfg.Routes {
+		log.Printf("Validating backends of route %s...", rc.Name)
+		transport := cfg.TransportConfig()
+		transport.H2C = rc.H2C
+		if rc.ProxyProtocol != 0 {
+			transport.ProxyProtocol = rc.ProxyProtocol
+		}
+
+		var lb pool.LoadBalancer
+		if len(rc.Groups) > 0 {
+			groups := make([]*pool.Group, 0, len(rc.Groups))
+			for _, g := range rc.Groups {
+				group := pool.NewGroup(g.Name, g.Weight, cfg.newServerPool(cfg.Strategy, cfg.healthProbe(rc.Name), g.Backends, transport))
+				group.Priority = g.Priority
+				group.Pool.Tags = rc.tagPolicy()
+				groups = append(groups, group)
+			}
+			lb = pool.NewGroupedPool(groups...)
+		} else {
+			sp := cfg.newServerPool(cfg.Strategy, cfg.healthProbe(rc.Name), rc.Backends, transport)
+			sp.Tags = rc.tagPolicy()
+			lb = sp
+		}
+
+		routeOpts := opts
+		routeOpts.Route = rc.Name
+		routeOpts.IPFilters = []*limit.IPFilter{filters["global"], filters[rc.Name]}
+		if rc.JWT != nil {
+			routeOpts.JWT, _ = rc.JWT.validator() // validated by prepare
+		}
+		if rc.CORS != nil {
+			routeOpts.CORS = rc.CORS
+		}
+		if rc.ProxyTimeout > 0 {
+			routeOpts.Timeout = time.Duration(rc.ProxyTimeout) * time.Second
+		}
+		if rc.MaxBodyBytes != 0 {
+			routeOpts.MaxBodyBytes = max(rc.MaxBodyBytes, 0)
+		}
+		routeOpts.Concurrency = cfg.concurrencyLimiter()
+		if rc.MaxResponseBytes != 0 {
+			routeOpts.MaxResponseBytes = max(rc.MaxResponseBytes, 0)
+		}
+		if rc.ResponseContentTypes != nil {
+			routeOpts.ResponseContentTypes = rc.ResponseContentTypes
+		}
+		if rc.HostHeader != "" {
+			routeOpts.HostHeader = rc.HostHeader
+		}
+		if rc.Bandwidth > 0 {
+			routeOpts.Bandwidth = limit.NewBandwidth(rc.Bandwidth)
+		}
+		routeOpts.HedgeDelay = time.Duration(rc.HedgeDelay) * time.Millisecond
+		routeOpts.SecurityHeaders = cfg.SecurityHeaders.With(rc.SecurityHeaders)
+		routeOpts.Rewrite = rc.Rewrite // compiled by prepare
+		if len(rc.Extensions) > 0 {
+			exts, _ := rc.middleware() // validated by prepare
+			routeOpts.Middleware = append(slices.Clone(opts.Middleware), exts...)
+		}
+		var rule *route.Rule
+		if rc.Rule != "" {
+			rule, _ = route.CompileRule(rc.Rule) // validated by prepare
+		}
+		routes = append(routes, &route.Route{
+			Name:       rc.Name,
+			Host:       rc.Host,
+			PathPrefix: rc.PathPrefix,
+			Rule:       rule,
+			Pool:       lb,
+			Handler:    proxy.NewHandler(lb, routeOpts),
+		})
+	}
+	return route.NewTable(routes...)
+}
+
+// buildTCPProxies creates a pool and a server for every TCP listener. The
+// pools are checked with a TCP connect, as their backends may speak no HTTP.
+func (cfg *Config) buildTCPProxies() []*tcpproxy.Server {
+	var servers []*tcpproxy.Server
+	for _, tc := range cfg.TCP {
+		log.Printf("Validating backends of TCP listener %s...", tc.Name)
+		serverPool := cfg.newServerPool(tc.Strategy, health.Probe{Type: health.CheckTCP}, tc.Backends, pool.TransportConfig{})
+		servers = append(servers, &tcpproxy.Server{
+			Name:        tc.Name,
+			Pool:        serverPool,
+			DialTimeout: time.Duration(tc.DialTimeout) * time.Second,
+		})
+	}
+	return servers
+}
@@ -0,0 +1,988 @@
+package reverseproxy_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-proxy/admin"
+	"reverse-proxy/extension"
+	"reverse-proxy/proxy"
+	"reverse-proxy/reverseproxy"
+)
+
+func newBackend(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// The proxy can be embedded: built from a Config, started on free ports and
+// stopped with a clean report.
+func TestServer_StartServeStop(t *testing.T) {
+	backend := newBackend(t, "hello")
+	srv, err := reverseproxy.New(&reverseproxy.Config{
+		Strategy: "round-robin",
+		Backends: []string{backend.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, body := get(t, "http://"+srv.Addr().String()+"/"); code != http.StatusOK || body != "hello" {
+		t.Errorf("proxy: got %d %q", code, body)
+	}
+	code, body := get(t, "http://"+srv.AdminAddr().String()+"/v1/status")
+	var status admin.StatusResponse
+	if err := json.Unmarshal([]byte(body), &status); code != http.StatusOK || err != nil || status.ActiveBackends != 1 {
+		t.Errorf("admin: got %d %q", code, body)
+	}
+	if srv.Pool().GetBackends()[0].Stats().Requests != 1 {
+		t.Error("the request should be counted on the default pool")
+	}
+
+	report, err := srv.Stop(t.Context())
+	if err != nil || !report.Clean {
+		t.Fatalf("expected a clean stop, got %+v, %v", report, err)
+	}
+	if _, err := http.Get("http://" + srv.Addr().String() + "/"); err == nil {
+		t.Error("the proxy should no longer accept connections")
+	}
+}
+
+// During the shutdown delay, /readyz fails while the proxy still serves.
+func TestServer_StopWaitsShutdownDelay(t *testing.T) {
+	backend := newBackend(t, "hello")
+	srv, err := reverseproxy.New(&reverseproxy.Config{
+		Strategy:  "round-robin",
+		Backends:  []string{backend.URL},
+		Readiness: reverseproxy.ReadinessSettings{ShutdownDelay: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := get(t, "http://"+srv.AdminAddr().String()+"/readyz"); code != http.StatusOK {
+		t.Fatalf("readyz before Stop: got %d %q", code, body)
+	}
+
+	stopped := make(chan error, 1)
+	started := time.Now()
+	go func() {
+		_, err := srv.Stop(t.Context())
+		stopped <- err
+	}()
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for {
+		code, body := get(t, "http://"+srv.AdminAddr().String()+"/readyz")
+		if code == http.StatusServiceUnavailable && strings.Contains(body, `"draining":true`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("readyz did not fail after Stop: got %d %q", code, body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code, body := get(t, "http://"+srv.Addr().String()+"/"); code != http.StatusOK || body != "hello" {
+		t.Errorf("proxy during the shutdown delay: got %d %q", code, body)
+	}
+
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed < time.Second {
+		t.Errorf("Stop returned after %v, before the shutdown delay", elapsed)
+	}
+}
+
+func TestNew_ValidatesAndFillsDefaults(t *testing.T) {
+	if _, err := reverseproxy.New(&reverseproxy.Config{Strategy: "coin-flip"}); err == nil {
+		t.Error("expected an unknown strategy to be rejected")
+	}
+	redirect := &reverseproxy.Config{Strategy: "round-robin", Listener: reverseproxy.ListenerSettings{HTTPRedirectPort: 8080}}
+	if _, err := reverseproxy.New(redirect); err == nil {
+		t.Error("expected http_redirect_port without TLS to be rejected")
+	}
+	debug := &reverseproxy.Config{Strategy: "round-robin", Admin: reverseproxy.AdminSettings{Debug: true}}
+	if _, err := reverseproxy.New(debug); err == nil {
+		t.Error("expected admin.debug without a token to be rejected")
+	}
+	debug.Admin.Tokens = []admin.Token{{Name: "dashboard", Secret: "look", Role: admin.RoleReadOnly}}
+	if _, err := reverseproxy.New(debug); err == nil {
+		t.Error("expected admin.debug with only a read-only token to be rejected")
+	}
+	dns := &reverseproxy.Config{Strategy: "round-robin", Transport: reverseproxy.TransportSettings{DNS: &reverseproxy.DNSSettings{Families: []string{"ipv5"}}}}
+	if _, err := reverseproxy.New(dns); err == nil {
+		t.Error("expected an unknown address family to be rejected")
+	}
+	roles := &reverseproxy.Config{Strategy: "round-robin", Admin: reverseproxy.AdminSettings{Tokens: []admin.Token{{Name: "x", Secret: "y", Role: "root"}}}}
+	if _, err := reverseproxy.New(roles); err == nil {
+		t.Error("expected an unknown token role to be rejected")
+	}
+	statsd := &reverseproxy.Config{Strategy: "round-robin", StatsD: &reverseproxy.StatsDSettings{Address: "127.0.0.1:8125", Tags: []string{"env:prod"}}}
+	if _, err := reverseproxy.New(statsd); err == nil {
+		t.Error("expected statsd.tags without dogstatsd to be rejected")
+	}
+	syslog := &reverseproxy.Config{Strategy: "round-robin", Logging: reverseproxy.LogSettings{Syslog: &reverseproxy.SyslogSettings{Address: "http://logs:514"}}}
+	if _, err := reverseproxy.New(syslog); err == nil {
+		t.Error("expected a syslog address without udp://, tcp:// or unix:// to be rejected")
+	}
+	bandwidth := &reverseproxy.Config{Strategy: "round-robin", Bandwidth: reverseproxy.BandwidthSettings{
+		Classes: []reverseproxy.ClientClassSettings{{Name: "batch", Header: "X-Batch: 1", Routes: []string{"downloads"}, BytesPerSecond: 1024}},
+	}}
+	if _, err := reverseproxy.New(bandwidth); err == nil {
+		t.Error("expected a bandwidth class on an unknown route to be rejected")
+	}
+	tiers := &reverseproxy.Config{Strategy: "round-robin", Routes: []reverseproxy.RouteConfig{{
+		Name: "web", Groups: []reverseproxy.GroupConfig{{Name: "eu", Weight: 1, Priority: -1, Backends: []string{"http://eu:8080"}}},
+	}}}
+	if _, err := reverseproxy.New(tiers); err == nil {
+		t.Error("expected a negative group priority to be rejected")
+	}
+	tagged := &reverseproxy.Config{Strategy: "round-robin",
+		BackendTags: map[string]map[string]string{"http://eu:8080": {"region": "eu"}},
+		Routes:      []reverseproxy.RouteConfig{{Name: "web", Backends: []string{"http://eu:8080"}, Tags: map[string]string{"region": "us"}}},
+	}
+	if _, err := reverseproxy.New(tagged); err == nil {
+		t.Error("expected route tags carried by none of its backends to be rejected")
+	}
+	weights := &reverseproxy.Config{Strategy: "weighted-least-connections", BackendWeights: map[string]int{"http://big:8080": -4}}
+	if _, err := reverseproxy.New(weights); err == nil {
+		t.Error("expected a negative backend weight to be rejected")
+	}
+	healthAuth := &reverseproxy.Config{Strategy: "round-robin", HealthCheckAuth: &reverseproxy.HealthCheckAuth{
+		BearerToken: &reverseproxy.Secret{Env: "REVERSE_PROXY_TEST_UNSET_TOKEN"},
+	}}
+	if _, err := reverseproxy.New(healthAuth); err == nil {
+		t.Error("expected a health check token from an unset environment variable to be rejected")
+	}
+	healthProxy := &reverseproxy.Config{Strategy: "round-robin", HealthCheckClient: reverseproxy.HealthCheckClientSettings{Proxy: "socks5://proxy:1080"}}
+	if _, err := reverseproxy.New(healthProxy); err == nil {
+		t.Error("expected a health check proxy other than http:// or https:// to be rejected")
+	}
+	backoff := &reverseproxy.Config{Strategy: "round-robin", HealthCheckBackoff: -1}
+	if _, err := reverseproxy.New(backoff); err == nil {
+		t.Error("expected a negative health_check_backoff to be rejected")
+	}
+	delay := &reverseproxy.Config{Strategy: "round-robin", Readiness: reverseproxy.ReadinessSettings{ShutdownDelay: -1}}
+	if _, err := reverseproxy.New(delay); err == nil {
+		t.Error("expected a negative shutdown delay to be rejected")
+	}
+	buffers := &reverseproxy.Config{Strategy: "round-robin", BufferSize: -1}
+	if _, err := reverseproxy.New(buffers); err == nil {
+		t.Error("expected a negative buffer_size to be rejected")
+	}
+	contentTypes := &reverseproxy.Config{Strategy: "round-robin", Routes: []reverseproxy.RouteConfig{{
+		Name: "api", Backends: []string{"http://api:8080"}, ResponseContentTypes: []string{"json"},
+	}}}
+	if _, err := reverseproxy.New(contentTypes); err == nil {
+		t.Error("expected a route content type without a subtype to be rejected")
+	}
+	ext := &reverseproxy.Config{Strategy: "round-robin", Routes: []reverseproxy.RouteConfig{
+		{Name: "api", Backends: []string{"http://127.0.0.1:1"}, Extensions: []extension.Config{{Name: "unregistered"}}},
+	}}
+	if _, err := reverseproxy.New(ext); err == nil {
+		t.Error("expected an unregistered extension to be rejected")
+	}
+	ext.Routes[0].Extensions, ext.Routes[0].Rule = nil, `header("X-Beta") = "true"`
+	if _, err := reverseproxy.New(ext); err == nil {
+		t.Error("expected an invalid route rule to be rejected")
+	}
+
+	cfg := &reverseproxy.Config{Strategy: "round-robin"}
+	if _, err := reverseproxy.New(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ProxyTimeout != 30 || cfg.ShutdownTimeout != 10 || cfg.HealthCheckFrequency != 10 {
+		t.Errorf("defaults not applied: %+v", cfg)
+	}
+}
+
+func TestNew_ValidatesACME(t *testing.T) {
+	for name, acme := range map[string]*reverseproxy.ACMESettings{
+		"no domains": {},
+		"wildcard":   {Domains: []string{"*.example.com"}},
+		"challenge":  {Domains: []string{"example.com"}, Challenge: "dns-01"},
+		"http port":  {Domains: []string{"example.com"}, Challenge: "http-01", HTTPPort: -1},
+	} {
+		cfg := &reverseproxy.Config{Strategy: "round-robin", Listener: reverseproxy.ListenerSettings{ACME: acme}}
+		if _, err := reverseproxy.New(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	cfg := &reverseproxy.Config{Strategy: "round-robin", Listener: reverseproxy.ListenerSettings{
+		TLSCertFile: "cert.pem", TLSKeyFile: "key.pem",
+		ACME: &reverseproxy.ACMESettings{Domains: []string{"example.com"}},
+	}}
+	if _, err := reverseproxy.New(cfg); err == nil {
+		t.Error("expected acme and tls_cert_file together to be rejected")
+	}
+
+	cfg.Listener.TLSCertFile, cfg.Listener.TLSKeyFile = "", ""
+	if _, err := reverseproxy.New(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listener.ACME.HTTPPort != 80 {
+		t.Errorf("expected http_port to default to 80, got %d", cfg.Listener.ACME.HTTPPort)
+	}
+}
+
+// /reload is only offered for a config that came from a file, which it
+// re-reads.
+func TestLoadConfig_EnablesReload(t *testing.T) {
+	first, second := newBackend(t, "first"), newBackend(t, "second")
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(backend string) {
+		data, _ := json.Marshal(map[string]any{"strategy": "round-robin", "backends": []string{backend}})
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(first.URL)
+
+	cfg, err := reverseproxy.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := reverseproxy.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Stop(t.Context()) })
+
+	write(second.URL)
+	resp, err := http.Post("http://"+srv.AdminAddr().String()+"/v1/reload", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("reload: expected 200, got %d", resp.StatusCode)
+	}
+
+	backends := srv.Pool().GetBackends()
+	if len(backends) != 1 || backends[0].URL.String() != second.URL {
+		t.Fatalf("expected only %s after reload, got %v", second.URL, backends)
+	}
+	// New backends wait for a health check; let one through by hand.
+	srv.Pool().SetBackendStatus(backends[0].URL, true)
+	deadline := time.Now().Add(time.Second)
+	for {
+		code, body := get(t, "http://"+srv.Addr().String()+"/")
+		if code == http.StatusOK && body == "second" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("proxy did not switch to the reloaded backend: %d %q", code, body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// A route's proxy_timeout replaces the global one for its requests only.
+func TestRoute_ProxyTimeoutOverride(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" { // health checks answer at once
+			time.Sleep(1100 * time.Millisecond)
+		}
+		io.WriteString(w, "report")
+	}))
+	t.Cleanup(slow.Close)
+
+	srv, err := reverseproxy.New(&reverseproxy.Config{
+		Strategy:     "round-robin",
+		ProxyTimeout: 1,
+		Backends:     []string{slow.URL},
+		Routes: []reverseproxy.RouteConfig{
+			{Name: "reports", PathPrefix: "/reports", ProxyTimeout: 3, Backends: []string{slow.URL}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Stop(t.Context()) })
+
+	if code, body := get(t, "http://"+srv.Addr().String()+"/reports/monthly"); code != http.StatusOK || body != "report" {
+		t.Errorf("route with a longer timeout: got %d %q", code, body)
+	}
+	if code, _ := get(t, "http://"+srv.Addr().String()+"/export"); code != http.StatusGatewayTimeout {
+		t.Errorf("default route: expected 504 after the global timeout, got %d", code)
+	}
+
+	if _, err := reverseproxy.New(&reverseproxy.Config{
+		Strategy: "round-robin",
+		Routes:   []reverseproxy.RouteConfig{{Name: "r", ProxyTimeout: -1}},
+	}); err == nil {
+		t.Error("expected a negative route proxy_timeout to be rejected")
+	}
+}
+
+// Every route has its own concurrency limiter: a saturated route does not
+// reject the requests of another one.
+func TestRoute_ConcurrencyLimitPerRoute(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			started <- struct{}{}
+			<-release
+		}
+		io.WriteString(w, "slow")
+	}))
+	t.Cleanup(slow.Close)
+	fast := newBackend(t, "fast")
+
+	cfg := &reverseproxy.Config{
+		Strategy: "round-robin",
+		Backends: []string{fast.URL},
+		Routes: []reverseproxy.RouteConfig{
+			{Name: "reports", PathPrefix: "/reports", Backends: []string{slow.URL}},
+			{Name: "search", PathPrefix: "/search", Backends: []string{fast.URL}},
+		},
+	}
+	cfg.Concurrency.MaxConcurrent = 1
+	srv, err := reverseproxy.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Stop(t.Context()) })
+	t.Cleanup(func() { close(release) })
+	base := "http://" + srv.Addr().String()
+
+	go http.Get(base + "/reports/monthly")
+	<-started
+	if code, _ := get(t, base+"/reports/weekly"); code != http.StatusServiceUnavailable {
+		t.Errorf("saturated route: expected 503, got %d", code)
+	}
+	if code, body := get(t, base+"/search"); code != http.StatusOK || body != "fast" {
+		t.Errorf("other route: got %d %q", code, body)
+	}
+	if code, body := get(t, base+"/"); code != http.StatusOK || body != "fast" {
+		t.Errorf("default route: got %d %q", code, body)
+	}
+}
+
+func TestUnavailable_ServesMaintenancePage(t *testing.T) {
+	page := filepath.Join(t.TempDir(), "maintenance.html")
+	if err := os.WriteFile(page, []byte("<h1>Maintenance</h1>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &reverseproxy.Config{Strategy: "round-robin"}
+	cfg.ErrorResponse.Unavailable = reverseproxy.UnavailableSettings{File: page, RetryAfter: 300}
+	srv, err := reverseproxy.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Stop(t.Context()) })
+
+	resp, err := http.Get("http://" + srv.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || string(body) != "<h1>Maintenance</h1>" ||
+		resp.Header.Get("Retry-After") != "300" || resp.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("unexpected answer: %d %v %q", resp.StatusCode, resp.Header, body)
+	}
+
+	cfg = &reverseproxy.Config{Strategy: "round-robin"}
+	cfg.ErrorResponse.Unavailable.File = filepath.Join(t.TempDir(), "missing.html")
+	if _, err := reverseproxy.New(cfg); err == nil {
+		t.Error("expected a missing maintenance page to be rejected")
+	}
+}
+
+// With health_check_type "tcp", a backend without a /health route is UP as
+// soon as it accepts connections.
+func TestHealthCheckType_TCP(t *testing.T) {
+	legacy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "legacy")
+	}))
+	t.Cleanup(legacy.Close)
+
+	srv, err := reverseproxy.New(&reverseproxy.Config{
+		Strategy: "round-robin",
+		Backends: []string{legacy.URL},
+		Routes: []reverseproxy.RouteConfig{
+			{Name: "legacy", PathPrefix: "/legacy", HealthCheckType: "tcp", Backends: []string{legacy.URL}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Stop(t.Context()) })
+
+	if code, body := get(t, "http://"+srv.Addr().String()+"/legacy/"); code != http.StatusOK || body != "legacy" {
+		t.Errorf("tcp-checked route: got %d %q", code, body)
+	}
+	if code, _ := get(t, "http://"+srv.Addr().String()+"/"); code != http.StatusServiceUnavailable {
+		t.Errorf("http-checked default route: expected 503, got %d", code)
+	}
+
+	if _, err := reverseproxy.New(&reverseproxy.Config{Strategy: "round-robin", HealthCheckType: "icmp"}); err == nil {
+		t.Error("expected an unknown health_check_type to be rejected")
+	}
+}
+
+func TestHealthCheckAuth_FromSecretFileAndEnv(t *testing.T) {
+	secured := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "probe" || pass != "from-file" || r.Header.Get("X-Env") != "from-env" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "secured")
+	}))
+	t.Cleanup(secured.Close)
+	password := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(password, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REVERSE_PROXY_TEST_HEALTH_HEADER", "from-env")
+
+	srv, err := reverseproxy.New(&reverseproxy.Config{
+		Strategy: "round-robin",
+		Backends: []string{secured.URL},
+		Routes: []reverseproxy.RouteConfig{{
+			Name: "secured", PathPrefix: "/secured", Backends: []string{secured.URL},
+			HealthCheckAuth: &reverseproxy.HealthCheckAuth{
+				Username: "probe",
+				Password: &reverseproxy.Secret{File: password},
+				Headers:  map[string]reverseproxy.Secret{"X-Env": {Env: "REVERSE_PROXY_TEST_HEALTH_HEADER"}},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Pool().GetBackends()[0].IsAlive() {
+		t.Error("the default route sends no credentials: its backend must be DOWN")
+	}
+	if b := srv.Routes().Get("secured").Pool.GetBackends()[0]; !b.IsAlive() {
+		t.Error("expected the backend checked with credentials to be UP")
+	}
+}
+
+// writePEM writes the blocks to a new file of dir and returns its path.
+func writePEM(t *testing.T, dir, name string, blocks ...*pem.Block) string {
+	t.Helper()
+	var data []byte
+	for _, b := range blocks {
+		data = append(data, pem.EncodeToMemory(b)...)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBackendTLS_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+	certFile := writePEM(t, dir, "client.pem", &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyFile := writePEM(t, dir, "client-key.pem", &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	clientCA, _ := x509.ParseCertificate(der)
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secure")
+	}))
+	backend.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+	backend.TLS.ClientCAs.AddCert(clientCA)
+	backend.StartTLS()
+	t.Cleanup(backend.Close)
+	caFile := writePEM(t, dir, "ca.pem", &pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw})
+	host := strings.TrimPrefix(backend.URL, "https://")
+
+	for _, tc := range []struct {
+		name      string
+		transport reverseproxy.TransportSettings
+		want      int
+	}{
+		{"client certificate", reverseproxy.TransportSettings{
+			TLS:        &reverseproxy.TLSSettings{CAFile: caFile},
+			BackendTLS: map[string]reverseproxy.TLSSettings{host: {CAFile: caFile, CertFile: certFile, KeyFile: keyFile}},
+		}, http.StatusOK},
+		{"no client certificate", reverseproxy.TransportSettings{
+			TLS: &reverseproxy.TLSSettings{CAFile: caFile},
+		}, http.StatusServiceUnavailable},
+	} {
+		srv, err := reverseproxy.New(&reverseproxy.Config{Strategy: "round-robin", Backends: []string{backend.URL}, Transport: tc.transport})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.Start(); err != nil {
+			t.Fatal(err)
+		}
+		if code, _ := get(t, "http://"+srv.Addr().String()+"/"); code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, code)
+		}
+		srv.Stop(t.Context())
+	}
+
+	for _, settings := range []reverseproxy.TLSSettings{
+		{CertFile: certFile}, // without its key
+		{CAFile: keyFile},    // not a certificate
+		{CAFile: filepath.Join(dir, "missing.pem")},
+	} {
+		cfg := &reverseproxy.Config{Strategy: "round-robin", Transport: reverseproxy.TransportSettings{TLS: &settings}}
+		if _, err := reverseproxy.New(cfg); err == nil {
+			t.Errorf("%+v: expected an error", settings)
+		}
+	}
+}
+
+// The health checks of a TLS backend use a copy of its TLS config: net/http
+// writes to the config of the proxy's transport as it serves, which must not
+// race with the checks (run with -race).
+func TestBackendTLS_HealthChecksWhileServing(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secure")
+	}))
+	t.Cleanup(backend.Close)
+	caFile := writePEM(t, t.TempDir(), "ca.pem", &pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw})
+
+	srv, err := reverseproxy.New(&reverseproxy.Config{
+		Strategy:             "round-robin",
+		Backends:             []string{backend.URL},
+		HealthCheckFrequency: 1,
+		Transport:            reverseproxy.TransportSettings{TLS: &reverseproxy.TLSSettings{CAFile: caFile}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Serve while the health checks start, and for long enough for them to
+	// run at least once.
+	served := make(chan int, 1)
+	go func() {
+		code := http.StatusOK
+		for deadline := time.Now().Add(1500 * time.Millisecond); time.Now().Before(deadline) && code == http.StatusOK; {
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			code = rec.Code
+			time.Sleep(10 * time.Millisecond)
+		}
+		served <- code
+	}()
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Stop(t.Context()) })
+	if code := <-served; code != http.StatusOK {
+		t.Errorf("expected the TLS backend to serve, got %d", code)
+	}
+}
+
+// writeServerCert writes a self-signed certificate for cn to cert.pem and
+// key.pem in dir, dated at, so that every call looks like a change.
+func writeServerCert(t *testing.T, dir, cn string, at time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+	for _, path := range []string{
+		writePEM(t, dir, "cert.pem", &pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		writePEM(t, dir, "key.pem", &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	} {
+		if err := os.Chtimes(path, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListenerTLS_HotReload(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeServerCert(t, dir, "first.test", now)
+	srv, err := reverseproxy.New(&reverseproxy.Config{
+		Strategy: "round-robin",
+		Backends: []string{newBackend(t, "hello").URL},
+		Listener: reverseproxy.ListenerSettings{
+			TLSCertFile:       filepath.Join(dir, "cert.pem"),
+			TLSKeyFile:        filepath.Join(dir, "key.pem"),
+			TLSReloadInterval: 1,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop(t.Context())
+
+	served := func() string {
+		conn, err := tls.Dial("tcp", srv.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	proxyURL := "https://" + srv.Addr().String() + "/"
+	if resp, err := client.Get(proxyURL); err != nil {
+		t.Fatal(err)
+	} else {
+		resp.Body.Close()
+	}
+	if cn := served(); cn != "first.test" {
+		t.Fatalf("expected first.test, got %s", cn)
+	}
+
+	// The watcher picks up new files.
+	writeServerCert(t, dir, "second.test", now.Add(time.Minute))
+	deadline := time.Now().Add(5 * time.Second)
+	for served() != "second.test" {
+		if time.Now().After(deadline) {
+			t.Fatal("the renewed certificate was not picked up")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	// The keep-alive connection opened before the swap is still usable.
+	if resp, err := client.Get(proxyURL); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("existing connection: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+
+	// So does the admin API, on demand; a broken pair keeps the current one.
+	reloadURL := "http://" + srv.AdminAddr().String() + "/v1/certs/reload"
+	os.WriteFile(filepath.Join(dir, "key.pem"), []byte("not a key"), 0o600)
+	resp, err := http.Post(reloadURL, "", nil)
+	if err != nil || resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected the broken pair to be rejected, got %v %v", resp.Status, err)
+	}
+	resp.Body.Close()
+	if cn := served(); cn != "second.test" {
+		t.Errorf("a failed reload must keep the current certificate, got %s", cn)
+	}
+
+	writeServerCert(t, dir, "third.test", now.Add(2*time.Minute))
+	resp, err = http.Post(reloadURL, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cert admin.CertificateStatus
+	json.NewDecoder(resp.Body).Decode(&cert)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(cert.DNSNames) != 1 || cert.DNSNames[0] != "third.test" {
+		t.Errorf("unexpected reload response: %d %+v", resp.StatusCode, cert)
+	}
+	if cn := served(); cn != "third.test" {
+		t.Errorf("expected third.test after the reload, got %s", cn)
+	}
+}
+
+func TestAdminTLS_ClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	writeServerCert(t, dir, "admin.test", time.Now())
+	clientDir := t.TempDir()
+	writeServerCert(t, clientDir, "operator", time.Now()) // self-signed: its own CA
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(clientDir, "cert.pem"), filepath.Join(clientDir, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := reverseproxy.AdminSettings{
+		TLSCertFile:  filepath.Join(dir, "cert.pem"),
+		TLSKeyFile:   filepath.Join(dir, "key.pem"),
+		ClientCAFile: filepath.Join(clientDir, "cert.pem"),
+	}
+	srv, err := reverseproxy.New(&reverseproxy.Config{Strategy: "round-robin", Admin: settings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop(t.Context())
+
+	if ip := srv.AdminAddr().(*net.TCPAddr).IP; !ip.IsLoopback() {
+		t.Errorf("the admin API must listen on the loopback by default, got %s", ip)
+	}
+	url := "https://" + srv.AdminAddr().String() + "/v1/healthz"
+	for _, tc := range []struct {
+		name  string
+		certs []tls.Certificate
+		ok    bool
+	}{
+		{"client certificate", []tls.Certificate{clientCert}, true},
+		{"no client certificate", nil, false},
+	} {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: tc.certs}}}
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if ok := err == nil && resp.StatusCode == http.StatusOK; ok != tc.ok {
+			t.Errorf("%s: expected success %t, got %v", tc.name, tc.ok, err)
+		}
+	}
+	if resp, err := http.Get("http://" + srv.AdminAddr().String() + "/v1/healthz"); err == nil && resp.StatusCode == http.StatusOK {
+		t.Error("the admin API must not answer plain HTTP")
+	}
+
+	for _, bad := range []reverseproxy.AdminSettings{
+		{ClientCAFile: settings.ClientCAFile}, // without a certificate
+		{TLSCertFile: settings.TLSCertFile},   // without its key
+		{TLSCertFile: settings.TLSCertFile, TLSKeyFile: settings.TLSKeyFile, ClientCAFile: settings.TLSKeyFile}, // not a certificate
+	} {
+		if _, err := reverseproxy.New(&reverseproxy.Config{Strategy: "round-robin", Admin: bad}); err == nil {
+			t.Errorf("%+v: expected an error", bad)
+		}
+	}
+}
+
+func TestUnixSocketBackend(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	backend := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path)
+	})}
+	go backend.Serve(ln)
+	t.Cleanup(func() { backend.Close() })
+
+	srv, err := reverseproxy.New(&reverseproxy.Config{Strategy: "round-robin", Backends: []string{"unix://" + socket + ":/api"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop(t.Context())
+
+	// Checked healthy at startup through the socket, /api/health included.
+	if code, body := get(t, "http://"+srv.Addr().String()+"/hello"); code != http.StatusOK || body != "/api/hello" {
+		t.Errorf("expected the prefixed path from the socket, got %d %q", code, body)
+	}
+
+	resp, err := http.Post("http://"+srv.AdminAddr().String()+"/v1/backends", "application/json", strings.NewReader(`{"url":"unix://relative.sock"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("a unix URL without an absolute socket path must be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestListeners_ShareRoutesWithOwnTLS(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "from backend")
+	}))
+	defer backend.Close()
+	dir := t.TempDir()
+	writeServerCert(t, dir, "extra.test", time.Now())
+
+	srv, err := reverseproxy.New(&reverseproxy.Config{
+		Strategy: "round-robin",
+		Backends: []string{backend.URL},
+		Listeners: []reverseproxy.ListenerAddress{
+			{Address: "127.0.0.1:0"},
+			{Address: "localhost:0", TLSCertFile: filepath.Join(dir, "cert.pem"), TLSKeyFile: filepath.Join(dir, "key.pem")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop(t.Context())
+
+	addrs := srv.ListenerAddrs()
+	if len(addrs) != 2 {
+		t.Fatalf("expected 2 extra listeners, got %v", addrs)
+	}
+	if code, body := get(t, "http://"+addrs[0].String()+"/"); code != http.StatusOK || body != "from backend" {
+		t.Errorf("plain listener: got %d %q", code, body)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + addrs[1].String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS.PeerCertificates[0].Subject.CommonName != "extra.test" {
+		t.Errorf("TLS listener: got %d with certificate %q", resp.StatusCode, resp.TLS.PeerCertificates[0].Subject.CommonName)
+	}
+
+	for _, bad := range [][]reverseproxy.ListenerAddress{
+		{{Address: "8080"}},
+		{{Address: ":8081"}, {Address: ":8081"}},
+		{{Address: ":8082", TLSCertFile: filepath.Join(dir, "cert.pem")}},
+	} {
+		if _, err := reverseproxy.New(&reverseproxy.Config{Strategy: "round-robin", Listeners: bad}); err == nil {
+			t.Errorf("%+v: expected an error", bad)
+		}
+	}
+}
+
+// The IP filters run ahead of the response cache: a denied client is not
+// served an entry cached for an allowed one.
+func TestIPFilter_AppliesToCachedResponses(t *testing.T) {
+	backend := newBackend(t, "secret")
+	cfg := &reverseproxy.Config{
+		Strategy: "round-robin",
+		Backends: []string{backend.URL},
+		Routes: []reverseproxy.RouteConfig{{
+			Name: "private", PathPrefix: "/private", Backends: []string{backend.URL},
+			IPFilter: reverseproxy.IPFilterSettings{Deny: []string{"192.0.2.1"}},
+		}},
+	}
+	cfg.Cache = reverseproxy.CacheSettings{Enabled: true, DefaultTTL: 60}
+	srv, err := reverseproxy.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Stop(t.Context()) })
+
+	serve := func(remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/private/report", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, r)
+		return w
+	}
+	if w := serve("198.51.100.7:1234"); w.Code != http.StatusOK || w.Body.String() != "secret" {
+		t.Fatalf("allowed client: got %d %q", w.Code, w.Body)
+	}
+	if w := serve("198.51.100.7:1234"); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("expected the response to be cached, got X-Cache %q", w.Header().Get("X-Cache"))
+	}
+	if w := serve("192.0.2.1:1234"); w.Code != http.StatusForbidden || w.Header().Get("X-Cache") != "" {
+		t.Errorf("denied client: expected 403 without a cache lookup, got %d (X-Cache %q)", w.Code, w.Header().Get("X-Cache"))
+	}
+}
+
+// Responses of a route with a CORS policy vary on Origin, with or without
+// one: the cache never serves them to another origin.
+func TestCORS_CachedResponsesVaryOnOrigin(t *testing.T) {
+	backend := newBackend(t, "catalog")
+	cfg := &reverseproxy.Config{
+		Strategy: "round-robin",
+		Backends: []string{backend.URL},
+		CORS:     &proxy.CORSPolicy{Origins: []string{"https://shop.example.com"}},
+	}
+	cfg.Cache = reverseproxy.CacheSettings{Enabled: true, DefaultTTL: 60}
+	srv, err := reverseproxy.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Stop(t.Context()) })
+
+	serve := func(origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/catalog", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, r)
+		return w
+	}
+	if w := serve(""); w.Code != http.StatusOK || w.Header().Get("Vary") != "Origin" {
+		t.Fatalf("same-origin request: got %d, Vary %q", w.Code, w.Header().Get("Vary"))
+	}
+	for _, tc := range []struct{ origin, allow string }{
+		{"https://shop.example.com", "https://shop.example.com"},
+		{"https://evil.example.com", ""},
+		{"https://shop.example.com", "https://shop.example.com"}, // from the cache
+	} {
+		if got := serve(tc.origin).Header().Get("Access-Control-Allow-Origin"); got != tc.allow {
+			t.Errorf("%s: expected Access-Control-Allow-Origin %q, got %q", tc.origin, tc.allow, got)
+		}
+	}
+	if w := serve(""); w.Header().Get("X-Cache") != "HIT" || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("same-origin request: expected its own cached variant, got %v", w.Header())
+	}
+}
@@ -0,0 +1,98 @@
+package reverseproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"reverse-proxy/pool"
+	"reverse-proxy/proxy"
+)
+
+// ShutdownReport summarises a graceful shutdown so deploy tooling can confirm
+// that in-flight requests were drained cleanly.
+type ShutdownReport struct {
+	StartedAt        time.Time           `json:"started_at"`
+	DrainDurationMs  int64               `json:"drain_duration_ms"`
+	InFlightAtSignal int64               `json:"in_flight_at_signal"`
+	RequestsDrained  int64               `json:"requests_drained"`
+	RequestsAborted  int64               `json:"requests_aborted"`
+	Clean            bool                `json:"clean"`
+	Backends         []BackendDrainState `json:"backends"`
+}
+
+// BackendDrainState is the number of connections a backend still had open
+// when the drain finished (non-zero means requests were cut off).
+type BackendDrainState struct {
+	URL          string `json:"url"`
+	CurrentConns int64  `json:"current_connections"`
+}
+
+// buildShutdownReport captures the final counters after server.Shutdown returned.
+func buildShutdownReport(started time.Time, inFlightAtSignal, completedAtSignal int64,
+	tracker *proxy.Tracker, pools []pool.LoadBalancer) ShutdownReport {
+
+	report := ShutdownReport{
+		StartedAt:        started,
+		DrainDurationMs:  time.Since(started).Milliseconds(),
+		InFlightAtSignal: inFlightAtSignal,
+		RequestsDrained:  tracker.Completed() - completedAtSignal,
+		RequestsAborted:  tracker.InFlight(),
+	}
+	report.Clean = report.RequestsAborted == 0
+
+	for _, lb := range pools {
+		for _, b := range lb.GetBackends() {
+			report.Backends = append(report.Backends, BackendDrainState{
+				URL:          b.URL.String(),
+				CurrentConns: atomic.LoadInt64(&b.CurrentConns),
+			})
+		}
+	}
+	return report
+}
+
+// emitShutdownReport logs the report as a single JSON line and, if a webhook
+// URL is configured, POSTs it there. Webhook failures are logged, never fatal.
+func emitShutdownReport(report ShutdownReport, webhookURL string) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("Failed to encode shutdown report: %v", err)
+		return
+	}
+	log.Printf("Shutdown report: %s", data)
+
+	if webhookURL == "" {
+		return
+	}
+	if err := postJSON(webhookURL, data, 5*time.Second); err != nil {
+		log.Printf("Shutdown webhook failed: %v", err)
+	}
+}
+
+func postJSON(url string, body []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
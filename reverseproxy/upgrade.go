@@ -0,0 +1,179 @@
+package reverseproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A binary upgrade hands the bound sockets of the running process over to a
+// new one started from the same path and arguments (see Upgrade): no port is
+// ever closed, so no connection is refused, and the old process drains its
+// in-flight requests once the new one is serving.
+const (
+	// listenersEnv lists the inherited sockets as "address=fd" pairs,
+	// separated by commas, the address being the one the new process asks
+	// for (e.g. ":8080").
+	listenersEnv = "REVERSE_PROXY_LISTENERS"
+	// readyEnv is the fd of a pipe the new process writes to once started.
+	readyEnv = "REVERSE_PROXY_READY_FD"
+)
+
+// UpgradeTimeout is how long Upgrade waits for the new process to start.
+const UpgradeTimeout = 30 * time.Second
+
+// socket is a listener opened by Start, with the address it was asked for.
+type socket struct {
+	addr string
+	ln   net.Listener
+}
+
+// listen binds addr, or takes over the socket inherited for it.
+func (s *Server) listen(addr string) (net.Listener, error) {
+	ln, ok := s.inherited[addr]
+	if ok {
+		delete(s.inherited, addr)
+	} else {
+		lc := net.ListenConfig{}
+		if s.cfg.Listener.ReusePort {
+			lc.Control = reusePort
+		}
+		var err error
+		if ln, err = lc.Listen(context.Background(), "tcp", addr); err != nil {
+			return nil, err
+		}
+	}
+	s.sockets = append(s.sockets, socket{addr, ln})
+	return ln, nil
+}
+
+// inheritListeners takes the sockets passed by the process being upgraded,
+// if any. The variables are cleared: they only hold for this Start.
+func inheritListeners() (map[string]net.Listener, error) {
+	raw := os.Getenv(listenersEnv)
+	os.Unsetenv(listenersEnv)
+	inherited := map[string]net.Listener{}
+	if raw == "" {
+		return inherited, nil
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		addr, fd, ok := strings.Cut(pair, "=")
+		n, err := strconv.Atoi(fd)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q", listenersEnv, pair)
+		}
+		f := os.NewFile(uintptr(n), addr)
+		ln, err := net.FileListener(f)
+		f.Close() // ln has its own copy
+		if err != nil {
+			return nil, fmt.Errorf("inherited listener %s: %w", addr, err)
+		}
+		inherited[addr] = ln
+	}
+	log.Printf("Took over %d listeners from the previous process", len(inherited))
+	return inherited, nil
+}
+
+// signalReady tells the process that started this one through Upgrade that
+// it is serving.
+func signalReady() {
+	raw := os.Getenv(readyEnv)
+	os.Unsetenv(readyEnv)
+	if raw == "" {
+		return
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("Invalid %s %q", readyEnv, raw)
+		return
+	}
+	f := os.NewFile(uintptr(n), "ready")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		log.Printf("Could not signal readiness to the previous process: %v", err)
+	}
+}
+
+// Upgrade starts a new process from the same executable path and
+// arguments, handing it the listening sockets, and returns once it has
+// started. The caller then stops this server as for a shutdown: the new
+// process accepts the connections from now on while this one drains. If the
+// new process fails to start (bad binary, invalid config), Upgrade returns
+// an error and this server keeps serving.
+func (s *Server) Upgrade() error {
+	if len(s.sockets) == 0 {
+		return errors.New("server not started")
+	}
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	var pairs []string
+	for _, sock := range s.sockets {
+		ln, ok := sock.ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("listener %s cannot be passed on", sock.addr)
+		}
+		f, err := ln.File()
+		if err != nil {
+			return fmt.Errorf("listener %s: %w", sock.addr, err)
+		}
+		files = append(files, f)
+		// ExtraFiles[i] becomes fd 3+i in the new process.
+		pairs = append(pairs, fmt.Sprintf("%s=%d", sock.addr, 2+len(files)))
+	}
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	files = append(files, readyW)
+
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		listenersEnv+"="+strings.Join(pairs, ","),
+		readyEnv+"="+strconv.Itoa(2+len(files)))
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting %s: %w", path, err)
+	}
+	readyW.Close() // the pipe reads EOF if the new process exits
+	files = files[:len(files)-1]
+	log.Printf("Started new process %d, waiting for it to serve", cmd.Process.Pid)
+
+	done := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		_, err := io.ReadFull(ready, b[:])
+		done <- err
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(UpgradeTimeout):
+		err = fmt.Errorf("not ready after %v", UpgradeTimeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return fmt.Errorf("new process %d failed to start: %w", cmd.Process.Pid, err)
+	}
+	// The new process outlives this one: leave it to init.
+	cmd.Process.Release()
+	s.upgraded = true
+	return nil
+}
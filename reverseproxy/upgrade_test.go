@@ -0,0 +1,69 @@
+//go:build unix
+
+package reverseproxy_test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"reverse-proxy/reverseproxy"
+)
+
+// TestStart_TakesOverInheritedListeners plays the new process of a binary
+// upgrade: its socket is already bound and passed by fd.
+func TestStart_TakesOverInheritedListeners(t *testing.T) {
+	backend := newBackend(t, "new process")
+	old, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	f, err := old.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fd, err := syscall.Dup(int(f.Fd())) // the server closes it
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ready.Close()
+	readyFd, err := syscall.Dup(int(readyW.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readyW.Close()
+
+	port := old.Addr().(*net.TCPAddr).Port
+	t.Setenv("REVERSE_PROXY_LISTENERS", fmt.Sprintf(":%d=%d", port, fd))
+	t.Setenv("REVERSE_PROXY_READY_FD", strconv.Itoa(readyFd))
+	srv, err := reverseproxy.New(&reverseproxy.Config{Strategy: "round-robin", Port: port, Backends: []string{backend.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop(t.Context())
+
+	var b [1]byte
+	if n, err := ready.Read(b[:]); n != 1 {
+		t.Fatalf("expected the readiness byte, got %v", err)
+	}
+	if srv.Addr().String() != old.Addr().String() {
+		t.Errorf("expected the inherited socket %s, got %s", old.Addr(), srv.Addr())
+	}
+	old.Close() // the previous process stops accepting
+	if code, body := get(t, "http://"+old.Addr().String()+"/"); code != http.StatusOK || body != "new process" {
+		t.Errorf("got %d %q", code, body)
+	}
+}
@@ -0,0 +1,311 @@
+package route
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Rule is a compiled routing expression over request attributes, such as
+//
+//	header("X-Beta") == "true" && method != "DELETE"
+//	path =~ "^/users/[0-9]+$" || query("preview") == "1"
+//
+// Values are method, path, host (port removed), header("Name"),
+// query("name"), cookie("name") and string literals, in double or single
+// quotes. Operators are == and != (exact), =~ and !~ (regular expression,
+// whose right side must be a literal, compiled once), !, && and ||, with
+// parentheses for grouping. A value on its own is true when it is not
+// empty: header("X-Debug").
+type Rule struct {
+	src  string
+	root ruleNode
+}
+
+// CompileRule parses src; the error points at the offending position.
+func CompileRule(src string) (*Rule, error) {
+	p := &ruleParser{src: src}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %q", tok.text)
+	}
+	return &Rule{src: src, root: root}, nil
+}
+
+// Match reports whether r satisfies the rule.
+func (rl *Rule) Match(r *http.Request) bool {
+	return rl.root.eval(r)
+}
+
+// String returns the source of the rule.
+func (rl *Rule) String() string {
+	return rl.src
+}
+
+type ruleNode interface {
+	eval(r *http.Request) bool
+}
+
+// ruleValue extracts a string from the request.
+type ruleValue func(r *http.Request) string
+
+type (
+	orNode  struct{ left, right ruleNode }
+	andNode struct{ left, right ruleNode }
+	notNode struct{ node ruleNode }
+	// setNode is a value used as a condition.
+	setNode   struct{ value ruleValue }
+	equalNode struct {
+		left, right ruleValue
+		negate      bool
+	}
+	regexpNode struct {
+		value  ruleValue
+		re     *regexp.Regexp
+		negate bool
+	}
+)
+
+func (n orNode) eval(r *http.Request) bool  { return n.left.eval(r) || n.right.eval(r) }
+func (n andNode) eval(r *http.Request) bool { return n.left.eval(r) && n.right.eval(r) }
+func (n notNode) eval(r *http.Request) bool { return !n.node.eval(r) }
+func (n setNode) eval(r *http.Request) bool { return n.value(r) != "" }
+
+func (n equalNode) eval(r *http.Request) bool {
+	return (n.left(r) == n.right(r)) != n.negate
+}
+
+func (n regexpNode) eval(r *http.Request) bool {
+	return n.re.MatchString(n.value(r)) != n.negate
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokOp // == != =~ !~ && || ! ( )
+)
+
+type token struct {
+	kind tokenKind
+	text string // the string's value for tokString
+	pos  int
+}
+
+type ruleParser struct {
+	src    string
+	tokens []token
+	next   int
+}
+
+func (p *ruleParser) errorf(tok token, format string, args ...any) error {
+	return fmt.Errorf("rule %q: at %d: %s", p.src, tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *ruleParser) lex() error {
+	src := p.src
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(src[i+1:], c)
+			if end < 0 {
+				return p.errorf(token{pos: i}, "unterminated string")
+			}
+			p.tokens = append(p.tokens, token{tokString, src[i+1 : i+1+end], i})
+			i += end + 2
+		case unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+			p.tokens = append(p.tokens, token{tokIdent, src[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "=~", "!~", "&&", "||", "!", "(", ")"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return p.errorf(token{pos: i}, "unexpected character %q", c)
+			}
+			p.tokens = append(p.tokens, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	p.tokens = append(p.tokens, token{tokEOF, "", len(src)})
+	return nil
+}
+
+func (p *ruleParser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *ruleParser) take() token {
+	tok := p.tokens[p.next]
+	if tok.kind != tokEOF {
+		p.next++
+	}
+	return tok
+}
+
+func (p *ruleParser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == op {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *ruleParser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		if tok.kind == tokEOF {
+			return p.errorf(tok, "expected %q, got end of rule", op)
+		}
+		return p.errorf(tok, "expected %q, got %q", op, tok.text)
+	}
+	return nil
+}
+
+func (p *ruleParser) parseOr() (ruleNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right ruleNode
+		if right, err = p.parseAnd(); err == nil {
+			left = orNode{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *ruleParser) parseAnd() (ruleNode, error) {
+	left, err := p.parseNot()
+	for err == nil && p.accept("&&") {
+		var right ruleNode
+		if right, err = p.parseNot(); err == nil {
+			left = andNode{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *ruleParser) parseNot() (ruleNode, error) {
+	if p.accept("!") {
+		node, err := p.parseNot()
+		return notNode{node}, err
+	}
+	if p.accept("(") {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	}
+	return p.parseComparison()
+}
+
+func (p *ruleParser) parseComparison() (ruleNode, error) {
+	left, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	if tok.kind != tokOp {
+		return setNode{left}, nil
+	}
+	switch tok.text {
+	case "==", "!=":
+		p.take()
+		right, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return equalNode{left, right, tok.text == "!="}, nil
+	case "=~", "!~":
+		p.take()
+		pattern := p.take()
+		if pattern.kind != tokString {
+			return nil, p.errorf(pattern, "%s needs a quoted regular expression", tok.text)
+		}
+		re, err := regexp.Compile(pattern.text)
+		if err != nil {
+			return nil, p.errorf(pattern, "%v", err)
+		}
+		return regexpNode{left, re, tok.text == "!~"}, nil
+	}
+	return setNode{left}, nil
+}
+
+func (p *ruleParser) parseValue() (ruleValue, error) {
+	tok := p.take()
+	switch tok.kind {
+	case tokString:
+		s := tok.text
+		return func(*http.Request) string { return s }, nil
+	case tokIdent:
+		switch tok.text {
+		case "method":
+			return func(r *http.Request) string { return r.Method }, nil
+		case "path":
+			return func(r *http.Request) string { return r.URL.Path }, nil
+		case "host":
+			return func(r *http.Request) string {
+				if h, _, err := net.SplitHostPort(r.Host); err == nil {
+					return h
+				}
+				return r.Host
+			}, nil
+		case "header", "query", "cookie":
+			name, err := p.parseArgument(tok)
+			if err != nil {
+				return nil, err
+			}
+			switch tok.text {
+			case "header":
+				return func(r *http.Request) string { return r.Header.Get(name) }, nil
+			case "query":
+				return func(r *http.Request) string { return r.URL.Query().Get(name) }, nil
+			default:
+				return func(r *http.Request) string {
+					if c, err := r.Cookie(name); err == nil {
+						return c.Value
+					}
+					return ""
+				}, nil
+			}
+		}
+		return nil, p.errorf(tok, "unknown value %q (want method, path, host, header, query, cookie or a quoted string)", tok.text)
+	case tokEOF:
+		return nil, p.errorf(tok, "unexpected end of rule")
+	}
+	return nil, p.errorf(tok, "expected a value, got %q", tok.text)
+}
+
+// parseArgument reads the ("name") following header, query or cookie.
+func (p *ruleParser) parseArgument(fn token) (string, error) {
+	if err := p.expect("("); err != nil {
+		return "", err
+	}
+	arg := p.take()
+	if arg.kind != tokString || arg.text == "" {
+		return "", p.errorf(arg, "%s needs a quoted name", fn.text)
+	}
+	return arg.text, p.expect(")")
+}
@@ -0,0 +1,59 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRule_Match(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "http://api.example.com:8080/users/42?preview=1", nil)
+	req.Header.Set("X-Beta", "true")
+	req.AddCookie(&http.Cookie{Name: "plan", Value: "pro"})
+
+	cases := map[string]bool{
+		`header("X-Beta") == "true"`:                                       true,
+		`header("x-beta") == 'true' && method == "POST"`:                   true,
+		`header("X-Beta") != "true"`:                                       false,
+		`header("X-Missing")`:                                              false,
+		`!header("X-Missing")`:                                             true,
+		`path =~ "^/users/[0-9]+$"`:                                        true,
+		`path !~ "^/users/"`:                                               false,
+		`query("preview") == "1" && cookie("plan") == "pro"`:               true,
+		`host == "api.example.com"`:                                        true,
+		`method == "GET" || (query("preview") == "1" && !cookie("none"))`:  true,
+		`method == "GET" || method == "PUT" && header("X-Beta") == "true"`: false,
+	}
+	for src, want := range cases {
+		rule, err := CompileRule(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if got := rule.Match(req); got != want {
+			t.Errorf("%s: got %v, want %v", src, got, want)
+		}
+	}
+}
+
+func TestCompileRule_Errors(t *testing.T) {
+	for src, want := range map[string]string{
+		``:                          "unexpected end",
+		`header("X-Beta") ==`:       "unexpected end",
+		`header(Beta)`:              "quoted name",
+		`header("X-Beta"`:           `expected ")"`,
+		`status == "200"`:           "unknown value",
+		`path =~ "("`:               "missing closing )",
+		`path =~ path`:              "quoted regular expression",
+		`method == "GET" "POST"`:    `unexpected "POST"`,
+		`(method == "GET"`:          `expected ")"`,
+		`header("X-Beta") == "true`: "unterminated string",
+		`method = "GET"`:            "unexpected character",
+	} {
+		_, err := CompileRule(src)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected an error containing %q, got %v", src, want, err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package route
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"reverse-proxy/pool"
+	"reverse-proxy/proxy"
+)
+
+// Route sends the requests it matches to its own load balancer through its
+// own handler (usually a proxy.NewHandler built with the route's options).
+type Route struct {
+	Name       string
+	Host       string // exact Host match, port ignored; empty matches any host
+	PathPrefix string // matched on path segment boundaries; defaults to "/"
+	Exact      bool   // the path must equal PathPrefix
+	Rule       *Rule  // optional condition on the whole request (headers, query, method...)
+	Pool       pool.LoadBalancer
+	Handler    http.Handler
+}
+
+// Groups returns the route's weighted backend groups, or nil if the route
+// does not split traffic.
+func (rt *Route) Groups() []*pool.Group {
+	if gp, ok := rt.Pool.(*pool.GroupedPool); ok {
+		return gp.Groups
+	}
+	return nil
+}
+
+func (rt *Route) matches(host, path string) bool {
+	if rt.Host != "" && !strings.EqualFold(rt.Host, host) {
+		return false
+	}
+	prefix := rt.PathPrefix
+	if rt.Exact {
+		return path == prefix
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	// "/api" matches "/api" and "/api/x", not "/apix".
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// Table dispatches requests to the most specific matching route: routes with
+// a Host come first, then routes with a Rule, then exact paths, then the
+// longest path prefix wins.
+// Weights and backends change inside the routes' pools; the route set itself
+// is swapped atomically with Replace.
+type Table struct {
+	routes atomic.Pointer[[]*Route]
+}
+
+// NewTable orders routes by specificity.
+func NewTable(routes ...*Route) *Table {
+	t := &Table{}
+	t.Replace(routes...)
+	return t
+}
+
+// Replace atomically swaps the whole route set; requests already dispatched
+// keep the route they matched.
+func (t *Table) Replace(routes ...*Route) {
+	sorted := make([]*Route, len(routes))
+	copy(sorted, routes)
+	for _, rt := range sorted {
+		if rt.PathPrefix == "" {
+			rt.PathPrefix = "/"
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if (sorted[i].Host != "") != (sorted[j].Host != "") {
+			return sorted[i].Host != ""
+		}
+		if (sorted[i].Rule != nil) != (sorted[j].Rule != nil) {
+			return sorted[i].Rule != nil
+		}
+		if sorted[i].Exact != sorted[j].Exact {
+			return sorted[i].Exact
+		}
+		return len(sorted[i].PathPrefix) > len(sorted[j].PathPrefix)
+	})
+	t.routes.Store(&sorted)
+}
+
+// Routes returns the routes in match order.
+func (t *Table) Routes() []*Route {
+	return *t.routes.Load()
+}
+
+// Get returns the route with the given name, or nil.
+func (t *Table) Get(name string) *Route {
+	for _, rt := range t.Routes() {
+		if rt.Name == name {
+			return rt
+		}
+	}
+	return nil
+}
+
+// Match returns the route serving r, or nil if none matches.
+func (t *Table) Match(r *http.Request) *Route {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, rt := range t.Routes() {
+		if rt.matches(host, r.URL.Path) && (rt.Rule == nil || rt.Rule.Match(r)) {
+			return rt
+		}
+	}
+	return nil
+}
+
+func (t *Table) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt := t.Match(r)
+	if rt == nil {
+		proxy.DecisionFromContext(r.Context()).Check("route", false, "no route matches")
+		http.NotFound(w, r)
+		return
+	}
+	rt.Handler.ServeHTTP(w, r)
+}
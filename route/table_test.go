@@ -0,0 +1,69 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func named(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	})
+}
+
+func TestTable_MatchesMostSpecificRoute(t *testing.T) {
+	table := NewTable(
+		&Route{Name: "default", Handler: named("default")},
+		&Route{Name: "api", PathPrefix: "/api", Handler: named("api")},
+		&Route{Name: "api-v2", PathPrefix: "/api/v2", Handler: named("api-v2")},
+		&Route{Name: "admin-host", Host: "admin.example.com", Handler: named("admin-host")},
+	)
+
+	cases := []struct{ host, path, want string }{
+		{"example.com", "/", "default"},
+		{"example.com", "/api", "api"},
+		{"example.com", "/api/orders", "api"},
+		{"example.com", "/apix", "default"},
+		{"example.com", "/api/v2/orders", "api-v2"},
+		{"admin.example.com:8080", "/api", "admin-host"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		req.Host = c.host
+		rec := httptest.NewRecorder()
+		table.ServeHTTP(rec, req)
+		if got := rec.Body.String(); got != c.want {
+			t.Errorf("%s%s: routed to %q, want %q", c.host, c.path, got, c.want)
+		}
+	}
+}
+
+func TestTable_NoMatchReturns404(t *testing.T) {
+	table := NewTable(&Route{Name: "api", PathPrefix: "/api", Handler: named("api")})
+	rec := httptest.NewRecorder()
+	table.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/other", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestTable_RuleRoutesComeFirst(t *testing.T) {
+	beta, err := CompileRule(`header("X-Beta") == "true"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := NewTable(
+		&Route{Name: "api", PathPrefix: "/api", Handler: named("api")},
+		&Route{Name: "beta", Rule: beta, Handler: named("beta")},
+	)
+	for header, want := range map[string]string{"true": "beta", "false": "api", "": "api"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+		req.Header.Set("X-Beta", header)
+		rec := httptest.NewRecorder()
+		table.ServeHTTP(rec, req)
+		if got := rec.Body.String(); got != want {
+			t.Errorf("X-Beta %q: routed to %q, want %q", header, got, want)
+		}
+	}
+}
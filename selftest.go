@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"reverse-proxy/admin"
+	"reverse-proxy/health"
+	"reverse-proxy/pool"
+	"reverse-proxy/proxy"
+	"reverse-proxy/reverseproxy"
+)
+
+// selfTestCheck is one line of the self-test report.
+type selfTestCheck struct {
+	name string
+	run  func() error
+}
+
+// runSelfTest boots the full stack (pool, health checker, proxy handler,
+// admin API) against ephemeral mock backends using the loaded config's
+// strategy, transport and proxy settings, then exercises routing, retries,
+// health transitions and the admin API. It prints a pass/fail report and
+// returns true if every check passed.
+func runSelfTest(cfg *reverseproxy.Config) bool {
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		fmt.Fprint(w, "self-test backend")
+	}))
+	defer mock.Close()
+
+	// Stops the health checker started by the "health" check.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A backend URL nothing listens on: claimed alive so the proxy has to retry.
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL, _ := url.Parse(dead.URL)
+	dead.Close()
+
+	mockURL, _ := url.Parse(mock.URL)
+	deadBackend := &pool.Backend{URL: deadURL}
+	deadBackend.SetAlive(true)
+	mockBackend := &pool.Backend{URL: mockURL}
+	mockBackend.SetAlive(true)
+	serverPool, err := pool.NewServerPool(
+		pool.WithStrategy(cfg.Strategy),
+		pool.WithTransport(cfg.TransportConfig()),
+		pool.WithBackends(deadBackend, mockBackend),
+	)
+	if err != nil {
+		log.Printf("Self-test: FAIL config: %v", err)
+		return false
+	}
+
+	opts, err := cfg.ProxyOptions()
+	if err != nil {
+		log.Printf("Self-test: FAIL config: %v", err)
+		return false
+	}
+	front := httptest.NewServer(proxy.NewHandler(serverPool, opts))
+	defer front.Close()
+	adminSrv := httptest.NewServer(admin.Handler(serverPool, admin.Options{}))
+	defer adminSrv.Close()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	checks := []selfTestCheck{
+		{"retries", func() error {
+			// Every request must end up on the mock; once the strategy has
+			// tried the dead backend it must have been marked DOWN.
+			for i := 0; i < 20 && deadBackend.IsAlive(); i++ {
+				if err := expectBody(client, front.URL, "self-test backend"); err != nil {
+					return err
+				}
+			}
+			if deadBackend.IsAlive() {
+				return fmt.Errorf("unreachable backend was never tried and marked DOWN")
+			}
+			return nil
+		}},
+		{"routing", func() error {
+			for i := 0; i < 5; i++ {
+				if err := expectBody(client, front.URL, "self-test backend"); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"health", func() error {
+			serverPool.SetBackendStatus(mockURL, false)
+			health.Start(ctx, serverPool, 100*time.Millisecond)
+			deadline := time.Now().Add(2 * time.Second)
+			for time.Now().Before(deadline) {
+				if mockBackend.IsAlive() {
+					return nil
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			return fmt.Errorf("health checker did not bring the mock backend back UP")
+		}},
+		{"admin", func() error {
+			return checkAdmin(client, adminSrv.URL)
+		}},
+	}
+
+	passed := true
+	for _, c := range checks {
+		start := time.Now()
+		if err := c.run(); err != nil {
+			passed = false
+			log.Printf("Self-test: FAIL %-8s (%v) %v", c.name, time.Since(start).Round(time.Millisecond), err)
+			continue
+		}
+		log.Printf("Self-test: PASS %-8s (%v)", c.name, time.Since(start).Round(time.Millisecond))
+	}
+
+	if passed {
+		log.Println("Self-test passed")
+	} else {
+		log.Println("Self-test FAILED")
+	}
+	return passed
+}
+
+func expectBody(client *http.Client, target, want string) error {
+	resp, err := client.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != want {
+		return fmt.Errorf("got %d %q, want 200 %q", resp.StatusCode, body, want)
+	}
+	return nil
+}
+
+// checkAdmin adds a backend through the API, verifies /status reports it,
+// then removes it again.
+func checkAdmin(client *http.Client, adminURL string) error {
+	const extra = "http://127.0.0.1:1"
+	payload, _ := json.Marshal(map[string]string{"url": extra})
+
+	resp, err := client.Post(adminURL+"/backends", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("POST /backends returned %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(adminURL + "/status")
+	if err != nil {
+		return err
+	}
+	var status admin.StatusResponse
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("GET /status returned invalid JSON: %w", err)
+	}
+	if status.TotalBackends != 3 {
+		return fmt.Errorf("GET /status reports %d backends, want 3", status.TotalBackends)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, adminURL+"/backends", bytes.NewReader(payload))
+	resp, err = client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("DELETE /backends returned %d", resp.StatusCode)
+	}
+	return nil
+}
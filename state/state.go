@@ -0,0 +1,265 @@
+// Package state persists the changes made through the admin API — backends
+// added or removed, maintenance flags, weights, tags — so they survive a
+// restart.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"reverse-proxy/pool"
+	"reverse-proxy/route"
+)
+
+// State is the runtime configuration that differs from the config file. It
+// doubles as the snapshot of the admin API's /config/export and
+// /config/import.
+type State struct {
+	Strategy   string                    `json:"strategy,omitempty"`   // of the default pool
+	Backends   []Backend                 `json:"backends"`             // the default pool, in order
+	Weights    map[string]map[string]int `json:"weights,omitempty"`    // route → group → weight
+	Strategies map[string]string         `json:"strategies,omitempty"` // route → strategy, the default one aside
+}
+
+// strategySwitcher is implemented by the pools whose strategy can change at
+// runtime, such as pool.ServerPool and pool.GroupedPool.
+type strategySwitcher interface {
+	SetStrategy(name string) error
+	StrategyName() string
+}
+
+// Backend is one backend of the default pool.
+type Backend struct {
+	URL       string `json:"url"`
+	AdminDown bool   `json:"admin_down,omitempty"`
+	Weight    *int   `json:"weight,omitempty"` // nil for pool.DefaultWeight
+
+	Tags map[string]string `json:"tags,omitempty"` // nil keeps those of the config
+}
+
+// Capture records the default pool and the group weights of every route.
+func Capture(defaultPool pool.LoadBalancer, routes *route.Table) *State {
+	st := &State{Backends: []Backend{}}
+	if sp, ok := defaultPool.(*pool.ServerPool); ok {
+		st.Strategy = sp.StrategyName()
+	}
+	for _, b := range defaultPool.GetBackends() {
+		saved := Backend{URL: b.URL.String(), AdminDown: b.IsAdminDown()}
+		if w := b.Weight(); w != pool.DefaultWeight {
+			saved.Weight = &w
+		}
+		saved.Tags = b.Tags()
+		st.Backends = append(st.Backends, saved)
+	}
+	if routes == nil {
+		return st
+	}
+	for _, rt := range routes.Routes() {
+		if sw, ok := rt.Pool.(strategySwitcher); ok && rt.Pool != defaultPool {
+			if st.Strategies == nil {
+				st.Strategies = map[string]string{}
+			}
+			st.Strategies[rt.Name] = sw.StrategyName()
+		}
+		groups := rt.Groups()
+		if groups == nil {
+			continue
+		}
+		if st.Weights == nil {
+			st.Weights = map[string]map[string]int{}
+		}
+		weights := map[string]int{}
+		for _, g := range groups {
+			weights[g.Name] = g.Weight()
+		}
+		st.Weights[rt.Name] = weights
+	}
+	return st
+}
+
+// URLs returns the backends of the default pool, to build it from in place
+// of the config file's list.
+func (st *State) URLs() []string {
+	urls := make([]string, len(st.Backends))
+	for i, b := range st.Backends {
+		urls[i] = b.URL
+	}
+	return urls
+}
+
+// Validate checks that st can be applied as a whole on top of the running
+// configuration: valid backend URLs and weights, a known strategy, and only
+// routes and groups that exist.
+func (st *State) Validate(defaultPool pool.LoadBalancer, routes *route.Table) error {
+	if st.Strategy != "" {
+		if _, err := pool.NewStrategy(st.Strategy); err != nil {
+			return err
+		}
+	}
+	if len(st.Backends) == 0 {
+		return errors.New("backend list must not be empty")
+	}
+	for _, b := range st.Backends {
+		if _, err := pool.ParseBackendURL(b.URL); err != nil {
+			return err
+		}
+		if b.Weight != nil && *b.Weight < 0 {
+			return fmt.Errorf("backend %s: weight must not be negative", b.URL)
+		}
+		if _, ok := b.Tags[""]; ok {
+			return fmt.Errorf("backend %s: tag names must not be empty", b.URL)
+		}
+	}
+	for name, strategy := range st.Strategies {
+		var rt *route.Route
+		if routes != nil {
+			rt = routes.Get(name)
+		}
+		if rt == nil {
+			return fmt.Errorf("unknown route: %s", name)
+		}
+		if _, ok := rt.Pool.(strategySwitcher); !ok {
+			return fmt.Errorf("route %s: strategy cannot be changed", name)
+		}
+		if _, err := pool.NewStrategy(strategy); err != nil {
+			return fmt.Errorf("route %s: %w", name, err)
+		}
+	}
+	for name, weights := range st.Weights {
+		var rt *route.Route
+		if routes != nil {
+			rt = routes.Get(name)
+		}
+		if rt == nil || rt.Groups() == nil {
+			return fmt.Errorf("unknown route with groups: %s", name)
+		}
+		for group, w := range weights {
+			if !hasGroup(rt, group) {
+				return fmt.Errorf("route %s: unknown group %s", name, group)
+			}
+			if w < 0 {
+				return fmt.Errorf("route %s: group %s: weight must not be negative", name, group)
+			}
+		}
+	}
+	return nil
+}
+
+func hasGroup(rt *route.Route, name string) bool {
+	for _, g := range rt.Groups() {
+		if g.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply restores the strategy, maintenance flags, weights and tags on the
+// default pool and the strategies and group weights on the routes. Routes
+// and groups no longer in the config, and unknown strategies, are ignored.
+func (st *State) Apply(defaultPool pool.LoadBalancer, routes *route.Table) {
+	if sw, ok := defaultPool.(strategySwitcher); ok && st.Strategy != "" && st.Strategy != sw.StrategyName() {
+		sw.SetStrategy(st.Strategy)
+	}
+	weights, _ := defaultPool.(interface {
+		SetBackendWeight(u *url.URL, weight int) bool
+	})
+	for _, b := range defaultPool.GetBackends() {
+		for _, saved := range st.Backends {
+			if saved.URL != b.URL.String() {
+				continue
+			}
+			defaultPool.SetBackendAdminDown(b.URL, saved.AdminDown)
+			w := pool.DefaultWeight
+			if saved.Weight != nil && *saved.Weight >= 0 {
+				w = *saved.Weight
+			}
+			if weights != nil {
+				weights.SetBackendWeight(b.URL, w) // emits the change, if any
+			} else if w != b.Weight() {
+				b.SetWeight(w)
+			}
+			if saved.Tags != nil {
+				b.SetTags(saved.Tags)
+			}
+		}
+	}
+	if routes == nil {
+		return
+	}
+	for name, strategy := range st.Strategies {
+		if rt := routes.Get(name); rt != nil {
+			if sw, ok := rt.Pool.(strategySwitcher); ok && strategy != sw.StrategyName() {
+				sw.SetStrategy(strategy)
+			}
+		}
+	}
+	for name, weights := range st.Weights {
+		rt := routes.Get(name)
+		if rt == nil {
+			continue
+		}
+		for _, g := range rt.Groups() {
+			if w, ok := weights[g.Name]; ok && w >= 0 {
+				g.SetWeight(w)
+			}
+		}
+	}
+}
+
+// File reads and writes a State as JSON. Writes go through a temporary file
+// renamed over the old one, so a crash never leaves a truncated state.
+type File struct {
+	Path string
+	mux  sync.Mutex
+}
+
+// Load returns the saved state, or nil when the file does not exist yet.
+func (f *File) Load() (*State, error) {
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// Save replaces the file with st.
+func (f *File) Save(st *State) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
@@ -0,0 +1,115 @@
+package state
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"reverse-proxy/pool"
+	"reverse-proxy/route"
+)
+
+func newPool(t *testing.T, rawURLs ...string) *pool.ServerPool {
+	t.Helper()
+	sp := &pool.ServerPool{Strategy: "round-robin"}
+	for _, raw := range rawURLs {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatalf("invalid URL %s: %v", raw, err)
+		}
+		sp.AddBackend(&pool.Backend{URL: u})
+	}
+	return sp
+}
+
+func newRoutes(t *testing.T) *route.Table {
+	t.Helper()
+	gp := pool.NewGroupedPool(
+		pool.NewGroup("stable", 95, newPool(t, "http://stable:8080")),
+		pool.NewGroup("canary", 5, newPool(t, "http://canary:8080")),
+	)
+	return route.NewTable(&route.Route{Name: "web", PathPrefix: "/", Pool: gp})
+}
+
+func TestFile_MissingFileIsNoState(t *testing.T) {
+	f := &File{Path: filepath.Join(t.TempDir(), "state.json")}
+	st, err := f.Load()
+	if err != nil || st != nil {
+		t.Errorf("expected no state and no error, got %v, %v", st, err)
+	}
+}
+
+func TestCaptureSaveLoadApply(t *testing.T) {
+	sp := newPool(t, "http://a:8080", "http://b:8080")
+	routes := newRoutes(t)
+	sp.SetBackendAdminDown(sp.GetBackends()[1].URL, true)
+	sp.SetBackendWeight(sp.GetBackends()[0].URL, 0)
+	routes.Get("web").Groups()[1].SetWeight(40)
+	sp.SetStrategy("least-connections")
+	routes.Get("web").Pool.(*pool.GroupedPool).SetStrategy("p2c")
+
+	f := &File{Path: filepath.Join(t.TempDir(), "state.json")}
+	if err := f.Save(Capture(sp, routes)); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(f.Path))
+	if len(entries) != 1 {
+		t.Errorf("expected only the state file, found %d entries", len(entries))
+	}
+
+	st, err := f.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if urls := st.URLs(); len(urls) != 2 || urls[0] != "http://a:8080" || urls[1] != "http://b:8080" {
+		t.Errorf("unexpected backends: %v", urls)
+	}
+
+	// A fresh start from the config, then the saved state on top.
+	fresh := newPool(t, st.URLs()...)
+	freshRoutes := newRoutes(t)
+	st.Apply(fresh, freshRoutes)
+	if fresh.GetBackends()[0].IsAdminDown() || !fresh.GetBackends()[1].IsAdminDown() {
+		t.Error("maintenance flags not restored")
+	}
+	if fresh.GetBackends()[0].Weight() != 0 || fresh.GetBackends()[1].Weight() != pool.DefaultWeight {
+		t.Error("backend weights not restored")
+	}
+	if w := freshRoutes.Get("web").Groups()[1].Weight(); w != 40 {
+		t.Errorf("canary weight not restored: %d", w)
+	}
+	if fresh.StrategyName() != "least-connections" || freshRoutes.Get("web").Pool.(*pool.GroupedPool).StrategyName() != "p2c" {
+		t.Error("strategies not restored")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	sp := newPool(t, "http://a:8080")
+	routes := newRoutes(t)
+	weight := func(w int) *int { return &w }
+
+	valid := &State{
+		Strategy: "round-robin",
+		Backends: []Backend{{URL: "http://a:8080", Weight: weight(3)}},
+		Weights:  map[string]map[string]int{"web": {"canary": 50}},
+	}
+	if err := valid.Validate(sp, routes); err != nil {
+		t.Errorf("valid state rejected: %v", err)
+	}
+
+	for name, st := range map[string]*State{
+		"unknown strategy": {Strategy: "least-conn", Backends: valid.Backends},
+		"no backends":      {},
+		"invalid URL":      {Backends: []Backend{{URL: "not a url"}}},
+		"negative weight":  {Backends: []Backend{{URL: "http://a:8080", Weight: weight(-1)}}},
+		"unknown route":    {Backends: valid.Backends, Weights: map[string]map[string]int{"api": {"canary": 1}}},
+		"unknown group":    {Backends: valid.Backends, Weights: map[string]map[string]int{"web": {"blue": 1}}},
+		"route strategy":   {Backends: valid.Backends, Strategies: map[string]string{"web": "fastest"}},
+		"strategy route":   {Backends: valid.Backends, Strategies: map[string]string{"api": "round-robin"}},
+	} {
+		if err := st.Validate(sp, routes); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
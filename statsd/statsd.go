@@ -0,0 +1,212 @@
+// Package statsd pushes the proxy's metrics to a StatsD or DogStatsD agent
+// over UDP, for fleets where nothing scrapes the proxy.
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"reverse-proxy/pool"
+	"reverse-proxy/proxy"
+)
+
+// maxPacket keeps the datagrams under a typical MTU, as the agents expect.
+const maxPacket = 1432
+
+// Config describes the agent and the metric names.
+type Config struct {
+	Address  string        // host:port of the agent, e.g. 127.0.0.1:8125
+	Prefix   string        // prepended to every metric, e.g. "reverse_proxy"
+	Interval time.Duration // between two pushes; defaults to 10s
+	// DogStatsD sends the route and backend as tags, with Tags added to
+	// every metric ("key:value"). Plain StatsD has no tags: they go into
+	// the metric names instead.
+	DogStatsD bool
+	Tags      []string
+}
+
+// Pool is one pool whose backends are reported, under its route name.
+type Pool struct {
+	Route    string
+	Backends pool.BackendLister
+}
+
+// Exporter sends, every interval, the proxy-wide request counters and, for
+// every backend, its state, connections, request counters and latency
+// percentiles:
+//
+//	requests.in_flight (gauge), requests.completed, requests.shed (counters)
+//	backend.up, backend.connections, backend.latency_p50_ms/p90/p99 (gauges)
+//	backend.requests, backend.failures, backend.bytes_in, backend.bytes_out (counters)
+//
+// Counters are sent as the increase since the previous push.
+type Exporter struct {
+	cfg     Config
+	conn    net.Conn
+	pools   func() []Pool
+	tracker *proxy.Tracker   // nil skips the requests.* metrics
+	last    map[string]int64 // counter totals of the previous push
+	next    map[string]int64 // of this one: removed backends are forgotten
+}
+
+// New dials the agent; UDP needs no answer, so this only fails on an
+// invalid address.
+func New(cfg Config, pools func() []Pool, tracker *proxy.Tracker) (*Exporter, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	conn, err := net.Dial("udp", cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &Exporter{cfg: cfg, conn: conn, pools: pools, tracker: tracker, last: map[string]int64{}}, nil
+}
+
+// Start pushes the metrics every interval in a background goroutine, until
+// ctx is cancelled; the connection is closed then.
+func (e *Exporter) Start(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.Interval)
+	go func() {
+		defer ticker.Stop()
+		defer e.conn.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				e.push()
+			}
+		}
+	}()
+}
+
+// push sends one round of metrics, several lines per datagram.
+func (e *Exporter) push() {
+	e.next = map[string]int64{}
+	lines := e.collect()
+	e.last = e.next
+	var packet bytes.Buffer
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxPacket {
+			e.send(packet.Bytes())
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		e.send(packet.Bytes())
+	}
+}
+
+func (e *Exporter) send(packet []byte) {
+	// Usually "connection refused" from an earlier datagram: the agent is
+	// down. Metrics are best effort.
+	if _, err := e.conn.Write(packet); err != nil {
+		log.Printf("StatsD push to %s failed: %v", e.cfg.Address, err)
+	}
+}
+
+// collect returns the lines of one push.
+func (e *Exporter) collect() []string {
+	var lines []string
+	if e.tracker != nil {
+		lines = append(lines,
+			e.gauge("requests.in_flight", nil, float64(e.tracker.InFlight())),
+			e.counter("requests.completed", nil, e.tracker.Completed()),
+			e.counter("requests.shed", nil, e.tracker.Shed()),
+		)
+	}
+	for _, p := range e.pools() {
+		for _, b := range p.Backends.GetBackends() {
+			tags := []string{"route:" + p.Route, "backend:" + b.URL.String()}
+			up := 0.0
+			if b.IsAvailable() {
+				up = 1
+			}
+			s := b.Stats()
+			lines = append(lines,
+				e.gauge("backend.up", tags, up),
+				e.gauge("backend.connections", tags, float64(atomic.LoadInt64(&b.CurrentConns))),
+				e.gauge("backend.latency_p50_ms", tags, s.P50),
+				e.gauge("backend.latency_p90_ms", tags, s.P90),
+				e.gauge("backend.latency_p99_ms", tags, s.P99),
+				e.counter("backend.requests", tags, s.Requests),
+				e.counter("backend.failures", tags, s.Failures),
+				e.counter("backend.bytes_in", tags, s.BytesIn),
+				e.counter("backend.bytes_out", tags, s.BytesOut),
+			)
+		}
+	}
+	return lines
+}
+
+func (e *Exporter) gauge(name string, tags []string, v float64) string {
+	return e.line(name, tags, strconv.FormatFloat(v, 'f', -1, 64)+"|g")
+}
+
+// counter sends the increase of a cumulative total since the last push. A
+// total that went down was reset (e.g. the backend was re-added) and counts
+// from zero.
+func (e *Exporter) counter(name string, tags []string, total int64) string {
+	key := name + "|" + strings.Join(tags, ",")
+	delta := total - e.last[key]
+	if delta < 0 {
+		delta = total
+	}
+	e.next[key] = total
+	return e.line(name, tags, fmt.Sprintf("%d|c", delta))
+}
+
+// line formats a metric: tags as DogStatsD tags, or folded into the name.
+func (e *Exporter) line(name string, tags []string, value string) string {
+	var b strings.Builder
+	if e.cfg.Prefix != "" {
+		b.WriteString(e.cfg.Prefix + ".")
+	}
+	if e.cfg.DogStatsD {
+		b.WriteString(name + ":" + value)
+		all := append(append([]string{}, e.cfg.Tags...), tags...)
+		if len(all) > 0 {
+			for i, t := range all {
+				all[i] = tagReplacer.Replace(t)
+			}
+			b.WriteString("|#" + strings.Join(all, ","))
+		}
+		return b.String()
+	}
+	// backend.requests with route:api, backend:http://a:8080 becomes
+	// backend.api.a_8080.requests.
+	group, metric, _ := strings.Cut(name, ".")
+	b.WriteString(group)
+	for _, t := range tags {
+		_, v, _ := strings.Cut(t, ":")
+		b.WriteString("." + sanitize(v))
+	}
+	b.WriteString("." + metric + ":" + value)
+	return b.String()
+}
+
+// tagReplacer drops the characters that end a DogStatsD tag.
+var tagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+// sanitize turns a value into one StatsD name segment.
+func sanitize(v string) string {
+	v = strings.TrimPrefix(strings.TrimPrefix(v, "http://"), "https://")
+	return strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+			return r
+		}
+		return '_'
+	}, v), "_")
+}
@@ -0,0 +1,104 @@
+package statsd
+
+import (
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-proxy/pool"
+	"reverse-proxy/proxy"
+)
+
+// agent listens like a StatsD agent and returns the lines of the next push.
+func agent(t *testing.T) (string, func() []string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().String(), func() []string {
+		var lines []string
+		buf := make([]byte, 65536)
+		for {
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return lines
+			}
+			lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+		}
+	}
+}
+
+func exporter(t *testing.T, cfg Config) (*Exporter, *pool.Backend) {
+	t.Helper()
+	sp, err := pool.NewServerPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &pool.Backend{URL: &url.URL{Scheme: "http", Host: "a:8080"}}
+	b.SetAlive(true)
+	sp.AddBackend(b)
+	pools := func() []Pool { return []Pool{{Route: "api", Backends: sp}} }
+	e, err := New(cfg, pools, &proxy.Tracker{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.conn.Close() })
+	return e, b
+}
+
+func TestPush_DogStatsD(t *testing.T) {
+	addr, read := agent(t)
+	e, b := exporter(t, Config{Address: addr, Prefix: "rp", DogStatsD: true, Tags: []string{"env:staging"}})
+
+	b.RecordRequest(pool.RequestResult{Latency: 5 * time.Millisecond})
+	b.RecordRequest(pool.RequestResult{Latency: 5 * time.Millisecond, Failed: true})
+	e.push()
+	lines := read()
+	for _, want := range []string{
+		"rp.requests.in_flight:0|g|#env:staging",
+		"rp.backend.up:1|g|#env:staging,route:api,backend:http://a:8080",
+		"rp.backend.requests:2|c|#env:staging,route:api,backend:http://a:8080",
+		"rp.backend.failures:1|c|#env:staging,route:api,backend:http://a:8080",
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("missing %q in %q", want, lines)
+		}
+	}
+
+	// Counters are sent as the increase since the previous push.
+	b.RecordRequest(pool.RequestResult{Latency: 5 * time.Millisecond})
+	e.push()
+	lines = read()
+	for _, want := range []string{
+		"rp.backend.requests:1|c|#env:staging,route:api,backend:http://a:8080",
+		"rp.backend.failures:0|c|#env:staging,route:api,backend:http://a:8080",
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("missing %q in %q", want, lines)
+		}
+	}
+}
+
+func TestPush_PlainStatsDFoldsTagsIntoNames(t *testing.T) {
+	addr, read := agent(t)
+	e, b := exporter(t, Config{Address: addr})
+
+	b.SetAdminDown(true)
+	e.push()
+	lines := read()
+	for _, want := range []string{
+		"requests.completed:0|c",
+		"backend.api.a_8080.up:0|g",
+		"backend.api.a_8080.requests:0|c",
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("missing %q in %q", want, lines)
+		}
+	}
+}
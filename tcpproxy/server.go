@@ -0,0 +1,214 @@
+// Package tcpproxy load-balances raw TCP connections (databases, MQTT,
+// custom protocols) across a pool, using the same strategies and health
+// state as the HTTP proxy.
+package tcpproxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"reverse-proxy/pool"
+)
+
+// Server accepts TCP connections and pipes each one to a backend chosen by
+// the pool. Backends are plain host:port targets, usually written tcp://host:port.
+// A backend that refuses the connection is marked DOWN and the next one is
+// tried, as the HTTP proxy does.
+type Server struct {
+	Name        string
+	Pool        pool.LoadBalancer
+	DialTimeout time.Duration // defaults to 5s
+
+	nextID uint64 // atomic
+
+	mux      sync.Mutex
+	conns    map[uint64]*Conn
+	listener net.Listener
+	closed   bool
+}
+
+// Conn is one proxied client connection and its byte counters.
+type Conn struct {
+	ID       uint64
+	Client   string
+	Backend  string
+	Started  time.Time
+	bytesIn  int64 // client → backend, atomic
+	bytesOut int64 // backend → client, atomic
+
+	client, upstream net.Conn
+}
+
+// BytesIn returns the bytes forwarded from the client to the backend so far.
+func (c *Conn) BytesIn() int64 { return atomic.LoadInt64(&c.bytesIn) }
+
+// BytesOut returns the bytes forwarded from the backend to the client so far.
+func (c *Conn) BytesOut() int64 { return atomic.LoadInt64(&c.bytesOut) }
+
+// Serve accepts connections on ln until it is closed.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mux.Lock()
+	if s.closed {
+		s.mux.Unlock()
+		ln.Close()
+		return net.ErrClosed
+	}
+	s.listener = ln
+	s.mux.Unlock()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return net.ErrClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		go s.handle(c)
+	}
+}
+
+// Close stops accepting and cuts every open connection.
+func (s *Server) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.closed = true
+	for _, c := range s.conns {
+		c.client.Close()
+		c.upstream.Close()
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+// Connections returns the open connections, oldest first.
+func (s *Server) Connections() []*Conn {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	out := make([]*Conn, 0, len(s.conns))
+	for _, c := range s.conns {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (s *Server) handle(client net.Conn) {
+	defer client.Close()
+
+	backend, upstream, connectTime := s.dial()
+	if upstream == nil {
+		log.Printf("TCP %s: no backend available for %s", s.Name, client.RemoteAddr())
+		return
+	}
+	defer upstream.Close()
+
+	atomic.AddInt64(&backend.CurrentConns, 1)
+	defer atomic.AddInt64(&backend.CurrentConns, -1)
+
+	c := &Conn{
+		ID:       atomic.AddUint64(&s.nextID, 1),
+		Client:   client.RemoteAddr().String(),
+		Backend:  backend.URL.String(),
+		Started:  time.Now(),
+		client:   client,
+		upstream: upstream,
+	}
+	if !s.track(c) {
+		return // closing
+	}
+	defer s.untrack(c)
+
+	// When one side finishes, half-close the other so protocols relying on
+	// EOF still see it, then wait for the opposite direction to drain.
+	done := make(chan struct{})
+	go func() {
+		pipe(upstream, client, &c.bytesIn)
+		close(done)
+	}()
+	pipe(client, upstream, &c.bytesOut)
+	<-done
+
+	// A connection counts as one request, judged on its connect time.
+	backend.RecordRequest(pool.RequestResult{Latency: connectTime, BytesIn: c.BytesIn(), BytesOut: c.BytesOut()})
+}
+
+// dial tries backends until one accepts the connection, and reports how
+// long that backend took to accept it.
+func (s *Server) dial() (*pool.Backend, net.Conn, time.Duration) {
+	timeout := s.DialTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	attempts := len(s.Pool.GetBackends())
+	for i := 0; i < attempts; i++ {
+		backend := s.Pool.GetNextValidPeer()
+		if backend == nil {
+			return nil, nil, 0
+		}
+		started := time.Now()
+		upstream, err := net.DialTimeout("tcp", backend.URL.Host, timeout)
+		if err == nil {
+			backend.ObserveLatency(time.Since(started))
+			return backend, upstream, time.Since(started)
+		}
+		backend.ObserveFailure()
+		backend.RecordRequest(pool.RequestResult{Latency: time.Since(started), Failed: true})
+		log.Printf("TCP %s: backend %s error: %v — marking DOWN, retrying (attempt %d/%d)",
+			s.Name, backend.URL.Host, err, i+1, attempts)
+		pool.SetStatusCause(s.Pool, backend.URL, false, pool.CauseProxyError, err.Error())
+	}
+	return nil, nil, 0
+}
+
+func (s *Server) track(c *Conn) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[uint64]*Conn)
+	}
+	s.conns[c.ID] = c
+	return true
+}
+
+func (s *Server) untrack(c *Conn) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.conns, c.ID)
+}
+
+// pipe copies src to dst, counting bytes, then half-closes dst for writing.
+func pipe(dst, src net.Conn, counter *int64) {
+	io.Copy(&countingWriter{w: dst, n: counter}, src)
+	if tcp, ok := dst.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,140 @@
+package tcpproxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"reverse-proxy/pool"
+)
+
+// echoBackend answers every line with "<name>: <line>".
+func echoBackend(t *testing.T, name string) *url.URL {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				sc := bufio.NewScanner(c)
+				for sc.Scan() {
+					io.WriteString(c, name+": "+sc.Text()+"\n")
+				}
+			}()
+		}
+	}()
+	return &url.URL{Scheme: "tcp", Host: ln.Addr().String()}
+}
+
+func startServer(t *testing.T, p *pool.ServerPool) (*Server, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Name: "test", Pool: p, DialTimeout: time.Second}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return srv, ln.Addr().String()
+}
+
+func addBackend(p *pool.ServerPool, u *url.URL) *pool.Backend {
+	b := &pool.Backend{URL: u}
+	b.SetAlive(true)
+	p.AddBackend(b)
+	return b
+}
+
+func TestServer_ProxiesAndCountsBytes(t *testing.T) {
+	p := &pool.ServerPool{Strategy: "least-connections"}
+	a := addBackend(p, echoBackend(t, "a"))
+	addBackend(p, echoBackend(t, "b"))
+	srv, addr := startServer(t, p)
+
+	// The first connection stays open: least-connections must send the
+	// second one to the other backend.
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	r1 := bufio.NewReader(first)
+	io.WriteString(first, "hello\n")
+	line, _ := r1.ReadString('\n')
+	if !strings.HasSuffix(line, ": hello\n") {
+		t.Fatalf("unexpected reply %q", line)
+	}
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	io.WriteString(second, "hi\n")
+	line2, _ := bufio.NewReader(second).ReadString('\n')
+	if line2[:1] == line[:1] {
+		t.Errorf("both connections went to backend %s, expected least-connections to spread them", line[:1])
+	}
+	if n := atomic.LoadInt64(&a.CurrentConns); n != 1 {
+		t.Errorf("expected 1 open connection on backend a, got %d", n)
+	}
+
+	conns := srv.Connections()
+	if len(conns) != 2 {
+		t.Fatalf("expected 2 tracked connections, got %d", len(conns))
+	}
+	if c := conns[0]; c.BytesIn() != int64(len("hello\n")) || c.BytesOut() != int64(len(line)) {
+		t.Errorf("unexpected counters: in %d out %d", c.BytesIn(), c.BytesOut())
+	}
+
+	// Closing the client ends the session and releases the backend.
+	first.Close()
+	second.Close()
+	deadline := time.Now().Add(time.Second)
+	for len(srv.Connections()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(srv.Connections()); n != 0 {
+		t.Errorf("expected closed connections to be untracked, %d left", n)
+	}
+}
+
+func TestServer_SkipsRefusingBackend(t *testing.T) {
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	deadURL := &url.URL{Scheme: "tcp", Host: dead.Addr().String()}
+	dead.Close()
+
+	p := &pool.ServerPool{Strategy: "round-robin"}
+	down := addBackend(p, deadURL)
+	addBackend(p, echoBackend(t, "ok"))
+	_, addr := startServer(t, p)
+
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(c, "ping\n")
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		c.Close()
+		if line != "ok: ping\n" {
+			t.Errorf("connection %d: expected the healthy backend, got %q", i, line)
+		}
+	}
+	if down.IsAlive() {
+		t.Error("expected the refusing backend to be marked DOWN")
+	}
+}
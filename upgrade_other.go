@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// Binary upgrades need SIGUSR2, which this platform does not have.
+var upgradeSignals []os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals start a binary upgrade, see reverseproxy.Server.Upgrade.
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}